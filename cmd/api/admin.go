@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Now-Tiger/envhub/internal/audit"
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/database"
+)
+
+// runAdmin executes an operator subcommand (e.g. `api token create ...`)
+// against the configured database and returns the process exit code
+func runAdmin(args []string) int {
+	if len(args) < 2 {
+		printAdminUsage()
+		return 2
+	}
+
+	ctx := context.Background()
+
+	var err error
+	switch args[0] + " " + args[1] {
+	case "token create":
+		err = adminCreateToken(ctx, args[2:])
+	case "secrets reseal":
+		err = adminResealSecrets(ctx, args[2:])
+	case "masterkey rotate":
+		err = adminRotateMasterKey(ctx, args[2:])
+	case "masterkey status":
+		err = adminMasterKeyStatus(ctx, args[2:])
+	case "audit seal":
+		err = adminSealAudit(ctx, args[2:])
+	case "audit verify":
+		err = adminVerifyAudit(ctx, args[2:])
+	case "audit keygen":
+		err = adminAuditKeygen(args[2:])
+	default:
+		printAdminUsage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printAdminUsage() {
+	fmt.Fprintln(os.Stderr, `usage: api <command>
+
+Commands:
+  token create      Mint an API token for a user (bootstraps CLI access)
+  secrets reseal    Bind existing secret ciphertexts to their project, environment and key
+  masterkey rotate  Re-wrap every project DEK with the active master key (resumable)
+  masterkey status  Show the progress of the latest master key rotation
+  audit seal        Seal new access log and secret history rows into their hash chains
+  audit verify      Walk the audit hash chains and report the first broken link
+  audit keygen      Generate a key pair for signing audit checkpoints
+
+Run without arguments to start the HTTP server.`)
+}
+
+// adminCreateToken mints a token for an existing user and prints it once
+func adminCreateToken(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the token owner (required)")
+	name := fs.String("name", "bootstrap", "human-friendly token name")
+	org := fs.String("org", "", "organization slug to bind the token to")
+	scopes := fs.String("scopes", "", "comma-separated scopes")
+	ttl := fs.Duration("ttl", 0, "token lifetime (0 means no expiry)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return fmt.Errorf("--email is required")
+	}
+
+	q, closeDB, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	user, err := q.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return fmt.Errorf("user %q not found: %w", *email, err)
+	}
+
+	params := auth.IssueParams{
+		UserID: user.ID,
+		Name:   *name,
+	}
+	if *scopes != "" {
+		parsed, err := auth.ParseScopes(strings.Split(*scopes, ","))
+		if err != nil {
+			return err
+		}
+		for _, scope := range parsed {
+			params.Scopes = append(params.Scopes, scope.String())
+		}
+	}
+	if *ttl > 0 {
+		expiresAt := time.Now().Add(*ttl)
+		params.ExpiresAt = &expiresAt
+	}
+	if *org != "" {
+		organization, err := q.GetOrganizationBySlug(ctx, *org)
+		if err != nil {
+			return fmt.Errorf("organization %q not found: %w", *org, err)
+		}
+		params.OrganizationID = organization.ID
+	}
+
+	plaintext, token, err := auth.IssueToken(ctx, q, params)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Created token %s (%s). It will not be shown again.\n", token.ID, token.Name)
+	fmt.Println(plaintext)
+	return nil
+}
+
+// adminResealSecrets re-encrypts unbound secret values so they are bound to
+// their identity. It is safe to re-run and to run alongside the server.
+func adminResealSecrets(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("secrets reseal", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the operator recorded in secret history (required)")
+	batch := fs.Int("batch", vault.DefaultResealBatchSize, "rows per batch")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return fmt.Errorf("--email is required")
+	}
+
+	keys, err := loadKeyRing(ctx)
+	if err != nil {
+		return err
+	}
+
+	q, closeDB, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	operator, err := q.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return fmt.Errorf("user %q not found: %w", *email, err)
+	}
+
+	stats, err := vault.New(keys).Reseal(ctx, q, vault.ResealOptions{
+		BatchSize: *batch,
+		DryRun:    *dryRun,
+		Actor:     operator.ID,
+		Progress: func(s vault.ResealStats) {
+			fmt.Fprintf(os.Stderr, "scanned %d, resealed %d, already bound %d\n", s.Scanned, s.Resealed, s.Bound)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	verb := "Resealed"
+	if *dryRun {
+		verb = "Would reseal"
+	}
+	fmt.Printf("%s %d values (%d already bound, %d skipped, %d failed)\n",
+		verb, stats.Resealed, stats.Bound, stats.Skipped, stats.Failed)
+	if stats.Failed > 0 {
+		return fmt.Errorf("%d values could not be decrypted", stats.Failed)
+	}
+	return nil
+}
+
+// adminRotateMasterKey re-wraps every project DEK with the active master key.
+// Re-running it resumes an interrupted rotation.
+func adminRotateMasterKey(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("masterkey rotate", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the operator starting the rotation (required)")
+	batch := fs.Int("batch", vault.DefaultRotationBatchSize, "projects per transaction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return fmt.Errorf("--email is required")
+	}
+
+	keys, err := loadKeyRing(ctx)
+	if err != nil {
+		return err
+	}
+
+	store, closeDB, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	operator, err := store.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return fmt.Errorf("user %q not found: %w", *email, err)
+	}
+
+	result, err := vault.New(keys).RotateMasterKey(ctx, store, vault.RotationOptions{
+		BatchSize: *batch,
+		Actor:     operator.ID,
+		Progress: func(job repository.MasterKeyRotation) {
+			fmt.Fprintf(os.Stderr, "scanned %d projects, re-wrapped %d\n", job.ProjectsScanned, job.ProjectsRotated)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Re-wrapped %d project keys with master key v%d\n", result.ItemsRotated, result.NewKeyVersion)
+	fmt.Println("Previous master keys can be removed from MASTER_KEY_PREVIOUS")
+	return nil
+}
+
+// adminMasterKeyStatus prints the latest master key rotation job
+func adminMasterKeyStatus(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("masterkey status", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, closeDB, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	job, err := store.GetLatestMasterKeyRotation(ctx)
+	if errors.Is(err, pgx.ErrNoRows) {
+		fmt.Println("No master key rotation has been started")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Job:       %s\n", job.ID)
+	fmt.Printf("Target:    master key v%d\n", job.TargetVersion)
+	fmt.Printf("Status:    %s\n", job.Status)
+	fmt.Printf("Progress:  %d projects scanned, %d re-wrapped\n", job.ProjectsScanned, job.ProjectsRotated)
+	fmt.Printf("Started:   %s\n", job.StartedAt.Format(time.RFC3339))
+	fmt.Printf("Updated:   %s\n", job.UpdatedAt.Format(time.RFC3339))
+	if job.CompletedAt.Valid {
+		fmt.Printf("Completed: %s\n", job.CompletedAt.Time.Format(time.RFC3339))
+	}
+	if job.LastError != nil {
+		fmt.Printf("Error:     %s\n", *job.LastError)
+	}
+	return nil
+}
+
+// adminSealAudit seals audit rows the server has not sealed yet, signing
+// checkpoints if AUDIT_SIGNING_KEY is set
+func adminSealAudit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("audit seal", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := audit.LoadSigningKeyFromEnv()
+	if err != nil {
+		return err
+	}
+
+	store, closeDB, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	var opts []audit.SealerOption
+	if key != nil {
+		opts = append(opts, audit.WithSigningKey(key))
+	}
+	n, err := audit.NewSealer(store, opts...).Seal(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sealed %d rows\n", n)
+	return nil
+}
+
+// adminVerifyAudit verifies the audit hash chains and fails if one is broken
+func adminVerifyAudit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	chain := fs.String("chain", "", "verify only this chain (access_logs or secret_history)")
+	publicKey := fs.String("public-key", "", "base64 checkpoint public key (default: derived from AUDIT_SIGNING_KEY)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var pub ed25519.PublicKey
+	if *publicKey != "" {
+		var err error
+		if pub, err = audit.ParsePublicKey(*publicKey); err != nil {
+			return err
+		}
+	} else {
+		key, err := audit.LoadSigningKeyFromEnv()
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			pub = key.Public().(ed25519.PublicKey)
+		}
+	}
+
+	chains := audit.Chains
+	if *chain != "" {
+		chains = []audit.Chain{audit.Chain(*chain)}
+	}
+
+	store, closeDB, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	broken := 0
+	for _, c := range chains {
+		report, err := audit.Verify(ctx, store, c, pub)
+		if err != nil {
+			return fmt.Errorf("verify %s: %w", c, err)
+		}
+
+		fmt.Printf("%s: %d links, %d checkpoints verified", c, report.Links, report.Checkpoints)
+		if report.UnknownKeys > 0 {
+			fmt.Printf(", %d checkpoints not checked", report.UnknownKeys)
+		}
+		fmt.Println()
+		if report.Broken != nil {
+			fmt.Printf("  BROKEN at %s\n", report.Broken)
+			broken++
+		}
+	}
+
+	if pub == nil {
+		fmt.Fprintln(os.Stderr, "No public key given: checkpoints were not checked")
+	}
+	if broken > 0 {
+		return fmt.Errorf("%d audit chains are broken", broken)
+	}
+	return nil
+}
+
+// adminAuditKeygen prints a new checkpoint signing key and its public key
+func adminAuditKeygen(args []string) error {
+	fs := flag.NewFlagSet("audit keygen", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("AUDIT_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(key.Seed()))
+	fmt.Printf("Public key:  %s\n", base64.StdEncoding.EncodeToString(pub))
+	fmt.Printf("Key id:      %s\n", audit.KeyID(pub))
+	return nil
+}
+
+// openStore connects to the database configured in the environment
+func openStore(ctx context.Context) (*repository.SQLStore, func(), error) {
+	dbConfig, err := database.LoadConfigFromEnv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load database config: %w", err)
+	}
+
+	pool, err := database.NewPool(ctx, dbConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return repository.NewStore(pool), func() { database.Close(pool) }, nil
+}
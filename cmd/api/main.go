@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,11 +18,22 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/Now-Tiger/envhub/internal/api"
+	"github.com/Now-Tiger/envhub/internal/audit"
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/repository"
 	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
 	"github.com/Now-Tiger/envhub/pkg/database"
 )
 
 func main() {
+	// Operator subcommands (e.g. `api token create`) run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runAdmin(os.Args[1:]))
+	}
+
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -50,6 +64,103 @@ func main() {
 		stats.AcquiredConns(),
 	)
 
+	// Load master keys (KEKs) used to unwrap project DEKs
+	keys, err := loadKeyRing(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load master key: %v", err)
+		return
+	}
+
+	var apiOpts []api.Option
+
+	// Optional login with JWTs from an external identity provider
+	oidcConfig, oidcEnabled, err := auth.LoadOIDCConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load OIDC config: %v", err)
+		return
+	}
+	if oidcEnabled {
+		verifier, err := auth.NewVerifierFromConfig(oidcConfig)
+		if err != nil {
+			log.Fatalf("Failed to initialise OIDC verifier: %v", err)
+			return
+		}
+		apiOpts = append(apiOpts, api.WithVerifier(verifier))
+		log.Printf("🔐 OIDC login enabled for issuer %s", oidcConfig.Issuer)
+	}
+
+	// Refuse secret values that are not bound to their identity once
+	// `api secrets reseal` has migrated existing rows
+	var vaultOpts []vault.Option
+	if os.Getenv("REQUIRE_SECRET_BINDING") == "true" {
+		vaultOpts = append(vaultOpts, vault.WithRequiredBinding())
+	}
+
+	// Environments created with new projects
+	templates, err := loadEnvironmentTemplates()
+	if err != nil {
+		log.Fatalf("Failed to load default environments: %v", err)
+		return
+	}
+	if templates != nil {
+		apiOpts = append(apiOpts, api.WithEnvironmentTemplates(templates))
+	}
+
+	store := repository.NewStore(pool)
+
+	// Access logs are written in the background and drained on shutdown
+	accessLog := audit.NewLogger(store)
+	apiOpts = append(apiOpts, api.WithAccessLog(accessLog))
+
+	// Audit rows are sealed into hash chains shortly after they are written
+	sealer, sealInterval, err := loadSealer(store)
+	if err != nil {
+		log.Fatalf("Failed to load audit signing key: %v", err)
+		return
+	}
+	sealCtx, stopSealing := context.WithCancel(ctx)
+	go sealer.Run(sealCtx, sealInterval)
+
+	// Environments created with a TTL are deleted once they expire
+	reapInterval, err := loadInterval("ENVIRONMENT_REAP_INTERVAL", api.DefaultReapInterval)
+	if err != nil {
+		log.Fatalf("Failed to load reaper settings: %v", err)
+		return
+	}
+	reapCtx, stopReaping := context.WithCancel(ctx)
+	go api.NewReaper(store).Run(reapCtx, reapInterval)
+
+	// Secrets past their expiry or rotation deadline are flagged
+	reminder, remindInterval, err := loadReminder(store)
+	if err != nil {
+		log.Fatalf("Failed to load secret reminder settings: %v", err)
+		return
+	}
+	remindCtx, stopReminding := context.WithCancel(ctx)
+	go reminder.Run(remindCtx, remindInterval)
+
+	v := vault.New(keys, vaultOpts...)
+
+	// Roles of expired dynamic secret leases are dropped from their targets
+	revokeInterval, err := loadInterval("LEASE_REVOKE_INTERVAL", api.DefaultRevokeInterval)
+	if err != nil {
+		log.Fatalf("Failed to load lease revoker settings: %v", err)
+		return
+	}
+	revokeCtx, stopRevoking := context.WithCancel(ctx)
+	go api.NewLeaseRevoker(store, v).Run(revokeCtx, revokeInterval)
+
+	// Events are delivered to the webhooks subscribed to them
+	webhookInterval, err := loadInterval("WEBHOOK_DELIVERY_INTERVAL", api.DefaultWebhookInterval)
+	if err != nil {
+		log.Fatalf("Failed to load webhook settings: %v", err)
+		return
+	}
+	webhookCtx, stopWebhooks := context.WithCancel(ctx)
+	go api.NewWebhookSender(store, v).Run(webhookCtx, webhookInterval)
+
+	apiServer := api.NewServer(store, v, apiOpts...)
+
 	// Initialize new router
 	r := chi.NewRouter()
 
@@ -63,6 +174,7 @@ func main() {
 	// Routes
 	r.Get("/health", healthCheckHandler(pool))
 	r.Get("/health/db", dbHealthCheckHandler(pool))
+	r.Mount("/v1", apiServer.Routes())
 
 	// Get port from environment
 	port := os.Getenv("PORT")
@@ -103,9 +215,133 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	stopReaping()
+	stopReminding()
+	stopRevoking()
+	stopWebhooks()
+
+	// Requests have finished, so every access log entry has been queued
+	if err := accessLog.Close(shutdownCtx); err != nil {
+		log.Printf("Access log not fully flushed: %v", err)
+	}
+
+	// Seal the last entries now rather than leave them for the next start
+	stopSealing()
+	if _, err := sealer.Seal(shutdownCtx); err != nil {
+		log.Printf("Audit rows not sealed: %v", err)
+	}
+
 	log.Println("✅ Server exited gracefully")
 }
 
+// loadKeyRing loads the active KEK from the provider selected by
+// MASTER_KEY_PROVIDER, plus any previous KEKs from MASTER_KEY_PREVIOUS
+func loadKeyRing(ctx context.Context) (*crypto.KeyRing, error) {
+	cfg, err := crypto.LoadKeyProviderConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	keys, err := crypto.LoadKeyRing(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s provider: %w", cfg.Kind, err)
+	}
+
+	log.Printf("🔑 Master %s loaded from %s provider", keys, cfg.Kind)
+	return keys, nil
+}
+
+// loadSealer creates the audit sealer, signing checkpoints with
+// AUDIT_SIGNING_KEY if it is set, and reads AUDIT_SEAL_INTERVAL
+func loadSealer(store repository.Store) (*audit.Sealer, time.Duration, error) {
+	interval := audit.DefaultSealInterval
+	if v := os.Getenv("AUDIT_SEAL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, 0, fmt.Errorf("invalid AUDIT_SEAL_INTERVAL %q", v)
+		}
+		interval = d
+	}
+
+	key, err := audit.LoadSigningKeyFromEnv()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var opts []audit.SealerOption
+	if key != nil {
+		opts = append(opts, audit.WithSigningKey(key))
+		log.Printf("🔏 Audit checkpoints signed with key %s", audit.KeyID(key.Public().(ed25519.PublicKey)))
+	}
+	return audit.NewSealer(store, opts...), interval, nil
+}
+
+// loadInterval reads a positive duration from the environment variable
+// name, or returns def if it is unset
+func loadInterval(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return d, nil
+}
+
+// loadReminder creates the secret reminder, which deactivates expired
+// secrets if DEACTIVATE_EXPIRED_SECRETS is true, and reads
+// SECRET_REMINDER_INTERVAL
+func loadReminder(store repository.Store) (*api.Reminder, time.Duration, error) {
+	interval, err := loadInterval("SECRET_REMINDER_INTERVAL", api.DefaultRemindInterval)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var deactivate bool
+	if v := os.Getenv("DEACTIVATE_EXPIRED_SECRETS"); v != "" {
+		if deactivate, err = strconv.ParseBool(v); err != nil {
+			return nil, 0, fmt.Errorf("invalid DEACTIVATE_EXPIRED_SECRETS %q", v)
+		}
+	}
+	if deactivate {
+		log.Println("⏳ Expired secrets are left out of reads")
+	}
+	return api.NewReminder(store, api.WithDeactivateExpired(deactivate)), interval, nil
+}
+
+// loadEnvironmentTemplates reads the environments created with new
+// projects from DEFAULT_ENVIRONMENTS, a JSON array of templates such as
+// [{"name":"dev"},{"name":"prod","protected":true,"required_approvals":2}].
+// It returns nil if the variable is unset.
+func loadEnvironmentTemplates() ([]api.EnvironmentTemplate, error) {
+	v := os.Getenv("DEFAULT_ENVIRONMENTS")
+	if v == "" {
+		return nil, nil
+	}
+
+	templates := []api.EnvironmentTemplate{}
+	dec := json.NewDecoder(strings.NewReader(v))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&templates); err != nil {
+		return nil, fmt.Errorf("invalid DEFAULT_ENVIRONMENTS: %w", err)
+	}
+	if err := api.ValidateEnvironmentTemplates(templates); err != nil {
+		return nil, fmt.Errorf("invalid DEFAULT_ENVIRONMENTS: %w", err)
+	}
+
+	names := make([]string, len(templates))
+	for i, t := range templates {
+		names[i] = t.Name
+	}
+	log.Printf("🌱 New projects get environments [%s]", strings.Join(names, ", "))
+	return templates, nil
+}
+
 // healthCheckHandler returns a simple health check
 func healthCheckHandler(pool *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
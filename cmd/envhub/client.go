@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds each API call; it does not apply to the child
+// process started by `envhub run`
+const requestTimeout = 30 * time.Second
+
+// client calls the EnvHub API with a bearer token
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newClient(baseURL, token string) *client {
+	return &client{
+		baseURL: strings.TrimSuffix(baseURL, "/") + "/v1",
+		token:   token,
+		http:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// apiError is a failure response from the API
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+// secret is a decrypted secret as listed by the API
+type secret struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+
+	// ReferenceError is set if the API could not expand the references in
+	// Value, which is then the stored value
+	ReferenceError string `json:"reference_error"`
+}
+
+// importResult is the API's summary of a .env import
+type importResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	Skipped   []string `json:"skipped"`
+}
+
+// changeRequest is the pending change request an import into a protected
+// environment returns
+type changeRequest struct {
+	ID                string `json:"id"`
+	RequiredApprovals int    `json:"required_approvals"`
+}
+
+// diffEntry is one key that differs between two environments
+type diffEntry struct {
+	Key         string  `json:"key"`
+	SourceValue *string `json:"source_value"`
+	TargetValue *string `json:"target_value"`
+}
+
+// environmentDiff compares a source environment to a target
+type environmentDiff struct {
+	Added     []diffEntry `json:"added"`
+	Removed   []diffEntry `json:"removed"`
+	Changed   []diffEntry `json:"changed"`
+	Unchanged []string    `json:"unchanged"`
+}
+
+// promoteResult is the API's summary of a promotion
+type promoteResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+}
+
+// do sends a request and returns the response if it succeeded. Failures
+// are returned as *apiError.
+func (c *client) do(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("User-Agent", "envhub-cli")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || envelope.Message == "" {
+		envelope.Message = http.StatusText(resp.StatusCode)
+	}
+	return nil, &apiError{Status: resp.StatusCode, Message: envelope.Message}
+}
+
+// decode reads the data of a success envelope into v
+func decode(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("unexpected response from server: %w", err)
+	}
+	return json.Unmarshal(envelope.Data, v)
+}
+
+// verifyToken checks that the server accepts the client's token
+func (c *client) verifyToken(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/tokens", nil, nil)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusForbidden {
+		// Valid, but scoped to other resources
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func environmentPath(project, env string) string {
+	return "/projects/" + url.PathEscape(project) + "/environments/" + url.PathEscape(env)
+}
+
+func secretsPath(project, env string) string {
+	return environmentPath(project, env) + "/secrets"
+}
+
+// listSecrets returns the decrypted secrets of an environment
+func (c *client) listSecrets(ctx context.Context, project, env string) ([]secret, error) {
+	resp, err := c.do(ctx, http.MethodGet, secretsPath(project, env), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var secrets []secret
+	if err := decode(resp, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// exportSecrets returns an environment's secrets as a file in format, with
+// references left unexpanded if raw, and the keys whose references could
+// not be expanded
+func (c *client) exportSecrets(ctx context.Context, project, env, format string, raw bool) ([]byte, []string, error) {
+	query := url.Values{"format": {format}}
+	if raw {
+		query.Set("raw", "true")
+	}
+	resp, err := c.do(ctx, http.MethodGet, secretsPath(project, env)+"/export", query, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header.Values("X-Envhub-Unresolved-References"), err
+}
+
+// importSecrets uploads a .env file. Exactly one of the results is set:
+// the change request if the environment is protected, the import summary
+// otherwise.
+func (c *client) importSecrets(ctx context.Context, project, env string, query url.Values, file io.Reader) (*importResult, *changeRequest, error) {
+	resp, err := c.do(ctx, http.MethodPost, secretsPath(project, env)+"/import", query, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		var cr changeRequest
+		return nil, &cr, decode(resp, &cr)
+	}
+	var result importResult
+	return &result, nil, decode(resp, &result)
+}
+
+// diffEnvironments compares the secrets of env with those of target
+func (c *client) diffEnvironments(ctx context.Context, project, env, target string, reveal bool) (*environmentDiff, error) {
+	query := url.Values{"target": {target}}
+	if reveal {
+		query.Set("reveal", "true")
+	}
+	resp, err := c.do(ctx, http.MethodGet, environmentPath(project, env)+"/diff", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var diff environmentDiff
+	if err := decode(resp, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// promoteSecrets copies keys of env to target. Like importSecrets, it
+// returns a change request instead if the target is protected.
+func (c *client) promoteSecrets(ctx context.Context, project, env, target string, keys []string, reason string) (*promoteResult, *changeRequest, error) {
+	body := map[string]any{"target": target, "keys": keys}
+	if reason != "" {
+		body["reason"] = reason
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, environmentPath(project, env)+"/promote", nil, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		var cr changeRequest
+		return nil, &cr, decode(resp, &cr)
+	}
+	var result promoteResult
+	return &result, nil, decode(resp, &result)
+}
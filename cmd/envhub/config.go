@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// defaultURL is the server used until `envhub login --url` names another
+const defaultURL = "http://localhost:8080"
+
+// config is what `envhub login` stores, in the user's config directory
+type config struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// configPath returns $ENVHUB_CONFIG, or config.json in the user's config
+// directory (e.g. ~/.config/envhub on Linux)
+func (c *cli) configPath() (string, error) {
+	if path := c.getenv("ENVHUB_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "envhub", "config.json"), nil
+}
+
+// loadConfig reads the stored config. A missing file is an empty config.
+func (c *cli) loadConfig() (config, error) {
+	var cfg config
+	path, err := c.configPath()
+	if err != nil {
+		return cfg, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, errors.New(path + " is not valid JSON")
+	}
+	return cfg, nil
+}
+
+// saveConfig writes cfg readable by the current user only, since it holds
+// their token
+func (c *cli) saveConfig(cfg config) (string, error) {
+	path, err := c.configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Write a sibling file and rename it, so a failed write never leaves a
+	// truncated config behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// client returns an API client for the server and token from the
+// environment (ENVHUB_URL, ENVHUB_TOKEN) or the stored config
+func (c *cli) client() (*client, error) {
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if v := c.getenv("ENVHUB_URL"); v != "" {
+		cfg.URL = v
+	}
+	if v := c.getenv("ENVHUB_TOKEN"); v != "" {
+		cfg.Token = v
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("not logged in: run `envhub login` or set ENVHUB_TOKEN")
+	}
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+	return newClient(cfg.URL, cfg.Token), nil
+}
+
+// environmentFlags holds the --project and --env flags shared by commands
+// that act on one environment
+type environmentFlags struct {
+	project string
+	env     string
+}
+
+func (c *cli) environmentFlags(flags *flag.FlagSet) *environmentFlags {
+	f := &environmentFlags{}
+	flags.StringVar(&f.project, "project", c.getenv("ENVHUB_PROJECT"), "project id (default $ENVHUB_PROJECT)")
+	flags.StringVar(&f.env, "env", c.getenv("ENVHUB_ENV"), "environment name (default $ENVHUB_ENV)")
+	return f
+}
+
+func (f *environmentFlags) validate() error {
+	switch {
+	case f.project == "" && f.env == "":
+		return errors.New("--project and --env are required")
+	case f.project == "":
+		return errors.New("--project is required")
+	case f.env == "":
+		return errors.New("--env is required")
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+// Command envhub is the developer CLI for EnvHub. It stores an API token,
+// runs programs with an environment's secrets injected, and moves secrets
+// in and out of .env files and between environments.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// cli holds the process state commands use, so tests can run them in-process
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+}
+
+// exitCode is returned by commands that exit with a specific status
+// without an error message, e.g. the status of the child of `envhub run`
+type exitCode int
+
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func main() {
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, getenv: os.Getenv}
+	os.Exit(c.main(os.Args[1:]))
+}
+
+// main runs the command in args and returns the process exit code
+func (c *cli) main(args []string) int {
+	if len(args) == 0 {
+		c.printUsage()
+		return 2
+	}
+
+	ctx := context.Background()
+
+	var err error
+	switch args[0] {
+	case "login":
+		err = c.login(ctx, args[1:])
+	case "run":
+		err = c.run(ctx, args[1:])
+	case "pull":
+		err = c.pull(ctx, args[1:])
+	case "push":
+		err = c.push(ctx, args[1:])
+	case "diff":
+		err = c.diff(ctx, args[1:])
+	case "promote":
+		err = c.promote(ctx, args[1:])
+	case "help", "-h", "--help":
+		c.printUsage()
+		return 0
+	default:
+		c.printUsage()
+		return 2
+	}
+
+	var code exitCode
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &code):
+		return int(code)
+	case errors.Is(err, flag.ErrHelp):
+		return 2
+	}
+	fmt.Fprintf(c.stderr, "error: %v\n", err)
+	return 1
+}
+
+func (c *cli) printUsage() {
+	fmt.Fprintln(c.stderr, `usage: envhub <command> [flags]
+
+Commands:
+  login    Store the server URL and an API token
+  run      Run a command with an environment's secrets in its environment
+  pull     Download an environment's secrets as a .env, JSON, YAML or shell file
+  push     Upload a .env file to an environment
+  diff     Compare the secrets of two environments
+  promote  Copy secrets from one environment to another
+
+ENVHUB_URL and ENVHUB_TOKEN override the stored login; ENVHUB_PROJECT and
+ENVHUB_ENV provide defaults for --project and --env.
+Run "envhub <command> -h" for the flags of a command.`)
+}
+
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("envhub "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// login verifies a token against the server and stores both. The token is
+// read from stdin unless --token is given, so it stays out of shell history.
+func (c *cli) login(ctx context.Context, args []string) error {
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+
+	fs := c.flagSet("login")
+	fs.StringVar(&cfg.URL, "url", cfg.URL, "server URL")
+	token := fs.String("token", "", "API token (read from stdin if omitted)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --url %q", cfg.URL)
+	}
+
+	cfg.Token = *token
+	if cfg.Token == "" {
+		fmt.Fprint(c.stderr, "API token: ")
+		line, err := bufio.NewReader(c.stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return errors.New("no token given")
+		}
+		cfg.Token = strings.TrimSpace(line)
+	}
+	if cfg.Token == "" {
+		return errors.New("no token given")
+	}
+
+	if err := newClient(cfg.URL, cfg.Token).verifyToken(ctx); err != nil {
+		return fmt.Errorf("token rejected by %s: %w", cfg.URL, err)
+	}
+	path, err := c.saveConfig(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "Logged in to %s (saved to %s)\n", cfg.URL, path)
+	return nil
+}
+
+// pull writes an environment's secrets to --output, or stdout
+func (c *cli) pull(ctx context.Context, args []string) error {
+	fs := c.flagSet("pull")
+	env := c.environmentFlags(fs)
+	format := fs.String("format", "dotenv", "dotenv, json, yaml or shell")
+	output := fs.String("output", "", "file to write (default stdout)")
+	raw := fs.Bool("raw", false, "keep ${...} references instead of expanding them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := env.validate(); err != nil {
+		return err
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	data, unresolved, err := api.exportSecrets(ctx, env.project, env.env, *format, *raw)
+	if err != nil {
+		return err
+	}
+	for _, key := range unresolved {
+		fmt.Fprintf(c.stderr, "warning: %s: references could not be expanded, kept as stored\n", key)
+	}
+
+	if *output == "" {
+		_, err = c.stdout.Write(data)
+		return err
+	}
+	// The file holds plaintext secrets; keep it private to the user
+	if err := os.WriteFile(*output, data, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "Wrote %s secrets to %s\n", env.env, *output)
+	return nil
+}
+
+// push imports a .env file into an environment and prints what changed
+func (c *cli) push(ctx context.Context, args []string) error {
+	fs := c.flagSet("push")
+	env := c.environmentFlags(fs)
+	file := fs.String("file", ".env", `file to upload ("-" for stdin)`)
+	mode := fs.String("mode", "merge", "merge keeps existing values that differ; overwrite replaces them")
+	dryRun := fs.Bool("dry-run", false, "show what would change without writing")
+	reason := fs.String("reason", "", "reason recorded on the change request in protected environments")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := env.validate(); err != nil {
+		return err
+	}
+
+	var in io.Reader = c.stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	query := url.Values{"mode": {*mode}}
+	if *dryRun {
+		query.Set("dry_run", "true")
+	}
+	if *reason != "" {
+		query.Set("reason", *reason)
+	}
+	result, cr, err := api.importSecrets(ctx, env.project, env.env, query, in)
+	if err != nil {
+		return err
+	}
+
+	if cr != nil {
+		c.printChangeRequest(env.env, cr)
+		return nil
+	}
+	for _, k := range result.Created {
+		fmt.Fprintf(c.stdout, "+ %s\n", k)
+	}
+	for _, k := range result.Updated {
+		fmt.Fprintf(c.stdout, "~ %s\n", k)
+	}
+	for _, k := range result.Skipped {
+		fmt.Fprintf(c.stdout, "! %s differs (kept; use --mode overwrite to replace)\n", k)
+	}
+	summary := fmt.Sprintf("%d created, %d updated, %d unchanged, %d skipped",
+		len(result.Created), len(result.Updated), len(result.Unchanged), len(result.Skipped))
+	if *dryRun {
+		summary += " (dry run, nothing written)"
+	}
+	fmt.Fprintln(c.stdout, summary)
+	return nil
+}
+
+// printChangeRequest reports a write to a protected environment that
+// awaits approval
+func (c *cli) printChangeRequest(env string, cr *changeRequest) {
+	fmt.Fprintf(c.stdout, "%s is protected: change request %s needs %d approval(s) before it is applied\n", env, cr.ID, cr.RequiredApprovals)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/api"
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// childMode makes the test binary act as the child of `envhub run`
+const childMode = "ENVHUB_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(childMode) {
+	case "":
+		os.Exit(m.Run())
+	case "print":
+		// Print the variables named in the arguments
+		for _, name := range os.Args[1:] {
+			fmt.Printf("%s=%s\n", name, os.Getenv(name))
+		}
+	case "exit":
+		code, _ := strconv.Atoi(os.Args[1])
+		os.Exit(code)
+	case "trap":
+		// Exit with 42 on SIGTERM, once ready to receive it
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM)
+		fmt.Println("ready")
+		select {
+		case <-sigs:
+			os.Exit(42)
+		case <-time.After(10 * time.Second):
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+// e2e is an in-process API server with a project and "dev" and "staging"
+// environments, and a CLI with its own config directory
+type e2e struct {
+	url     string
+	token   string
+	project string
+	env     map[string]string
+	stdin   string
+	stdout  bytes.Buffer
+	stderr  bytes.Buffer
+}
+
+func newE2E(t *testing.T) *e2e {
+	t.Helper()
+
+	mk, err := crypto.GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dek, err := crypto.GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedDEK, err := crypto.EncryptDEK(dek, mk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := crypto.NewKeyRing(mk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := repotest.NewMemStore()
+	user := store.AddUser(repository.User{Email: "dev@example.com"})
+	token, _, err := auth.IssueToken(t.Context(), store, auth.IssueParams{UserID: user.ID, Name: "cli"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orgID := uuid.New()
+	store.AddMember(repository.OrganizationMember{OrganizationID: orgID, UserID: user.ID, Role: repository.OrgRoleOwner})
+	project := store.AddProject(repository.Project{OrganizationID: orgID, Name: "api", EncryptedDek: encryptedDEK})
+	store.AddEnvironment(repository.Environment{ProjectID: project.ID, Name: "dev"})
+	store.AddEnvironment(repository.Environment{ProjectID: project.ID, Name: "staging"})
+
+	r := chi.NewRouter()
+	r.Mount("/v1", api.NewServer(store, vault.New(keys)).Routes())
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return &e2e{
+		url:     srv.URL,
+		token:   token,
+		project: project.ID.String(),
+		env:     map[string]string{"ENVHUB_CONFIG": filepath.Join(t.TempDir(), "envhub", "config.json")},
+	}
+}
+
+// envhub runs the CLI with args and returns its exit code. Output
+// accumulates in e.stdout and e.stderr.
+func (e *e2e) envhub(t *testing.T, args ...string) int {
+	t.Helper()
+
+	c := &cli{
+		stdin:  strings.NewReader(e.stdin),
+		stdout: &e.stdout,
+		stderr: &e.stderr,
+		getenv: func(name string) string { return e.env[name] },
+	}
+	return c.main(args)
+}
+
+func (e *e2e) login(t *testing.T) {
+	t.Helper()
+
+	e.stdin = e.token + "\n"
+	if code := e.envhub(t, "login", "--url", e.url); code != 0 {
+		t.Fatalf("login exited with %d: %s", code, &e.stderr)
+	}
+}
+
+func (e *e2e) reset() {
+	e.stdout.Reset()
+	e.stderr.Reset()
+}
+
+func TestLogin(t *testing.T) {
+	e := newE2E(t)
+
+	if code := e.envhub(t, "pull", "--project", e.project, "--env", "dev"); code != 1 || !strings.Contains(e.stderr.String(), "not logged in") {
+		t.Errorf("Expected pull to fail before login, got %d: %s", code, &e.stderr)
+	}
+
+	e.reset()
+	if code := e.envhub(t, "login", "--url", e.url, "--token", "ehb_wrong"); code != 1 || !strings.Contains(e.stderr.String(), "token rejected") {
+		t.Errorf("Expected a bad token to be rejected, got %d: %s", code, &e.stderr)
+	}
+	if _, err := os.Stat(e.env["ENVHUB_CONFIG"]); !os.IsNotExist(err) {
+		t.Errorf("Expected no config to be saved for a bad token, got %v", err)
+	}
+
+	e.login(t)
+	info, err := os.Stat(e.env["ENVHUB_CONFIG"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the config to be private, got %v", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(e.env["ENVHUB_CONFIG"])
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil || cfg.URL != e.url || cfg.Token != e.token {
+		t.Errorf("Unexpected config %s (%v)", data, err)
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	e := newE2E(t)
+	e.login(t)
+	env := []string{"--project", e.project, "--env", "dev"}
+
+	file := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(file, []byte("export API_KEY=sk_test_123\nCERT=\"line1\nline2\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e.reset()
+	if code := e.envhub(t, append([]string{"push", "--file", file, "--dry-run"}, env...)...); code != 0 {
+		t.Fatalf("push --dry-run exited with %d: %s", code, &e.stderr)
+	}
+	if want := "+ API_KEY\n+ CERT\n2 created, 0 updated, 0 unchanged, 0 skipped (dry run, nothing written)\n"; e.stdout.String() != want {
+		t.Errorf("Expected %q, got %q", want, &e.stdout)
+	}
+
+	e.reset()
+	if code := e.envhub(t, append([]string{"push", "--file", file}, env...)...); code != 0 {
+		t.Fatalf("push exited with %d: %s", code, &e.stderr)
+	}
+
+	// A changed value is kept unless overwriting
+	e.reset()
+	e.stdin = "API_KEY=sk_live_456\n"
+	e.envhub(t, append([]string{"push", "--file", "-"}, env...)...)
+	if !strings.Contains(e.stdout.String(), "! API_KEY differs") {
+		t.Errorf("Expected API_KEY to be skipped, got %q", &e.stdout)
+	}
+	e.reset()
+	e.envhub(t, append([]string{"push", "--file", "-", "--mode", "overwrite"}, env...)...)
+	if !strings.Contains(e.stdout.String(), "~ API_KEY") {
+		t.Errorf("Expected API_KEY to be updated, got %q", &e.stdout)
+	}
+
+	e.reset()
+	if code := e.envhub(t, append([]string{"pull"}, env...)...); code != 0 {
+		t.Fatalf("pull exited with %d: %s", code, &e.stderr)
+	}
+	if want := "API_KEY=sk_live_456\nCERT=\"line1\\nline2\"\n"; e.stdout.String() != want {
+		t.Errorf("Expected %q, got %q", want, &e.stdout)
+	}
+
+	out := filepath.Join(t.TempDir(), "dev.json")
+	if code := e.envhub(t, append([]string{"pull", "--format", "json", "--output", out}, env...)...); code != 0 {
+		t.Fatalf("pull --output exited with %d: %s", code, &e.stderr)
+	}
+	var got map[string]string
+	data, _ := os.ReadFile(out)
+	if err := json.Unmarshal(data, &got); err != nil || got["CERT"] != "line1\nline2" {
+		t.Errorf("Unexpected JSON export %s (%v)", data, err)
+	}
+
+	e.reset()
+	if code := e.envhub(t, "pull", "--project", e.project, "--env", "prod"); code != 1 || !strings.Contains(e.stderr.String(), "environment not found (HTTP 404)") {
+		t.Errorf("Expected an API error, got %d: %s", code, &e.stderr)
+	}
+}
+
+func TestDiffPromote(t *testing.T) {
+	e := newE2E(t)
+	e.login(t)
+	e.env["ENVHUB_PROJECT"] = e.project
+	e.stdin = "A=1\nB=new\nC=3\n"
+	e.envhub(t, "push", "--env", "dev", "--file", "-")
+	e.stdin = "B=old\nD=4\n"
+	e.envhub(t, "push", "--env", "staging", "--file", "-")
+
+	e.reset()
+	if code := e.envhub(t, "diff", "--env", "dev", "--target", "staging"); code != 0 {
+		t.Fatalf("diff exited with %d: %s", code, &e.stderr)
+	}
+	if want := "+ A\n+ C\n- D\n~ B\n2 only in dev, 1 only in staging, 1 changed, 0 unchanged\n"; e.stdout.String() != want {
+		t.Errorf("Expected %q, got %q", want, &e.stdout)
+	}
+	e.reset()
+	e.envhub(t, "diff", "--env", "dev", "--target", "staging", "--reveal")
+	if !strings.Contains(e.stdout.String(), `~ B: "old" -> "new"`) || !strings.Contains(e.stdout.String(), `+ A="1"`) {
+		t.Errorf("Expected revealed values, got %q", &e.stdout)
+	}
+
+	e.reset()
+	if code := e.envhub(t, "promote", "--env", "dev", "--target", "staging", "A"); code != 0 {
+		t.Fatalf("promote exited with %d: %s", code, &e.stderr)
+	}
+	if want := "+ A\n1 created, 0 updated, 0 unchanged in staging\n"; e.stdout.String() != want {
+		t.Errorf("Expected %q, got %q", want, &e.stdout)
+	}
+
+	e.reset()
+	if code := e.envhub(t, "promote", "--env", "dev", "--target", "staging", "--all"); code != 0 {
+		t.Fatalf("promote --all exited with %d: %s", code, &e.stderr)
+	}
+	if want := "+ C\n~ B\n1 created, 1 updated, 0 unchanged in staging\n"; e.stdout.String() != want {
+		t.Errorf("Expected %q, got %q", want, &e.stdout)
+	}
+	e.reset()
+	e.envhub(t, "promote", "--env", "dev", "--target", "staging", "--all")
+	if want := "staging is up to date with dev\n"; e.stdout.String() != want {
+		t.Errorf("Expected %q, got %q", want, &e.stdout)
+	}
+
+	e.reset()
+	if code := e.envhub(t, "promote", "--env", "dev", "--target", "staging"); code != 1 || !strings.Contains(e.stderr.String(), "--all") {
+		t.Errorf("Expected promote without keys to fail, got %d: %s", code, &e.stderr)
+	}
+}
+
+func TestRun(t *testing.T) {
+	e := newE2E(t)
+	e.login(t)
+	e.stdin = "API_KEY=sk_test_123\nHOME=/from/envhub\nMULTI=\"a\nb\"\nLOCAL=${MISSING}/bin\n"
+	e.envhub(t, "push", "--project", e.project, "--env", "dev", "--file", "-")
+
+	// Project and environment may come from the environment
+	e.env["ENVHUB_PROJECT"] = e.project
+	e.env["ENVHUB_ENV"] = "dev"
+
+	t.Setenv(childMode, "print")
+	t.Setenv("UNRELATED", "kept")
+	e.reset()
+	code := e.envhub(t, "run", "--", os.Args[0], "API_KEY", "HOME", "UNRELATED", "MULTI", "LOCAL")
+	if code != 0 {
+		t.Fatalf("run exited with %d: %s", code, &e.stderr)
+	}
+	if want := "API_KEY=sk_test_123\nHOME=/from/envhub\nUNRELATED=kept\nMULTI=a\nb\nLOCAL=${MISSING}/bin\n"; e.stdout.String() != want {
+		t.Errorf("Expected %q, got %q", want, &e.stdout)
+	}
+	if want := "warning: LOCAL: ${MISSING}: secret not found, kept as stored\n"; e.stderr.String() != want {
+		t.Errorf("Expected %q, got %q", want, &e.stderr)
+	}
+
+	t.Setenv(childMode, "exit")
+	if code := e.envhub(t, "run", "--", os.Args[0], "3"); code != 3 {
+		t.Errorf("Expected the child's exit code 3, got %d", code)
+	}
+
+	e.reset()
+	if code := e.envhub(t, "run", "--", "envhub-no-such-command"); code != 127 {
+		t.Errorf("Expected 127 for a missing command, got %d: %s", code, &e.stderr)
+	}
+	if code := e.envhub(t, "run"); code != 1 || !strings.Contains(e.stderr.String(), "no command given") {
+		t.Errorf("Expected an error without a command, got %d", code)
+	}
+}
+
+// readyWriter closes ready once "ready" has been written
+type readyWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (w *readyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.buf.Write(p)
+	if strings.Contains(w.buf.String(), "ready") {
+		w.once.Do(func() { close(w.ready) })
+	}
+	return n, err
+}
+
+func TestRunForwardsSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals cannot be sent on Windows")
+	}
+
+	e := newE2E(t)
+	e.login(t)
+	t.Setenv(childMode, "trap")
+
+	stdout := &readyWriter{ready: make(chan struct{})}
+	c := &cli{
+		stdin:  strings.NewReader(""),
+		stdout: stdout,
+		stderr: &e.stderr,
+		getenv: func(name string) string { return e.env[name] },
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	go func() {
+		select {
+		case <-stdout.ready:
+			// Sent to the CLI, which relays it to the child
+			self, _ := os.FindProcess(os.Getpid())
+			_ = self.Signal(syscall.SIGTERM)
+		case <-ctx.Done():
+		}
+	}()
+
+	code := c.main([]string{"run", "--project", e.project, "--env", "dev", "--", os.Args[0]})
+	if code != 42 {
+		t.Errorf("Expected the child to exit with 42 after SIGTERM, got %d: %s", code, &e.stderr)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// diff prints how the secrets of --env differ from those of --target
+func (c *cli) diff(ctx context.Context, args []string) error {
+	fs := c.flagSet("diff")
+	env := c.environmentFlags(fs)
+	target := fs.String("target", "", "environment to compare with (required)")
+	reveal := fs.Bool("reveal", false, "show the values that differ")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := env.validate(); err != nil {
+		return err
+	}
+	if *target == "" {
+		return errors.New("--target is required")
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	diff, err := api.diffEnvironments(ctx, env.project, env.env, *target, *reveal)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range diff.Added {
+		fmt.Fprintf(c.stdout, "+ %s%s\n", d.Key, revealed(d.SourceValue))
+	}
+	for _, d := range diff.Removed {
+		fmt.Fprintf(c.stdout, "- %s%s\n", d.Key, revealed(d.TargetValue))
+	}
+	for _, d := range diff.Changed {
+		if d.SourceValue != nil && d.TargetValue != nil {
+			fmt.Fprintf(c.stdout, "~ %s: %s -> %s\n", d.Key, strconv.Quote(*d.TargetValue), strconv.Quote(*d.SourceValue))
+		} else {
+			fmt.Fprintf(c.stdout, "~ %s\n", d.Key)
+		}
+	}
+	fmt.Fprintf(c.stdout, "%d only in %s, %d only in %s, %d changed, %d unchanged\n",
+		len(diff.Added), env.env, len(diff.Removed), *target, len(diff.Changed), len(diff.Unchanged))
+	return nil
+}
+
+func revealed(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return "=" + strconv.Quote(*value)
+}
+
+// promote copies the keys given as arguments, or with --all every key
+// that is new or changed, from --env to --target
+func (c *cli) promote(ctx context.Context, args []string) error {
+	fs := c.flagSet("promote")
+	env := c.environmentFlags(fs)
+	target := fs.String("target", "", "environment to copy to (required)")
+	all := fs.Bool("all", false, "promote every key that is missing or different in the target")
+	reason := fs.String("reason", "", "reason recorded on the change request if the target is protected")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: envhub promote --project <id> --env <from> --target <to> [--all | KEY...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := env.validate(); err != nil {
+		return err
+	}
+	if *target == "" {
+		return errors.New("--target is required")
+	}
+	keys := fs.Args()
+	if *all && len(keys) > 0 || !*all && len(keys) == 0 {
+		return errors.New("name the keys to promote, or pass --all")
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	if *all {
+		diff, err := api.diffEnvironments(ctx, env.project, env.env, *target, false)
+		if err != nil {
+			return err
+		}
+		for _, d := range append(diff.Added, diff.Changed...) {
+			keys = append(keys, d.Key)
+		}
+		if len(keys) == 0 {
+			fmt.Fprintf(c.stdout, "%s is up to date with %s\n", *target, env.env)
+			return nil
+		}
+	}
+
+	result, cr, err := api.promoteSecrets(ctx, env.project, env.env, *target, keys, *reason)
+	if err != nil {
+		return err
+	}
+	if cr != nil {
+		c.printChangeRequest(*target, cr)
+		return nil
+	}
+	for _, k := range result.Created {
+		fmt.Fprintf(c.stdout, "+ %s\n", k)
+	}
+	for _, k := range result.Updated {
+		fmt.Fprintf(c.stdout, "~ %s\n", k)
+	}
+	fmt.Fprintf(c.stdout, "%d created, %d updated, %d unchanged in %s\n", len(result.Created), len(result.Updated), len(result.Unchanged), *target)
+	return nil
+}
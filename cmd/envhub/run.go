@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// forwardedSignals are relayed to the child of `envhub run` instead of
+// stopping the CLI, so the child decides how to shut down
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// run starts a command with the secrets of an environment added to its
+// environment. Secrets are only held in memory and take precedence over
+// variables already set. The CLI waits for the command, relaying signals
+// to it, and exits with its status.
+func (c *cli) run(ctx context.Context, args []string) error {
+	fs := c.flagSet("run")
+	env := c.environmentFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: envhub run --project <id> --env <name> -- <command> [args...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := env.validate(); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no command given, e.g. envhub run --project <id> --env dev -- npm start")
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	secrets, err := api.listSecrets(ctx, env.project, env.env)
+	if err != nil {
+		return err
+	}
+	for _, s := range secrets {
+		if s.ReferenceError != "" {
+			fmt.Fprintf(c.stderr, "warning: %s, kept as stored\n", s.ReferenceError)
+		}
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Env = childEnv(os.Environ(), secrets)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = c.stdin, c.stdout, c.stderr
+
+	// Start listening first so no signal slips past between start and relay
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(c.stderr, "error: %v\n", err)
+		// The shell convention for a command that could not be run
+		return exitCode(127)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				_ = cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+
+	return childExit(err)
+}
+
+// childEnv returns environ with secrets added, replacing variables of the
+// same name
+func childEnv(environ []string, secrets []secret) []string {
+	override := make(map[string]bool, len(secrets))
+	for _, s := range secrets {
+		override[s.Key] = true
+	}
+
+	env := make([]string, 0, len(environ)+len(secrets))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if !override[name] {
+			env = append(env, kv)
+		}
+	}
+	for _, s := range secrets {
+		env = append(env, s.Key+"="+s.Value)
+	}
+	return env
+}
+
+// childExit maps the result of waiting for the child to the CLI's exit
+// status. A child killed by a signal exits with 128+signal, like a shell.
+func childExit(err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return exitCode(128 + int(status.Signal()))
+	}
+	return exitCode(exitErr.ExitCode())
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+)
+
+// Resource types recorded in access_logs
+const (
+	resourceProject       = "project"
+	resourceEnvironment   = "environment"
+	resourceSecret        = "secret"
+	resourceChangeRequest = "change_request"
+	resourceDynamicSecret = "dynamic_secret"
+	resourceWebhook       = "webhook"
+)
+
+// maxErrorBody bounds how much of a failure response is kept to extract
+// its message
+const maxErrorBody = 4096
+
+// accessActions maps the policy actions checked by loadProject and
+// loadEnvironment to the action recorded in access_logs
+var accessActions = map[policy.Action]repository.AccessAction{
+	policy.ProjectRead:          repository.AccessActionRead,
+	policy.ProjectUpdate:        repository.AccessActionUpdate,
+	policy.ProjectDelete:        repository.AccessActionDelete,
+	policy.ProjectRotateKey:     repository.AccessActionUpdate,
+	policy.EnvironmentRead:      repository.AccessActionRead,
+	policy.EnvironmentCreate:    repository.AccessActionCreate,
+	policy.EnvironmentUpdate:    repository.AccessActionUpdate,
+	policy.EnvironmentDelete:    repository.AccessActionDelete,
+	policy.SecretRead:           repository.AccessActionRead,
+	policy.SecretCreate:         repository.AccessActionCreate,
+	policy.SecretUpdate:         repository.AccessActionUpdate,
+	policy.SecretDelete:         repository.AccessActionDelete,
+	policy.ChangeRequestApprove: repository.AccessActionApprove,
+}
+
+type accessKey struct{}
+
+// accessEntry is one resource a request accessed
+type accessEntry struct {
+	resourceType string
+	resourceID   uuid.UUID
+	action       repository.AccessAction
+}
+
+// accessRecord collects what a request accessed. The target starts as the
+// project or environment in the URL and is narrowed by handlers once they
+// resolve the secret or change request; related lists further resources,
+// e.g. each secret returned by a listing.
+type accessRecord struct {
+	organizationID uuid.UUID
+	target         *accessEntry
+	related        []accessEntry
+}
+
+// setAccessOrganization records the organization owning the resources
+// accessed, so organization admins can search the entries
+func setAccessOrganization(r *http.Request, id uuid.UUID) {
+	if rec, ok := r.Context().Value(accessKey{}).(*accessRecord); ok {
+		rec.organizationID = id
+	}
+}
+
+// setAccessTarget names the resource the request acts on
+func setAccessTarget(r *http.Request, resourceType string, id uuid.UUID, action repository.AccessAction) {
+	if rec, ok := r.Context().Value(accessKey{}).(*accessRecord); ok {
+		rec.target = &accessEntry{resourceType: resourceType, resourceID: id, action: action}
+	}
+}
+
+// addAccess records a further resource the request accessed
+func addAccess(r *http.Request, entries ...accessEntry) {
+	if rec, ok := r.Context().Value(accessKey{}).(*accessRecord); ok {
+		rec.related = append(rec.related, entries...)
+	}
+}
+
+// logAccess records the resources each request accessed in access_logs,
+// with the caller, client and outcome. Entries are handed to the audit
+// logger after the response is written.
+func (s *Server) logAccess(next http.Handler) http.Handler {
+	if s.accessLog == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecord{}
+		aw := &accessWriter{ResponseWriter: w}
+
+		r = r.WithContext(context.WithValue(r.Context(), accessKey{}, rec))
+		next.ServeHTTP(aw, r)
+
+		if rec.target == nil {
+			return
+		}
+
+		base := repository.CreateAccessLogParams{
+			IpAddress: clientAddr(r),
+			Success:   aw.status < http.StatusBadRequest,
+			CreatedAt: start,
+		}
+		if rec.organizationID != uuid.Nil {
+			base.OrganizationID = pgtype.UUID{Bytes: rec.organizationID, Valid: true}
+		}
+		if p, ok := auth.PrincipalFromContext(r.Context()); ok {
+			base.UserID = pgtype.UUID{Bytes: p.UserID, Valid: true}
+			if p.TokenID != uuid.Nil {
+				base.ApiTokenID = pgtype.UUID{Bytes: p.TokenID, Valid: true}
+			}
+		}
+		if ua := r.UserAgent(); ua != "" {
+			base.UserAgent = &ua
+		}
+		if !base.Success {
+			msg := aw.errorMessage()
+			base.ErrorMessage = &msg
+		}
+
+		for _, e := range append([]accessEntry{*rec.target}, rec.related...) {
+			entry := base
+			entry.ResourceType = e.resourceType
+			entry.ResourceID = e.resourceID
+			entry.Action = e.action
+			s.accessLog.Log(entry)
+		}
+	})
+}
+
+// accessWriter records the status of a response and the body of failures
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && w.body.Len() < maxErrorBody {
+		w.body.Write(b[:min(len(b), maxErrorBody-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// errorMessage returns the message of the failure envelope written, or
+// the status text if there is none
+func (w *accessWriter) errorMessage() string {
+	var resp utils.ErrorResponse
+	if err := json.Unmarshal(w.body.Bytes(), &resp); err == nil && resp.Message != "" {
+		return resp.Message
+	}
+	return http.StatusText(w.status)
+}
+
+// clientAddr returns the request's remote IP, if it can be parsed.
+// middleware.RealIP may have replaced RemoteAddr with a bare IP.
+func clientAddr(r *http.Request) *netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil
+	}
+	return &addr
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+func TestAccessLogging(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+
+	rec := te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "v1"})
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+
+	req := httptest.NewRequest(http.MethodGet, "/projects/"+te.project.ID.String()+"/environments/dev/secrets/API_KEY", nil)
+	req.Header.Set("Authorization", "Bearer "+te.token)
+	req.Header.Set("User-Agent", "envhub-cli/1.0")
+	te.handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	te.do(t, http.MethodGet, base, nil)
+	te.do(t, http.MethodGet, base+"MISSING", nil)
+	te.do(t, http.MethodGet, "/tokens/", nil)
+
+	viewer := te.loginAs(t, repository.OrgRoleViewer)
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "v2"})
+
+	logs := te.accessLogs(t)
+	type access struct {
+		resourceType string
+		resourceID   string
+		action       repository.AccessAction
+		success      bool
+	}
+	secretID, envID := secret.ID.String(), te.env.ID.String()
+	want := []access{
+		{resourceSecret, secretID, repository.AccessActionCreate, true},
+		{resourceSecret, secretID, repository.AccessActionRead, true},
+		{resourceEnvironment, envID, repository.AccessActionRead, true}, // listing
+		{resourceSecret, secretID, repository.AccessActionRead, true},
+		{resourceEnvironment, envID, repository.AccessActionRead, false}, // missing secret
+		{resourceEnvironment, envID, repository.AccessActionUpdate, false},
+	}
+	if len(logs) != len(want) {
+		t.Fatalf("Expected %d entries, got %+v", len(want), logs)
+	}
+	for i, l := range logs {
+		got := access{l.ResourceType, l.ResourceID.String(), l.Action, l.Success}
+		if got != want[i] {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, want[i], got)
+		}
+		if l.IpAddress == nil || l.IpAddress.String() != "192.0.2.1" {
+			t.Errorf("Entry %d: expected client IP, got %v", i, l.IpAddress)
+		}
+		if !l.ApiTokenID.Valid {
+			t.Errorf("Entry %d: expected the API token", i)
+		}
+	}
+
+	if logs[0].UserID.Bytes != te.user.ID || logs[0].UserAgent != nil || logs[0].ErrorMessage != nil {
+		t.Errorf("Unexpected create entry %+v", logs[0])
+	}
+	if logs[1].UserAgent == nil || *logs[1].UserAgent != "envhub-cli/1.0" {
+		t.Errorf("Expected user agent, got %v", logs[1].UserAgent)
+	}
+	if msg := logs[4].ErrorMessage; msg == nil || *msg != "secret not found" {
+		t.Errorf("Expected not found message, got %v", msg)
+	}
+	if logs[5].UserID.Bytes != viewer.ID || logs[5].ErrorMessage == nil || *logs[5].ErrorMessage != "role viewer is not allowed to secret:update" {
+		t.Errorf("Unexpected forbidden entry %+v", logs[5])
+	}
+}
@@ -0,0 +1,460 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+)
+
+// Page sizes for audit searches and exports
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	exportPageSize    = 1000
+)
+
+// Export formats
+const (
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// accessLogResponse is the public representation of an access_logs entry
+type accessLogResponse struct {
+	ID           uuid.UUID               `json:"id"`
+	CreatedAt    time.Time               `json:"created_at"`
+	UserID       *uuid.UUID              `json:"user_id"`
+	ApiTokenID   *uuid.UUID              `json:"api_token_id"`
+	ResourceType string                  `json:"resource_type"`
+	ResourceID   uuid.UUID               `json:"resource_id"`
+	Action       repository.AccessAction `json:"action"`
+	Success      bool                    `json:"success"`
+	ErrorMessage *string                 `json:"error_message"`
+	IPAddress    *string                 `json:"ip_address"`
+	UserAgent    *string                 `json:"user_agent"`
+}
+
+var accessLogCSVHeader = []string{
+	"id", "created_at", "user_id", "api_token_id", "resource_type", "resource_id",
+	"action", "success", "error_message", "ip_address", "user_agent",
+}
+
+func (l accessLogResponse) csvRecord() []string {
+	return []string{
+		l.ID.String(), l.CreatedAt.Format(time.RFC3339Nano), csvUUID(l.UserID), csvUUID(l.ApiTokenID),
+		l.ResourceType, l.ResourceID.String(), string(l.Action), strconv.FormatBool(l.Success),
+		csvString(l.ErrorMessage), csvString(l.IPAddress), csvString(l.UserAgent),
+	}
+}
+
+// auditHistoryResponse is a secret history entry found by an audit search
+type auditHistoryResponse struct {
+	secretVersionResponse
+	SecretID      uuid.UUID `json:"secret_id"`
+	EnvironmentID uuid.UUID `json:"environment_id"`
+	Key           string    `json:"key"`
+}
+
+var auditHistoryCSVHeader = []string{
+	"id", "created_at", "secret_id", "environment_id", "key", "version",
+	"action", "restored_version", "changed_by",
+}
+
+func (h auditHistoryResponse) csvRecord() []string {
+	restored := ""
+	if h.RestoredVersion != nil {
+		restored = strconv.Itoa(int(*h.RestoredVersion))
+	}
+	return []string{
+		h.ID.String(), h.CreatedAt.Format(time.RFC3339Nano), h.SecretID.String(), h.EnvironmentID.String(),
+		h.Key, strconv.Itoa(int(h.Version)), string(h.Action), restored, h.ChangedBy.String(),
+	}
+}
+
+// exportRow is an entry that can be written as NDJSON or CSV
+type exportRow interface {
+	csvRecord() []string
+}
+
+// searchAccessLogs returns an organization's access log entries matching
+// the query filters, newest first
+func (s *Server) searchAccessLogs(w http.ResponseWriter, r *http.Request) {
+	params, ok := s.accessLogSearch(w, r)
+	if !ok {
+		return
+	}
+
+	logs, err := s.store.SearchAccessLogs(r.Context(), params)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := make([]accessLogResponse, 0, len(logs))
+	for _, l := range logs {
+		resp = append(resp, toAccessLogResponse(l))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// exportAccessLogs streams every matching access log entry as NDJSON or CSV
+func (s *Server) exportAccessLogs(w http.ResponseWriter, r *http.Request) {
+	params, ok := s.accessLogSearch(w, r)
+	if !ok {
+		return
+	}
+	params.Limit = exportPageSize
+
+	s.export(w, r, "access-logs", accessLogCSVHeader, func() ([]exportRow, error) {
+		logs, err := s.store.SearchAccessLogs(r.Context(), params)
+		if err != nil || len(logs) == 0 {
+			return nil, err
+		}
+		params.Before = pgtype.UUID{Bytes: logs[len(logs)-1].ID, Valid: true}
+
+		rows := make([]exportRow, 0, len(logs))
+		for _, l := range logs {
+			rows = append(rows, toAccessLogResponse(l))
+		}
+		return rows, nil
+	})
+}
+
+// searchSecretHistory returns an organization's secret history entries
+// matching the query filters, newest first. Values are never included.
+func (s *Server) searchSecretHistory(w http.ResponseWriter, r *http.Request) {
+	params, ok := s.secretHistorySearch(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := s.store.SearchSecretHistory(r.Context(), params)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := make([]auditHistoryResponse, 0, len(history))
+	for _, h := range history {
+		resp = append(resp, toAuditHistoryResponse(h))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// exportSecretHistory streams every matching secret history entry as
+// NDJSON or CSV
+func (s *Server) exportSecretHistory(w http.ResponseWriter, r *http.Request) {
+	params, ok := s.secretHistorySearch(w, r)
+	if !ok {
+		return
+	}
+	params.Limit = exportPageSize
+
+	s.export(w, r, "secret-history", auditHistoryCSVHeader, func() ([]exportRow, error) {
+		history, err := s.store.SearchSecretHistory(r.Context(), params)
+		if err != nil || len(history) == 0 {
+			return nil, err
+		}
+		params.Before = pgtype.UUID{Bytes: history[len(history)-1].ID, Valid: true}
+
+		rows := make([]exportRow, 0, len(history))
+		for _, h := range history {
+			rows = append(rows, toAuditHistoryResponse(h))
+		}
+		return rows, nil
+	})
+}
+
+// accessLogSearch authorizes the caller and parses the access log filters.
+// It writes an error response and returns ok=false otherwise.
+func (s *Server) accessLogSearch(w http.ResponseWriter, r *http.Request) (repository.SearchAccessLogsParams, bool) {
+	var params repository.SearchAccessLogsParams
+
+	orgID, ok := s.loadAuditOrganization(w, r)
+	if !ok {
+		return params, false
+	}
+	f, msg := parseAuditFilter(r)
+	if msg == "" {
+		params = repository.SearchAccessLogsParams{
+			OrganizationID: orgID,
+			UserID:         f.user,
+			Since:          f.since,
+			Until:          f.until,
+			Before:         f.before,
+			Limit:          f.limit,
+		}
+		q := r.URL.Query()
+		if v := q.Get("resource_type"); v != "" {
+			params.ResourceType = &v
+		}
+		params.ResourceID, msg = parseUUIDParam(q.Get("resource_id"), "resource_id")
+	}
+	if msg == "" {
+		if v := r.URL.Query().Get("action"); v != "" {
+			action := repository.AccessAction(v)
+			if !action.Valid() {
+				msg = "invalid action"
+			}
+			params.Action = repository.NullAccessAction{AccessAction: action, Valid: true}
+		}
+	}
+	if msg == "" {
+		if v := r.URL.Query().Get("success"); v != "" {
+			success, err := strconv.ParseBool(v)
+			if err != nil {
+				msg = "success must be true or false"
+			}
+			params.Success = &success
+		}
+	}
+	if msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return params, false
+	}
+	return params, true
+}
+
+// secretHistorySearch authorizes the caller and parses the secret history
+// filters. It writes an error response and returns ok=false otherwise.
+func (s *Server) secretHistorySearch(w http.ResponseWriter, r *http.Request) (repository.SearchSecretHistoryParams, bool) {
+	var params repository.SearchSecretHistoryParams
+
+	orgID, ok := s.loadAuditOrganization(w, r)
+	if !ok {
+		return params, false
+	}
+	f, msg := parseAuditFilter(r)
+	if msg == "" {
+		params = repository.SearchSecretHistoryParams{
+			OrganizationID: orgID,
+			ChangedBy:      f.user,
+			Since:          f.since,
+			Until:          f.until,
+			Before:         f.before,
+			Limit:          f.limit,
+		}
+		params.SecretID, msg = parseUUIDParam(r.URL.Query().Get("secret_id"), "secret_id")
+	}
+	if msg == "" {
+		params.EnvironmentID, msg = parseUUIDParam(r.URL.Query().Get("environment_id"), "environment_id")
+	}
+	if msg == "" {
+		if v := r.URL.Query().Get("action"); v != "" {
+			action := repository.SecretAction(v)
+			if !action.Valid() {
+				msg = "invalid action"
+			}
+			params.Action = repository.NullSecretAction{SecretAction: action, Valid: true}
+		}
+	}
+	if msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return params, false
+	}
+	return params, true
+}
+
+// loadAuditOrganization checks that the caller may read the audit trail of
+// the organization named in the URL
+func (s *Server) loadAuditOrganization(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	orgID, err := uuid.Parse(chi.URLParam(r, "orgID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid organization id")
+		return uuid.Nil, false
+	}
+	if !s.authorize(w, r, policy.AuditRead, auth.Target{OrganizationID: orgID}) {
+		return uuid.Nil, false
+	}
+	return orgID, true
+}
+
+// auditFilter holds the query filters shared by audit searches
+type auditFilter struct {
+	user   pgtype.UUID
+	since  pgtype.Timestamptz
+	until  pgtype.Timestamptz
+	before pgtype.UUID
+	limit  int32
+}
+
+// parseAuditFilter reads ?user_id=, ?since=, ?until= (RFC 3339), ?before=
+// and ?limit=. It returns a non-empty message if one is invalid.
+func parseAuditFilter(r *http.Request) (auditFilter, string) {
+	q := r.URL.Query()
+	f := auditFilter{limit: defaultAuditLimit}
+
+	var msg string
+	if f.user, msg = parseUUIDParam(q.Get("user_id"), "user_id"); msg != "" {
+		return f, msg
+	}
+	if f.before, msg = parseUUIDParam(q.Get("before"), "before cursor"); msg != "" {
+		return f, msg
+	}
+	if f.since, msg = parseTimeParam(q.Get("since"), "since"); msg != "" {
+		return f, msg
+	}
+	if f.until, msg = parseTimeParam(q.Get("until"), "until"); msg != "" {
+		return f, msg
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			return f, "limit must be between 1 and 1000"
+		}
+		f.limit = int32(n)
+	}
+	return f, ""
+}
+
+func parseUUIDParam(v, name string) (pgtype.UUID, string) {
+	if v == "" {
+		return pgtype.UUID{}, ""
+	}
+	id, err := uuid.Parse(v)
+	if err != nil {
+		return pgtype.UUID{}, "invalid " + name
+	}
+	return pgtype.UUID{Bytes: id, Valid: true}, ""
+}
+
+func parseTimeParam(v, name string) (pgtype.Timestamptz, string) {
+	if v == "" {
+		return pgtype.Timestamptz{}, ""
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return pgtype.Timestamptz{}, name + " must be an RFC 3339 time"
+	}
+	return pgtype.Timestamptz{Time: t, Valid: true}, ""
+}
+
+// export streams the pages returned by next until it returns none, as
+// NDJSON (the default) or CSV per ?format=. The export is not wrapped in
+// the JSON envelope; if a page fails midway the response ends early, and
+// the export can be resumed with ?before= set to the last id received.
+func (s *Server) export(w http.ResponseWriter, r *http.Request, name string, header []string, next func() ([]exportRow, error)) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatNDJSON
+	}
+	if format != formatNDJSON && format != formatCSV {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "format must be ndjson or csv")
+		return
+	}
+
+	rows, err := next()
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	// Large exports outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	var (
+		enc *json.Encoder
+		cw  *csv.Writer
+	)
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+		cw = csv.NewWriter(w)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc = json.NewEncoder(w)
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+"."+format+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	if cw != nil {
+		_ = cw.Write(header)
+	}
+	for len(rows) > 0 {
+		for _, row := range rows {
+			if cw != nil {
+				err = cw.Write(row.csvRecord())
+			} else {
+				err = enc.Encode(row)
+			}
+			if err != nil {
+				log.Printf("api: %s export aborted: %v", name, err)
+				return
+			}
+		}
+		if cw != nil {
+			cw.Flush()
+		}
+		_ = rc.Flush()
+
+		if rows, err = next(); err != nil {
+			log.Printf("api: %s export aborted: %v", name, err)
+			return
+		}
+	}
+}
+
+func toAccessLogResponse(l repository.AccessLog) accessLogResponse {
+	resp := accessLogResponse{
+		ID:           l.ID,
+		CreatedAt:    l.CreatedAt,
+		UserID:       uuidPtr(l.UserID),
+		ApiTokenID:   uuidPtr(l.ApiTokenID),
+		ResourceType: l.ResourceType,
+		ResourceID:   l.ResourceID,
+		Action:       l.Action,
+		Success:      l.Success,
+		ErrorMessage: l.ErrorMessage,
+		UserAgent:    l.UserAgent,
+	}
+	if l.IpAddress != nil {
+		ip := l.IpAddress.String()
+		resp.IPAddress = &ip
+	}
+	return resp
+}
+
+func toAuditHistoryResponse(h repository.SecretHistory) auditHistoryResponse {
+	return auditHistoryResponse{
+		secretVersionResponse: toSecretVersionResponse(h),
+		SecretID:              h.SecretID,
+		EnvironmentID:         h.EnvironmentID,
+		Key:                   h.Key,
+	}
+}
+
+func uuidPtr(id pgtype.UUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	u := uuid.UUID(id.Bytes)
+	return &u
+}
+
+func csvUUID(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+func csvString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
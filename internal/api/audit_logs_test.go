@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// seedAudit makes a few logged requests and returns the created secret
+func seedAudit(t *testing.T, te *testEnv) (secretResponse, []repository.AccessLog) {
+	t.Helper()
+
+	base := te.secretsPath()
+	rec := te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "v1"})
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "DB_URL", Value: "postgres://"})
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "v2"})
+	te.do(t, http.MethodGet, base+"API_KEY", nil)
+
+	ownerToken := te.token
+	te.loginAs(t, repository.OrgRoleViewer)
+	te.do(t, http.MethodDelete, base+"API_KEY", nil)
+	te.token = ownerToken
+
+	// An entry of another organization must never show up
+	_, _ = te.store.CreateAccessLog(t.Context(), repository.CreateAccessLogParams{
+		ResourceType:   resourceSecret,
+		ResourceID:     uuid.New(),
+		Action:         repository.AccessActionRead,
+		Success:        true,
+		CreatedAt:      time.Now(),
+		OrganizationID: pgtype.UUID{Bytes: uuid.New(), Valid: true},
+	})
+
+	return secret, te.accessLogs(t)
+}
+
+func (te *testEnv) auditPath(kind string, query url.Values) string {
+	return "/organizations/" + te.project.OrganizationID.String() + "/audit/" + kind + "?" + query.Encode()
+}
+
+func TestSearchAccessLogs(t *testing.T) {
+	te := newTestEnv(t)
+	secret, logs := seedAudit(t, te)
+	ownLogs := len(logs) - 1
+
+	search := func(query url.Values) []accessLogResponse {
+		t.Helper()
+		rec := te.do(t, http.MethodGet, te.auditPath("access-logs", query), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("search %v: expected 200, got %d: %s", query, rec.Code, rec.Body)
+		}
+		var got []accessLogResponse
+		decodeData(t, rec, &got)
+		return got
+	}
+
+	all := search(nil)
+	if len(all) != ownLogs {
+		t.Fatalf("Expected %d entries of this organization, got %d", ownLogs, len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].CreatedAt.After(all[i-1].CreatedAt) {
+			t.Fatal("Expected entries newest first")
+		}
+	}
+
+	failed := search(url.Values{"success": {"false"}})
+	if len(failed) != 1 || failed[0].Action != repository.AccessActionDelete || failed[0].ErrorMessage == nil {
+		t.Errorf("Expected the viewer's denied delete, got %+v", failed)
+	}
+
+	updates := search(url.Values{"resource_id": {secret.ID.String()}, "action": {"update"}})
+	if len(updates) != 1 || updates[0].UserID == nil || *updates[0].UserID != te.user.ID {
+		t.Errorf("Expected the owner's update of %s, got %+v", secret.Key, updates)
+	}
+
+	if got := search(url.Values{"since": {time.Now().Add(time.Hour).Format(time.RFC3339)}}); len(got) != 0 {
+		t.Errorf("Expected no entries in the future, got %d", len(got))
+	}
+	if got := search(url.Values{"user_id": {te.user.ID.String()}, "resource_type": {resourceSecret}}); len(got) != ownLogs-1 {
+		t.Errorf("Expected %d secret entries by the owner, got %d", ownLogs-1, len(got))
+	}
+
+	// Keyset pages cover every entry exactly once
+	seen := make(map[uuid.UUID]bool)
+	query := url.Values{"limit": {"2"}}
+	for {
+		page := search(query)
+		if len(page) == 0 {
+			break
+		}
+		for _, l := range page {
+			if seen[l.ID] {
+				t.Fatalf("Entry %s returned twice", l.ID)
+			}
+			seen[l.ID] = true
+		}
+		query.Set("before", page[len(page)-1].ID.String())
+	}
+	if len(seen) != ownLogs {
+		t.Errorf("Expected pages to cover %d entries, got %d", ownLogs, len(seen))
+	}
+}
+
+func TestAuditRequestErrors(t *testing.T) {
+	te := newTestEnv(t)
+
+	for name, query := range map[string]url.Values{
+		"limit":   {"limit": {"0"}},
+		"since":   {"since": {"yesterday"}},
+		"action":  {"action": {"peek"}},
+		"success": {"success": {"maybe"}},
+		"user_id": {"user_id": {"nope"}},
+		"format":  {"format": {"xml"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := te.auditPath("access-logs", query)
+			if name == "format" {
+				path = te.auditPath("access-logs/export", query)
+			}
+			if rec := te.do(t, http.MethodGet, path, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400, got %d", rec.Code)
+			}
+		})
+	}
+
+	te.loginAs(t, repository.OrgRoleMember)
+	if rec := te.do(t, http.MethodGet, te.auditPath("access-logs", nil), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("member: expected 403, got %d", rec.Code)
+	}
+	te.loginAs(t, "")
+	if rec := te.do(t, http.MethodGet, te.auditPath("secret-history", nil), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("non-member: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestExportAccessLogs(t *testing.T) {
+	te := newTestEnv(t)
+	_, logs := seedAudit(t, te)
+	ownLogs := len(logs) - 1
+
+	rec := te.do(t, http.MethodGet, te.auditPath("access-logs/export", nil), nil)
+	if ct := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || ct != "application/x-ndjson" {
+		t.Fatalf("Expected NDJSON, got %d %q", rec.Code, ct)
+	}
+	lines := 0
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var l accessLogResponse
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("line %d: %v", lines, err)
+		}
+		lines++
+	}
+	if lines != ownLogs {
+		t.Errorf("Expected %d lines, got %d", ownLogs, lines)
+	}
+
+	rec = te.do(t, http.MethodGet, te.auditPath("access-logs/export", url.Values{"format": {"csv"}, "success": {"false"}}), nil)
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("read CSV: %v", err)
+	}
+	if len(records) != 2 || records[0][0] != "id" || records[1][6] != "delete" || records[1][7] != "false" {
+		t.Errorf("Unexpected CSV %v", records)
+	}
+}
+
+func TestSearchSecretHistory(t *testing.T) {
+	te := newTestEnv(t)
+	secret, _ := seedAudit(t, te)
+
+	rec := te.do(t, http.MethodGet, te.auditPath("secret-history", url.Values{"secret_id": {secret.ID.String()}}), nil)
+	var history []auditHistoryResponse
+	decodeData(t, rec, &history)
+	if len(history) != 2 || history[0].Action != repository.SecretActionUpdated || history[0].Version != 2 || history[0].Key != "API_KEY" {
+		t.Errorf("Unexpected history %+v", history)
+	}
+
+	rec = te.do(t, http.MethodGet, te.auditPath("secret-history", url.Values{"action": {"created"}}), nil)
+	decodeData(t, rec, &history)
+	if len(history) != 2 {
+		t.Errorf("Expected both creations, got %d", len(history))
+	}
+
+	rec = te.do(t, http.MethodGet, te.auditPath("secret-history/export", url.Values{"format": {"csv"}}), nil)
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("read CSV: %v", err)
+	}
+	if len(records) != 4 || records[1][4] != "API_KEY" || records[1][6] != "updated" {
+		t.Errorf("Unexpected CSV %v", records)
+	}
+}
+
+func TestSearchSecretHistoryOfDeletedEnvironment(t *testing.T) {
+	te := newTestEnv(t)
+	expired := te.addExpiring("pr-1", time.Now().Add(-time.Minute))
+	te.do(t, http.MethodPost, te.envPath("pr-1")+"/secrets/", createSecretRequest{Key: "API_KEY", Value: "v"})
+	if n, err := NewReaper(te.store).Reap(t.Context()); err != nil || n != 1 {
+		t.Fatalf("Expected 1 environment reaped, got %d (%v)", n, err)
+	}
+
+	// The history outlives the environment
+	rec := te.do(t, http.MethodGet, te.auditPath("secret-history", url.Values{"environment_id": {expired.ID.String()}}), nil)
+	var history []auditHistoryResponse
+	decodeData(t, rec, &history)
+	if len(history) != 2 || history[0].Action != repository.SecretActionDeleted || history[1].Action != repository.SecretActionCreated {
+		t.Errorf("Expected the creation and deletion of the reaped secret, got %+v", history)
+	}
+}
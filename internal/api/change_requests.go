@@ -0,0 +1,717 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/internal/vault"
+)
+
+// Page size bounds for the change request listing
+const (
+	defaultChangeRequestLimit = 50
+	maxChangeRequestLimit     = 200
+)
+
+var (
+	errChangeConflict      = errors.New("secret changed since the change request was made")
+	errChangeRequestClosed = errors.New("change request is no longer pending")
+	errSelfApproval        = errors.New("change requests cannot be approved by their requester")
+	errSecretExists        = errors.New("secret already exists")
+)
+
+// proposedChange is a secret write awaiting approval, before encryption
+type proposedChange struct {
+	Key             string
+	Operation       repository.ChangeOperation
+	Value           *string
+	Description     *string
+	RestoredVersion *int32
+}
+
+type changeRequestItemRequest struct {
+	Key         string                     `json:"key"`
+	Operation   repository.ChangeOperation `json:"operation"`
+	Value       *string                    `json:"value"`
+	Description *string                    `json:"description"`
+}
+
+type createChangeRequestRequest struct {
+	Reason  *string                    `json:"reason"`
+	Changes []changeRequestItemRequest `json:"changes"`
+}
+
+type decideChangeRequestRequest struct {
+	Comment *string `json:"comment"`
+}
+
+// changeRequestResponse is the public representation of a change request.
+// Proposed values are only included while the request is pending.
+type changeRequestResponse struct {
+	ID                uuid.UUID                      `json:"id"`
+	EnvironmentID     uuid.UUID                      `json:"environment_id"`
+	Status            repository.ChangeRequestStatus `json:"status"`
+	Reason            *string                        `json:"reason,omitempty"`
+	RequiredApprovals int32                          `json:"required_approvals"`
+	RequestedBy       uuid.UUID                      `json:"requested_by"`
+	DecidedBy         *uuid.UUID                     `json:"decided_by,omitempty"`
+	DecisionComment   *string                        `json:"decision_comment,omitempty"`
+	CreatedAt         time.Time                      `json:"created_at"`
+	DecidedAt         *time.Time                     `json:"decided_at,omitempty"`
+	Changes           []changeResponse               `json:"changes,omitempty"`
+	Approvals         []approvalResponse             `json:"approvals,omitempty"`
+}
+
+type changeResponse struct {
+	Key             string                     `json:"key"`
+	Operation       repository.ChangeOperation `json:"operation"`
+	Value           *string                    `json:"value,omitempty"`
+	Description     *string                    `json:"description,omitempty"`
+	BaseVersion     *int32                     `json:"base_version,omitempty"`
+	RestoredVersion *int32                     `json:"restored_version,omitempty"`
+}
+
+type approvalResponse struct {
+	ApprovedBy uuid.UUID `json:"approved_by"`
+	Comment    *string   `json:"comment,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// listChangeRequests returns an environment's change requests, newest
+// first, optionally filtered with ?status=
+func (s *Server) listChangeRequests(w http.ResponseWriter, r *http.Request) {
+	_, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+
+	params := repository.ListChangeRequestsByEnvironmentParams{
+		EnvironmentID: env.ID,
+		Limit:         defaultChangeRequestLimit,
+	}
+	if v := r.URL.Query().Get("status"); v != "" {
+		status := repository.ChangeRequestStatus(v)
+		if !status.Valid() {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid status")
+			return
+		}
+		params.Status = repository.NullChangeRequestStatus{ChangeRequestStatus: status, Valid: true}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxChangeRequestLimit {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		params.Limit = int32(n)
+	}
+
+	requests, err := s.store.ListChangeRequestsByEnvironment(r.Context(), params)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := make([]changeRequestResponse, 0, len(requests))
+	for _, cr := range requests {
+		resp = append(resp, toChangeRequestResponse(cr))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// getChangeRequest returns a change request with its changes and approvals
+func (s *Server) getChangeRequest(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+	id, ok := changeRequestID(w, r)
+	if !ok {
+		return
+	}
+	setAccessTarget(r, resourceChangeRequest, id, repository.AccessActionRead)
+
+	cr, err := s.store.GetChangeRequest(r.Context(), id)
+	if err == nil && cr.EnvironmentID != env.ID {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		s.writeStoreError(w, err, "change request not found")
+		return
+	}
+
+	// Reviewers need to see what they approve; values of closed requests
+	// are not shown
+	var reveal func(repository.ChangeRequestItem) (string, error)
+	if cr.Status == repository.ChangeRequestStatusPending {
+		dek, err := s.vault.DataKey(project)
+		if err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+		reveal = func(item repository.ChangeRequestItem) (string, error) {
+			id := vault.SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: item.Key, Version: proposedVersion(item.BaseVersion)}
+			return s.vault.DecryptValue(dek, id, *item.EncryptedValue)
+		}
+	}
+
+	resp, err := changeRequestDetails(r.Context(), s.store, cr, reveal)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// createChangeRequest proposes a batch of secret changes to an environment
+func (s *Server) createChangeRequest(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+
+	var req createChangeRequestRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Changes) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "changes are required")
+		return
+	}
+
+	changes := make([]proposedChange, 0, len(req.Changes))
+	seen := make(map[string]bool, len(req.Changes))
+	actions := make(map[policy.Action]bool, 3)
+	for _, c := range req.Changes {
+		if msg := validateSecretKey(c.Key); msg != "" {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, c.Key+": "+msg)
+			return
+		}
+		if seen[c.Key] {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, c.Key+": key appears more than once")
+			return
+		}
+		seen[c.Key] = true
+
+		switch c.Operation {
+		case repository.ChangeOperationCreate, repository.ChangeOperationUpdate:
+			if c.Value == nil {
+				utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, c.Key+": value is required")
+				return
+			}
+		case repository.ChangeOperationDelete:
+			if c.Value != nil || c.Description != nil {
+				utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, c.Key+": delete takes no value or description")
+				return
+			}
+		default:
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, c.Key+": operation must be create, update or delete")
+			return
+		}
+		actions[operationAction(c.Operation)] = true
+
+		changes = append(changes, proposedChange{
+			Key:         c.Key,
+			Operation:   c.Operation,
+			Value:       c.Value,
+			Description: c.Description,
+		})
+	}
+
+	// Proposing a change needs the same permission as making it
+	target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+	for _, action := range []policy.Action{policy.SecretCreate, policy.SecretUpdate, policy.SecretDelete} {
+		if actions[action] && !s.authorize(w, r, action, target) {
+			return
+		}
+	}
+
+	s.proposeChanges(w, r, project, env, req.Reason, changes...)
+}
+
+// proposeChanges encrypts changes to an environment and records them as a
+// pending change request instead of applying them. Values are bound to the
+// version they would give their secret.
+func (s *Server) proposeChanges(w http.ResponseWriter, r *http.Request, project repository.Project, env repository.Environment, reason *string, changes ...proposedChange) {
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	items := make([]repository.CreateChangeRequestItemParams, 0, len(changes))
+	for _, c := range changes {
+		item := repository.CreateChangeRequestItemParams{
+			Key:             c.Key,
+			Operation:       c.Operation,
+			Description:     c.Description,
+			RestoredVersion: c.RestoredVersion,
+		}
+		items = append(items, item)
+	}
+
+	var resp changeRequestResponse
+	err = s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		if err := vault.CheckDataKey(r.Context(), q, project.ID, dek); err != nil {
+			return err
+		}
+
+		// Record the version each change is based on, so it cannot apply
+		// over a concurrent edit. Creating a secret that was deactivated
+		// when it expired gives it a new version, and creating a deleted
+		// key continues the deleted secrets' versions.
+		for i, item := range items {
+			secret, err := q.GetSecretByKeyIncludingInactive(r.Context(), repository.GetSecretByKeyIncludingInactiveParams{
+				EnvironmentID: env.ID,
+				Key:           item.Key,
+			})
+			switch {
+			case item.Operation == repository.ChangeOperationCreate && err == nil && (secret.IsActive == nil || *secret.IsActive):
+				return errSecretExists
+			case item.Operation == repository.ChangeOperationCreate && errors.Is(err, pgx.ErrNoRows):
+				latest, err := q.GetLatestSecretVersion(r.Context(), repository.GetLatestSecretVersionParams{EnvironmentID: env.ID, Key: item.Key})
+				if err != nil {
+					return err
+				}
+				if latest > 0 {
+					items[i].BaseVersion = &latest
+				}
+			case err != nil:
+				return err
+			default:
+				items[i].BaseVersion = &secret.Version
+			}
+
+			if value := changes[i].Value; value != nil {
+				id := vault.SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: item.Key, Version: proposedVersion(items[i].BaseVersion)}
+				encrypted, err := s.vault.EncryptValue(dek, id, *value)
+				if err != nil {
+					return err
+				}
+				items[i].EncryptedValue = &encrypted
+			}
+		}
+
+		cr, err := q.CreateChangeRequest(r.Context(), repository.CreateChangeRequestParams{
+			EnvironmentID:     env.ID,
+			Reason:            reason,
+			RequiredApprovals: env.RequiredApprovals,
+			RequestedBy:       auth.UserIDFromContext(r.Context()).Bytes,
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			item.ChangeRequestID = cr.ID
+			if _, err := q.CreateChangeRequestItem(r.Context(), item); err != nil {
+				return err
+			}
+		}
+		setAccessTarget(r, resourceChangeRequest, cr.ID, repository.AccessActionCreate)
+
+		resp, err = changeRequestDetails(r.Context(), q, cr, nil)
+		return err
+	})
+	if err != nil {
+		s.writeChangeError(w, err, "secret not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, resp)
+}
+
+// approveChangeRequest records the caller's approval and applies the
+// change request once it has enough of them
+func (s *Server) approveChangeRequest(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.ChangeRequestApprove)
+	if !ok {
+		return
+	}
+	id, ok := changeRequestID(w, r)
+	if !ok {
+		return
+	}
+	setAccessTarget(r, resourceChangeRequest, id, repository.AccessActionApprove)
+	req, ok := decodeDecision(w, r)
+	if !ok {
+		return
+	}
+	approver := auth.UserIDFromContext(r.Context())
+
+	var (
+		resp    changeRequestResponse
+		applied []accessEntry
+	)
+	err := s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		cr, err := lockPendingChangeRequest(r.Context(), q, id, env.ID)
+		if err != nil {
+			return err
+		}
+		if cr.RequestedBy == approver.Bytes {
+			return errSelfApproval
+		}
+
+		if _, err := q.CreateChangeRequestApproval(r.Context(), repository.CreateChangeRequestApprovalParams{
+			ChangeRequestID: cr.ID,
+			ApprovedBy:      approver.Bytes,
+			Comment:         req.Comment,
+		}); err != nil {
+			return err
+		}
+
+		approvals, err := q.ListChangeRequestApprovals(r.Context(), cr.ID)
+		if err != nil {
+			return err
+		}
+		if len(approvals) >= int(cr.RequiredApprovals) {
+			applied, err = applyChangeRequest(r.Context(), q, project, env, cr)
+			if err != nil {
+				return err
+			}
+			cr, err = q.DecideChangeRequest(r.Context(), repository.DecideChangeRequestParams{
+				Status:    repository.ChangeRequestStatusApplied,
+				DecidedBy: approver,
+				ID:        cr.ID,
+			})
+			if err != nil {
+				return err
+			}
+			applied = append(applied, accessEntry{resourceChangeRequest, cr.ID, repository.AccessActionApply})
+		}
+
+		resp, err = changeRequestDetails(r.Context(), q, cr, nil)
+		return err
+	})
+	if err != nil {
+		s.writeChangeError(w, err, "change request not found")
+		return
+	}
+	addAccess(r, applied...)
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// rejectChangeRequest closes a pending change request without applying it
+func (s *Server) rejectChangeRequest(w http.ResponseWriter, r *http.Request) {
+	_, env, ok := s.loadEnvironment(w, r, policy.ChangeRequestApprove)
+	if !ok {
+		return
+	}
+	s.closeChangeRequest(w, r, env, repository.ChangeRequestStatusRejected)
+}
+
+// cancelChangeRequest lets the requester withdraw a pending change request
+func (s *Server) cancelChangeRequest(w http.ResponseWriter, r *http.Request) {
+	_, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+	s.closeChangeRequest(w, r, env, repository.ChangeRequestStatusCancelled)
+}
+
+// closeChangeRequest rejects or cancels a pending change request
+func (s *Server) closeChangeRequest(w http.ResponseWriter, r *http.Request, env repository.Environment, status repository.ChangeRequestStatus) {
+	id, ok := changeRequestID(w, r)
+	if !ok {
+		return
+	}
+	action := repository.AccessActionReject
+	if status == repository.ChangeRequestStatusCancelled {
+		action = repository.AccessActionCancel
+	}
+	setAccessTarget(r, resourceChangeRequest, id, action)
+
+	req, ok := decodeDecision(w, r)
+	if !ok {
+		return
+	}
+	caller := auth.UserIDFromContext(r.Context())
+
+	var resp changeRequestResponse
+	err := s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		cr, err := lockPendingChangeRequest(r.Context(), q, id, env.ID)
+		if err != nil {
+			return err
+		}
+		if status == repository.ChangeRequestStatusCancelled && cr.RequestedBy != caller.Bytes {
+			return policy.ErrForbidden
+		}
+
+		cr, err = q.DecideChangeRequest(r.Context(), repository.DecideChangeRequestParams{
+			Status:          status,
+			DecidedBy:       caller,
+			DecisionComment: req.Comment,
+			ID:              cr.ID,
+		})
+		if err != nil {
+			return err
+		}
+
+		resp, err = changeRequestDetails(r.Context(), q, cr, nil)
+		return err
+	})
+	if err != nil {
+		s.writeChangeError(w, err, "change request not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// applyChangeRequest writes every change of an approved request to secrets.
+// Changes are attributed to the requester. It returns the secrets written,
+// for the access log.
+func applyChangeRequest(ctx context.Context, q repository.Querier, project repository.Project, env repository.Environment, cr repository.ChangeRequest) ([]accessEntry, error) {
+	// Proposed values are sealed with the current DEK (rotations re-encrypt
+	// pending requests); hold it until commit
+	if _, err := q.LockProjectDEK(ctx, project.ID); err != nil {
+		return nil, err
+	}
+
+	items, err := q.ListChangeRequestItems(ctx, cr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	author := pgtype.UUID{Bytes: cr.RequestedBy, Valid: true}
+	written := make([]accessEntry, 0, len(items))
+	for _, item := range items {
+		secret, err := q.GetSecretByKeyIncludingInactive(ctx, repository.GetSecretByKeyIncludingInactiveParams{
+			EnvironmentID: cr.EnvironmentID,
+			Key:           item.Key,
+		})
+		if item.Operation == repository.ChangeOperationCreate && errors.Is(err, pgx.ErrNoRows) {
+			// The key must not have been created or deleted since
+			latest, err := q.GetLatestSecretVersion(ctx, repository.GetLatestSecretVersionParams{EnvironmentID: cr.EnvironmentID, Key: item.Key})
+			if err != nil {
+				return nil, err
+			}
+			if latest+1 != proposedVersion(item.BaseVersion) {
+				return nil, errChangeConflict
+			}
+			active := true
+			secret, err := q.CreateSecret(ctx, repository.CreateSecretParams{
+				EnvironmentID:  cr.EnvironmentID,
+				Key:            item.Key,
+				EncryptedValue: *item.EncryptedValue,
+				Description:    item.Description,
+				IsActive:       &active,
+				Version:        latest + 1,
+				CreatedBy:      author,
+			})
+			if isUniqueViolation(err) {
+				return nil, errChangeConflict
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := recordSecretEvent(ctx, q, project, env, eventSecretCreated, secret, author); err != nil {
+				return nil, err
+			}
+			written = append(written, accessEntry{resourceSecret, secret.ID, repository.AccessActionCreate})
+			continue
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errChangeConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+		// A create only replaces the expired secret it was based on
+		if item.Operation == repository.ChangeOperationCreate && item.BaseVersion == nil {
+			return nil, errChangeConflict
+		}
+		if item.BaseVersion != nil && secret.Version != *item.BaseVersion {
+			return nil, errChangeConflict
+		}
+
+		action, typ := repository.AccessActionUpdate, eventSecretUpdated
+		switch {
+		case item.Operation == repository.ChangeOperationDelete:
+			action, typ = repository.AccessActionDelete, eventSecretDeleted
+			err = q.SoftDeleteSecret(ctx, repository.SoftDeleteSecretParams{ID: secret.ID, UpdatedBy: author})
+		case item.RestoredVersion != nil:
+			secret, err = q.RollbackSecret(ctx, repository.RollbackSecretParams{
+				EncryptedValue:  *item.EncryptedValue,
+				RestoredVersion: *item.RestoredVersion,
+				UpdatedBy:       author,
+				ID:              secret.ID,
+			})
+		default:
+			secret, err = q.UpdateSecret(ctx, repository.UpdateSecretParams{
+				ID:             secret.ID,
+				EncryptedValue: *item.EncryptedValue,
+				Description:    item.Description,
+				UpdatedBy:      author,
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		if item.EncryptedValue != nil && secret.Version != proposedVersion(item.BaseVersion) {
+			return nil, errChangeConflict
+		}
+		if err := recordSecretEvent(ctx, q, project, env, typ, secret, author); err != nil {
+			return nil, err
+		}
+		written = append(written, accessEntry{resourceSecret, secret.ID, action})
+	}
+
+	return written, nil
+}
+
+// proposedVersion is the version a change based on base gives its secret,
+// which its proposed value is bound to
+func proposedVersion(base *int32) int32 {
+	if base == nil {
+		return 1
+	}
+	return *base + 1
+}
+
+// lockPendingChangeRequest locks a change request of env for the rest of
+// the transaction and checks that it is still pending
+func lockPendingChangeRequest(ctx context.Context, q repository.Querier, id, envID uuid.UUID) (repository.ChangeRequest, error) {
+	cr, err := q.GetChangeRequestForUpdate(ctx, id)
+	if err != nil {
+		return cr, err
+	}
+	if cr.EnvironmentID != envID {
+		return cr, pgx.ErrNoRows
+	}
+	if cr.Status != repository.ChangeRequestStatusPending {
+		return cr, errChangeRequestClosed
+	}
+	return cr, nil
+}
+
+// changeRequestDetails loads the changes and approvals of cr. Proposed
+// values are decrypted with reveal, or left out if it is nil.
+func changeRequestDetails(ctx context.Context, q repository.Querier, cr repository.ChangeRequest, reveal func(repository.ChangeRequestItem) (string, error)) (changeRequestResponse, error) {
+	resp := toChangeRequestResponse(cr)
+
+	items, err := q.ListChangeRequestItems(ctx, cr.ID)
+	if err != nil {
+		return resp, err
+	}
+	for _, item := range items {
+		change := changeResponse{
+			Key:             item.Key,
+			Operation:       item.Operation,
+			Description:     item.Description,
+			BaseVersion:     item.BaseVersion,
+			RestoredVersion: item.RestoredVersion,
+		}
+		if reveal != nil && item.EncryptedValue != nil {
+			value, err := reveal(item)
+			if err != nil {
+				return resp, err
+			}
+			change.Value = &value
+		}
+		resp.Changes = append(resp.Changes, change)
+	}
+
+	approvals, err := q.ListChangeRequestApprovals(ctx, cr.ID)
+	if err != nil {
+		return resp, err
+	}
+	for _, a := range approvals {
+		resp.Approvals = append(resp.Approvals, approvalResponse{
+			ApprovedBy: a.ApprovedBy,
+			Comment:    a.Comment,
+			CreatedAt:  a.CreatedAt,
+		})
+	}
+
+	return resp, nil
+}
+
+// writeChangeError maps change request workflow errors to HTTP responses
+func (s *Server) writeChangeError(w http.ResponseWriter, err error, notFoundMsg string) {
+	switch {
+	case errors.Is(err, errChangeConflict), errors.Is(err, errChangeRequestClosed), errors.Is(err, errSecretExists):
+		utils.WriteError(w, http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, errSelfApproval):
+		utils.WriteError(w, http.StatusForbidden, CodeForbidden, err.Error())
+	case errors.Is(err, policy.ErrForbidden):
+		utils.WriteError(w, http.StatusForbidden, CodeForbidden, "only the requester can cancel a change request")
+	case isUniqueViolation(err):
+		utils.WriteError(w, http.StatusConflict, CodeConflict, "change request already approved by this user")
+	default:
+		s.writeStoreError(w, err, notFoundMsg)
+	}
+}
+
+// changeRequestID parses the change request id in the URL
+func changeRequestID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "changeRequestID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid change request id")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// decodeDecision decodes the optional body of approve, reject and cancel
+func decodeDecision(w http.ResponseWriter, r *http.Request) (decideChangeRequestRequest, bool) {
+	var req decideChangeRequestRequest
+	if err := utils.DecodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return req, false
+	}
+	return req, true
+}
+
+// operationAction returns the policy action a change operation needs
+func operationAction(op repository.ChangeOperation) policy.Action {
+	switch op {
+	case repository.ChangeOperationCreate:
+		return policy.SecretCreate
+	case repository.ChangeOperationDelete:
+		return policy.SecretDelete
+	}
+	return policy.SecretUpdate
+}
+
+// isProtected reports whether writes to env need an approved change request
+func isProtected(env repository.Environment) bool {
+	return env.IsProtected != nil && *env.IsProtected
+}
+
+func toChangeRequestResponse(cr repository.ChangeRequest) changeRequestResponse {
+	resp := changeRequestResponse{
+		ID:                cr.ID,
+		EnvironmentID:     cr.EnvironmentID,
+		Status:            cr.Status,
+		Reason:            cr.Reason,
+		RequiredApprovals: cr.RequiredApprovals,
+		RequestedBy:       cr.RequestedBy,
+		DecisionComment:   cr.DecisionComment,
+		CreatedAt:         cr.CreatedAt,
+	}
+	if cr.DecidedBy.Valid {
+		decidedBy := uuid.UUID(cr.DecidedBy.Bytes)
+		resp.DecidedBy = &decidedBy
+	}
+	if cr.DecidedAt.Valid {
+		resp.DecidedAt = &cr.DecidedAt.Time
+	}
+	return resp
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// protect switches the test client to a new protected "production"
+// environment needing approvals
+func (te *testEnv) protect(approvals int32) {
+	protected := true
+	te.env = te.store.AddEnvironment(repository.Environment{
+		ProjectID:         te.project.ID,
+		Name:              "production",
+		IsProtected:       &protected,
+		RequiredApprovals: approvals,
+	})
+}
+
+func (te *testEnv) changeRequestsPath() string {
+	return "/projects/" + te.project.ID.String() + "/environments/" + te.env.Name + "/change-requests/"
+}
+
+func TestProtectedEnvironmentApproval(t *testing.T) {
+	te := newTestEnv(t)
+	te.protect(2)
+	base := te.secretsPath()
+	owner, ownerToken := te.user, te.token
+
+	reason := "rotate Stripe key"
+	rec := te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "sk_live_1", Reason: &reason})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("create: expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var cr changeRequestResponse
+	decodeData(t, rec, &cr)
+	if cr.Status != repository.ChangeRequestStatusPending || cr.RequiredApprovals != 2 || cr.RequestedBy != owner.ID {
+		t.Errorf("Unexpected change request %+v", cr)
+	}
+	if len(cr.Changes) != 1 || cr.Changes[0].Value != nil {
+		t.Errorf("Expected one change without its value, got %+v", cr.Changes)
+	}
+	if rec := te.do(t, http.MethodGet, base+"API_KEY", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the secret to wait for approval, got %d", rec.Code)
+	}
+
+	path := te.changeRequestsPath() + cr.ID.String()
+	rec = te.do(t, http.MethodGet, path, nil)
+	var got changeRequestResponse
+	decodeData(t, rec, &got)
+	if got.Changes[0].Value == nil || *got.Changes[0].Value != "sk_live_1" {
+		t.Errorf("Expected reviewers to see the proposed value, got %+v", got.Changes[0])
+	}
+
+	if rec := te.do(t, http.MethodPost, path+"/approve", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("self-approval: expected 403, got %d: %s", rec.Code, rec.Body)
+	}
+
+	te.loginAs(t, repository.OrgRoleMember)
+	if rec := te.do(t, http.MethodPost, path+"/approve", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("member approve: expected 403, got %d", rec.Code)
+	}
+
+	first := te.loginAs(t, repository.OrgRoleAdmin)
+	rec = te.do(t, http.MethodPost, path+"/approve", decideChangeRequestRequest{})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("approve: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	decodeData(t, rec, &got)
+	if got.Status != repository.ChangeRequestStatusPending || len(got.Approvals) != 1 || got.Approvals[0].ApprovedBy != first.ID {
+		t.Errorf("Expected one approval and still pending, got %+v", got)
+	}
+	if rec := te.do(t, http.MethodPost, path+"/approve", nil); rec.Code != http.StatusConflict {
+		t.Errorf("second approval by the same user: expected 409, got %d", rec.Code)
+	}
+
+	second := te.loginAs(t, repository.OrgRoleAdmin)
+	rec = te.do(t, http.MethodPost, path+"/approve", nil)
+	decodeData(t, rec, &got)
+	if got.Status != repository.ChangeRequestStatusApplied || got.DecidedBy == nil || *got.DecidedBy != second.ID {
+		t.Errorf("Expected the request to apply on the second approval, got %+v", got)
+	}
+	if rec := te.do(t, http.MethodPost, path+"/approve", nil); rec.Code != http.StatusConflict {
+		t.Errorf("approve applied request: expected 409, got %d", rec.Code)
+	}
+
+	te.token = ownerToken
+	rec = te.do(t, http.MethodGet, base+"API_KEY", nil)
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+	if secret.Value != "sk_live_1" {
+		t.Errorf("Expected applied value, got %q", secret.Value)
+	}
+	if h := te.store.History(secret.ID); len(h) != 1 || h[0].ChangedBy != owner.ID {
+		t.Errorf("Expected the change attributed to the requester, got %+v", h)
+	}
+
+	type access struct {
+		resourceType string
+		action       repository.AccessAction
+	}
+	var trail []access
+	var failures int
+	for _, l := range te.accessLogs(t) {
+		if !l.Success {
+			failures++
+			continue
+		}
+		trail = append(trail, access{l.ResourceType, l.Action})
+	}
+	want := []access{
+		{resourceChangeRequest, repository.AccessActionCreate},
+		{resourceChangeRequest, repository.AccessActionRead},
+		{resourceChangeRequest, repository.AccessActionApprove}, // first admin
+		{resourceChangeRequest, repository.AccessActionApprove}, // second admin
+		{resourceSecret, repository.AccessActionCreate},
+		{resourceChangeRequest, repository.AccessActionApply},
+		{resourceSecret, repository.AccessActionRead},
+	}
+	if len(trail) != len(want) {
+		t.Fatalf("Expected access trail %v, got %v", want, trail)
+	}
+	for i := range want {
+		if trail[i] != want[i] {
+			t.Errorf("Access entry %d: expected %v, got %v", i, want[i], trail[i])
+		}
+	}
+	if failures != 5 {
+		t.Errorf("Expected 5 failed accesses, got %d", failures)
+	}
+}
+
+func TestChangeRequestDecisions(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "v1"})
+
+	// Protect the existing environment
+	protected := true
+	te.env.IsProtected = &protected
+	te.env = te.store.AddEnvironment(te.env)
+	ownerToken := te.token
+
+	propose := func(value string) changeRequestResponse {
+		t.Helper()
+		rec := te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: value})
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("update: expected 202, got %d: %s", rec.Code, rec.Body)
+		}
+		var cr changeRequestResponse
+		decodeData(t, rec, &cr)
+		return cr
+	}
+	first, second, third := propose("v2"), propose("v3"), propose("v4")
+	if first.Changes[0].BaseVersion == nil || *first.Changes[0].BaseVersion != 1 {
+		t.Errorf("Expected base version 1, got %+v", first.Changes[0])
+	}
+
+	te.loginAs(t, repository.OrgRoleAdmin)
+	if rec := te.do(t, http.MethodPost, te.changeRequestsPath()+first.ID.String()+"/approve", nil); rec.Code != http.StatusOK {
+		t.Fatalf("approve: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// The second request was based on version 1 and must not apply over v2
+	rec := te.do(t, http.MethodPost, te.changeRequestsPath()+second.ID.String()+"/approve", nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("stale approve: expected 409, got %d: %s", rec.Code, rec.Body)
+	}
+	rec = te.do(t, http.MethodPost, te.changeRequestsPath()+second.ID.String()+"/reject", decideChangeRequestRequest{Comment: ptr("stale")})
+	var got changeRequestResponse
+	decodeData(t, rec, &got)
+	if got.Status != repository.ChangeRequestStatusRejected || got.DecisionComment == nil || *got.DecisionComment != "stale" {
+		t.Errorf("Expected rejection with comment, got %+v", got)
+	}
+
+	if rec := te.do(t, http.MethodPost, te.changeRequestsPath()+third.ID.String()+"/cancel", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("cancel by non-requester: expected 403, got %d", rec.Code)
+	}
+	te.token = ownerToken
+	rec = te.do(t, http.MethodPost, te.changeRequestsPath()+third.ID.String()+"/cancel", nil)
+	decodeData(t, rec, &got)
+	if got.Status != repository.ChangeRequestStatusCancelled {
+		t.Errorf("Expected cancelled, got %+v", got)
+	}
+
+	rec = te.do(t, http.MethodGet, base+"API_KEY", nil)
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+	if secret.Value != "v2" || secret.Version != 2 {
+		t.Errorf("Expected only the approved change applied, got %+v", secret)
+	}
+
+	rec = te.do(t, http.MethodGet, te.changeRequestsPath()+"?status=pending", nil)
+	var pending []changeRequestResponse
+	decodeData(t, rec, &pending)
+	if len(pending) != 0 {
+		t.Errorf("Expected no pending requests, got %d", len(pending))
+	}
+	rec = te.do(t, http.MethodGet, te.changeRequestsPath(), nil)
+	var all []changeRequestResponse
+	decodeData(t, rec, &all)
+	if len(all) != 3 || all[0].ID != third.ID {
+		t.Errorf("Expected 3 requests newest first, got %+v", all)
+	}
+}
+
+func TestCreateChangeRequest(t *testing.T) {
+	te := newTestEnv(t)
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "OLD_KEY", Value: "old"})
+	te.protect(1)
+	path := te.changeRequestsPath()
+
+	tests := []struct {
+		name string
+		body createChangeRequestRequest
+		want int
+	}{
+		{"No changes", createChangeRequestRequest{}, http.StatusBadRequest},
+		{"Duplicate key", createChangeRequestRequest{Changes: []changeRequestItemRequest{
+			{Key: "A", Operation: repository.ChangeOperationCreate, Value: ptr("1")},
+			{Key: "A", Operation: repository.ChangeOperationCreate, Value: ptr("2")},
+		}}, http.StatusBadRequest},
+		{"Missing value", createChangeRequestRequest{Changes: []changeRequestItemRequest{
+			{Key: "A", Operation: repository.ChangeOperationUpdate},
+		}}, http.StatusBadRequest},
+		{"Unknown operation", createChangeRequestRequest{Changes: []changeRequestItemRequest{
+			{Key: "A", Operation: "upsert", Value: ptr("1")},
+		}}, http.StatusBadRequest},
+		{"Update of missing secret", createChangeRequestRequest{Changes: []changeRequestItemRequest{
+			{Key: "A", Operation: repository.ChangeOperationUpdate, Value: ptr("1")},
+		}}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := te.do(t, http.MethodPost, path, tt.body); rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	ownerToken := te.token
+	te.loginAs(t, repository.OrgRoleViewer)
+	body := createChangeRequestRequest{Changes: []changeRequestItemRequest{
+		{Key: "A", Operation: repository.ChangeOperationCreate, Value: ptr("1")},
+		{Key: "B", Operation: repository.ChangeOperationCreate, Value: ptr("2")},
+	}}
+	if rec := te.do(t, http.MethodPost, path, body); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer propose: expected 403, got %d", rec.Code)
+	}
+	te.token = ownerToken
+
+	rec := te.do(t, http.MethodPost, path, body)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("propose: expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var cr changeRequestResponse
+	decodeData(t, rec, &cr)
+
+	// Pending values survive a DEK rotation
+	if rec := te.do(t, http.MethodPost, "/projects/"+te.project.ID.String()+"/rotate-key", nil); rec.Code != http.StatusOK {
+		t.Fatalf("rotate: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	te.loginAs(t, repository.OrgRoleAdmin)
+	rec = te.do(t, http.MethodPost, path+cr.ID.String()+"/approve", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("approve: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	for key, want := range map[string]string{"A": "1", "B": "2"} {
+		rec := te.do(t, http.MethodGet, te.secretsPath()+key, nil)
+		var secret secretResponse
+		decodeData(t, rec, &secret)
+		if secret.Value != want {
+			t.Errorf("%s: expected %q, got %q", key, want, secret.Value)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+	"github.com/Now-Tiger/envhub/pkg/database"
+)
+
+// A dynamic secret holds an admin connection to a PostgreSQL server rather
+// than a value. Each read creates a login role there that lives until its
+// lease expires or is revoked; the LeaseRevoker drops expired roles.
+
+// Defaults for the leases of new dynamic secrets
+const (
+	defaultLeaseTTL = time.Hour
+	defaultMaxTTL   = 24 * time.Hour
+)
+
+// minLeaseTTL bounds how short a lease may be
+const minLeaseTTL = time.Minute
+
+// rolePrefix starts the name of every role EnvHub creates
+const rolePrefix = "envhub_"
+
+// StatementRunner executes SQL statements on the server at connString in
+// one transaction; database.RunStatements is the default
+type StatementRunner func(ctx context.Context, connString, statements string) error
+
+// targetError is a failure of the target server rather than of EnvHub
+type targetError struct {
+	err error
+}
+
+func (e *targetError) Error() string { return "target database: " + e.err.Error() }
+func (e *targetError) Unwrap() error { return e.err }
+
+type createDynamicSecretRequest struct {
+	Key string `json:"key"`
+
+	// ConnectionURL is a postgres:// URL of a user allowed to create roles
+	ConnectionURL string `json:"connection_url"`
+
+	// Statements default to database.DefaultCreationStatements and
+	// database.DefaultRevocationStatements
+	CreationStatements   string `json:"creation_statements"`
+	RevocationStatements string `json:"revocation_statements"`
+
+	// DefaultTTL and MaxTTL are durations such as "1h"
+	DefaultTTL string `json:"default_ttl"`
+	MaxTTL     string `json:"max_ttl"`
+}
+
+// dynamicSecretResponse describes a dynamic secret; the connection is shown
+// without its password
+type dynamicSecretResponse struct {
+	ID                   uuid.UUID `json:"id"`
+	Key                  string    `json:"key"`
+	Connection           string    `json:"connection"`
+	CreationStatements   string    `json:"creation_statements"`
+	RevocationStatements string    `json:"revocation_statements"`
+	DefaultTTL           string    `json:"default_ttl"`
+	MaxTTL               string    `json:"max_ttl"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// credentialsResponse is a lease on a new role; the password is only ever
+// returned here
+type credentialsResponse struct {
+	LeaseID   uuid.UUID `json:"lease_id"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type leaseResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	Username    string     `json:"username"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokeError *string    `json:"revoke_error,omitempty"`
+}
+
+// listDynamicSecrets returns the dynamic secrets of an environment
+func (s *Server) listDynamicSecrets(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	secrets, err := s.store.ListDynamicSecretsByEnvironment(r.Context(), env.ID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	resp := make([]dynamicSecretResponse, 0, len(secrets))
+	for _, d := range secrets {
+		conn, err := s.decryptConnection(dek, project.ID, d)
+		if err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+		resp = append(resp, toDynamicSecretResponse(d, conn))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// createDynamicSecret stores an admin connection after checking that it
+// works. In protected environments this also needs the right to approve
+// change requests, as the statements run with the admin's privileges.
+func (s *Server) createDynamicSecret(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretCreate)
+	if !ok {
+		return
+	}
+	if isProtected(env) {
+		target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+		if !s.authorize(w, r, policy.ChangeRequestApprove, target) {
+			return
+		}
+	}
+
+	var req createDynamicSecretRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	params, msg := req.params()
+	if msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return
+	}
+
+	if err := s.runStatements(r.Context(), req.ConnectionURL, "SELECT 1"); err != nil {
+		log.Printf("api: dynamic secret %s: %v", req.Key, err)
+		utils.WriteError(w, http.StatusBadGateway, CodeBadGateway, "could not connect with connection_url")
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	id := vault.DynamicSecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: req.Key}
+	if params.EncryptedConnection, err = s.vault.EncryptValue(dek, id, req.ConnectionURL); err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	params.EnvironmentID = env.ID
+	params.CreatedBy = auth.UserIDFromContext(r.Context())
+
+	var created repository.DynamicSecret
+	err = s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		if err := vault.CheckDataKey(r.Context(), q, project.ID, dek); err != nil {
+			return err
+		}
+		created, err = q.CreateDynamicSecret(r.Context(), params)
+		return err
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "environment not found")
+		return
+	}
+	setAccessTarget(r, resourceDynamicSecret, created.ID, repository.AccessActionCreate)
+
+	utils.WriteJSON(w, http.StatusCreated, toDynamicSecretResponse(created, req.ConnectionURL))
+}
+
+// params validates the request and fills in its defaults, or returns a
+// message saying what is wrong
+func (req *createDynamicSecretRequest) params() (repository.CreateDynamicSecretParams, string) {
+	if msg := validateSecretKey(req.Key); msg != "" {
+		return repository.CreateDynamicSecretParams{}, msg
+	}
+	if _, err := database.ParseConnectionURL(req.ConnectionURL); err != nil {
+		return repository.CreateDynamicSecretParams{}, "connection_url must be a postgres:// URL"
+	}
+
+	if req.CreationStatements == "" {
+		req.CreationStatements = database.DefaultCreationStatements
+	}
+	if req.RevocationStatements == "" {
+		req.RevocationStatements = database.DefaultRevocationStatements
+	}
+	if !strings.Contains(req.CreationStatements, database.PlaceholderName) || !strings.Contains(req.RevocationStatements, database.PlaceholderName) {
+		return repository.CreateDynamicSecretParams{}, "creation_statements and revocation_statements must use " + database.PlaceholderName
+	}
+
+	ttl, maxTTL := defaultLeaseTTL, defaultMaxTTL
+	for _, f := range []struct {
+		v   string
+		out *time.Duration
+	}{{req.DefaultTTL, &ttl}, {req.MaxTTL, &maxTTL}} {
+		if f.v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.v)
+		if err != nil || d < minLeaseTTL {
+			return repository.CreateDynamicSecretParams{}, fmt.Sprintf("default_ttl and max_ttl must be durations of at least %s, such as 1h", minLeaseTTL)
+		}
+		*f.out = d
+	}
+	if req.MaxTTL == "" {
+		maxTTL = max(maxTTL, ttl)
+	}
+	if ttl > maxTTL {
+		return repository.CreateDynamicSecretParams{}, "default_ttl must not exceed max_ttl"
+	}
+
+	return repository.CreateDynamicSecretParams{
+		Key:                  req.Key,
+		CreationStatements:   req.CreationStatements,
+		RevocationStatements: req.RevocationStatements,
+		DefaultTtl:           pgtype.Interval{Microseconds: ttl.Microseconds(), Valid: true},
+		MaxTtl:               pgtype.Interval{Microseconds: maxTTL.Microseconds(), Valid: true},
+	}, ""
+}
+
+// deleteDynamicSecret revokes every active lease of a dynamic secret and
+// deletes it. Nothing is deleted if a role cannot be dropped.
+func (s *Server) deleteDynamicSecret(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretDelete)
+	if !ok {
+		return
+	}
+	if isProtected(env) {
+		target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+		if !s.authorize(w, r, policy.ChangeRequestApprove, target) {
+			return
+		}
+	}
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	err = s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		d, err := q.GetDynamicSecretByKey(r.Context(), repository.GetDynamicSecretByKeyParams{
+			EnvironmentID: env.ID,
+			Key:           chi.URLParam(r, "key"),
+		})
+		if err != nil {
+			return err
+		}
+		setAccessTarget(r, resourceDynamicSecret, d.ID, repository.AccessActionDelete)
+
+		leases, err := q.ListActiveDynamicSecretLeases(r.Context(), d.ID)
+		if err != nil {
+			return err
+		}
+		if len(leases) > 0 {
+			conn, err := s.decryptConnection(dek, project.ID, d)
+			if err != nil {
+				return err
+			}
+			for _, l := range leases {
+				if err := dropRole(r.Context(), s.runStatements, conn, d.RevocationStatements, l.RoleName); err != nil {
+					return err
+				}
+			}
+		}
+		return q.DeleteDynamicSecret(r.Context(), d.ID)
+	})
+	if err != nil {
+		s.writeDynamicSecretError(w, err, "dynamic secret not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueCredentials creates a role from a dynamic secret's creation
+// statements and returns its login under a lease of ?ttl= (the secret's
+// default TTL if unset, at most its max TTL). The lease is recorded before
+// the role is created, so a role is never left without one.
+func (s *Server) issueCredentials(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+	d, err := s.store.GetDynamicSecretByKey(r.Context(), repository.GetDynamicSecretByKeyParams{
+		EnvironmentID: env.ID,
+		Key:           chi.URLParam(r, "key"),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "dynamic secret not found")
+		return
+	}
+	setAccessTarget(r, resourceDynamicSecret, d.ID, repository.AccessActionRead)
+
+	ttl := intervalDuration(d.DefaultTtl)
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		maxTTL := intervalDuration(d.MaxTtl)
+		if ttl, err = time.ParseDuration(v); err != nil || ttl < minLeaseTTL || ttl > maxTTL {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("ttl must be a duration between %s and %s", minLeaseTTL, maxTTL))
+			return
+		}
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	conn, err := s.decryptConnection(dek, project.ID, d)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	creds, err := database.NewRoleCredentials(rolePrefix+d.Key, time.Now().Add(ttl))
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	url, err := creds.URL(conn)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	lease, err := s.store.CreateDynamicSecretLease(r.Context(), repository.CreateDynamicSecretLeaseParams{
+		DynamicSecretID: d.ID,
+		RoleName:        creds.Username,
+		ExpiresAt:       creds.Expiration,
+		CreatedBy:       auth.UserIDFromContext(r.Context()),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "dynamic secret not found")
+		return
+	}
+
+	if err := s.runStatements(r.Context(), conn, creds.Render(d.CreationStatements)); err != nil {
+		// The statements ran in one transaction, so there is no role to drop
+		if rerr := s.store.RevokeDynamicSecretLease(context.WithoutCancel(r.Context()), lease.ID); rerr != nil {
+			err = fmt.Errorf("%w (revoking lease %s: %v)", err, lease.ID, rerr)
+		}
+		s.writeDynamicSecretError(w, &targetError{err}, "dynamic secret not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, credentialsResponse{
+		LeaseID:   lease.ID,
+		Username:  creds.Username,
+		Password:  creds.Password,
+		URL:       url,
+		ExpiresAt: lease.ExpiresAt,
+	})
+}
+
+// listLeases returns the leases of a dynamic secret that are not revoked
+func (s *Server) listLeases(w http.ResponseWriter, r *http.Request) {
+	_, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+
+	var leases []repository.DynamicSecretLease
+	err := s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		d, err := q.GetDynamicSecretByKey(r.Context(), repository.GetDynamicSecretByKeyParams{
+			EnvironmentID: env.ID,
+			Key:           chi.URLParam(r, "key"),
+		})
+		if err != nil {
+			return err
+		}
+		setAccessTarget(r, resourceDynamicSecret, d.ID, repository.AccessActionRead)
+
+		leases, err = q.ListActiveDynamicSecretLeases(r.Context(), d.ID)
+		return err
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "dynamic secret not found")
+		return
+	}
+
+	resp := make([]leaseResponse, 0, len(leases))
+	for _, l := range leases {
+		resp = append(resp, toLeaseResponse(l))
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// revokeLease drops the role of a lease and ends it early. Callers may
+// revoke the leases they were issued; revoking anyone else's needs the
+// right to update secrets. Revoking a revoked lease does nothing.
+func (s *Server) revokeLease(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+	leaseID, err := uuid.Parse(chi.URLParam(r, "leaseID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid lease id")
+		return
+	}
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	err = s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		d, err := q.GetDynamicSecretByKey(r.Context(), repository.GetDynamicSecretByKeyParams{
+			EnvironmentID: env.ID,
+			Key:           chi.URLParam(r, "key"),
+		})
+		if err != nil {
+			return err
+		}
+		setAccessTarget(r, resourceDynamicSecret, d.ID, repository.AccessActionUpdate)
+
+		lease, err := q.GetDynamicSecretLeaseForUpdate(r.Context(), repository.GetDynamicSecretLeaseForUpdateParams{
+			ID:              leaseID,
+			DynamicSecretID: d.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if lease.CreatedBy != auth.UserIDFromContext(r.Context()) {
+			target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+			if err := s.permit(r.Context(), policy.SecretUpdate, target); err != nil {
+				return err
+			}
+		}
+		if lease.RevokedAt.Valid {
+			return nil
+		}
+
+		conn, err := s.decryptConnection(dek, project.ID, d)
+		if err != nil {
+			return err
+		}
+		if err := dropRole(r.Context(), s.runStatements, conn, d.RevocationStatements, lease.RoleName); err != nil {
+			return err
+		}
+		return q.RevokeDynamicSecretLease(r.Context(), lease.ID)
+	})
+	if err != nil {
+		s.writeDynamicSecretError(w, err, "lease not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeDynamicSecretError maps denials and failures of the target server to
+// HTTP responses, and everything else like writeStoreError
+func (s *Server) writeDynamicSecretError(w http.ResponseWriter, err error, notFoundMsg string) {
+	var (
+		denied *deniedError
+		target *targetError
+	)
+	switch {
+	case errors.As(err, &denied):
+		utils.WriteError(w, denied.status, denied.code, denied.Error())
+	case errors.As(err, &target):
+		// Target errors can name its schema, so only the log gets them
+		log.Printf("api: %v", err)
+		utils.WriteError(w, http.StatusBadGateway, CodeBadGateway, "target database rejected the statements")
+	default:
+		s.writeStoreError(w, err, notFoundMsg)
+	}
+}
+
+// decryptConnection decrypts the admin connection of a dynamic secret
+func (s *Server) decryptConnection(dek *crypto.DataKey, projectID uuid.UUID, d repository.DynamicSecret) (string, error) {
+	id := vault.DynamicSecretIdentity{ProjectID: projectID, EnvironmentID: d.EnvironmentID, Key: d.Key}
+	return s.vault.DecryptValue(dek, id, d.EncryptedConnection)
+}
+
+// dropRole runs a dynamic secret's revocation statements for role. A role
+// that no longer exists counts as dropped, so revoking can be retried.
+func dropRole(ctx context.Context, run StatementRunner, conn, statements, role string) error {
+	err := run(ctx, conn, database.RoleCredentials{Username: role}.Render(statements))
+	if err != nil && !database.IsUndefinedRole(err) {
+		return &targetError{err}
+	}
+	return nil
+}
+
+func toDynamicSecretResponse(d repository.DynamicSecret, conn string) dynamicSecretResponse {
+	resp := dynamicSecretResponse{
+		ID:                   d.ID,
+		Key:                  d.Key,
+		CreationStatements:   d.CreationStatements,
+		RevocationStatements: d.RevocationStatements,
+		DefaultTTL:           formatInterval(d.DefaultTtl),
+		MaxTTL:               formatInterval(d.MaxTtl),
+		CreatedAt:            d.CreatedAt,
+	}
+	if u, err := database.ParseConnectionURL(conn); err == nil {
+		resp.Connection = u.Redacted()
+	}
+	return resp
+}
+
+func toLeaseResponse(l repository.DynamicSecretLease) leaseResponse {
+	resp := leaseResponse{
+		ID:          l.ID,
+		Username:    l.RoleName,
+		ExpiresAt:   l.ExpiresAt,
+		CreatedAt:   l.CreatedAt,
+		RevokeError: l.RevokeError,
+	}
+	if l.CreatedBy.Valid {
+		id := uuid.UUID(l.CreatedBy.Bytes)
+		resp.CreatedBy = &id
+	}
+	return resp
+}
@@ -0,0 +1,481 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// maxEnvironmentNameLength is the size of environments.name
+const maxEnvironmentNameLength = 50
+
+// Bounds of the TTL of an expiring environment
+const (
+	minEnvironmentTTL = time.Minute
+	maxEnvironmentTTL = 90 * 24 * time.Hour
+)
+
+// EnvironmentTemplate describes an environment created with a new project
+type EnvironmentTemplate struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+	Color       *string `json:"color,omitempty"`
+	Protected   bool    `json:"protected"`
+
+	// RequiredApprovals applies to protected environments; 0 means 1
+	RequiredApprovals int32 `json:"required_approvals,omitempty"`
+
+	// Parent names the environment this one inherits secrets from
+	Parent string `json:"parent,omitempty"`
+}
+
+// DefaultEnvironments are created with every new project unless the server
+// is configured WithEnvironmentTemplates or the request names its own
+var DefaultEnvironments = []EnvironmentTemplate{
+	{Name: "dev", Color: ptr("#22C55E")},
+	{Name: "staging", Color: ptr("#EAB308")},
+	{Name: "production", Color: ptr("#EF4444"), Protected: true, RequiredApprovals: 1},
+}
+
+// ValidateEnvironmentTemplates checks that templates have valid, distinct
+// names, valid colors, non-negative approval counts and parents among the
+// other templates that do not lead back to them
+func ValidateEnvironmentTemplates(templates []EnvironmentTemplate) error {
+	seen := make(map[string]bool, len(templates))
+	protected := make(map[string]bool, len(templates))
+	for _, t := range templates {
+		if msg := validateEnvironmentName(t.Name); msg != "" {
+			return errors.New(msg)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("environment %s appears more than once", t.Name)
+		}
+		seen[t.Name] = true
+		if !validColor(t.Color) {
+			return fmt.Errorf("environment %s: color must be #RRGGBB", t.Name)
+		}
+		if t.RequiredApprovals < 0 {
+			return fmt.Errorf("environment %s: required_approvals must not be negative", t.Name)
+		}
+		protected[t.Name] = t.Protected
+	}
+
+	names := make([]string, len(templates))
+	parents := make(map[string]string)
+	for i, t := range templates {
+		names[i] = t.Name
+		if t.Parent == "" {
+			continue
+		}
+		if !seen[t.Parent] {
+			return fmt.Errorf("environment %s: parent %s is not in the list", t.Name, t.Parent)
+		}
+		if t.Protected && !protected[t.Parent] {
+			return errors.New(unprotectedParentMessage(t.Name, t.Parent))
+		}
+		parents[t.Name] = t.Parent
+	}
+	if msg := checkInheritance(names, parents, func(name string) string { return name }); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+type createEnvironmentRequest struct {
+	EnvironmentTemplate
+
+	// TTL, a duration such as "72h", makes the environment expire; it is
+	// then deleted with its secrets
+	TTL string `json:"ttl,omitempty"`
+
+	// CopyFrom names an environment whose own secrets are copied
+	CopyFrom string `json:"copy_from,omitempty"`
+}
+
+type createEnvironmentResponse struct {
+	environmentResponse
+	SecretsCopied int `json:"secrets_copied"`
+}
+
+type setParentRequest struct {
+	Parent string `json:"parent"`
+}
+
+// listEnvironments returns the environments of a project, oldest first
+func (s *Server) listEnvironments(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, policy.EnvironmentRead)
+	if !ok {
+		return
+	}
+
+	envs, err := s.store.ListEnvironmentsByProject(r.Context(), project.ID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, toEnvironmentResponses(envs))
+}
+
+// createEnvironment adds an environment to a project, optionally inheriting
+// from an existing one, seeded with copies of another one's secrets and
+// expiring after a TTL
+func (s *Server) createEnvironment(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, policy.EnvironmentCreate)
+	if !ok {
+		return
+	}
+
+	var req createEnvironmentRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	own := req.EnvironmentTemplate
+	own.Parent = ""
+	if err := ValidateEnvironmentTemplates([]EnvironmentTemplate{own}); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	params := environmentParams(project.ID, req.EnvironmentTemplate)
+	if req.TTL != "" {
+		ttl, msg := parseTTL(req.TTL)
+		if msg != "" {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+			return
+		}
+		params.ExpiresAt = pgtype.Timestamptz{Time: time.Now().Add(ttl), Valid: true}
+	}
+
+	var dek *crypto.DataKey
+	var values map[string]secretResponse
+	if req.CopyFrom != "" {
+		source, err := s.store.GetEnvironmentByName(r.Context(), repository.GetEnvironmentByNameParams{
+			ProjectID: project.ID,
+			Name:      req.CopyFrom,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "environment "+req.CopyFrom+" to copy from not found")
+			return
+		}
+		if err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+		target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: source.Name}
+		if !s.authorize(w, r, policy.SecretRead, target) {
+			return
+		}
+		target.Environment = req.Name
+		if !s.authorize(w, r, policy.SecretCreate, target) {
+			return
+		}
+
+		if dek, err = s.vault.DataKey(project); err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+		if values, err = s.environmentSecrets(r.Context(), dek, project.ID, source.ID); err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+	}
+
+	var env repository.Environment
+	var written []accessEntry
+	err := s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		if req.Parent != "" {
+			parent, err := lockParent(r.Context(), q, project.ID, req.Parent)
+			if err != nil {
+				return err
+			}
+			env := repository.Environment{ProjectID: project.ID, Name: req.Name, IsProtected: params.IsProtected, ExpiresAt: params.ExpiresAt}
+			if err := checkParent(r.Context(), q, env, parent); err != nil {
+				return err
+			}
+			params.ParentID = pgtype.UUID{Bytes: parent.ID, Valid: true}
+		}
+		var err error
+		if env, err = q.CreateEnvironment(r.Context(), params); err != nil {
+			return err
+		}
+		if err := recordEnvironmentEvent(r.Context(), q, project, eventEnvironmentCreated, env); err != nil {
+			return err
+		}
+		if values == nil {
+			return nil
+		}
+		if err := vault.CheckDataKey(r.Context(), q, project.ID, dek); err != nil {
+			return err
+		}
+		written, err = s.copySecrets(r.Context(), q, dek, project, env, values)
+		return err
+	})
+	if err != nil {
+		s.writeEnvironmentError(w, err, req.Name)
+		return
+	}
+	setAccessTarget(r, resourceEnvironment, env.ID, repository.AccessActionCreate)
+	for _, secret := range values {
+		addAccess(r, accessEntry{resourceSecret, secret.ID, repository.AccessActionRead})
+	}
+	addAccess(r, written...)
+
+	resp := createEnvironmentResponse{
+		environmentResponse: toEnvironmentResponse(env, nil),
+		SecretsCopied:       len(written),
+	}
+	if req.Parent != "" {
+		resp.Parent = &req.Parent
+	}
+	utils.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// parseTTL returns how long until a new environment expires, or a non-empty
+// message if ttl is not a duration between minEnvironmentTTL and
+// maxEnvironmentTTL
+func parseTTL(ttl string) (time.Duration, string) {
+	d, err := time.ParseDuration(ttl)
+	switch {
+	case err != nil:
+		return 0, "ttl must be a duration such as 72h"
+	case d < minEnvironmentTTL || d > maxEnvironmentTTL:
+		return 0, fmt.Sprintf("ttl must be between %s and %s", minEnvironmentTTL, maxEnvironmentTTL)
+	}
+	return d, ""
+}
+
+// setEnvironmentParent makes the environment in the URL inherit from the one
+// named in the request
+func (s *Server) setEnvironmentParent(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadInheritingEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	var req setParentRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if req.Parent == "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "parent is required")
+		return
+	}
+	s.updateParent(w, r, project, env, req.Parent)
+}
+
+// removeEnvironmentParent stops the environment in the URL from inheriting
+func (s *Server) removeEnvironmentParent(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadInheritingEnvironment(w, r)
+	if !ok {
+		return
+	}
+	s.updateParent(w, r, project, env, "")
+}
+
+// loadInheritingEnvironment loads the environment whose parent is changed.
+// A new parent changes the secrets a protected environment sees without a
+// change request, so there it also takes the right to approve changes.
+func (s *Server) loadInheritingEnvironment(w http.ResponseWriter, r *http.Request) (repository.Project, repository.Environment, bool) {
+	project, env, ok := s.loadEnvironment(w, r, policy.EnvironmentUpdate)
+	if !ok || !isProtected(env) {
+		return project, env, ok
+	}
+	target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+	return project, env, s.authorize(w, r, policy.ChangeRequestApprove, target)
+}
+
+// updateParent sets or, if name is empty, clears the parent of env
+func (s *Server) updateParent(w http.ResponseWriter, r *http.Request, project repository.Project, env repository.Environment, name string) {
+	err := s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		var parentID pgtype.UUID
+		if name != "" {
+			parent, err := lockParent(r.Context(), q, project.ID, name)
+			if err != nil {
+				return err
+			}
+			if err := checkParent(r.Context(), q, env, parent); err != nil {
+				return err
+			}
+			parentID = pgtype.UUID{Bytes: parent.ID, Valid: true}
+		}
+		var err error
+		env, err = q.SetEnvironmentParent(r.Context(), repository.SetEnvironmentParentParams{
+			ID:       env.ID,
+			ParentID: parentID,
+		})
+		if err != nil {
+			return err
+		}
+		return recordEnvironmentEvent(r.Context(), q, project, eventEnvironmentUpdated, env)
+	})
+	if err != nil {
+		s.writeEnvironmentError(w, err, env.Name)
+		return
+	}
+
+	resp := toEnvironmentResponse(env, nil)
+	if name != "" {
+		resp.Parent = &name
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// lockParent locks the project, so parents cannot change concurrently, and
+// looks up the environment named as a parent
+func lockParent(ctx context.Context, q repository.Querier, projectID uuid.UUID, name string) (repository.Environment, error) {
+	if _, err := q.GetProjectForUpdate(ctx, projectID); err != nil {
+		return repository.Environment{}, err
+	}
+	parent, err := q.GetEnvironmentByName(ctx, repository.GetEnvironmentByNameParams{
+		ProjectID: projectID,
+		Name:      name,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return parent, &inheritanceError{http.StatusBadRequest, "parent environment " + name + " not found"}
+	}
+	return parent, err
+}
+
+// writeEnvironmentError maps environment write errors to HTTP responses
+func (s *Server) writeEnvironmentError(w http.ResponseWriter, err error, name string) {
+	var inherit *inheritanceError
+	switch {
+	case errors.As(err, &inherit):
+		code := CodeConflict
+		if inherit.status == http.StatusBadRequest {
+			code = CodeBadRequest
+		}
+		utils.WriteError(w, inherit.status, code, inherit.msg)
+	case isUniqueViolation(err):
+		utils.WriteError(w, http.StatusConflict, CodeConflict, "environment "+name+" already exists")
+	default:
+		s.writeStoreError(w, err, "environment not found")
+	}
+}
+
+// validateEnvironmentName returns a non-empty message if name cannot be used
+// in URLs and token scopes
+func validateEnvironmentName(name string) string {
+	switch {
+	case name == "":
+		return "environment name is required"
+	case len(name) > maxEnvironmentNameLength:
+		return fmt.Sprintf("environment name must be at most %d characters", maxEnvironmentNameLength)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "environment name may only contain letters, digits, '-', '_' and '.'"
+		}
+	}
+	return ""
+}
+
+// validColor reports whether color is unset or a #RRGGBB hex color
+func validColor(color *string) bool {
+	if color == nil {
+		return true
+	}
+	c := *color
+	if len(c) != 7 || c[0] != '#' {
+		return false
+	}
+	for _, d := range c[1:] {
+		if !(d >= '0' && d <= '9' || d >= 'a' && d <= 'f' || d >= 'A' && d <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// environmentParams turns a template into the row to insert
+func environmentParams(projectID uuid.UUID, t EnvironmentTemplate) repository.CreateEnvironmentParams {
+	protected := t.Protected
+	return repository.CreateEnvironmentParams{
+		ProjectID:         projectID,
+		Name:              t.Name,
+		Description:       t.Description,
+		IsProtected:       &protected,
+		Color:             t.Color,
+		RequiredApprovals: max(t.RequiredApprovals, 1),
+	}
+}
+
+// environmentTemplate describes an existing environment, for cloning.
+// parent is the name of its parent, if any.
+func environmentTemplate(env repository.Environment, parent string) EnvironmentTemplate {
+	return EnvironmentTemplate{
+		Name:              env.Name,
+		Description:       env.Description,
+		Color:             env.Color,
+		Protected:         isProtected(env),
+		RequiredApprovals: env.RequiredApprovals,
+		Parent:            parent,
+	}
+}
+
+type environmentResponse struct {
+	ID                uuid.UUID  `json:"id"`
+	Name              string     `json:"name"`
+	Description       *string    `json:"description,omitempty"`
+	Color             *string    `json:"color,omitempty"`
+	Protected         bool       `json:"protected"`
+	RequiredApprovals int32      `json:"required_approvals"`
+	Parent            *string    `json:"parent,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// toEnvironmentResponse describes env; names maps the environments of its
+// project by id, to name its parent
+func toEnvironmentResponse(env repository.Environment, names map[uuid.UUID]string) environmentResponse {
+	resp := environmentResponse{
+		ID:                env.ID,
+		Name:              env.Name,
+		Description:       env.Description,
+		Color:             env.Color,
+		Protected:         isProtected(env),
+		RequiredApprovals: env.RequiredApprovals,
+		CreatedAt:         env.CreatedAt,
+	}
+	if env.ParentID.Valid {
+		resp.Parent = ptr(names[env.ParentID.Bytes])
+	}
+	if env.ExpiresAt.Valid {
+		resp.ExpiresAt = &env.ExpiresAt.Time
+	}
+	return resp
+}
+
+func toEnvironmentResponses(envs []repository.Environment) []environmentResponse {
+	names := environmentNamesByID(envs)
+	resp := make([]environmentResponse, len(envs))
+	for i, env := range envs {
+		resp[i] = toEnvironmentResponse(env, names)
+	}
+	return resp
+}
+
+func environmentNamesByID(envs []repository.Environment) map[uuid.UUID]string {
+	names := make(map[uuid.UUID]string, len(envs))
+	for _, env := range envs {
+		names[env.ID] = env.Name
+	}
+	return names
+}
+
+func ptr[T any](v T) *T { return &v }
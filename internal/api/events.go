@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// Types of the events recorded in the events outbox
+const (
+	eventSecretCreated     = "secret.created"
+	eventSecretUpdated     = "secret.updated"
+	eventSecretDeleted     = "secret.deleted"
+	eventSecretExpired     = "secret.expired"
+	eventSecretRotationDue = "secret.rotation_due"
+
+	eventEnvironmentCreated = "environment.created"
+	eventEnvironmentUpdated = "environment.updated"
+	eventEnvironmentDeleted = "environment.deleted"
+
+	eventDEKRotated = "dek.rotated"
+)
+
+// eventTypes lists every event type, which webhooks subscribe to
+var eventTypes = []string{
+	eventSecretCreated, eventSecretUpdated, eventSecretDeleted, eventSecretExpired, eventSecretRotationDue,
+	eventEnvironmentCreated, eventEnvironmentUpdated, eventEnvironmentDeleted,
+	eventDEKRotated,
+}
+
+// secretEvent is the payload of secret events. It never holds the value.
+type secretEvent struct {
+	SecretID      uuid.UUID  `json:"secret_id"`
+	Key           string     `json:"key"`
+	Version       int32      `json:"version"`
+	EnvironmentID uuid.UUID  `json:"environment_id"`
+	Environment   string     `json:"environment"`
+	ProjectID     uuid.UUID  `json:"project_id"`
+	DueAt         *time.Time `json:"due_at,omitempty"`
+
+	// Deactivated reports whether an expired secret was left out of reads
+	Deactivated bool `json:"deactivated,omitempty"`
+
+	// UserID is who made the change, if anyone
+	UserID *uuid.UUID `json:"user_id,omitempty"`
+}
+
+// environmentEvent is the payload of environment events
+type environmentEvent struct {
+	EnvironmentID uuid.UUID  `json:"environment_id"`
+	Environment   string     `json:"environment"`
+	ProjectID     uuid.UUID  `json:"project_id"`
+	Protected     bool       `json:"protected"`
+	ParentID      *uuid.UUID `json:"parent_id,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+
+	// Expired reports whether the environment was deleted because it expired
+	Expired bool `json:"expired,omitempty"`
+
+	UserID *uuid.UUID `json:"user_id,omitempty"`
+}
+
+// dekRotatedEvent is the payload of dek.rotated
+type dekRotatedEvent struct {
+	ProjectID      uuid.UUID  `json:"project_id"`
+	OldDEKVersion  int        `json:"old_dek_version"`
+	NewDEKVersion  int        `json:"new_dek_version"`
+	SecretsRotated int        `json:"secrets_rotated"`
+	UserID         *uuid.UUID `json:"user_id,omitempty"`
+}
+
+// recordEvent adds an event to the outbox in the transaction of q, so it is
+// only delivered if the change it describes is committed
+func recordEvent(ctx context.Context, q repository.Querier, orgID, projectID uuid.UUID, typ string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = q.CreateEvent(ctx, repository.CreateEventParams{
+		OrganizationID: orgID,
+		ProjectID:      pgtype.UUID{Bytes: projectID, Valid: true},
+		Type:           typ,
+		Payload:        data,
+	})
+	return err
+}
+
+// recordSecretEvent records a change to secret, in env of project, by user
+func recordSecretEvent(ctx context.Context, q repository.Querier, project repository.Project, env repository.Environment, typ string, secret repository.Secret, user pgtype.UUID) error {
+	return recordEvent(ctx, q, project.OrganizationID, project.ID, typ, secretEvent{
+		SecretID:      secret.ID,
+		Key:           secret.Key,
+		Version:       secret.Version,
+		EnvironmentID: env.ID,
+		Environment:   env.Name,
+		ProjectID:     project.ID,
+		UserID:        uuidPtr(user),
+	})
+}
+
+// recordEnvironmentEvent records a change to env, in project, by the caller
+func recordEnvironmentEvent(ctx context.Context, q repository.Querier, project repository.Project, typ string, env repository.Environment) error {
+	payload := environmentEvent{
+		EnvironmentID: env.ID,
+		Environment:   env.Name,
+		ProjectID:     project.ID,
+		Protected:     isProtected(env),
+		ParentID:      uuidPtr(env.ParentID),
+		UserID:        uuidPtr(auth.UserIDFromContext(ctx)),
+	}
+	if env.ExpiresAt.Valid {
+		payload.ExpiresAt = &env.ExpiresAt.Time
+	}
+	return recordEvent(ctx, q, project.OrganizationID, project.ID, typ, payload)
+}
+
+// recordDEKRotated records that the caller rotated the DEK of project
+func recordDEKRotated(ctx context.Context, q repository.Querier, project repository.Project, result crypto.RotationResult) error {
+	return recordEvent(ctx, q, project.OrganizationID, project.ID, eventDEKRotated, dekRotatedEvent{
+		ProjectID:      project.ID,
+		OldDEKVersion:  result.OldKeyVersion,
+		NewDEKVersion:  result.NewKeyVersion,
+		SecretsRotated: result.ItemsRotated,
+		UserID:         uuidPtr(auth.UserIDFromContext(ctx)),
+	})
+}
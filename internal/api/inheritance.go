@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// An environment may inherit the secrets of a parent in the same project.
+// Reads (list, get, export and references) see the parent's secrets merged
+// with the environment's own, which override keys of the same name; the
+// parent may in turn have a parent. Writes, diffs, promotions and imports
+// only ever touch an environment's own secrets, so setting a key in a child
+// overrides it and deleting it there uncovers the inherited value again.
+//
+// A protected environment only inherits from protected environments, or
+// unreviewed edits to the parent would reach it without a change request.
+// Nor may an environment inherit from one that expires before it does.
+
+// maxInheritanceDepth bounds how many ancestors an environment may have
+const maxInheritanceDepth = 10
+
+// inheritanceError is a parent an environment may not have
+type inheritanceError struct {
+	status int
+	msg    string
+}
+
+func (e *inheritanceError) Error() string { return e.msg }
+
+// checkInheritance returns a non-empty message if following parents from
+// any of keys leads back to it or passes more than maxInheritanceDepth
+// ancestors. Cycles are looked for first so they are reported as such.
+func checkInheritance[K comparable](keys []K, parents map[K]K, name func(K) string) string {
+	for _, k := range keys {
+		steps := 0
+		for p, ok := parents[k]; ok && steps <= len(parents); p, ok = parents[p] {
+			if p == k {
+				return fmt.Sprintf("environment %s would inherit from itself", name(k))
+			}
+			steps++
+		}
+	}
+	for _, k := range keys {
+		steps := 0
+		for p, ok := parents[k]; ok; p, ok = parents[p] {
+			if steps++; steps > maxInheritanceDepth {
+				return fmt.Sprintf("environment %s would have more than %d ancestors", name(k), maxInheritanceDepth)
+			}
+		}
+	}
+	return ""
+}
+
+func unprotectedParentMessage(env, parent string) string {
+	return fmt.Sprintf("protected environment %s cannot inherit from unprotected environment %s", env, parent)
+}
+
+// checkParent checks that env may inherit from parent, given the other
+// environments of the project. The caller holds the project row lock so
+// no other parent changes in the meantime. env.ID is uuid.Nil for an
+// environment about to be created.
+func checkParent(ctx context.Context, q repository.Querier, env, parent repository.Environment) error {
+	if isProtected(env) && !isProtected(parent) {
+		return &inheritanceError{http.StatusConflict, unprotectedParentMessage(env.Name, parent.Name)}
+	}
+	if parent.ExpiresAt.Valid && (!env.ExpiresAt.Valid || env.ExpiresAt.Time.After(parent.ExpiresAt.Time)) {
+		msg := fmt.Sprintf("environment %s cannot inherit from environment %s, which expires first", env.Name, parent.Name)
+		return &inheritanceError{http.StatusConflict, msg}
+	}
+
+	envs, err := q.ListEnvironmentsByProject(ctx, env.ProjectID)
+	if err != nil {
+		return err
+	}
+	names := map[uuid.UUID]string{env.ID: env.Name}
+	parents := map[uuid.UUID]uuid.UUID{env.ID: parent.ID}
+	keys := []uuid.UUID{env.ID}
+	for _, e := range envs {
+		if e.ID == env.ID {
+			continue
+		}
+		names[e.ID] = e.Name
+		keys = append(keys, e.ID)
+		if e.ParentID.Valid {
+			parents[e.ID] = e.ParentID.Bytes
+		}
+	}
+	if msg := checkInheritance(keys, parents, func(id uuid.UUID) string { return names[id] }); msg != "" {
+		return &inheritanceError{http.StatusConflict, msg}
+	}
+	return nil
+}
+
+// ancestors returns env's parent, the parent's parent and so on
+func ancestors(ctx context.Context, q repository.Querier, env repository.Environment) ([]repository.Environment, error) {
+	var chain []repository.Environment
+	for env.ParentID.Valid {
+		if len(chain) == maxInheritanceDepth {
+			return nil, fmt.Errorf("environment %s has more than %d ancestors", env.ID, maxInheritanceDepth)
+		}
+		var err error
+		if env, err = q.GetEnvironmentByID(ctx, env.ParentID.Bytes); err != nil {
+			return nil, err
+		}
+		chain = append(chain, env)
+	}
+	return chain, nil
+}
+
+// mergedSecrets returns the decrypted active secrets env sees by key: its
+// own, then those of its parent it does not override, and so on up. Each
+// inherited secret names the environment it is stored in. It returns a
+// *deniedError if the caller may not read an ancestor's secrets.
+func (s *Server) mergedSecrets(ctx context.Context, dek *crypto.DataKey, project repository.Project, env repository.Environment) (map[string]secretResponse, error) {
+	values, err := s.environmentSecrets(ctx, dek, project.ID, env.ID)
+	if err != nil || !env.ParentID.Valid {
+		return values, err
+	}
+
+	chain, err := ancestors(ctx, s.store, env)
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range chain {
+		target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: parent.Name}
+		err := s.permit(ctx, policy.SecretRead, target)
+		var denied *deniedError
+		if errors.As(err, &denied) {
+			return nil, &deniedError{denied.status, denied.code, "inherited from " + parent.Name + ": " + denied.msg}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		inherited, err := s.environmentSecrets(ctx, dek, project.ID, parent.ID)
+		if err != nil {
+			return nil, err
+		}
+		for key, secret := range inherited {
+			if _, ok := values[key]; !ok {
+				secret.InheritedFrom = parent.Name
+				values[key] = secret
+			}
+		}
+	}
+	return values, nil
+}
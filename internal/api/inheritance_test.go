@@ -0,0 +1,279 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+func (te *testEnv) environmentsPath() string {
+	return "/projects/" + te.project.ID.String() + "/environments"
+}
+
+// seedBase makes dev inherit from a new "base" environment holding
+// DB_HOST, DB_URL and LOG_LEVEL, and overrides LOG_LEVEL in dev
+func seedBase(t *testing.T, te *testEnv) {
+	t.Helper()
+
+	rec := te.do(t, http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: "base"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create base: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	for _, kv := range [][2]string{{"DB_HOST", "db.internal"}, {"DB_URL", "postgres://${DB_HOST}/app"}, {"LOG_LEVEL", "info"}} {
+		te.do(t, http.MethodPost, te.envPath("base")+"/secrets/", createSecretRequest{Key: kv[0], Value: kv[1]})
+	}
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "LOG_LEVEL", Value: "debug"})
+
+	rec = te.do(t, http.MethodPut, te.envPath("dev")+"/parent", setParentRequest{Parent: "base"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set parent: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCheckInheritance(t *testing.T) {
+	tests := []struct {
+		parents map[string]string
+		want    string
+	}{
+		{map[string]string{"b": "a", "c": "b"}, ""},
+		{map[string]string{"a": "a"}, "environment a would inherit from itself"},
+		{map[string]string{"x": "a", "a": "b", "b": "a"}, "environment a would inherit from itself"},
+		{map[string]string{"a": "b", "b": "c", "c": "d", "d": "e", "e": "f", "f": "g", "g": "h", "h": "i", "i": "j", "j": "k", "k": "l"}, "environment a would have more than 10 ancestors"},
+	}
+	for _, tt := range tests {
+		keys := []string{"x", "a", "b", "c"}
+		if got := checkInheritance(keys, tt.parents, func(k string) string { return k }); got != tt.want {
+			t.Errorf("checkInheritance(%v) = %q; want %q", tt.parents, got, tt.want)
+		}
+	}
+}
+
+func TestEnvironmentInheritance(t *testing.T) {
+	te := newTestEnv(t)
+	seedBase(t, te)
+
+	rec := te.do(t, http.MethodGet, te.secretsPath(), nil)
+	var list []secretResponse
+	decodeData(t, rec, &list)
+	from := make(map[string]string, len(list))
+	for _, s := range list {
+		from[s.Key] = s.InheritedFrom
+	}
+	if want := map[string]string{"DB_HOST": "base", "DB_URL": "base", "LOG_LEVEL": ""}; !reflect.DeepEqual(from, want) {
+		t.Errorf("Expected keys annotated with their origin %v, got %v", want, from)
+	}
+	if v := te.values(t); v["LOG_LEVEL"] != "debug" || v["DB_URL"] != "postgres://db.internal/app" {
+		t.Errorf("Expected dev's override and the parent's values, got %v", v)
+	}
+
+	// Edits to the parent reach keys dev does not override, and references
+	// in inherited values resolve against dev
+	te.do(t, http.MethodPut, te.envPath("base")+"/secrets/LOG_LEVEL", updateSecretRequest{Value: "warn"})
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "DB_HOST", Value: "localhost"})
+	if v := te.values(t); v["LOG_LEVEL"] != "debug" || v["DB_URL"] != "postgres://localhost/app" {
+		t.Errorf("Expected the override to win and DB_URL to use it, got %v", v)
+	}
+	te.do(t, http.MethodDelete, te.secretsPath()+"LOG_LEVEL", nil)
+	if v := te.values(t); v["LOG_LEVEL"] != "warn" {
+		t.Errorf("Expected the parent's value once the override is deleted, got %v", v)
+	}
+
+	rec = te.do(t, http.MethodGet, te.secretsPath()+"DB_URL", nil)
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+	if secret.Value != "postgres://localhost/app" || secret.InheritedFrom != "base" {
+		t.Errorf("get: expected the inherited secret, got %+v", secret)
+	}
+	if rec := te.do(t, http.MethodGet, te.secretsPath()+"NOPE", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("get missing: expected 404, got %d", rec.Code)
+	}
+	rec = te.do(t, http.MethodGet, te.secretsPath()+"export?format=dotenv", nil)
+	if !strings.Contains(rec.Body.String(), "LOG_LEVEL=warn\n") {
+		t.Errorf("Expected the export to include inherited secrets, got %s", rec.Body)
+	}
+
+	// Diffs only compare dev's own secrets
+	rec = te.do(t, http.MethodGet, te.envPath("dev")+"/diff?target=base", nil)
+	var diff environmentDiffResponse
+	decodeData(t, rec, &diff)
+	if len(diff.Removed) != 2 || diff.Removed[0].Key != "DB_URL" || diff.Removed[1].Key != "LOG_LEVEL" {
+		t.Errorf("Expected inherited keys to be missing from dev, got %+v", diff)
+	}
+
+	if rec := te.do(t, http.MethodDelete, te.envPath("dev")+"/parent", nil); rec.Code != http.StatusOK {
+		t.Fatalf("remove parent: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if v := te.values(t); !reflect.DeepEqual(v, map[string]string{"DB_HOST": "localhost"}) {
+		t.Errorf("Expected only dev's own secrets without a parent, got %v", v)
+	}
+}
+
+func TestEnvironmentInheritanceLogsReads(t *testing.T) {
+	te := newTestEnv(t)
+	seedBase(t, te)
+	base, _ := te.store.GetEnvironmentByName(t.Context(), repository.GetEnvironmentByNameParams{ProjectID: te.project.ID, Name: "base"})
+	host, _ := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: base.ID, Key: "DB_HOST"})
+	te.store.RewriteAccessLogs(func([]repository.AccessLog) []repository.AccessLog { return nil })
+
+	te.do(t, http.MethodGet, te.secretsPath()+"DB_URL", nil)
+	var reads int
+	for _, l := range te.accessLogs(t) {
+		if l.ResourceType == resourceSecret && l.Action == repository.AccessActionRead && l.ResourceID == host.ID {
+			reads++
+		}
+	}
+	if reads != 1 {
+		t.Errorf("Expected the inherited DB_HOST to be logged as read once, got %d", reads)
+	}
+}
+
+func TestEnvironmentParentErrors(t *testing.T) {
+	te := newTestEnv(t)
+	seedBase(t, te)
+	te.protect(1)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    any
+		status  int
+		message string
+	}{
+		{"Self", http.MethodPut, te.envPath("base") + "/parent", setParentRequest{Parent: "base"}, http.StatusConflict, "environment base would inherit from itself"},
+		{"Cycle", http.MethodPut, te.envPath("base") + "/parent", setParentRequest{Parent: "dev"}, http.StatusConflict, "environment base would inherit from itself"},
+		{"Unknown parent", http.MethodPut, te.envPath("dev") + "/parent", setParentRequest{Parent: "qa"}, http.StatusBadRequest, "parent environment qa not found"},
+		{"Missing parent", http.MethodPut, te.envPath("dev") + "/parent", setParentRequest{}, http.StatusBadRequest, "parent is required"},
+		{"Unprotected parent", http.MethodPut, te.envPath("production") + "/parent", setParentRequest{Parent: "base"}, http.StatusConflict, "protected environment production cannot inherit from unprotected environment base"},
+		{"Create unprotected parent", http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: "prod-eu", Protected: true, Parent: "dev"}, http.StatusConflict, "cannot inherit from unprotected environment dev"},
+		{"Create unknown parent", http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: "alice", Parent: "qa"}, http.StatusBadRequest, "parent environment qa not found"},
+		{"Create duplicate", http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: "dev"}, http.StatusConflict, "environment dev already exists"},
+		{"Create invalid name", http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: "a b"}, http.StatusBadRequest, "environment name may only contain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := te.do(t, tt.method, tt.path, tt.body)
+			if rec.Code != tt.status || !strings.Contains(errorMessage(t, rec), tt.message) {
+				t.Errorf("Expected %d %q, got %d: %s", tt.status, tt.message, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	// A chain of 10 ancestors is allowed, an 11th is not
+	parent := "base"
+	for i := 1; i < maxInheritanceDepth; i++ {
+		name := fmt.Sprintf("level-%d", i)
+		if rec := te.do(t, http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: name, Parent: parent}); rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: expected 201, got %d: %s", name, rec.Code, rec.Body)
+		}
+		parent = name
+	}
+	rec := te.do(t, http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: "too-deep", Parent: parent})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("tenth ancestor: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	rec = te.do(t, http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: "deeper", Parent: "too-deep"})
+	if rec.Code != http.StatusConflict || !strings.Contains(errorMessage(t, rec), "more than 10 ancestors") {
+		t.Errorf("eleventh ancestor: expected 409, got %d: %s", rec.Code, rec.Body)
+	}
+	// Moving base under dev's sibling would push too-deep past the limit too
+	te.do(t, http.MethodPost, te.environmentsPath(), EnvironmentTemplate{Name: "root"})
+	rec = te.do(t, http.MethodPut, te.envPath("base")+"/parent", setParentRequest{Parent: "root"})
+	if rec.Code != http.StatusConflict || !strings.Contains(errorMessage(t, rec), "environment too-deep would have more than 10 ancestors") {
+		t.Errorf("deepen chain: expected 409, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestEnvironmentParentPermissions(t *testing.T) {
+	te := newTestEnv(t)
+	seedBase(t, te)
+	te.store.AddEnvironment(repository.Environment{ProjectID: te.project.ID, Name: "prod-base", IsProtected: ptr(true)})
+	te.store.AddEnvironment(repository.Environment{ProjectID: te.project.ID, Name: "production", IsProtected: ptr(true)})
+
+	te.loginAs(t, repository.OrgRoleViewer)
+	if rec := te.do(t, http.MethodPut, te.envPath("base")+"/parent", setParentRequest{Parent: "dev"}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer: expected 403, got %d", rec.Code)
+	}
+	if rec := te.do(t, http.MethodGet, te.environmentsPath(), nil); rec.Code != http.StatusOK {
+		t.Errorf("viewer list: expected 200, got %d", rec.Code)
+	}
+
+	// Members may rewire unprotected environments but not protected ones,
+	// whose secrets would change without an approval
+	te.loginAs(t, repository.OrgRoleMember)
+	if rec := te.do(t, http.MethodDelete, te.envPath("dev")+"/parent", nil); rec.Code != http.StatusOK {
+		t.Errorf("member unprotected: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := te.do(t, http.MethodPut, te.envPath("production")+"/parent", setParentRequest{Parent: "prod-base"}); rec.Code != http.StatusForbidden {
+		t.Errorf("member protected: expected 403, got %d", rec.Code)
+	}
+	te.loginAs(t, repository.OrgRoleAdmin)
+	if rec := te.do(t, http.MethodPut, te.envPath("production")+"/parent", setParentRequest{Parent: "prod-base"}); rec.Code != http.StatusOK {
+		t.Errorf("admin protected: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// A token limited to dev cannot read what dev inherits
+	te.do(t, http.MethodPut, te.envPath("dev")+"/parent", setParentRequest{Parent: "base"})
+	scope := "read:secrets:project/" + te.project.ID.String() + "/env/dev"
+	rec := te.do(t, http.MethodPost, "/tokens/", createTokenRequest{Name: "ci", Scopes: []string{scope}})
+	var created createTokenResponse
+	decodeData(t, rec, &created)
+	te.token = created.Token
+
+	rec = te.do(t, http.MethodGet, te.secretsPath(), nil)
+	if rec.Code != http.StatusForbidden || !strings.HasPrefix(errorMessage(t, rec), "inherited from base: token is missing required scope") {
+		t.Errorf("Expected 403 naming the parent, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := te.do(t, http.MethodGet, te.secretsPath()+"LOG_LEVEL?raw=true", nil); rec.Code != http.StatusOK {
+		t.Errorf("own secret: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProjectTemplateInheritance(t *testing.T) {
+	te := newTestEnv(t)
+
+	templates := []EnvironmentTemplate{
+		{Name: "dev", Parent: "common"},
+		{Name: "common"},
+		{Name: "production", Protected: true},
+	}
+	rec := te.do(t, http.MethodPost, te.projectsPath(), createProjectRequest{Name: "web", Environments: &templates})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var got projectResponse
+	decodeData(t, rec, &got)
+	if dev := got.Environments[0]; dev.Parent == nil || *dev.Parent != "common" {
+		t.Errorf("Expected dev to inherit from common, got %+v", dev)
+	}
+
+	rec = te.do(t, http.MethodPost, "/projects/"+got.ID.String()+"/clone", cloneProjectRequest{Name: "web-copy"})
+	var clone cloneProjectResponse
+	decodeData(t, rec, &clone)
+	parents := make(map[string]string)
+	for _, env := range clone.Environments {
+		if env.Parent != nil {
+			parents[env.Name] = *env.Parent
+		}
+	}
+	if !reflect.DeepEqual(parents, map[string]string{"dev": "common"}) {
+		t.Errorf("Expected the clone to keep parents, got %v", parents)
+	}
+
+	for _, tt := range []struct {
+		templates []EnvironmentTemplate
+		message   string
+	}{
+		{[]EnvironmentTemplate{{Name: "dev", Parent: "qa"}}, "environment dev: parent qa is not in the list"},
+		{[]EnvironmentTemplate{{Name: "a", Parent: "b"}, {Name: "b", Parent: "a"}}, "environment a would inherit from itself"},
+		{[]EnvironmentTemplate{{Name: "dev"}, {Name: "prod", Protected: true, Parent: "dev"}}, "protected environment prod cannot inherit from unprotected environment dev"},
+	} {
+		if err := ValidateEnvironmentTemplates(tt.templates); err == nil || err.Error() != tt.message {
+			t.Errorf("ValidateEnvironmentTemplates(%+v) = %v; want %q", tt.templates, err, tt.message)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// The background jobs (Reaper, Reminder, LeaseRevoker and WebhookSender) run
+// in every API replica. Each works in batches, one transaction per batch,
+// and every batch starts by trying to take the job's Postgres advisory
+// lock. While one replica holds it, the others skip their turn instead of
+// waiting, so the work is done once and replicas never block each other.
+
+// runPeriodically calls run every interval until ctx is done, logging its
+// errors
+func runPeriodically(ctx context.Context, interval time.Duration, run func(context.Context) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := run(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("api: %v", err)
+			}
+		}
+	}
+}
+
+// runBatches calls batch until it reports that there is no more to do or
+// fails, and returns the total it handled
+func runBatches(ctx context.Context, batch func(context.Context) (n int, more bool, err error)) (int, error) {
+	total := 0
+	for {
+		n, more, err := batch(ctx)
+		total += n
+		if err != nil || !more {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+	"github.com/Now-Tiger/envhub/pkg/database"
+)
+
+// Defaults for a LeaseRevoker
+const (
+	DefaultRevokeInterval  = time.Minute
+	DefaultRevokeBatchSize = 100
+)
+
+// revokerUserAgent marks the access log entries of expired leases, which
+// have no user
+const revokerUserAgent = "envhub-lease-revoker"
+
+// LeaseRevoker drops the roles of dynamic secret leases that have expired.
+// A role that cannot be dropped keeps its lease, with the error, and is
+// retried on the next run.
+type LeaseRevoker struct {
+	store     repository.Store
+	vault     *vault.Vault
+	run       StatementRunner
+	batchSize int
+}
+
+// LeaseRevokerOption configures a LeaseRevoker
+type LeaseRevokerOption func(*LeaseRevoker)
+
+// WithRevokeBatchSize sets the most leases revoked in one transaction
+func WithRevokeBatchSize(n int) LeaseRevokerOption {
+	return func(l *LeaseRevoker) { l.batchSize = n }
+}
+
+// WithRevokeStatementRunner replaces database.RunStatements as the way
+// roles are dropped
+func WithRevokeStatementRunner(run StatementRunner) LeaseRevokerOption {
+	return func(l *LeaseRevoker) { l.run = run }
+}
+
+// NewLeaseRevoker creates a LeaseRevoker for the leases in store, whose
+// admin connections v decrypts
+func NewLeaseRevoker(store repository.Store, v *vault.Vault, opts ...LeaseRevokerOption) *LeaseRevoker {
+	l := &LeaseRevoker{store: store, vault: v, run: database.RunStatements, batchSize: DefaultRevokeBatchSize}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Revoke drops the role of every expired lease it can and returns how many
+// leases it revoked
+func (l *LeaseRevoker) Revoke(ctx context.Context) (int, error) {
+	n, err := runBatches(ctx, l.revokeBatch)
+	if err != nil {
+		return n, fmt.Errorf("revoke leases: %w", err)
+	}
+	return n, nil
+}
+
+// Run revokes every interval until ctx is done
+func (l *LeaseRevoker) Run(ctx context.Context, interval time.Duration) {
+	runPeriodically(ctx, interval, l.Revoke)
+}
+
+// revokeBatch revokes the leases that expired first in one transaction.
+// more reports whether a full batch was revoked; failed leases end the run,
+// so they are not retried right away.
+func (l *LeaseRevoker) revokeBatch(ctx context.Context) (n int, more bool, err error) {
+	var revoked, failed int
+	err = l.store.ExecTx(ctx, func(q repository.Querier) error {
+		revoked, failed = 0, 0
+		locked, err := q.TryLockDynamicSecretLeases(ctx)
+		if err != nil || !locked {
+			return err
+		}
+
+		rows, err := q.ListExpiredDynamicSecretLeases(ctx, int32(l.batchSize))
+		if err != nil {
+			return err
+		}
+		keys := make(map[uuid.UUID]*crypto.DataKey)
+		agent := revokerUserAgent
+		for _, row := range rows {
+			if err := l.dropRole(ctx, q, keys, row); err != nil {
+				failed++
+				log.Printf("api: lease %s of dynamic secret %s: %v", row.ID, row.Key, err)
+				msg := err.Error()
+				if err := q.FailDynamicSecretLeaseRevocation(ctx, repository.FailDynamicSecretLeaseRevocationParams{
+					ID:          row.ID,
+					RevokeError: &msg,
+				}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := q.RevokeDynamicSecretLease(ctx, row.ID); err != nil {
+				return err
+			}
+			if _, err := q.CreateAccessLog(ctx, repository.CreateAccessLogParams{
+				ResourceType:   resourceDynamicSecret,
+				ResourceID:     row.DynamicSecretID,
+				Action:         repository.AccessActionUpdate,
+				UserAgent:      &agent,
+				Success:        true,
+				CreatedAt:      time.Now(),
+				OrganizationID: pgtype.UUID{Bytes: row.OrganizationID, Valid: true},
+			}); err != nil {
+				return err
+			}
+			revoked++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	if revoked+failed > 0 {
+		log.Printf("api: revoked %d expired leases (%d failed)", revoked, failed)
+	}
+	return revoked, failed == 0 && revoked == l.batchSize, nil
+}
+
+// dropRole runs the revocation statements of row's dynamic secret, with the
+// project DEKs cached in keys
+func (l *LeaseRevoker) dropRole(ctx context.Context, q repository.Querier, keys map[uuid.UUID]*crypto.DataKey, row repository.ListExpiredDynamicSecretLeasesRow) error {
+	dek, ok := keys[row.ProjectID]
+	if !ok {
+		project, err := q.GetProjectByID(ctx, row.ProjectID)
+		if err != nil {
+			return err
+		}
+		if dek, err = l.vault.DataKey(project); err != nil {
+			return err
+		}
+		keys[row.ProjectID] = dek
+	}
+
+	id := vault.DynamicSecretIdentity{ProjectID: row.ProjectID, EnvironmentID: row.EnvironmentID, Key: row.Key}
+	conn, err := l.vault.DecryptValue(dek, id, row.EncryptedConnection)
+	if err != nil {
+		return err
+	}
+	return dropRole(ctx, l.run, conn, row.RevocationStatements, row.RoleName)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+func (lockedQuerier) TryLockDynamicSecretLeases(context.Context) (bool, error) {
+	return false, nil
+}
+
+func (lockedQuerier) ListExpiredDynamicSecretLeases(context.Context, int32) ([]repository.ListExpiredDynamicSecretLeasesRow, error) {
+	return nil, errors.New("listed without the lock")
+}
+
+// expireLease issues credentials for key and moves their lease into the past
+func (te *testEnv) expireLease(t *testing.T, key string) credentialsResponse {
+	t.Helper()
+
+	creds := te.issueCredentials(t, key, "")
+	d, _ := te.store.GetDynamicSecretByKey(t.Context(), repository.GetDynamicSecretByKeyParams{EnvironmentID: te.env.ID, Key: key})
+	// Replace the lease with one for the same role that has expired
+	if err := te.store.RevokeDynamicSecretLease(t.Context(), creds.LeaseID); err != nil {
+		t.Fatalf("RevokeDynamicSecretLease failed: %v", err)
+	}
+	lease, err := te.store.CreateDynamicSecretLease(t.Context(), repository.CreateDynamicSecretLeaseParams{
+		DynamicSecretID: d.ID,
+		RoleName:        creds.Username,
+		ExpiresAt:       time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("CreateDynamicSecretLease failed: %v", err)
+	}
+	creds.LeaseID = lease.ID
+	return creds
+}
+
+func TestLeaseRevokerDropsExpiredRoles(t *testing.T) {
+	te, target := newDynamicTestEnv(t)
+	expired := te.expireLease(t, "DATABASE_URL")
+	live := te.issueCredentials(t, "DATABASE_URL", "")
+	revoker := NewLeaseRevoker(te.store, te.vault, WithRevokeStatementRunner(target.run))
+
+	// Failures are recorded and retried on the next run
+	target.fail(errors.New("connection refused"))
+	if n, err := revoker.Revoke(t.Context()); err != nil || n != 0 {
+		t.Fatalf("Expected nothing revoked while the target is down, got %d (%v)", n, err)
+	}
+	if l, _ := te.store.Lease(expired.LeaseID); l.RevokedAt.Valid || l.RevokeError == nil {
+		t.Errorf("Expected the failure on the lease, got %+v", l)
+	}
+	target.fail(nil)
+
+	if n, err := revoker.Revoke(t.Context()); err != nil || n != 1 {
+		t.Fatalf("Expected 1 lease revoked, got %d (%v)", n, err)
+	}
+	if target.has(expired.Username) || !target.has(live.Username) {
+		t.Errorf("Expected only %s to be dropped, got %v", expired.Username, target.roles)
+	}
+	if l, _ := te.store.Lease(expired.LeaseID); !l.RevokedAt.Valid || l.RevokeError != nil {
+		t.Errorf("Expected the lease to be revoked, got %+v", l)
+	}
+	if n, err := revoker.Revoke(t.Context()); err != nil || n != 0 {
+		t.Errorf("Expected nothing revoked twice, got %d (%v)", n, err)
+	}
+
+	var logged bool
+	for _, l := range te.store.AccessLogs() {
+		if l.ResourceType == resourceDynamicSecret && l.UserAgent != nil && *l.UserAgent == revokerUserAgent {
+			logged = l.Action == repository.AccessActionUpdate && !l.UserID.Valid
+		}
+	}
+	if !logged {
+		t.Errorf("Expected the revocation in the access logs")
+	}
+}
+
+func TestLeaseRevokerBatches(t *testing.T) {
+	te, target := newDynamicTestEnv(t)
+	for i := 0; i < 5; i++ {
+		te.expireLease(t, "DATABASE_URL")
+	}
+
+	n, err := NewLeaseRevoker(te.store, te.vault, WithRevokeStatementRunner(target.run), WithRevokeBatchSize(2)).Revoke(t.Context())
+	if err != nil || n != 5 {
+		t.Errorf("Expected all 5 leases revoked in batches, got %d (%v)", n, err)
+	}
+	if len(target.roles) != 0 {
+		t.Errorf("Expected every role to be dropped, got %v", target.roles)
+	}
+}
+
+func TestReaperWaitsForLeases(t *testing.T) {
+	te, target := newDynamicTestEnv(t)
+	te.env = te.addExpiring("pr-1", time.Now().Add(-time.Minute))
+	te.do(t, "POST", te.dynamicSecretsPath(), createDynamicSecretRequest{Key: "DATABASE_URL", ConnectionURL: targetURL})
+	creds := te.expireLease(t, "DATABASE_URL")
+
+	// Deleting the environment would lose track of the role
+	if n, err := NewReaper(te.store).Reap(t.Context()); err != nil || n != 0 {
+		t.Fatalf("Expected the environment to be kept while a lease is active, got %d (%v)", n, err)
+	}
+	if _, err := NewLeaseRevoker(te.store, te.vault, WithRevokeStatementRunner(target.run)).Revoke(t.Context()); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if n, err := NewReaper(te.store).Reap(t.Context()); err != nil || n != 1 {
+		t.Errorf("Expected the environment reaped once its lease is revoked, got %d (%v)", n, err)
+	}
+	if target.has(creds.Username) {
+		t.Errorf("Expected the role to be dropped")
+	}
+}
+
+func TestLeaseRevokerSkipsWhileLocked(t *testing.T) {
+	te, target := newDynamicTestEnv(t)
+	creds := te.expireLease(t, "DATABASE_URL")
+
+	n, err := NewLeaseRevoker(lockedStore{te.store}, te.vault, WithRevokeStatementRunner(target.run)).Revoke(t.Context())
+	if err != nil || n != 0 {
+		t.Errorf("Expected nothing revoked while another replica holds the lock, got %d (%v)", n, err)
+	}
+	if !target.has(creds.Username) {
+		t.Errorf("Expected the role to remain")
+	}
+}
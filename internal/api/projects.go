@@ -0,0 +1,395 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// Column sizes of projects.name and projects.icon
+const (
+	maxProjectNameLength = 255
+	maxProjectIconLength = 50
+)
+
+type createProjectRequest struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Color       *string `json:"color"`
+	Icon        *string `json:"icon"`
+
+	// Environments replaces the server's environment templates; an empty
+	// list creates a project without environments
+	Environments *[]EnvironmentTemplate `json:"environments"`
+}
+
+type cloneProjectRequest struct {
+	Name string `json:"name"`
+
+	// Description defaults to the source project's
+	Description *string `json:"description"`
+
+	// IncludeSecrets copies every secret, re-encrypted under the new
+	// project's DEK
+	IncludeSecrets bool `json:"include_secrets"`
+}
+
+type projectResponse struct {
+	ID             uuid.UUID             `json:"id"`
+	OrganizationID uuid.UUID             `json:"organization_id"`
+	Name           string                `json:"name"`
+	Description    *string               `json:"description,omitempty"`
+	Color          *string               `json:"color,omitempty"`
+	Icon           *string               `json:"icon,omitempty"`
+	DEKVersion     int32                 `json:"dek_version"`
+	Environments   []environmentResponse `json:"environments"`
+	CreatedAt      time.Time             `json:"created_at"`
+}
+
+type cloneProjectResponse struct {
+	projectResponse
+	SourceID      uuid.UUID `json:"source_id"`
+	SecretsCopied int       `json:"secrets_copied"`
+}
+
+// rotateKeyResponse reports the outcome of a project DEK rotation
+type rotateKeyResponse struct {
+	OldDEKVersion  int `json:"old_dek_version"`
+	NewDEKVersion  int `json:"new_dek_version"`
+	SecretsRotated int `json:"secrets_rotated"`
+}
+
+// createProject creates a project with a new DEK and the server's default
+// environments, or those named in the request
+func (s *Server) createProject(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(chi.URLParam(r, "orgID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid organization id")
+		return
+	}
+	setAccessOrganization(r, orgID)
+
+	var req createProjectRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if msg := validateProject(req.Name, req.Color, req.Icon); msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return
+	}
+	templates := s.environments
+	if req.Environments != nil {
+		templates = *req.Environments
+		if err := ValidateEnvironmentTemplates(templates); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+	}
+
+	if !s.authorizeProjectCreate(w, r, orgID, len(templates) > 0) {
+		return
+	}
+
+	project, envs, err := s.newProject(r.Context(), repository.CreateProjectParams{
+		OrganizationID: orgID,
+		Name:           req.Name,
+		Description:    req.Description,
+		Color:          req.Color,
+		Icon:           req.Icon,
+	}, templates, nil)
+	if err != nil {
+		s.writeProjectError(w, err, req.Name)
+		return
+	}
+	setAccessTarget(r, resourceProject, project.ID, repository.AccessActionCreate)
+	for _, env := range envs {
+		addAccess(r, accessEntry{resourceEnvironment, env.ID, repository.AccessActionCreate})
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, toProjectResponse(project, envs))
+}
+
+// cloneProject creates a project with the same environments as the one in
+// the URL and, if asked, copies of its secrets. The copy gets its own DEK;
+// secrets are decrypted and re-encrypted under it.
+func (s *Server) cloneProject(w http.ResponseWriter, r *http.Request) {
+	source, ok := s.loadProject(w, r, policy.ProjectRead)
+	if !ok {
+		return
+	}
+
+	var req cloneProjectRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if msg := validateProject(req.Name, nil, nil); msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return
+	}
+	if req.Description == nil {
+		req.Description = source.Description
+	}
+
+	envs, err := s.store.ListEnvironmentsByProject(r.Context(), source.ID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	if !s.authorizeProjectCreate(w, r, source.OrganizationID, len(envs) > 0) {
+		return
+	}
+
+	names := environmentNamesByID(envs)
+	templates := make([]EnvironmentTemplate, len(envs))
+	for i, env := range envs {
+		templates[i] = environmentTemplate(env, names[env.ParentID.Bytes])
+	}
+
+	// values[i] holds the secrets of envs[i]
+	var values []map[string]secretResponse
+	var read []accessEntry
+	if req.IncludeSecrets {
+		for _, env := range envs {
+			target := auth.Target{OrganizationID: source.OrganizationID, ProjectID: source.ID, Environment: env.Name}
+			if !s.authorize(w, r, policy.SecretRead, target) {
+				return
+			}
+		}
+		if !s.authorize(w, r, policy.SecretCreate, auth.Target{OrganizationID: source.OrganizationID}) {
+			return
+		}
+
+		dek, err := s.vault.DataKey(source)
+		if err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+		values = make([]map[string]secretResponse, len(envs))
+		for i, env := range envs {
+			if values[i], err = s.environmentSecrets(r.Context(), dek, source.ID, env.ID); err != nil {
+				s.writeInternalError(w, err)
+				return
+			}
+			for _, secret := range values[i] {
+				read = append(read, accessEntry{resourceSecret, secret.ID, repository.AccessActionRead})
+			}
+		}
+	}
+
+	var written []accessEntry
+	copySecrets := func(ctx context.Context, q repository.Querier, dek *crypto.DataKey, project repository.Project, created []repository.Environment) error {
+		written = written[:0]
+		for i, env := range created {
+			copied, err := s.copySecrets(ctx, q, dek, project, env, values[i])
+			if err != nil {
+				return err
+			}
+			written = append(written, copied...)
+		}
+		return nil
+	}
+	if !req.IncludeSecrets {
+		copySecrets = nil
+	}
+
+	project, created, err := s.newProject(r.Context(), repository.CreateProjectParams{
+		OrganizationID: source.OrganizationID,
+		Name:           req.Name,
+		Description:    req.Description,
+		Color:          source.Color,
+		Icon:           source.Icon,
+	}, templates, copySecrets)
+	if err != nil {
+		s.writeProjectError(w, err, req.Name)
+		return
+	}
+	setAccessTarget(r, resourceProject, project.ID, repository.AccessActionCreate)
+	addAccess(r, accessEntry{resourceProject, source.ID, repository.AccessActionRead})
+	for _, env := range created {
+		addAccess(r, accessEntry{resourceEnvironment, env.ID, repository.AccessActionCreate})
+	}
+	addAccess(r, read...)
+	addAccess(r, written...)
+
+	utils.WriteJSON(w, http.StatusCreated, cloneProjectResponse{
+		projectResponse: toProjectResponse(project, created),
+		SourceID:        source.ID,
+		SecretsCopied:   len(written),
+	})
+}
+
+// newProject generates and wraps a DEK, then creates the project and one
+// environment per template in a single transaction. Parents are set once
+// every environment exists, so templates may name them in any order. seed,
+// if not nil, runs in the same transaction with the new DEK and environments.
+func (s *Server) newProject(ctx context.Context, params repository.CreateProjectParams, templates []EnvironmentTemplate, seed func(context.Context, repository.Querier, *crypto.DataKey, repository.Project, []repository.Environment) error) (repository.Project, []repository.Environment, error) {
+	dek, err := crypto.GenerateDataKey()
+	if err != nil {
+		return repository.Project{}, nil, err
+	}
+	params.EncryptedDek, err = s.vault.WrapDataKey(dek)
+	if err != nil {
+		return repository.Project{}, nil, err
+	}
+	params.DekVersion = int32(dek.Version)
+
+	var project repository.Project
+	var envs []repository.Environment
+	err = s.store.ExecTx(ctx, func(q repository.Querier) error {
+		var err error
+		if project, err = q.CreateProject(ctx, params); err != nil {
+			return err
+		}
+		envs = make([]repository.Environment, len(templates))
+		ids := make(map[string]uuid.UUID, len(templates))
+		for i, t := range templates {
+			if envs[i], err = q.CreateEnvironment(ctx, environmentParams(project.ID, t)); err != nil {
+				return err
+			}
+			ids[t.Name] = envs[i].ID
+		}
+		for i, t := range templates {
+			if t.Parent == "" {
+				continue
+			}
+			envs[i], err = q.SetEnvironmentParent(ctx, repository.SetEnvironmentParentParams{
+				ID:       envs[i].ID,
+				ParentID: pgtype.UUID{Bytes: ids[t.Parent], Valid: true},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, env := range envs {
+			if err := recordEnvironmentEvent(ctx, q, project, eventEnvironmentCreated, env); err != nil {
+				return err
+			}
+		}
+		if seed == nil {
+			return nil
+		}
+		return seed(ctx, q, dek, project, envs)
+	})
+	if err != nil {
+		return repository.Project{}, nil, err
+	}
+	return project, envs, nil
+}
+
+// copySecrets encrypts values for env under dek and creates them there as
+// new secrets attributed to the caller, in key order
+func (s *Server) copySecrets(ctx context.Context, q repository.Querier, dek *crypto.DataKey, project repository.Project, env repository.Environment, values map[string]secretResponse) ([]accessEntry, error) {
+	user := auth.UserIDFromContext(ctx)
+	written := make([]accessEntry, 0, len(values))
+	for _, key := range sortedKeys(values, nil) {
+		secret := values[key]
+		id := vault.SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: key, Version: 1}
+		encrypted, err := s.vault.EncryptValue(dek, id, secret.Value)
+		if err != nil {
+			return nil, err
+		}
+		active := true
+		copied, err := q.CreateSecret(ctx, repository.CreateSecretParams{
+			EnvironmentID:  env.ID,
+			Key:            key,
+			EncryptedValue: encrypted,
+			Description:    secret.Description,
+			IsActive:       &active,
+			Version:        1,
+			CreatedBy:      user,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := recordSecretEvent(ctx, q, project, env, eventSecretCreated, copied, user); err != nil {
+			return nil, err
+		}
+		written = append(written, accessEntry{resourceSecret, copied.ID, repository.AccessActionCreate})
+	}
+	return written, nil
+}
+
+// authorizeProjectCreate checks that the caller may create projects in the
+// organization and, if withEnvironments, environments in them
+func (s *Server) authorizeProjectCreate(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, withEnvironments bool) bool {
+	target := auth.Target{OrganizationID: orgID}
+	if !s.authorize(w, r, policy.ProjectCreate, target) {
+		return false
+	}
+	return !withEnvironments || s.authorize(w, r, policy.EnvironmentCreate, target)
+}
+
+// writeProjectError maps newProject errors to HTTP responses
+func (s *Server) writeProjectError(w http.ResponseWriter, err error, name string) {
+	if isUniqueViolation(err) {
+		utils.WriteError(w, http.StatusConflict, CodeConflict, "project "+name+" already exists")
+		return
+	}
+	s.writeStoreError(w, err, "organization not found")
+}
+
+// validateProject returns a non-empty message if a project field is invalid
+func validateProject(name string, color, icon *string) string {
+	switch {
+	case name == "":
+		return "name is required"
+	case len(name) > maxProjectNameLength:
+		return "name must be at most 255 characters"
+	case !validColor(color):
+		return "color must be #RRGGBB"
+	case icon != nil && len(*icon) > maxProjectIconLength:
+		return "icon must be at most 50 characters"
+	}
+	return ""
+}
+
+func toProjectResponse(project repository.Project, envs []repository.Environment) projectResponse {
+	return projectResponse{
+		ID:             project.ID,
+		OrganizationID: project.OrganizationID,
+		Name:           project.Name,
+		Description:    project.Description,
+		Color:          project.Color,
+		Icon:           project.Icon,
+		DEKVersion:     project.DekVersion,
+		Environments:   toEnvironmentResponses(envs),
+		CreatedAt:      project.CreatedAt,
+	}
+}
+
+// rotateProjectKey replaces the project's DEK and re-encrypts all of its
+// secrets, including history, in a single transaction
+func (s *Server) rotateProjectKey(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, policy.ProjectRotateKey)
+	if !ok {
+		return
+	}
+
+	result, err := s.vault.RotateDataKey(r.Context(), s.store, project.ID, vault.DataKeyRotationOptions{
+		Actor:     auth.UserIDFromContext(r.Context()).Bytes,
+		OnRotated: recordDEKRotated,
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "project not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rotateKeyResponse{
+		OldDEKVersion:  result.OldKeyVersion,
+		NewDEKVersion:  result.NewKeyVersion,
+		SecretsRotated: result.ItemsRotated,
+	})
+}
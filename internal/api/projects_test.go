@@ -0,0 +1,214 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+func TestRotateProjectKey(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+	path := "/projects/" + te.project.ID.String() + "/rotate-key"
+
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "sk_live_1"})
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "DB_URL", Value: "postgres://db"})
+
+	rec := te.do(t, http.MethodPost, path, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rotate: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got rotateKeyResponse
+	decodeData(t, rec, &got)
+	if got.OldDEKVersion != 1 || got.NewDEKVersion != 2 || got.SecretsRotated != 2 {
+		t.Errorf("Unexpected rotation result %+v", got)
+	}
+
+	// Secrets still read back, and writes use the new key
+	rec = te.do(t, http.MethodGet, base+"API_KEY", nil)
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+	if secret.Value != "sk_live_1" {
+		t.Errorf("Expected value to survive rotation, got %q", secret.Value)
+	}
+	if rec := te.do(t, http.MethodPut, base+"DB_URL", updateSecretRequest{Value: "postgres://new"}); rec.Code != http.StatusOK {
+		t.Errorf("update after rotation: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	te.loginAs(t, repository.OrgRoleMember)
+	if rec := te.do(t, http.MethodPost, path, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("member rotate: expected 403, got %d", rec.Code)
+	}
+}
+
+func (te *testEnv) projectsPath() string {
+	return "/organizations/" + te.project.OrganizationID.String() + "/projects"
+}
+
+func environmentNames(envs []environmentResponse) []string {
+	names := make([]string, len(envs))
+	for i, env := range envs {
+		names[i] = env.Name
+	}
+	return names
+}
+
+func TestCreateProject(t *testing.T) {
+	te := newTestEnv(t)
+
+	rec := te.do(t, http.MethodPost, te.projectsPath(), createProjectRequest{Name: "web", Color: ptr("#123abc")})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var got projectResponse
+	decodeData(t, rec, &got)
+	if names := environmentNames(got.Environments); !reflect.DeepEqual(names, []string{"dev", "staging", "production"}) {
+		t.Errorf("Expected the default environments, got %v", names)
+	}
+	if prod := got.Environments[2]; !prod.Protected || prod.RequiredApprovals != 1 || *prod.Color != "#EF4444" {
+		t.Errorf("Expected a protected production environment, got %+v", prod)
+	}
+	if got.DEKVersion != 1 || *got.Color != "#123abc" {
+		t.Errorf("Unexpected project %+v", got)
+	}
+
+	// The generated DEK encrypts and decrypts the new project's secrets
+	te.project, _ = te.store.Project(got.ID)
+	if rec := te.do(t, http.MethodPost, te.envPath("dev")+"/secrets/", createSecretRequest{Key: "A", Value: "1"}); rec.Code != http.StatusCreated {
+		t.Fatalf("create secret: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	rec = te.do(t, http.MethodGet, te.envPath("dev")+"/secrets/A", nil)
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+	if secret.Value != "1" {
+		t.Errorf("Expected 1, got %q", secret.Value)
+	}
+	if rec := te.do(t, http.MethodPost, te.envPath("production")+"/secrets/", createSecretRequest{Key: "A", Value: "1"}); rec.Code != http.StatusAccepted {
+		t.Errorf("create in production: expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// The request may name its own environments
+	envs := []EnvironmentTemplate{{Name: "local"}, {Name: "live", Protected: true, RequiredApprovals: 2}}
+	rec = te.do(t, http.MethodPost, te.projectsPath(), createProjectRequest{Name: "worker", Environments: &envs})
+	decodeData(t, rec, &got)
+	if names := environmentNames(got.Environments); !reflect.DeepEqual(names, []string{"local", "live"}) || got.Environments[1].RequiredApprovals != 2 {
+		t.Errorf("Expected the requested environments, got %+v", got.Environments)
+	}
+	rec = te.do(t, http.MethodPost, te.projectsPath(), createProjectRequest{Name: "empty", Environments: &[]EnvironmentTemplate{}})
+	decodeData(t, rec, &got)
+	if len(got.Environments) != 0 {
+		t.Errorf("Expected no environments, got %+v", got.Environments)
+	}
+
+	logged := false
+	for _, l := range te.accessLogs(t) {
+		if l.ResourceType == resourceProject && l.ResourceID == got.ID && l.Action == repository.AccessActionCreate {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Error("Expected the project creation to be logged")
+	}
+}
+
+func TestCreateProjectErrors(t *testing.T) {
+	te := newTestEnv(t)
+
+	tests := []struct {
+		name   string
+		body   createProjectRequest
+		status int
+	}{
+		{"No name", createProjectRequest{}, http.StatusBadRequest},
+		{"Bad color", createProjectRequest{Name: "web", Color: ptr("red")}, http.StatusBadRequest},
+		{"Bad environment name", createProjectRequest{Name: "web", Environments: &[]EnvironmentTemplate{{Name: "a/b"}}}, http.StatusBadRequest},
+		{"Duplicate environment", createProjectRequest{Name: "web", Environments: &[]EnvironmentTemplate{{Name: "dev"}, {Name: "dev"}}}, http.StatusBadRequest},
+		{"Negative approvals", createProjectRequest{Name: "web", Environments: &[]EnvironmentTemplate{{Name: "dev", RequiredApprovals: -1}}}, http.StatusBadRequest},
+		{"Existing name", createProjectRequest{Name: te.project.Name}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := te.do(t, http.MethodPost, te.projectsPath(), tt.body); rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	if rec := te.do(t, http.MethodPost, "/organizations/"+uuid.NewString()+"/projects", createProjectRequest{Name: "web"}); rec.Code != http.StatusNotFound {
+		t.Errorf("other organization: expected 404, got %d", rec.Code)
+	}
+	te.loginAs(t, repository.OrgRoleViewer)
+	if rec := te.do(t, http.MethodPost, te.projectsPath(), createProjectRequest{Name: "web"}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer create: expected 403, got %d", rec.Code)
+	}
+}
+
+func TestCreateProjectWithTemplates(t *testing.T) {
+	te := newTestEnv(t, WithEnvironmentTemplates([]EnvironmentTemplate{{Name: "qa", Color: ptr("#000000")}}))
+
+	rec := te.do(t, http.MethodPost, te.projectsPath(), createProjectRequest{Name: "web"})
+	var got projectResponse
+	decodeData(t, rec, &got)
+	if names := environmentNames(got.Environments); !reflect.DeepEqual(names, []string{"qa"}) {
+		t.Errorf("Expected the configured environments, got %v", names)
+	}
+}
+
+func TestCloneProject(t *testing.T) {
+	te := newTestEnv(t)
+	seedStaging(t, te)
+	te.store.AddEnvironment(repository.Environment{ProjectID: te.project.ID, Name: "prod", IsProtected: ptr(true), RequiredApprovals: 2, Color: ptr("#EF4444")})
+	source := te.project
+	path := "/projects/" + source.ID.String() + "/clone"
+
+	rec := te.do(t, http.MethodPost, path, cloneProjectRequest{Name: "api-copy", IncludeSecrets: true})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var got cloneProjectResponse
+	decodeData(t, rec, &got)
+	if names := environmentNames(got.Environments); !reflect.DeepEqual(names, []string{"dev", "staging", "prod"}) {
+		t.Errorf("Expected the source's environments, got %v", names)
+	}
+	if prod := got.Environments[2]; !prod.Protected || prod.RequiredApprovals != 2 || *prod.Color != "#EF4444" {
+		t.Errorf("Expected prod's settings to be copied, got %+v", prod)
+	}
+	if got.SourceID != source.ID || got.SecretsCopied != 7 {
+		t.Errorf("Expected 7 secrets copied from %s, got %+v", source.ID, got)
+	}
+
+	clone, _ := te.store.Project(got.ID)
+	if clone.EncryptedDek == source.EncryptedDek {
+		t.Error("Expected the clone to have its own DEK")
+	}
+	te.project = clone
+	if v := te.values(t); !reflect.DeepEqual(v, map[string]string{"ONLY_DEV": "d", "SAME": "s", "CHANGED": "new", "NEW_FEATURE": "on"}) {
+		t.Errorf("Expected dev's secrets in the clone, got %v", v)
+	}
+
+	// Copies are independent of the source
+	te.do(t, http.MethodPut, te.secretsPath()+"SAME", updateSecretRequest{Value: "changed"})
+	te.project = source
+	if v := te.values(t)["SAME"]; v != "s" {
+		t.Errorf("Expected the source to be untouched, got %q", v)
+	}
+
+	rec = te.do(t, http.MethodPost, path, cloneProjectRequest{Name: "api-shape"})
+	decodeData(t, rec, &got)
+	te.project, _ = te.store.Project(got.ID)
+	if len(got.Environments) != 3 || got.SecretsCopied != 0 || len(te.values(t)) != 0 {
+		t.Errorf("Expected environments without secrets, got %+v", got)
+	}
+
+	if rec := te.do(t, http.MethodPost, path, cloneProjectRequest{Name: "api-copy"}); rec.Code != http.StatusConflict {
+		t.Errorf("existing name: expected 409, got %d", rec.Code)
+	}
+	te.loginAs(t, repository.OrgRoleViewer)
+	if rec := te.do(t, http.MethodPost, path, cloneProjectRequest{Name: "viewer-copy", IncludeSecrets: true}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer clone: expected 403, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+)
+
+// diffEntry is one key that differs between two environments. Values are
+// only included when revealed.
+type diffEntry struct {
+	Key         string  `json:"key"`
+	SourceValue *string `json:"source_value,omitempty"`
+	TargetValue *string `json:"target_value,omitempty"`
+}
+
+// environmentDiffResponse compares a source environment to a target, as
+// what promoting every key of the source would do to the target
+type environmentDiffResponse struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Revealed bool   `json:"revealed"`
+	// Added keys are only in the source
+	Added []diffEntry `json:"added"`
+	// Removed keys are only in the target
+	Removed   []diffEntry `json:"removed"`
+	Changed   []diffEntry `json:"changed"`
+	Unchanged []string    `json:"unchanged"`
+}
+
+type promoteRequest struct {
+	Target string   `json:"target"`
+	Keys   []string `json:"keys"`
+
+	// Reason is recorded on the change request if the target is protected
+	Reason *string `json:"reason,omitempty"`
+}
+
+type promoteResponse struct {
+	Source    string   `json:"source"`
+	Target    string   `json:"target"`
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+}
+
+// diffEnvironments compares the secrets of the environment in the URL with
+// those of ?target=. Values are masked unless ?reveal=true.
+func (s *Server) diffEnvironments(w http.ResponseWriter, r *http.Request) {
+	project, source, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+	reveal := false
+	if v := r.URL.Query().Get("reveal"); v != "" {
+		var err error
+		if reveal, err = strconv.ParseBool(v); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "reveal must be true or false")
+			return
+		}
+	}
+	target, ok := s.loadTargetEnvironment(w, r, project, source, r.URL.Query().Get("target"))
+	if !ok {
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	from, err := s.environmentSecrets(r.Context(), dek, project.ID, source.ID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	to, err := s.environmentSecrets(r.Context(), dek, project.ID, target.ID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := environmentDiffResponse{
+		Source:    source.Name,
+		Target:    target.Name,
+		Revealed:  reveal,
+		Added:     []diffEntry{},
+		Removed:   []diffEntry{},
+		Changed:   []diffEntry{},
+		Unchanged: []string{},
+	}
+	// value returns a revealed value, recording that it was read
+	value := func(secret secretResponse) *string {
+		if !reveal {
+			return nil
+		}
+		addAccess(r, accessEntry{resourceSecret, secret.ID, repository.AccessActionRead})
+		return &secret.Value
+	}
+	for _, key := range sortedKeys(from, to) {
+		a, inSource := from[key]
+		b, inTarget := to[key]
+		switch {
+		case !inTarget:
+			resp.Added = append(resp.Added, diffEntry{Key: key, SourceValue: value(a)})
+		case !inSource:
+			resp.Removed = append(resp.Removed, diffEntry{Key: key, TargetValue: value(b)})
+		case a.Value != b.Value:
+			resp.Changed = append(resp.Changed, diffEntry{Key: key, SourceValue: value(a), TargetValue: value(b)})
+		default:
+			resp.Unchanged = append(resp.Unchanged, key)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// promoteSecrets copies the named secrets of the environment in the URL to
+// another environment of the project in one transaction. Missing secrets
+// are created and differing ones updated, taking the source's value and
+// description. In a protected target the copy is proposed as a change
+// request instead.
+func (s *Server) promoteSecrets(w http.ResponseWriter, r *http.Request) {
+	project, source, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+
+	var req promoteRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Keys) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "keys are required")
+		return
+	}
+	seen := make(map[string]bool, len(req.Keys))
+	for _, key := range req.Keys {
+		if seen[key] {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, key+": key appears more than once")
+			return
+		}
+		seen[key] = true
+	}
+	target, ok := s.loadTargetEnvironment(w, r, project, source, req.Target)
+	if !ok {
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	from, err := s.environmentSecrets(r.Context(), dek, project.ID, source.ID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	to, err := s.environmentSecrets(r.Context(), dek, project.ID, target.ID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := promoteResponse{
+		Source:    source.Name,
+		Target:    target.Name,
+		Created:   []string{},
+		Updated:   []string{},
+		Unchanged: []string{},
+	}
+	var changes []proposedChange
+	var read []accessEntry
+	for _, key := range req.Keys {
+		secret, ok := from[key]
+		if !ok {
+			utils.WriteError(w, http.StatusNotFound, CodeNotFound, key+": secret not found in "+source.Name)
+			return
+		}
+		read = append(read, accessEntry{resourceSecret, secret.ID, repository.AccessActionRead})
+
+		change := proposedChange{Key: key, Value: &secret.Value, Description: secret.Description}
+		existing, exists := to[key]
+		switch {
+		case !exists:
+			change.Operation = repository.ChangeOperationCreate
+			resp.Created = append(resp.Created, key)
+		case existing.Value != secret.Value:
+			change.Operation = repository.ChangeOperationUpdate
+			resp.Updated = append(resp.Updated, key)
+		default:
+			resp.Unchanged = append(resp.Unchanged, key)
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	if !s.authorizeChanges(w, r, project, target, changes) {
+		return
+	}
+	addAccess(r, read...)
+	if len(changes) == 0 {
+		utils.WriteJSON(w, http.StatusOK, resp)
+		return
+	}
+	if isProtected(target) {
+		s.proposeChanges(w, r, project, target, req.Reason, changes...)
+		return
+	}
+
+	written, err := s.writeSecrets(r.Context(), project, target, dek, to, changes)
+	if err != nil {
+		s.writeSecretsError(w, err)
+		return
+	}
+	addAccess(r, written...)
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// loadTargetEnvironment resolves the second environment of a comparison
+// and checks that the caller may read its secrets.
+// It writes an error response and returns ok=false otherwise.
+func (s *Server) loadTargetEnvironment(w http.ResponseWriter, r *http.Request, project repository.Project, source repository.Environment, name string) (repository.Environment, bool) {
+	switch name {
+	case "":
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "target is required")
+		return repository.Environment{}, false
+	case source.Name:
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "target must be another environment")
+		return repository.Environment{}, false
+	}
+
+	env, err := s.store.GetEnvironmentByName(r.Context(), repository.GetEnvironmentByNameParams{
+		ProjectID: project.ID,
+		Name:      name,
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "target environment not found")
+		return env, false
+	}
+	addAccess(r, accessEntry{resourceEnvironment, env.ID, repository.AccessActionRead})
+
+	target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+	if !s.authorize(w, r, policy.SecretRead, target) {
+		return env, false
+	}
+	return env, true
+}
+
+// authorizeChanges checks that the caller may make changes in env.
+// Proposing a change to a protected environment needs the same permission.
+func (s *Server) authorizeChanges(w http.ResponseWriter, r *http.Request, project repository.Project, env repository.Environment, changes []proposedChange) bool {
+	target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+	checked := make(map[policy.Action]bool, 3)
+	for _, c := range changes {
+		action := operationAction(c.Operation)
+		if checked[action] {
+			continue
+		}
+		if !s.authorize(w, r, action, target) {
+			return false
+		}
+		checked[action] = true
+	}
+	return true
+}
+
+// sortedKeys returns the keys of both maps, sorted and without duplicates
+func sortedKeys(a, b map[string]secretResponse) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// seedStaging adds a "staging" environment next to "dev" and fills both
+func seedStaging(t *testing.T, te *testEnv) repository.Environment {
+	t.Helper()
+
+	staging := te.store.AddEnvironment(repository.Environment{ProjectID: te.project.ID, Name: "staging"})
+	for env, values := range map[string]map[string]string{
+		"dev":     {"ONLY_DEV": "d", "SAME": "s", "CHANGED": "new", "NEW_FEATURE": "on"},
+		"staging": {"SAME": "s", "CHANGED": "old", "ONLY_STAGING": "x"},
+	} {
+		for k, v := range values {
+			rec := te.do(t, http.MethodPost, te.envPath(env)+"/secrets/", createSecretRequest{Key: k, Value: v})
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("seed %s/%s: expected 201, got %d: %s", env, k, rec.Code, rec.Body)
+			}
+		}
+	}
+	return staging
+}
+
+func (te *testEnv) envPath(name string) string {
+	return "/projects/" + te.project.ID.String() + "/environments/" + name
+}
+
+func TestDiffEnvironments(t *testing.T) {
+	te := newTestEnv(t)
+	seedStaging(t, te)
+
+	rec := te.do(t, http.MethodGet, te.envPath("dev")+"/diff?target=staging", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var diff environmentDiffResponse
+	decodeData(t, rec, &diff)
+	want := environmentDiffResponse{
+		Source:    "dev",
+		Target:    "staging",
+		Added:     []diffEntry{{Key: "NEW_FEATURE"}, {Key: "ONLY_DEV"}},
+		Removed:   []diffEntry{{Key: "ONLY_STAGING"}},
+		Changed:   []diffEntry{{Key: "CHANGED"}},
+		Unchanged: []string{"SAME"},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("Expected %+v, got %+v", want, diff)
+	}
+
+	rec = te.do(t, http.MethodGet, te.envPath("dev")+"/diff?target=staging&reveal=true", nil)
+	decodeData(t, rec, &diff)
+	changed := diff.Changed[0]
+	if !diff.Revealed || changed.SourceValue == nil || *changed.SourceValue != "new" || *changed.TargetValue != "old" {
+		t.Errorf("Expected revealed values, got %+v", diff)
+	}
+	if v := diff.Removed[0].TargetValue; v == nil || *v != "x" {
+		t.Errorf("Expected the removed value to be revealed, got %v", v)
+	}
+
+	reads := 0
+	for _, l := range te.accessLogs(t) {
+		if l.ResourceType == resourceSecret && l.Action == repository.AccessActionRead {
+			reads++
+		}
+	}
+	// Only the revealed diff reads values: 2 added, 1 removed, 2 changed
+	if reads != 5 {
+		t.Errorf("Expected 5 secret reads logged, got %d", reads)
+	}
+}
+
+func TestDiffEnvironmentsErrors(t *testing.T) {
+	te := newTestEnv(t)
+	seedStaging(t, te)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"Missing target", "", http.StatusBadRequest},
+		{"Same environment", "?target=dev", http.StatusBadRequest},
+		{"Unknown target", "?target=prod", http.StatusNotFound},
+		{"Bad reveal", "?target=staging&reveal=please", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := te.do(t, http.MethodGet, te.envPath("dev")+"/diff"+tt.query, nil); rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestPromoteSecrets(t *testing.T) {
+	te := newTestEnv(t)
+	staging := seedStaging(t, te)
+	desc := "feature flag"
+	te.do(t, http.MethodPut, te.secretsPath()+"NEW_FEATURE", updateSecretRequest{Value: "on", Description: &desc})
+
+	rec := te.do(t, http.MethodPost, te.envPath("dev")+"/promote", promoteRequest{
+		Target: "staging",
+		Keys:   []string{"NEW_FEATURE", "CHANGED", "SAME"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp promoteResponse
+	decodeData(t, rec, &resp)
+	want := promoteResponse{
+		Source:    "dev",
+		Target:    "staging",
+		Created:   []string{"NEW_FEATURE"},
+		Updated:   []string{"CHANGED"},
+		Unchanged: []string{"SAME"},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("Expected %+v, got %+v", want, resp)
+	}
+
+	rec = te.do(t, http.MethodGet, te.envPath("staging")+"/secrets/NEW_FEATURE", nil)
+	var got secretResponse
+	decodeData(t, rec, &got)
+	if got.Value != "on" || got.Description == nil || *got.Description != desc {
+		t.Errorf("Expected the value and description to be copied, got %+v", got)
+	}
+
+	// Promoted writes are recorded like any other
+	changed, err := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: staging.ID, Key: "CHANGED"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := te.store.History(changed.ID)
+	if last := history[len(history)-1]; last.Action != repository.SecretActionUpdated || last.ChangedBy != te.user.ID {
+		t.Errorf("Expected an update by the promoter in history, got %+v", last)
+	}
+
+	rec = te.do(t, http.MethodGet, te.envPath("dev")+"/diff?target=staging", nil)
+	var diff environmentDiffResponse
+	decodeData(t, rec, &diff)
+	if len(diff.Changed) != 0 || len(diff.Added) != 1 {
+		t.Errorf("Expected only ONLY_DEV left to promote, got %+v", diff)
+	}
+}
+
+func TestPromoteSecretsErrors(t *testing.T) {
+	te := newTestEnv(t)
+	seedStaging(t, te)
+	path := te.envPath("dev") + "/promote"
+
+	tests := []struct {
+		name   string
+		body   promoteRequest
+		status int
+	}{
+		{"No keys", promoteRequest{Target: "staging"}, http.StatusBadRequest},
+		{"Duplicate keys", promoteRequest{Target: "staging", Keys: []string{"SAME", "SAME"}}, http.StatusBadRequest},
+		{"No target", promoteRequest{Keys: []string{"SAME"}}, http.StatusBadRequest},
+		{"Unknown target", promoteRequest{Target: "prod", Keys: []string{"SAME"}}, http.StatusNotFound},
+		{"Key not in source", promoteRequest{Target: "staging", Keys: []string{"CHANGED", "ONLY_STAGING"}}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := te.do(t, http.MethodPost, path, tt.body); rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	// Nothing is written if any key fails
+	rec := te.do(t, http.MethodGet, te.envPath("staging")+"/secrets/CHANGED", nil)
+	var got secretResponse
+	decodeData(t, rec, &got)
+	if got.Value != "old" {
+		t.Errorf("Expected CHANGED to be untouched, got %q", got.Value)
+	}
+
+	te.loginAs(t, repository.OrgRoleViewer)
+	if rec := te.do(t, http.MethodPost, path, promoteRequest{Target: "staging", Keys: []string{"CHANGED"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer promote: expected 403, got %d", rec.Code)
+	}
+}
+
+func TestPromoteToProtectedEnvironment(t *testing.T) {
+	te := newTestEnv(t)
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "v2"})
+	te.protect(1)
+
+	reason := "release 1.4"
+	rec := te.do(t, http.MethodPost, te.envPath("dev")+"/promote", promoteRequest{Target: te.env.Name, Keys: []string{"API_KEY"}, Reason: &reason})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var cr changeRequestResponse
+	decodeData(t, rec, &cr)
+	if len(cr.Changes) != 1 || cr.Changes[0].Operation != repository.ChangeOperationCreate || *cr.Reason != reason {
+		t.Errorf("Expected a change request creating API_KEY, got %+v", cr)
+	}
+	if len(te.values(t)) != 0 {
+		t.Error("Expected nothing to be written before approval")
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// Defaults for a Reaper
+const (
+	DefaultReapInterval  = time.Minute
+	DefaultReapBatchSize = 100
+)
+
+// reaperUserAgent marks the access log entries of reaped environments,
+// which have no user
+const reaperUserAgent = "envhub-reaper"
+
+// Reaper deletes environments whose TTL has passed, together with their
+// secrets and change requests, and records each deletion in access_logs
+type Reaper struct {
+	store     repository.Store
+	batchSize int
+}
+
+// ReaperOption configures a Reaper
+type ReaperOption func(*Reaper)
+
+// WithReapBatchSize sets the most environments deleted in one transaction
+func WithReapBatchSize(n int) ReaperOption {
+	return func(r *Reaper) { r.batchSize = n }
+}
+
+// NewReaper creates a Reaper for the environments in store
+func NewReaper(store repository.Store, opts ...ReaperOption) *Reaper {
+	r := &Reaper{store: store, batchSize: DefaultReapBatchSize}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Reap deletes every expired environment that no other environment inherits
+// from and returns how many it deleted
+func (r *Reaper) Reap(ctx context.Context) (int, error) {
+	n, err := runBatches(ctx, r.reapBatch)
+	if err != nil {
+		return n, fmt.Errorf("reap environments: %w", err)
+	}
+	return n, nil
+}
+
+// Run reaps every interval until ctx is done
+func (r *Reaper) Run(ctx context.Context, interval time.Duration) {
+	runPeriodically(ctx, interval, r.Reap)
+}
+
+// reapBatch deletes the environments that expired first in one
+// transaction. more reports whether a full batch was deleted.
+func (r *Reaper) reapBatch(ctx context.Context) (n int, more bool, err error) {
+	var reaped []repository.ListExpiredEnvironmentsRow
+	err = r.store.ExecTx(ctx, func(q repository.Querier) error {
+		reaped = nil
+		locked, err := q.TryLockEnvironmentReaper(ctx)
+		if err != nil || !locked {
+			return err
+		}
+
+		envs, err := q.ListExpiredEnvironments(ctx, int32(r.batchSize))
+		if err != nil {
+			return err
+		}
+		agent := reaperUserAgent
+		for _, env := range envs {
+			if err := q.DeleteEnvironment(ctx, env.ID); err != nil {
+				return err
+			}
+			if _, err := q.CreateAccessLog(ctx, repository.CreateAccessLogParams{
+				ResourceType:   resourceEnvironment,
+				ResourceID:     env.ID,
+				Action:         repository.AccessActionDelete,
+				UserAgent:      &agent,
+				Success:        true,
+				CreatedAt:      time.Now(),
+				OrganizationID: pgtype.UUID{Bytes: env.OrganizationID, Valid: true},
+			}); err != nil {
+				return err
+			}
+			expiresAt := env.ExpiresAt.Time
+			if err := recordEvent(ctx, q, env.OrganizationID, env.ProjectID, eventEnvironmentDeleted, environmentEvent{
+				EnvironmentID: env.ID,
+				Environment:   env.Name,
+				ProjectID:     env.ProjectID,
+				ExpiresAt:     &expiresAt,
+				Expired:       true,
+			}); err != nil {
+				return err
+			}
+		}
+		reaped = envs
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, env := range reaped {
+		log.Printf("api: deleted environment %s (%s) of project %s, expired at %s", env.Name, env.ID, env.ProjectID, env.ExpiresAt.Time.Format(time.RFC3339))
+	}
+	return len(reaped), len(reaped) == r.batchSize, nil
+}
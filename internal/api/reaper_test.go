@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+)
+
+// addExpiring adds an environment to the test project that expires at
+func (te *testEnv) addExpiring(name string, at time.Time) repository.Environment {
+	return te.store.AddEnvironment(repository.Environment{
+		ProjectID: te.project.ID,
+		Name:      name,
+		ExpiresAt: pgtype.Timestamptz{Time: at, Valid: true},
+	})
+}
+
+func TestCreateExpiringEnvironment(t *testing.T) {
+	te := newTestEnv(t)
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "dev-key"})
+
+	before := time.Now()
+	rec := te.do(t, http.MethodPost, te.environmentsPath(), createEnvironmentRequest{
+		EnvironmentTemplate: EnvironmentTemplate{Name: "pr-42"},
+		TTL:                 "72h",
+		CopyFrom:            "dev",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created createEnvironmentResponse
+	decodeData(t, rec, &created)
+	if created.ExpiresAt == nil || created.ExpiresAt.Before(before.Add(72*time.Hour)) || created.ExpiresAt.After(time.Now().Add(72*time.Hour)) {
+		t.Errorf("Expected the environment to expire in 72h, got %v", created.ExpiresAt)
+	}
+	if created.SecretsCopied != 1 {
+		t.Errorf("Expected 1 secret copied, got %d", created.SecretsCopied)
+	}
+
+	te.env.Name = "pr-42"
+	if v := te.values(t); !reflect.DeepEqual(v, map[string]string{"API_KEY": "dev-key"}) {
+		t.Errorf("Expected the copied secrets, got %v", v)
+	}
+
+	tests := []struct {
+		req  createEnvironmentRequest
+		want int
+	}{
+		{createEnvironmentRequest{EnvironmentTemplate: EnvironmentTemplate{Name: "a"}, TTL: "soon"}, http.StatusBadRequest},
+		{createEnvironmentRequest{EnvironmentTemplate: EnvironmentTemplate{Name: "b"}, TTL: "1s"}, http.StatusBadRequest},
+		{createEnvironmentRequest{EnvironmentTemplate: EnvironmentTemplate{Name: "c"}, CopyFrom: "nope"}, http.StatusBadRequest},
+		// A child may not outlive its parent
+		{createEnvironmentRequest{EnvironmentTemplate: EnvironmentTemplate{Name: "d", Parent: "pr-42"}}, http.StatusConflict},
+		{createEnvironmentRequest{EnvironmentTemplate: EnvironmentTemplate{Name: "e", Parent: "pr-42"}, TTL: "96h"}, http.StatusConflict},
+		{createEnvironmentRequest{EnvironmentTemplate: EnvironmentTemplate{Name: "f", Parent: "pr-42"}, TTL: "1h"}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if rec := te.do(t, http.MethodPost, te.environmentsPath(), tt.req); rec.Code != tt.want {
+			t.Errorf("%+v: expected %d, got %d: %s", tt.req, tt.want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestReaperDeletesExpiredEnvironments(t *testing.T) {
+	te := newTestEnv(t)
+	expired := te.addExpiring("pr-1", time.Now().Add(-time.Minute))
+	te.addExpiring("pr-2", time.Now().Add(time.Hour))
+	te.do(t, http.MethodPost, te.envPath("pr-1")+"/secrets/", createSecretRequest{Key: "API_KEY", Value: "v"})
+	secret, err := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: expired.ID, Key: "API_KEY"})
+	if err != nil {
+		t.Fatalf("GetSecretByKey failed: %v", err)
+	}
+
+	n, err := NewReaper(te.store).Reap(t.Context())
+	if err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 environment reaped, got %d", n)
+	}
+
+	envs, _ := te.store.ListEnvironmentsByProject(t.Context(), te.project.ID)
+	var names []string
+	for _, e := range envs {
+		names = append(names, e.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"dev", "pr-2"}) {
+		t.Errorf("Expected the unexpired environments to remain, got %v", names)
+	}
+
+	history := te.store.History(secret.ID)
+	if last := history[len(history)-1]; last.Action != repository.SecretActionDeleted || last.EncryptedValue != nil {
+		t.Errorf("Expected the secret's deletion in its history, got %+v", last)
+	}
+
+	var logged bool
+	for _, l := range te.store.AccessLogs() {
+		if l.ResourceID == expired.ID && l.Action == repository.AccessActionDelete {
+			logged = !l.UserID.Valid && l.UserAgent != nil && *l.UserAgent == reaperUserAgent && l.Success
+		}
+	}
+	if !logged {
+		t.Errorf("Expected the deletion in the access logs")
+	}
+}
+
+func TestReaperSkipsParents(t *testing.T) {
+	te := newTestEnv(t)
+	parent := te.addExpiring("pr-1", time.Now().Add(-time.Hour))
+	child := te.addExpiring("pr-1-api", time.Now().Add(-time.Minute))
+	if _, err := te.store.SetEnvironmentParent(t.Context(), repository.SetEnvironmentParentParams{
+		ID:       child.ID,
+		ParentID: pgtype.UUID{Bytes: parent.ID, Valid: true},
+	}); err != nil {
+		t.Fatalf("SetEnvironmentParent failed: %v", err)
+	}
+
+	// The child goes first, then the parent on the next run
+	r := NewReaper(te.store)
+	for i, want := range []int{1, 1, 0} {
+		if n, err := r.Reap(t.Context()); err != nil || n != want {
+			t.Errorf("run %d: expected %d reaped, got %d (%v)", i, want, n, err)
+		}
+	}
+}
+
+func TestReaperBatches(t *testing.T) {
+	te := newTestEnv(t)
+	for _, name := range []string{"pr-1", "pr-2", "pr-3", "pr-4", "pr-5"} {
+		te.addExpiring(name, time.Now().Add(-time.Minute))
+	}
+
+	n, err := NewReaper(te.store, WithReapBatchSize(2)).Reap(t.Context())
+	if err != nil || n != 5 {
+		t.Errorf("Expected all 5 environments reaped in batches, got %d (%v)", n, err)
+	}
+}
+
+// lockedStore is a store whose background job locks are always held
+// elsewhere
+type lockedStore struct {
+	*repotest.MemStore
+}
+
+func (s lockedStore) ExecTx(ctx context.Context, fn func(q repository.Querier) error) error {
+	return s.MemStore.ExecTx(ctx, func(q repository.Querier) error {
+		return fn(lockedQuerier{q})
+	})
+}
+
+type lockedQuerier struct {
+	repository.Querier
+}
+
+func (lockedQuerier) TryLockEnvironmentReaper(context.Context) (bool, error) {
+	return false, nil
+}
+
+func (lockedQuerier) ListExpiredEnvironments(context.Context, int32) ([]repository.ListExpiredEnvironmentsRow, error) {
+	return nil, errors.New("listed without the lock")
+}
+
+func TestReaperSkipsWhileLocked(t *testing.T) {
+	te := newTestEnv(t)
+	te.addExpiring("pr-1", time.Now().Add(-time.Minute))
+
+	n, err := NewReaper(lockedStore{te.store}).Reap(t.Context())
+	if err != nil || n != 0 {
+		t.Errorf("Expected nothing reaped while another replica holds the lock, got %d (%v)", n, err)
+	}
+	if _, err := te.store.GetEnvironmentByName(t.Context(), repository.GetEnvironmentByNameParams{ProjectID: te.project.ID, Name: "pr-1"}); err != nil {
+		t.Errorf("Expected pr-1 to remain, got %v", err)
+	}
+}
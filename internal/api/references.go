@@ -0,0 +1,380 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+)
+
+// Secret values may refer to other secrets:
+//
+//	${KEY}               a secret in the same environment
+//	${env.NAME.KEY}      a secret in environment NAME of the same project
+//	${PROJECT/NAME/KEY}  a secret in another project of the organization,
+//	                     named by project name or id
+//
+// $${ is a literal ${. A ${ without a closing }, or whose text is none of
+// the above (such as ${HOME:-/root}), is left as is.
+// References are expanded when secrets are read unless ?raw=true; they are
+// stored, compared and promoted unexpanded. A reference resolves against
+// what the environment sees, inherited secrets included, so a value
+// inherited from a parent picks up the child's overrides. A reference that
+// cannot be resolved only fails the secret holding it: reading that secret
+// fails, and lists and exports return its stored value (see readSecrets).
+
+// headerUnresolvedReferences lists, once per key, the secrets an export
+// holds unexpanded because their references cannot be resolved
+const headerUnresolvedReferences = "X-Envhub-Unresolved-References"
+
+const (
+	// maxReferenceDepth bounds how many references are followed from a secret
+	maxReferenceDepth = 10
+
+	// maxResolvedLength bounds the size of an expanded value
+	maxResolvedLength = 64 << 10
+)
+
+// referenceError is a secret whose references cannot be resolved
+type referenceError struct {
+	msg string
+}
+
+func (e *referenceError) Error() string { return e.msg }
+
+// reference is a parsed ${...}. Empty project and env mean those of the
+// secret holding the reference.
+type reference struct {
+	project string
+	env     string
+	key     string
+}
+
+// parseReference parses the text between ${ and }
+func parseReference(ref string) (reference, bool) {
+	var r reference
+	switch {
+	case strings.HasPrefix(ref, "env."):
+		var ok bool
+		if r.env, r.key, ok = strings.Cut(ref[len("env."):], "."); !ok || r.env == "" {
+			return r, false
+		}
+	case strings.Contains(ref, "/"):
+		parts := strings.SplitN(ref, "/", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return r, false
+		}
+		r.project, r.env, r.key = parts[0], parts[1], parts[2]
+	default:
+		r.key = ref
+	}
+	return r, validateSecretKey(r.key) == ""
+}
+
+// expandReferences replaces each ${...} in value with what fn returns for
+// the text between the braces
+func expandReferences(value string, fn func(ref string) (string, error)) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			break
+		}
+		if i > 0 && value[i-1] == '$' {
+			b.WriteString(value[:i-1])
+			b.WriteString("${")
+			value = value[i+2:]
+			continue
+		}
+		end := strings.IndexByte(value[i+2:], '}')
+		if end < 0 {
+			break
+		}
+		b.WriteString(value[:i])
+		v, err := fn(value[i+2 : i+2+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(v)
+		if b.Len() > maxResolvedLength {
+			return "", &referenceError{fmt.Sprintf("expanded value exceeds %d bytes", maxResolvedLength)}
+		}
+		value = value[i+3+end:]
+	}
+	b.WriteString(value)
+	if b.Len() > maxResolvedLength {
+		return "", &referenceError{fmt.Sprintf("expanded value exceeds %d bytes", maxResolvedLength)}
+	}
+	return b.String(), nil
+}
+
+// refEnvironment is an environment secrets were referenced in, with its
+// raw values loaded on first use
+type refEnvironment struct {
+	project repository.Project
+	env     repository.Environment
+	secrets map[string]secretResponse
+}
+
+type envKey struct {
+	projectID uuid.UUID
+	name      string
+}
+
+type secretKey struct {
+	envID uuid.UUID
+	key   string
+}
+
+// resolver expands the references in secrets of one environment. Each
+// referenced environment is loaded once, after checking that the caller may
+// read its secrets, and each expanded value is remembered.
+type resolver struct {
+	s        *Server
+	ctx      context.Context
+	base     *refEnvironment
+	projects map[string]repository.Project
+	envs     map[envKey]*refEnvironment
+	resolved map[secretKey]string
+	active   map[secretKey]bool
+
+	// chain lists the references followed from the secret being resolved,
+	// for error messages
+	chain []string
+
+	read  map[uuid.UUID]bool
+	reads []accessEntry
+}
+
+// newResolver creates a resolver for secrets of env, which the caller has
+// been authorized to read. secrets, if not nil, are the raw values env sees
+// (see mergedSecrets).
+func (s *Server) newResolver(ctx context.Context, project repository.Project, env repository.Environment, secrets map[string]secretResponse) *resolver {
+	base := &refEnvironment{project: project, env: env, secrets: secrets}
+	return &resolver{
+		s:        s,
+		ctx:      ctx,
+		base:     base,
+		projects: map[string]repository.Project{project.Name: project, project.ID.String(): project},
+		envs:     map[envKey]*refEnvironment{{project.ID, env.Name}: base},
+		resolved: make(map[secretKey]string),
+		active:   make(map[secretKey]bool),
+		read:     make(map[uuid.UUID]bool),
+	}
+}
+
+// markRead excludes a secret the handler already logged as read from accessed
+func (res *resolver) markRead(id uuid.UUID) {
+	res.read[id] = true
+}
+
+// expand returns the value of a secret of the resolver's environment with
+// its references expanded. It returns a *referenceError if a reference is
+// invalid, missing or cyclic, and a *deniedError if the caller may not read
+// a referenced secret.
+func (res *resolver) expand(secret secretResponse) (string, error) {
+	res.chain = res.chain[:0]
+	v, err := res.expandIn(res.base, secret.Key, secret.Value)
+	var refErr *referenceError
+	var denied *deniedError
+	switch {
+	case errors.As(err, &refErr):
+		return "", &referenceError{secret.Key + ": " + refErr.msg}
+	case errors.As(err, &denied):
+		return "", &deniedError{denied.status, denied.code, secret.Key + ": " + denied.msg}
+	}
+	return v, err
+}
+
+// accessed returns the referenced secrets read, other than those marked read
+func (res *resolver) accessed() []accessEntry {
+	return res.reads
+}
+
+func (res *resolver) expandIn(e *refEnvironment, key, value string) (string, error) {
+	id := secretKey{e.env.ID, key}
+	if v, ok := res.resolved[id]; ok {
+		return v, nil
+	}
+	if res.active[id] {
+		return "", res.fail("reference cycle")
+	}
+	res.active[id] = true
+	defer delete(res.active, id)
+
+	v, err := expandReferences(value, func(text string) (string, error) {
+		ref, ok := parseReference(text)
+		if !ok {
+			return "${" + text + "}", nil
+		}
+
+		res.chain = append(res.chain, "${"+text+"}")
+		defer func() { res.chain = res.chain[:len(res.chain)-1] }()
+		if len(res.chain) > maxReferenceDepth {
+			return "", res.fail(fmt.Sprintf("references nested more than %d deep", maxReferenceDepth))
+		}
+
+		target, err := res.environment(e, ref)
+		if err != nil {
+			return "", err
+		}
+		secret, ok := target.secrets[ref.key]
+		if !ok {
+			return "", res.fail("secret not found")
+		}
+		if !res.read[secret.ID] {
+			res.read[secret.ID] = true
+			res.reads = append(res.reads, accessEntry{resourceSecret, secret.ID, repository.AccessActionRead})
+		}
+		return res.expandIn(target, ref.key, secret.Value)
+	})
+	if err != nil {
+		return "", err
+	}
+	res.resolved[id] = v
+	return v, nil
+}
+
+// environment loads the environment ref points to from a secret in from
+func (res *resolver) environment(from *refEnvironment, ref reference) (*refEnvironment, error) {
+	project := from.project
+	if ref.project != "" {
+		var err error
+		if project, err = res.project(ref.project); err != nil {
+			return nil, err
+		}
+	}
+	name := ref.env
+	if name == "" {
+		name = from.env.Name
+	}
+
+	e, ok := res.envs[envKey{project.ID, name}]
+	if !ok {
+		env, err := res.s.store.GetEnvironmentByName(res.ctx, repository.GetEnvironmentByNameParams{
+			ProjectID: project.ID,
+			Name:      name,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, res.fail("environment not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+		if err := res.s.permit(res.ctx, policy.SecretRead, target); err != nil {
+			return nil, res.denied(err)
+		}
+
+		e = &refEnvironment{project: project, env: env}
+		res.envs[envKey{project.ID, name}] = e
+	}
+
+	if e.secrets == nil {
+		dek, err := res.s.vault.DataKey(e.project)
+		if err != nil {
+			return nil, err
+		}
+		if e.secrets, err = res.s.mergedSecrets(res.ctx, dek, e.project, e.env); err != nil {
+			return nil, res.denied(err)
+		}
+	}
+	return e, nil
+}
+
+// project finds a project of the resolver's organization by name or id
+func (res *resolver) project(nameOrID string) (repository.Project, error) {
+	if p, ok := res.projects[nameOrID]; ok {
+		return p, nil
+	}
+
+	orgID := res.base.project.OrganizationID
+	var p repository.Project
+	var err error
+	if id, parseErr := uuid.Parse(nameOrID); parseErr == nil {
+		p, err = res.s.store.GetProjectByID(res.ctx, id)
+		if err == nil && p.OrganizationID != orgID {
+			err = pgx.ErrNoRows
+		}
+	} else {
+		p, err = res.s.store.GetProjectByName(res.ctx, repository.GetProjectByNameParams{
+			OrganizationID: orgID,
+			Name:           nameOrID,
+		})
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, res.fail("project not found")
+	}
+	if err != nil {
+		return p, err
+	}
+
+	res.projects[nameOrID] = p
+	return p, nil
+}
+
+// unresolved reports whether err, from expand, is about the references of
+// the secret expanded rather than the read as a whole
+func unresolved(err error) bool {
+	var refErr *referenceError
+	var denied *deniedError
+	return errors.As(err, &refErr) || errors.As(err, &denied)
+}
+
+// denied prefixes a *deniedError with the current chain
+func (res *resolver) denied(err error) error {
+	var denied *deniedError
+	if errors.As(err, &denied) {
+		return &deniedError{denied.status, denied.code, strings.Join(res.chain, " -> ") + ": " + denied.msg}
+	}
+	return err
+}
+
+// fail reports an unresolvable reference at the end of the current chain
+func (res *resolver) fail(msg string) error {
+	return &referenceError{strings.Join(res.chain, " -> ") + ": " + msg}
+}
+
+// parseRaw reads ?raw=, which skips expanding references.
+// It writes an error response and returns ok=false if it is invalid.
+func parseRaw(w http.ResponseWriter, r *http.Request) (raw, ok bool) {
+	v := r.URL.Query().Get("raw")
+	if v == "" {
+		return false, true
+	}
+	raw, err := strconv.ParseBool(v)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "raw must be true or false")
+		return false, false
+	}
+	return raw, true
+}
+
+// writeReferenceError maps resolver errors to HTTP responses
+func (s *Server) writeReferenceError(w http.ResponseWriter, err error) {
+	var refErr *referenceError
+	var denied *deniedError
+	switch {
+	case errors.As(err, &refErr):
+		utils.WriteError(w, http.StatusUnprocessableEntity, CodeInvalidReference, err.Error())
+	case errors.As(err, &denied):
+		utils.WriteError(w, denied.status, denied.code, denied.Error())
+	default:
+		s.writeStoreError(w, err, "secret not found")
+	}
+}
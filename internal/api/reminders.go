@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// Defaults for a Reminder
+const (
+	DefaultRemindInterval  = 5 * time.Minute
+	DefaultRemindBatchSize = 100
+)
+
+// reminderUserAgent marks the access log entries of secrets deactivated on
+// expiry, which have no user
+const reminderUserAgent = "envhub-reminders"
+
+// Reminder flags secrets that have expired or are due for rotation, once per
+// deadline, and records a secret.expired or secret.rotation_due event for
+// each. It can also deactivate expired secrets, which leaves them out of
+// reads until their expiry is extended.
+type Reminder struct {
+	store      repository.Store
+	batchSize  int
+	deactivate bool
+}
+
+// ReminderOption configures a Reminder
+type ReminderOption func(*Reminder)
+
+// WithRemindBatchSize sets the most secrets of each kind flagged in one
+// transaction
+func WithRemindBatchSize(n int) ReminderOption {
+	return func(r *Reminder) { r.batchSize = n }
+}
+
+// WithDeactivateExpired sets whether expired secrets are deactivated
+func WithDeactivateExpired(deactivate bool) ReminderOption {
+	return func(r *Reminder) { r.deactivate = deactivate }
+}
+
+// NewReminder creates a Reminder for the secrets in store
+func NewReminder(store repository.Store, opts ...ReminderOption) *Reminder {
+	r := &Reminder{store: store, batchSize: DefaultRemindBatchSize}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Remind flags every secret that has passed a deadline it was not yet
+// flagged for and returns how many it flagged
+func (r *Reminder) Remind(ctx context.Context) (int, error) {
+	n, err := runBatches(ctx, r.remindBatch)
+	if err != nil {
+		return n, fmt.Errorf("remind secrets: %w", err)
+	}
+	return n, nil
+}
+
+// Run reminds every interval until ctx is done
+func (r *Reminder) Run(ctx context.Context, interval time.Duration) {
+	runPeriodically(ctx, interval, r.Remind)
+}
+
+// remindBatch flags the secrets that passed their deadline first in one
+// transaction. more reports whether a full batch of either kind was flagged.
+func (r *Reminder) remindBatch(ctx context.Context) (n int, more bool, err error) {
+	var expired, deactivated, due int
+	err = r.store.ExecTx(ctx, func(q repository.Querier) error {
+		expired, deactivated, due = 0, 0, 0
+		locked, err := q.TryLockSecretReminders(ctx)
+		if err != nil || !locked {
+			return err
+		}
+
+		rows, err := q.ListExpiredSecrets(ctx, int32(r.batchSize))
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			deactivate := r.deactivate && (row.IsActive == nil || *row.IsActive)
+			if deactivate {
+				if err := deactivateSecret(ctx, q, row); err != nil {
+					return err
+				}
+				deactivated++
+			}
+			if err := flagSecret(ctx, q, eventSecretExpired, row, deactivate); err != nil {
+				return err
+			}
+		}
+		expired = len(rows)
+
+		dueRows, err := q.ListSecretsDueForRotation(ctx, int32(r.batchSize))
+		if err != nil {
+			return err
+		}
+		for _, row := range dueRows {
+			if err := flagSecret(ctx, q, eventSecretRotationDue, repository.ListExpiredSecretsRow(row), false); err != nil {
+				return err
+			}
+		}
+		due = len(dueRows)
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	if expired+due > 0 {
+		log.Printf("api: flagged %d expired secrets (%d deactivated) and %d due for rotation", expired, deactivated, due)
+	}
+	return expired + due, expired == r.batchSize || due == r.batchSize, nil
+}
+
+// deactivateSecret leaves an expired secret out of reads and logs it. The
+// secret keeps its last writer, as no user deactivated it.
+func deactivateSecret(ctx context.Context, q repository.Querier, row repository.ListExpiredSecretsRow) error {
+	if err := q.DeactivateSecret(ctx, repository.DeactivateSecretParams{
+		ID:        row.ID,
+		UpdatedBy: row.UpdatedBy,
+	}); err != nil {
+		return err
+	}
+	agent := reminderUserAgent
+	_, err := q.CreateAccessLog(ctx, repository.CreateAccessLogParams{
+		ResourceType:   resourceSecret,
+		ResourceID:     row.ID,
+		Action:         repository.AccessActionUpdate,
+		UserAgent:      &agent,
+		Success:        true,
+		CreatedAt:      time.Now(),
+		OrganizationID: pgtype.UUID{Bytes: row.OrganizationID, Valid: true},
+	})
+	return err
+}
+
+// flagSecret records that row passed its deadline, so it is not flagged
+// again, along with the event for it
+func flagSecret(ctx context.Context, q repository.Querier, event string, row repository.ListExpiredSecretsRow, deactivated bool) error {
+	if err := q.CreateSecretReminder(ctx, repository.CreateSecretReminderParams{
+		SecretID: row.ID,
+		Event:    event,
+		DueAt:    row.DueAt.Time,
+	}); err != nil {
+		return err
+	}
+	return recordEvent(ctx, q, row.OrganizationID, row.ProjectID, event, secretEvent{
+		SecretID:      row.ID,
+		Key:           row.Key,
+		Version:       row.Version,
+		EnvironmentID: row.EnvironmentID,
+		Environment:   row.EnvironmentName,
+		ProjectID:     row.ProjectID,
+		DueAt:         &row.DueAt.Time,
+		Deactivated:   deactivated,
+	})
+}
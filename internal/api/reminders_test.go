@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/vault"
+)
+
+func (lockedQuerier) TryLockSecretReminders(context.Context) (bool, error) {
+	return false, nil
+}
+
+func (lockedQuerier) ListExpiredSecrets(context.Context, int32) ([]repository.ListExpiredSecretsRow, error) {
+	return nil, errors.New("listed without the lock")
+}
+
+// secretEvents decodes the recorded events of type typ
+func (te *testEnv) secretEvents(t *testing.T, typ string) []secretEvent {
+	t.Helper()
+
+	var events []secretEvent
+	for _, e := range te.store.Events() {
+		if e.Type != typ {
+			continue
+		}
+		if strings.Contains(string(e.Payload), "s3cr3t") {
+			t.Errorf("Expected no secret value in event payload %s", e.Payload)
+		}
+		var payload secretEvent
+		if err := json.Unmarshal(e.Payload, &payload); err != nil {
+			t.Fatalf("Invalid event payload %s: %v", e.Payload, err)
+		}
+		events = append(events, payload)
+	}
+	return events
+}
+
+func TestReminderFlagsSecrets(t *testing.T) {
+	te := newTestEnv(t)
+	for _, key := range []string{"EXPIRED", "STALE", "FRESH"} {
+		te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: key, Value: "s3cr3t"})
+	}
+	past := time.Now().Add(-time.Minute)
+	te.setLifetime(t, "EXPIRED", secretLifetimeRequest{ExpiresAt: &past})
+	te.setLifetime(t, "FRESH", secretLifetimeRequest{RotateEvery: "720h"})
+	// Below the API's minimum, so STALE is due right away
+	stale, _ := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: te.env.ID, Key: "STALE"})
+	if _, err := te.store.SetSecretLifetime(t.Context(), repository.SetSecretLifetimeParams{
+		ID:          stale.ID,
+		RotateEvery: pgtype.Interval{Microseconds: 1, Valid: true},
+		IsActive:    stale.IsActive,
+	}); err != nil {
+		t.Fatalf("SetSecretLifetime failed: %v", err)
+	}
+
+	r := NewReminder(te.store)
+	if n, err := r.Remind(t.Context()); err != nil || n != 2 {
+		t.Fatalf("Expected 2 secrets flagged, got %d (%v)", n, err)
+	}
+	expired := te.secretEvents(t, eventSecretExpired)
+	if len(expired) != 1 || expired[0].Key != "EXPIRED" || expired[0].Environment != "dev" || expired[0].Deactivated {
+		t.Errorf("Unexpected secret.expired events %+v", expired)
+	}
+	due := te.secretEvents(t, eventSecretRotationDue)
+	if len(due) != 1 || due[0].Key != "STALE" || due[0].Version != 1 {
+		t.Errorf("Unexpected secret.rotation_due events %+v", due)
+	}
+
+	// Each deadline is flagged once; a new value sets the next one
+	if n, err := r.Remind(t.Context()); err != nil || n != 0 {
+		t.Errorf("Expected nothing flagged twice, got %d (%v)", n, err)
+	}
+	te.do(t, http.MethodPut, te.secretsPath()+"STALE", updateSecretRequest{Value: "s3cr3t-2"})
+	if n, err := r.Remind(t.Context()); err != nil || n != 1 {
+		t.Errorf("Expected the new value to be flagged, got %d (%v)", n, err)
+	}
+	if due := te.secretEvents(t, eventSecretRotationDue); len(due) != 2 || due[1].Version != 2 {
+		t.Errorf("Expected a second secret.rotation_due event for version 2, got %+v", due)
+	}
+
+	// Expired secrets stay readable unless the reminder deactivates them
+	if v := te.values(t); v["EXPIRED"] != "s3cr3t" {
+		t.Errorf("Expected the expired secret to be readable, got %v", v)
+	}
+}
+
+func TestReminderDeactivatesExpiredSecrets(t *testing.T) {
+	te := newTestEnv(t)
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "s3cr3t"})
+	past := time.Now().Add(-time.Minute)
+	te.setLifetime(t, "API_KEY", secretLifetimeRequest{ExpiresAt: &past})
+	secret, _ := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: te.env.ID, Key: "API_KEY"})
+
+	if n, err := NewReminder(te.store, WithDeactivateExpired(true)).Remind(t.Context()); err != nil || n != 1 {
+		t.Fatalf("Expected 1 secret flagged, got %d (%v)", n, err)
+	}
+	if v := te.values(t); len(v) != 0 {
+		t.Errorf("Expected the expired secret to be left out of reads, got %v", v)
+	}
+	if rec := te.do(t, http.MethodGet, te.secretsPath()+"API_KEY", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("get: expected 404, got %d", rec.Code)
+	}
+	if events := te.secretEvents(t, eventSecretExpired); len(events) != 1 || !events[0].Deactivated {
+		t.Errorf("Expected the event to report the deactivation, got %+v", events)
+	}
+	if h := te.store.History(secret.ID); len(h) != 1 {
+		t.Errorf("Expected no history entry for the deactivation, got %d", len(h))
+	}
+	var logged bool
+	for _, l := range te.store.AccessLogs() {
+		if l.ResourceID == secret.ID && l.UserAgent != nil && *l.UserAgent == reminderUserAgent {
+			logged = l.Action == repository.AccessActionUpdate && !l.UserID.Valid
+		}
+	}
+	if !logged {
+		t.Errorf("Expected the deactivation in the access logs")
+	}
+
+	// Extending the expiry brings it back
+	later := time.Now().Add(time.Hour)
+	if resp := te.setLifetime(t, "API_KEY", secretLifetimeRequest{ExpiresAt: &later}); !resp.Active {
+		t.Errorf("Expected the secret to be active again, got %+v", resp)
+	}
+	if v := te.values(t); v["API_KEY"] != "s3cr3t" {
+		t.Errorf("Expected the secret to be readable again, got %v", v)
+	}
+}
+
+func TestRewriteExpiredSecrets(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+	past := time.Now().Add(-time.Minute)
+	for _, key := range []string{"PUT_KEY", "POST_KEY", "IMPORT_KEY"} {
+		te.do(t, http.MethodPost, base, createSecretRequest{Key: key, Value: "s3cr3t"})
+		te.setLifetime(t, key, secretLifetimeRequest{ExpiresAt: &past})
+	}
+	reminder := NewReminder(te.store, WithDeactivateExpired(true))
+	if n, err := reminder.Remind(t.Context()); err != nil || n != 3 {
+		t.Fatalf("Expected 3 secrets flagged, got %d (%v)", n, err)
+	}
+
+	// A new value replaces the expired one, however it is written
+	if rec := te.do(t, http.MethodPut, base+"PUT_KEY", updateSecretRequest{Value: "put"}); rec.Code != http.StatusOK {
+		t.Errorf("update: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := te.do(t, http.MethodPost, base, createSecretRequest{Key: "POST_KEY", Value: "post"})
+	if rec.Code != http.StatusCreated {
+		t.Errorf("create: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created secretResponse
+	decodeData(t, rec, &created)
+	if created.Version != 2 || created.ExpiresAt != nil {
+		t.Errorf("Expected version 2 without an expiry, got %+v", created)
+	}
+	if rec := te.doRaw(t, http.MethodPost, base+"import", "IMPORT_KEY=imported\n"); rec.Code != http.StatusOK {
+		t.Errorf("import: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	want := map[string]string{"PUT_KEY": "put", "POST_KEY": "post", "IMPORT_KEY": "imported"}
+	if v := te.values(t); !maps.Equal(v, want) {
+		t.Errorf("Expected %v, got %v", want, v)
+	}
+	if updated := te.secretEvents(t, eventSecretUpdated); len(updated) != 3 {
+		t.Errorf("Expected 3 secret.updated events, got %+v", updated)
+	}
+	if n, err := reminder.Remind(t.Context()); err != nil || n != 0 {
+		t.Errorf("Expected the new values not to be flagged, got %d (%v)", n, err)
+	}
+
+	// So does a change request creating the key in a protected environment
+	te.protect(1)
+	dek, _ := te.vault.DataKey(te.project)
+	sealed, _ := te.vault.EncryptValue(dek, vault.SecretIdentity{ProjectID: te.project.ID, EnvironmentID: te.env.ID, Key: "API_KEY", Version: 1}, "s3cr3t")
+	secret, err := te.store.CreateSecret(t.Context(), repository.CreateSecretParams{
+		EnvironmentID:  te.env.ID,
+		Key:            "API_KEY",
+		EncryptedValue: sealed,
+		Version:        1,
+	})
+	if err != nil {
+		t.Fatalf("CreateSecret failed: %v", err)
+	}
+	if err := te.store.DeactivateSecret(t.Context(), repository.DeactivateSecretParams{ID: secret.ID}); err != nil {
+		t.Fatalf("DeactivateSecret failed: %v", err)
+	}
+	rec = te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "proposed"})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("propose: expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var cr changeRequestResponse
+	decodeData(t, rec, &cr)
+	te.loginAs(t, repository.OrgRoleAdmin)
+	if rec := te.do(t, http.MethodPost, te.changeRequestsPath()+cr.ID.String()+"/approve", nil); rec.Code != http.StatusOK {
+		t.Fatalf("approve: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if v := te.values(t); v["API_KEY"] != "proposed" {
+		t.Errorf("Expected the proposed value, got %v", v)
+	}
+}
+
+func TestReminderSkipsWhileLocked(t *testing.T) {
+	te := newTestEnv(t)
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "s3cr3t"})
+	past := time.Now().Add(-time.Minute)
+	te.setLifetime(t, "API_KEY", secretLifetimeRequest{ExpiresAt: &past})
+	before := len(te.store.Events())
+
+	n, err := NewReminder(lockedStore{te.store}).Remind(t.Context())
+	if err != nil || n != 0 {
+		t.Errorf("Expected nothing flagged while another replica holds the lock, got %d (%v)", n, err)
+	}
+	if events := te.store.Events(); len(events) != before {
+		t.Errorf("Expected no events, got %d", len(events)-before)
+	}
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/pkg/dotenv"
+)
+
+// maxImportSize bounds the .env file accepted by importSecrets
+const maxImportSize = 1 << 20
+
+// Import modes
+const (
+	importMerge     = "merge"
+	importOverwrite = "overwrite"
+)
+
+// Export formats
+const (
+	formatDotenv = "dotenv"
+	formatJSON   = "json"
+	formatYAML   = "yaml"
+	formatShell  = "shell"
+)
+
+// yamlPlainKey matches keys that need no quoting in YAML, apart from the
+// words in yamlReserved
+var yamlPlainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// yamlReserved are the plain scalars YAML 1.1 reads as booleans or null
+var yamlReserved = map[string]bool{
+	"y": true, "yes": true, "n": true, "no": true, "true": true, "false": true,
+	"on": true, "off": true, "null": true,
+}
+
+// importResponse lists what an import did, or would do on a dry run, by key
+type importResponse struct {
+	Mode      string   `json:"mode"`
+	DryRun    bool     `json:"dry_run"`
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	// Skipped keys already hold a different value and are kept in merge mode
+	Skipped []string `json:"skipped"`
+}
+
+// importSecrets writes the assignments of a .env request body to an
+// environment. Keys missing from the environment are created; keys whose
+// value differs are kept (?mode=merge, the default) or updated
+// (?mode=overwrite). Secrets not in the file are left alone. With
+// ?dry_run=true only the diff is returned. In protected environments the
+// changes are proposed as a change request instead, with ?reason= as its
+// reason.
+func (s *Server) importSecrets(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = importMerge
+	}
+	if mode != importMerge && mode != importOverwrite {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "mode must be merge or overwrite")
+		return
+	}
+	dryRun := false
+	if v := q.Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+
+	entries, msg := readDotenv(w, r)
+	if msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	current, err := s.environmentSecrets(r.Context(), dek, project.ID, env.ID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := importResponse{
+		Mode:      mode,
+		DryRun:    dryRun,
+		Created:   []string{},
+		Updated:   []string{},
+		Unchanged: []string{},
+		Skipped:   []string{},
+	}
+	var changes []proposedChange
+	for _, e := range entries {
+		cur, exists := current[e.Key]
+		switch {
+		case !exists:
+			resp.Created = append(resp.Created, e.Key)
+			changes = append(changes, proposedChange{Key: e.Key, Operation: repository.ChangeOperationCreate, Value: &e.Value})
+		case cur.Value == e.Value:
+			resp.Unchanged = append(resp.Unchanged, e.Key)
+		case mode == importMerge:
+			resp.Skipped = append(resp.Skipped, e.Key)
+		default:
+			resp.Updated = append(resp.Updated, e.Key)
+			changes = append(changes, proposedChange{Key: e.Key, Operation: repository.ChangeOperationUpdate, Value: &e.Value})
+		}
+	}
+
+	// A dry run only needs read access
+	if dryRun || len(changes) == 0 {
+		utils.WriteJSON(w, http.StatusOK, resp)
+		return
+	}
+	if !s.authorizeChanges(w, r, project, env, changes) {
+		return
+	}
+	if isProtected(env) {
+		var reason *string
+		if v := q.Get("reason"); v != "" {
+			reason = &v
+		}
+		s.proposeChanges(w, r, project, env, reason, changes...)
+		return
+	}
+
+	written, err := s.writeSecrets(r.Context(), project, env, dek, current, changes)
+	if err != nil {
+		s.writeSecretsError(w, err)
+		return
+	}
+	addAccess(r, written...)
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// readDotenv parses the request body as a .env file. It returns a
+// non-empty message if the body is too large, malformed, or assigns a key
+// twice or one that is not a valid secret name.
+func readDotenv(w http.ResponseWriter, r *http.Request) ([]dotenv.Entry, string) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, "file must be at most 1 MiB"
+		}
+		return nil, "invalid request body"
+	}
+
+	entries, err := dotenv.Parse(data)
+	if err != nil {
+		return nil, err.Error()
+	}
+	if len(entries) == 0 {
+		return nil, "file has no assignments"
+	}
+
+	lines := make(map[string]int, len(entries))
+	for _, e := range entries {
+		if msg := validateSecretKey(e.Key); msg != "" {
+			return nil, fmt.Sprintf("line %d: %s", e.Line, msg)
+		}
+		if first, ok := lines[e.Key]; ok {
+			return nil, fmt.Sprintf("line %d: %s is already set on line %d", e.Line, e.Key, first)
+		}
+		lines[e.Key] = e.Line
+	}
+	return entries, ""
+}
+
+// exportSecrets returns an environment's decrypted secrets, sorted by key,
+// as a file in the ?format= given: dotenv (the default), json, yaml or
+// shell (`export KEY='value'` lines). References are expanded unless
+// ?raw=true; secrets whose references cannot be resolved are exported as
+// stored and named in headerUnresolvedReferences. The file is not wrapped
+// in the JSON envelope.
+func (s *Server) exportSecrets(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatDotenv
+	}
+	var contentType, ext string
+	switch format {
+	case formatDotenv:
+		contentType, ext = "text/plain; charset=utf-8", "env"
+	case formatJSON:
+		contentType, ext = "application/json", "json"
+	case formatYAML:
+		contentType, ext = "application/yaml", "yaml"
+	case formatShell:
+		contentType, ext = "text/x-shellscript", "sh"
+	default:
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "format must be dotenv, json, yaml or shell")
+		return
+	}
+
+	raw, ok := parseRaw(w, r)
+	if !ok {
+		return
+	}
+
+	secrets, ok := s.readSecrets(w, r, project, env, raw)
+	if !ok {
+		return
+	}
+	entries := make([]dotenv.Entry, len(secrets))
+	for i, secret := range secrets {
+		entries[i] = dotenv.Entry{Key: secret.Key, Value: secret.Value}
+	}
+
+	var body []byte
+	var err error
+	switch format {
+	case formatDotenv:
+		body = dotenv.Marshal(entries)
+	case formatJSON:
+		body, err = marshalJSONObject(entries)
+	case formatYAML:
+		body, err = marshalYAML(entries)
+	case formatShell:
+		if body, err = dotenv.MarshalShell(entries); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+	}
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+env.Name+"."+ext+`"`)
+	for _, secret := range secrets {
+		if secret.ReferenceError != "" {
+			w.Header().Add(headerUnresolvedReferences, secret.Key)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// marshalJSONObject writes entries as one JSON object, keeping their order
+func marshalJSONObject(entries []dotenv.Entry) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, e := range entries {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString("\n  ")
+		if err := writeJSONString(&b, e.Key); err != nil {
+			return nil, err
+		}
+		b.WriteString(": ")
+		if err := writeJSONString(&b, e.Value); err != nil {
+			return nil, err
+		}
+	}
+	if len(entries) > 0 {
+		b.WriteByte('\n')
+	}
+	b.WriteString("}\n")
+	return b.Bytes(), nil
+}
+
+// marshalYAML writes entries as a YAML mapping. Values are always written
+// as double-quoted scalars, whose escapes are a superset of JSON's, so
+// strings like "yes" or "0755" keep their type.
+func marshalYAML(entries []dotenv.Entry) ([]byte, error) {
+	var b bytes.Buffer
+	for _, e := range entries {
+		if yamlPlainKey.MatchString(e.Key) && !yamlReserved[strings.ToLower(e.Key)] {
+			b.WriteString(e.Key)
+		} else if err := writeJSONString(&b, e.Key); err != nil {
+			return nil, err
+		}
+		b.WriteString(": ")
+		if err := writeJSONString(&b, e.Value); err != nil {
+			return nil, err
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// writeJSONString writes s as a JSON string without escaping HTML
+// characters
+func writeJSONString(b *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// Drop the newline Encode appends
+	b.Truncate(b.Len() - 1)
+	return nil
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/pkg/dotenv"
+)
+
+// doRaw sends body as-is rather than JSON-encoded
+func (te *testEnv) doRaw(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+te.token)
+	rec := httptest.NewRecorder()
+	te.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// values lists the decrypted secrets of the test environment by key
+func (te *testEnv) values(t *testing.T) map[string]string {
+	t.Helper()
+
+	rec := te.do(t, http.MethodGet, te.secretsPath(), nil)
+	var list []secretResponse
+	decodeData(t, rec, &list)
+	values := make(map[string]string, len(list))
+	for _, s := range list {
+		values[s.Key] = s.Value
+	}
+	return values
+}
+
+func TestImportSecrets(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "SAME", Value: "1"})
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "DIFFERENT", Value: "old"})
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "UNTOUCHED", Value: "keep"})
+
+	file := "# imported\nexport SAME=1\nDIFFERENT=\"new\"\nCERT='line1\nline2'\n"
+	doImport := func(query string) importResponse {
+		t.Helper()
+		rec := te.doRaw(t, http.MethodPost, base+"import"+query, file)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("import%s: expected 200, got %d: %s", query, rec.Code, rec.Body)
+		}
+		var resp importResponse
+		decodeData(t, rec, &resp)
+		return resp
+	}
+
+	// A dry run reports the diff without writing anything
+	resp := doImport("?mode=overwrite&dry_run=true")
+	want := importResponse{
+		Mode:      importOverwrite,
+		DryRun:    true,
+		Created:   []string{"CERT"},
+		Updated:   []string{"DIFFERENT"},
+		Unchanged: []string{"SAME"},
+		Skipped:   []string{},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("dry run: expected %+v, got %+v", want, resp)
+	}
+	if _, ok := te.values(t)["CERT"]; ok {
+		t.Fatal("dry run created a secret")
+	}
+
+	// Merging keeps values that differ
+	resp = doImport("")
+	if resp.Mode != importMerge || !reflect.DeepEqual(resp.Created, []string{"CERT"}) || !reflect.DeepEqual(resp.Skipped, []string{"DIFFERENT"}) {
+		t.Errorf("merge: unexpected result %+v", resp)
+	}
+	values := te.values(t)
+	if values["CERT"] != "line1\nline2" || values["DIFFERENT"] != "old" {
+		t.Errorf("merge: unexpected values %q", values)
+	}
+
+	resp = doImport("?mode=overwrite")
+	if !reflect.DeepEqual(resp.Updated, []string{"DIFFERENT"}) || len(resp.Created) != 0 {
+		t.Errorf("overwrite: unexpected result %+v", resp)
+	}
+	values = te.values(t)
+	wantValues := map[string]string{"SAME": "1", "DIFFERENT": "new", "CERT": "line1\nline2", "UNTOUCHED": "keep"}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("overwrite: expected %q, got %q", wantValues, values)
+	}
+
+	// Nothing left to change
+	if resp = doImport("?mode=overwrite"); len(resp.Unchanged) != 3 {
+		t.Errorf("re-import: expected 3 unchanged keys, got %+v", resp)
+	}
+
+	creates, updates := 0, 0
+	for _, l := range te.accessLogs(t) {
+		if l.ResourceType != resourceSecret {
+			continue
+		}
+		switch l.Action {
+		case repository.AccessActionCreate:
+			creates++
+		case repository.AccessActionUpdate:
+			updates++
+		}
+	}
+	// Three secrets seeded, one imported; one imported update
+	if creates != 4 || updates != 1 {
+		t.Errorf("Expected 4 creates and 1 update logged, got %d and %d", creates, updates)
+	}
+}
+
+func TestImportSecretsErrors(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		msg   string
+	}{
+		{"Empty file", "", "# nothing here\n", "no assignments"},
+		{"Parse error", "", "A=1\nB=\"open\n", "line 2"},
+		{"Duplicate key", "", "A=1\n\nA=2\n", "line 3: A is already set on line 1"},
+		{"Invalid mode", "?mode=replace", "A=1", "mode"},
+		{"Invalid dry run", "?dry_run=maybe", "A=1", "dry_run"},
+		{"Too large", "", "A=" + strings.Repeat("x", maxImportSize), "1 MiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := te.doRaw(t, http.MethodPost, base+"import"+tt.query, tt.body)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.msg) {
+				t.Errorf("Expected 400 mentioning %q, got %d: %s", tt.msg, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	// Viewers may preview an import but not apply it
+	te.loginAs(t, repository.OrgRoleViewer)
+	if rec := te.doRaw(t, http.MethodPost, base+"import?dry_run=true", "A=1"); rec.Code != http.StatusOK {
+		t.Errorf("viewer dry run: expected 200, got %d", rec.Code)
+	}
+	if rec := te.doRaw(t, http.MethodPost, base+"import", "A=1"); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer import: expected 403, got %d", rec.Code)
+	}
+}
+
+func TestImportSecretsProtected(t *testing.T) {
+	te := newTestEnv(t)
+	te.protect(1)
+
+	rec := te.doRaw(t, http.MethodPost, te.secretsPath()+"import?reason=bootstrap", "A=1\nB=2\n")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var cr changeRequestResponse
+	decodeData(t, rec, &cr)
+	if len(cr.Changes) != 2 || cr.Reason == nil || *cr.Reason != "bootstrap" {
+		t.Errorf("Expected a change request creating 2 secrets, got %+v", cr)
+	}
+	if len(te.values(t)) != 0 {
+		t.Error("Expected nothing to be written before approval")
+	}
+
+	// Dry runs never propose anything
+	rec = te.doRaw(t, http.MethodPost, te.secretsPath()+"import?dry_run=true", "A=1")
+	if rec.Code != http.StatusOK {
+		t.Errorf("dry run: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestExportSecrets(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+	secrets := map[string]string{
+		"API_KEY":  "sk_test_123",
+		"CERT":     "-----BEGIN-----\nabc\n-----END-----\n",
+		"QUOTED":   `it's "quoted" $HOME`,
+		"ON":       "yes",
+		"app.name": "<envhub>",
+	}
+	for k, v := range secrets {
+		te.do(t, http.MethodPost, base, createSecretRequest{Key: k, Value: v})
+	}
+
+	export := func(format string) string {
+		t.Helper()
+		rec := te.do(t, http.MethodGet, base+"export?format="+format, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("export %s: expected 200, got %d: %s", format, rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+
+	entries, err := dotenv.Parse([]byte(export(formatDotenv)))
+	if err != nil {
+		t.Fatalf("Parse of export failed: %v", err)
+	}
+	got := make(map[string]string, len(entries))
+	for _, e := range entries {
+		got[e.Key] = e.Value
+	}
+	if !reflect.DeepEqual(got, secrets) {
+		t.Errorf("dotenv: expected %q, got %q", secrets, got)
+	}
+	if entries[0].Key != "API_KEY" {
+		t.Errorf("Expected keys in order, got %s first", entries[0].Key)
+	}
+
+	got = nil
+	if err := json.Unmarshal([]byte(export(formatJSON)), &got); err != nil || !reflect.DeepEqual(got, secrets) {
+		t.Errorf("json: expected %q, got %q (%v)", secrets, got, err)
+	}
+
+	yaml := export(formatYAML)
+	for _, line := range []string{
+		`API_KEY: "sk_test_123"`,
+		`CERT: "-----BEGIN-----\nabc\n-----END-----\n"`,
+		`"ON": "yes"`,
+		`app.name: "<envhub>"`,
+	} {
+		if !strings.Contains(yaml, line+"\n") {
+			t.Errorf("yaml: expected line %s in\n%s", line, yaml)
+		}
+	}
+
+	// app.name is not a shell variable name
+	if rec := te.do(t, http.MethodGet, base+"export?format=shell", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("shell: expected 400, got %d", rec.Code)
+	}
+	te.do(t, http.MethodDelete, base+"app.name", nil)
+	if shell := export(formatShell); !strings.Contains(shell, `export QUOTED='it'\''s "quoted" $HOME'`) {
+		t.Errorf("shell: unexpected output\n%s", shell)
+	}
+
+	if rec := te.do(t, http.MethodGet, base+"export?format=toml", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: expected 400, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+)
+
+// A secret may carry the time it expires and how often its value should be
+// rotated. Neither changes its value, so setting them records no version.
+// The Reminder flags secrets past either deadline.
+
+// minRotateEvery bounds how often a secret may be due for rotation
+const minRotateEvery = time.Hour
+
+type secretLifetimeRequest struct {
+	// ExpiresAt is when the secret expires; null if it never does
+	ExpiresAt *time.Time `json:"expires_at"`
+
+	// RotateEvery is how long a value may be used, a duration such as
+	// "720h"; empty if it need not be rotated
+	RotateEvery string `json:"rotate_every"`
+}
+
+// secretLifetimeResponse is when a secret expires and is due for rotation,
+// without its value
+type secretLifetimeResponse struct {
+	ID            uuid.UUID  `json:"id"`
+	Environment   string     `json:"environment"`
+	Key           string     `json:"key"`
+	Version       int32      `json:"version"`
+	Active        bool       `json:"active"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	RotateEvery   string     `json:"rotate_every,omitempty"`
+	RotationDueAt *time.Time `json:"rotation_due_at,omitempty"`
+	Expired       bool       `json:"expired"`
+	RotationDue   bool       `json:"rotation_due"`
+}
+
+// setSecretLifetime replaces when a secret expires and how often it is due
+// for rotation. Extending the expiry of a secret deactivated when it expired
+// makes it active again. In protected environments this also needs the
+// right to approve change requests, as an expiry can take a secret out of
+// reads.
+func (s *Server) setSecretLifetime(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretUpdate)
+	if !ok {
+		return
+	}
+	if isProtected(env) {
+		target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: env.Name}
+		if !s.authorize(w, r, policy.ChangeRequestApprove, target) {
+			return
+		}
+	}
+
+	var req secretLifetimeRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	params := repository.SetSecretLifetimeParams{UpdatedBy: auth.UserIDFromContext(r.Context())}
+	if req.ExpiresAt != nil {
+		params.ExpiresAt = pgtype.Timestamptz{Time: *req.ExpiresAt, Valid: true}
+	}
+	if req.RotateEvery != "" {
+		d, err := time.ParseDuration(req.RotateEvery)
+		if err != nil || d < minRotateEvery {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("rotate_every must be a duration of at least %s, such as 720h", minRotateEvery))
+			return
+		}
+		params.RotateEvery = pgtype.Interval{Microseconds: d.Microseconds(), Valid: true}
+	}
+
+	var updated repository.Secret
+	err := s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		secret, err := q.GetSecretByKeyIncludingInactive(r.Context(), repository.GetSecretByKeyIncludingInactiveParams{
+			EnvironmentID: env.ID,
+			Key:           chi.URLParam(r, "key"),
+		})
+		if err != nil {
+			return err
+		}
+		setAccessTarget(r, resourceSecret, secret.ID, repository.AccessActionUpdate)
+
+		params.ID = secret.ID
+		params.IsActive = secret.IsActive
+		if !params.ExpiresAt.Valid || params.ExpiresAt.Time.After(time.Now()) {
+			active := true
+			params.IsActive = &active
+		}
+		updated, err = q.SetSecretLifetime(r.Context(), params)
+		return err
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "secret not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, toSecretLifetimeResponse(repository.ListDueSecretsByProjectRow{
+		ID:              updated.ID,
+		EnvironmentName: env.Name,
+		Key:             updated.Key,
+		Version:         updated.Version,
+		IsActive:        updated.IsActive,
+		ExpiresAt:       updated.ExpiresAt,
+		RotateEvery:     updated.RotateEvery,
+		ValueChangedAt:  updated.ValueChangedAt,
+	}, time.Now()))
+}
+
+// listDueSecrets returns the secrets of a project that have expired or are
+// due for rotation, or will be within ?within=, in the environments whose
+// secrets the caller may read. Values are not included.
+func (s *Server) listDueSecrets(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, policy.ProjectRead)
+	if !ok {
+		return
+	}
+	var within time.Duration
+	if v := r.URL.Query().Get("within"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "within must be a duration such as 168h")
+			return
+		}
+		within = d
+	}
+
+	now := time.Now()
+	rows, err := s.store.ListDueSecretsByProject(r.Context(), repository.ListDueSecretsByProjectParams{
+		ProjectID: project.ID,
+		Before:    pgtype.Timestamptz{Time: now.Add(within), Valid: true},
+	})
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	readable := make(map[string]bool)
+	resp := make([]secretLifetimeResponse, 0, len(rows))
+	for _, row := range rows {
+		allowed, seen := readable[row.EnvironmentName]
+		if !seen {
+			target := auth.Target{OrganizationID: project.OrganizationID, ProjectID: project.ID, Environment: row.EnvironmentName}
+			err := s.permit(r.Context(), policy.SecretRead, target)
+			var denied *deniedError
+			if err != nil && !errors.As(err, &denied) {
+				s.writeInternalError(w, err)
+				return
+			}
+			allowed = err == nil
+			readable[row.EnvironmentName] = allowed
+		}
+		if !allowed {
+			continue
+		}
+
+		resp = append(resp, toSecretLifetimeResponse(row, now))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// toSecretLifetimeResponse reports whether row is past its deadlines at now
+func toSecretLifetimeResponse(row repository.ListDueSecretsByProjectRow, now time.Time) secretLifetimeResponse {
+	resp := secretLifetimeResponse{
+		ID:          row.ID,
+		Environment: row.EnvironmentName,
+		Key:         row.Key,
+		Version:     row.Version,
+		Active:      row.IsActive == nil || *row.IsActive,
+		RotateEvery: formatInterval(row.RotateEvery),
+	}
+	if row.ExpiresAt.Valid {
+		resp.ExpiresAt = &row.ExpiresAt.Time
+		resp.Expired = !row.ExpiresAt.Time.After(now)
+	}
+	if row.RotateEvery.Valid {
+		due := addInterval(row.ValueChangedAt, row.RotateEvery)
+		resp.RotationDueAt = &due
+		resp.RotationDue = !due.After(now)
+	}
+	return resp
+}
+
+// addInterval adds iv to t the way Postgres adds an interval to a timestamp
+func addInterval(t time.Time, iv pgtype.Interval) time.Time {
+	return t.AddDate(0, int(iv.Months), int(iv.Days)).Add(time.Duration(iv.Microseconds) * time.Microsecond)
+}
+
+// formatInterval renders iv as a Go duration, or "" if iv is NULL
+func formatInterval(iv pgtype.Interval) string {
+	if !iv.Valid {
+		return ""
+	}
+	return intervalDuration(iv).String()
+}
+
+// intervalDuration converts iv to a duration, counting a day as 24 hours
+// and a month as 30 days
+func intervalDuration(iv pgtype.Interval) time.Duration {
+	days := time.Duration(iv.Days) + 30*time.Duration(iv.Months)
+	return days*24*time.Hour + time.Duration(iv.Microseconds)*time.Microsecond
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+func (te *testEnv) setLifetime(t *testing.T, key string, req secretLifetimeRequest) secretLifetimeResponse {
+	t.Helper()
+
+	rec := te.do(t, http.MethodPut, te.secretsPath()+key+"/lifetime", req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set lifetime of %s: expected 200, got %d: %s", key, rec.Code, rec.Body)
+	}
+	var resp secretLifetimeResponse
+	decodeData(t, rec, &resp)
+	return resp
+}
+
+func TestSetSecretLifetime(t *testing.T) {
+	te := newTestEnv(t)
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "v1"})
+
+	expires := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	resp := te.setLifetime(t, "API_KEY", secretLifetimeRequest{ExpiresAt: &expires, RotateEvery: "720h"})
+	if resp.ExpiresAt == nil || !resp.ExpiresAt.Equal(expires) || resp.Expired {
+		t.Errorf("Expected the secret to expire at %v, got %+v", expires, resp)
+	}
+	if resp.RotateEvery != "720h0m0s" || resp.RotationDueAt == nil || resp.RotationDueAt.Before(time.Now().Add(719*time.Hour)) {
+		t.Errorf("Expected rotation to be due in 720h, got %+v", resp)
+	}
+
+	rec := te.do(t, http.MethodGet, te.secretsPath()+"API_KEY", nil)
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+	if secret.ExpiresAt == nil || !secret.ExpiresAt.Equal(expires) || secret.RotationDueAt == nil {
+		t.Errorf("Expected reads to include the lifetime, got %+v", secret)
+	}
+	if h := te.store.History(secret.ID); len(h) != 1 {
+		t.Errorf("Expected no new version for a lifetime change, got %d history entries", len(h))
+	}
+
+	// Rotating the value moves the deadline
+	te.do(t, http.MethodPut, te.secretsPath()+"API_KEY", updateSecretRequest{Value: "v2"})
+	rec = te.do(t, http.MethodGet, te.secretsPath()+"API_KEY", nil)
+	var rotated secretResponse
+	decodeData(t, rec, &rotated)
+	if !rotated.RotationDueAt.After(*secret.RotationDueAt) {
+		t.Errorf("Expected a new value to move the rotation deadline from %v, got %v", secret.RotationDueAt, rotated.RotationDueAt)
+	}
+
+	cleared := te.setLifetime(t, "API_KEY", secretLifetimeRequest{})
+	if cleared.ExpiresAt != nil || cleared.RotateEvery != "" || cleared.RotationDueAt != nil {
+		t.Errorf("Expected an empty lifetime to clear both, got %+v", cleared)
+	}
+
+	tests := []struct {
+		key  string
+		body secretLifetimeRequest
+		want int
+	}{
+		{"API_KEY", secretLifetimeRequest{RotateEvery: "monthly"}, http.StatusBadRequest},
+		{"API_KEY", secretLifetimeRequest{RotateEvery: "5m"}, http.StatusBadRequest},
+		{"NOPE", secretLifetimeRequest{}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := te.do(t, http.MethodPut, te.secretsPath()+tt.key+"/lifetime", tt.body); rec.Code != tt.want {
+			t.Errorf("%s %+v: expected %d, got %d: %s", tt.key, tt.body, tt.want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestListDueSecrets(t *testing.T) {
+	te := newTestEnv(t)
+	for _, key := range []string{"EXPIRED", "SOON", "LATER", "FOREVER"} {
+		te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: key, Value: "v"})
+	}
+	for key, in := range map[string]time.Duration{"EXPIRED": -time.Hour, "SOON": 72 * time.Hour, "LATER": 30 * 24 * time.Hour} {
+		at := time.Now().Add(in)
+		te.setLifetime(t, key, secretLifetimeRequest{ExpiresAt: &at})
+	}
+	path := "/projects/" + te.project.ID.String() + "/secrets/due"
+
+	keys := func(query string) []string {
+		t.Helper()
+		rec := te.do(t, http.MethodGet, path+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list due: expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var due []secretLifetimeResponse
+		decodeData(t, rec, &due)
+		var keys []string
+		for _, d := range due {
+			if d.Environment != "dev" || d.Expired != (d.Key == "EXPIRED") {
+				t.Errorf("Unexpected entry %+v", d)
+			}
+			keys = append(keys, d.Key)
+		}
+		return keys
+	}
+	if got := keys(""); len(got) != 1 || got[0] != "EXPIRED" {
+		t.Errorf("Expected only the expired secret, got %v", got)
+	}
+	if got := keys("?within=168h"); len(got) != 2 || got[0] != "EXPIRED" || got[1] != "SOON" {
+		t.Errorf("Expected the secrets expiring within a week, got %v", got)
+	}
+	if rec := te.do(t, http.MethodGet, path+"?within=soon", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid within: expected 400, got %d", rec.Code)
+	}
+
+	// Only environments whose secrets the caller may read are listed
+	te.store.AddEnvironment(repository.Environment{ProjectID: te.project.ID, Name: "ops"})
+	te.do(t, http.MethodPost, te.envPath("ops")+"/secrets/", createSecretRequest{Key: "OPS_KEY", Value: "v"})
+	past := time.Now().Add(-time.Minute)
+	te.env.Name = "ops"
+	te.setLifetime(t, "OPS_KEY", secretLifetimeRequest{ExpiresAt: &past})
+	project := "project/" + te.project.ID.String()
+	rec := te.do(t, http.MethodPost, "/tokens/", createTokenRequest{Name: "ci", Scopes: []string{"read:projects:" + project, "read:secrets:" + project + "/env/dev"}})
+	var created createTokenResponse
+	decodeData(t, rec, &created)
+	te.token = created.Token
+	if got := keys(""); len(got) != 1 || got[0] != "EXPIRED" {
+		t.Errorf("Expected ops to be left out, got %v", got)
+	}
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// Page size bounds for the secret history listing
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// secretVersionResponse is one secret history entry, without its value
+type secretVersionResponse struct {
+	ID              uuid.UUID               `json:"id"`
+	Version         int32                   `json:"version"`
+	Action          repository.SecretAction `json:"action"`
+	RestoredVersion *int32                  `json:"restored_version,omitempty"`
+	ChangedBy       uuid.UUID               `json:"changed_by"`
+	CreatedAt       time.Time               `json:"created_at"`
+}
+
+// secretVersionValueResponse is a history entry with its decrypted value
+type secretVersionValueResponse struct {
+	secretVersionResponse
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type rollbackSecretRequest struct {
+	Version int32   `json:"version"`
+	Reason  *string `json:"reason,omitempty"`
+}
+
+// listSecretVersions returns a secret's history, newest first.
+// Pages are requested with ?limit= and ?before=<entry id>.
+func (s *Server) listSecretVersions(w http.ResponseWriter, r *http.Request) {
+	_, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+
+	params := repository.ListSecretHistoryParams{Limit: defaultHistoryLimit}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		params.Limit = int32(n)
+	}
+	if v := r.URL.Query().Get("before"); v != "" {
+		before, err := uuid.Parse(v)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid before cursor")
+			return
+		}
+		params.Before = pgtype.UUID{Bytes: before, Valid: true}
+	}
+
+	secret, err := s.store.GetSecretByKey(r.Context(), repository.GetSecretByKeyParams{
+		EnvironmentID: env.ID,
+		Key:           chi.URLParam(r, "key"),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "secret not found")
+		return
+	}
+	setAccessTarget(r, resourceSecret, secret.ID, repository.AccessActionRead)
+	params.SecretID = secret.ID
+
+	history, err := s.store.ListSecretHistory(r.Context(), params)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := make([]secretVersionResponse, 0, len(history))
+	for _, h := range history {
+		resp = append(resp, toSecretVersionResponse(h))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// getSecretVersion returns the decrypted value a secret had at a version
+func (s *Server) getSecretVersion(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.ParseInt(chi.URLParam(r, "version"), 10, 32)
+	if err != nil || version < 1 {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid version")
+		return
+	}
+
+	secret, err := s.store.GetSecretByKey(r.Context(), repository.GetSecretByKeyParams{
+		EnvironmentID: env.ID,
+		Key:           chi.URLParam(r, "key"),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "secret not found")
+		return
+	}
+	setAccessTarget(r, resourceSecret, secret.ID, repository.AccessActionRead)
+
+	entry, err := s.store.GetSecretHistoryVersion(r.Context(), repository.GetSecretHistoryVersionParams{
+		SecretID: secret.ID,
+		Version:  int32(version),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "version not found")
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	value, err := s.decryptVersion(dek, project.ID, entry)
+	if err != nil {
+		s.writeVersionError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, secretVersionValueResponse{
+		secretVersionResponse: toSecretVersionResponse(entry),
+		Key:                   entry.Key,
+		Value:                 value,
+	})
+}
+
+// rollbackSecret restores the value a secret had at an earlier version.
+// The value is written as a new version and logged as 'rolled_back'.
+func (s *Server) rollbackSecret(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretUpdate)
+	if !ok {
+		return
+	}
+
+	var req rollbackSecretRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if req.Version < 1 {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "version is required")
+		return
+	}
+
+	secret, err := s.store.GetSecretByKey(r.Context(), repository.GetSecretByKeyParams{
+		EnvironmentID: env.ID,
+		Key:           chi.URLParam(r, "key"),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "secret not found")
+		return
+	}
+	setAccessTarget(r, resourceSecret, secret.ID, repository.AccessActionUpdate)
+	if req.Version == secret.Version {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "secret is already at version "+strconv.Itoa(int(req.Version)))
+		return
+	}
+
+	entry, err := s.store.GetSecretHistoryVersion(r.Context(), repository.GetSecretHistoryVersionParams{
+		SecretID: secret.ID,
+		Version:  req.Version,
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "version not found")
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	value, err := s.decryptVersion(dek, project.ID, entry)
+	if err != nil {
+		s.writeVersionError(w, err)
+		return
+	}
+
+	if isProtected(env) {
+		s.proposeChanges(w, r, project, env, req.Reason, proposedChange{
+			Key:             secret.Key,
+			Operation:       repository.ChangeOperationUpdate,
+			Value:           &value,
+			RestoredVersion: &req.Version,
+		})
+		return
+	}
+
+	// Re-seal rather than copy the old ciphertext so the value is bound to
+	// the version it is restored as and the current envelope format
+	id := vault.SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: secret.Key, Version: secret.Version + 1}
+	encrypted, err := s.vault.EncryptValue(dek, id, value)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	var updated repository.Secret
+	err = s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		if err := vault.CheckDataKey(r.Context(), q, project.ID, dek); err != nil {
+			return err
+		}
+
+		updated, err = q.RollbackSecret(r.Context(), repository.RollbackSecretParams{
+			EncryptedValue:  encrypted,
+			RestoredVersion: req.Version,
+			UpdatedBy:       auth.UserIDFromContext(r.Context()),
+			ID:              secret.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if updated.Version != id.Version {
+			return errSecretsChanged
+		}
+		return recordSecretEvent(r.Context(), q, project, env, eventSecretUpdated, updated, updated.UpdatedBy)
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "secret not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, toSecretResponse(updated, value))
+}
+
+// decryptVersion decrypts the value recorded in a history entry
+func (s *Server) decryptVersion(dek *crypto.DataKey, projectID uuid.UUID, entry repository.SecretHistory) (string, error) {
+	if entry.EncryptedValue == nil {
+		return "", crypto.ErrDecryptionFailed
+	}
+	id := vault.SecretIdentity{ProjectID: projectID, EnvironmentID: entry.EnvironmentID, Key: entry.Key}
+	if entry.Version != nil {
+		id.Version = *entry.Version
+	}
+	return s.vault.DecryptValue(dek, id, *entry.EncryptedValue)
+}
+
+// writeVersionError reports historical values that can no longer be
+// decrypted, e.g. after a DEK rotation that skipped history
+func (s *Server) writeVersionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, crypto.ErrDecryptionFailed) {
+		utils.WriteError(w, http.StatusGone, CodeGone, "value of this version is no longer readable")
+		return
+	}
+	s.writeInternalError(w, err)
+}
+
+func toSecretVersionResponse(h repository.SecretHistory) secretVersionResponse {
+	resp := secretVersionResponse{
+		ID:              h.ID,
+		Action:          h.Action,
+		RestoredVersion: h.RestoredVersion,
+		ChangedBy:       h.ChangedBy,
+		CreatedAt:       h.CreatedAt,
+	}
+	if h.Version != nil {
+		resp.Version = *h.Version
+	}
+	return resp
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+func TestSecretVersions(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "v1"})
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "v2"})
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "v3"})
+
+	rec := te.do(t, http.MethodGet, base+"API_KEY/versions", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var versions []secretVersionResponse
+	decodeData(t, rec, &versions)
+	if len(versions) != 3 {
+		t.Fatalf("Expected 3 history entries, got %d", len(versions))
+	}
+	if versions[0].Version != 3 || versions[0].Action != repository.SecretActionUpdated {
+		t.Errorf("Expected newest entry first, got %+v", versions[0])
+	}
+	if versions[2].Version != 1 || versions[2].Action != repository.SecretActionCreated || versions[2].ChangedBy != te.user.ID {
+		t.Errorf("Unexpected first entry %+v", versions[2])
+	}
+
+	// Keyset pagination
+	rec = te.do(t, http.MethodGet, base+"API_KEY/versions?limit=2", nil)
+	var page []secretVersionResponse
+	decodeData(t, rec, &page)
+	if len(page) != 2 {
+		t.Fatalf("Expected a page of 2, got %d", len(page))
+	}
+	rec = te.do(t, http.MethodGet, base+"API_KEY/versions?limit=2&before="+page[1].ID.String(), nil)
+	var last []secretVersionResponse
+	decodeData(t, rec, &last)
+	if len(last) != 1 || last[0].Version != 1 {
+		t.Errorf("Expected the last page to hold version 1, got %+v", last)
+	}
+
+	rec = te.do(t, http.MethodGet, base+"API_KEY/versions/2", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get version: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var v2 secretVersionValueResponse
+	decodeData(t, rec, &v2)
+	if v2.Value != "v2" || v2.Version != 2 || v2.Key != "API_KEY" {
+		t.Errorf("Unexpected version 2 %+v", v2)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"Unknown version", base + "API_KEY/versions/9", http.StatusNotFound},
+		{"Invalid version", base + "API_KEY/versions/latest", http.StatusBadRequest},
+		{"Unknown secret", base + "MISSING/versions", http.StatusNotFound},
+		{"Invalid limit", base + "API_KEY/versions?limit=0", http.StatusBadRequest},
+		{"Invalid cursor", base + "API_KEY/versions?before=x", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := te.do(t, http.MethodGet, tt.path, nil); rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRollbackSecret(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "v1"})
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "v2"})
+
+	rec := te.do(t, http.MethodPost, base+"API_KEY/rollback", rollbackSecretRequest{Version: 1})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rollback: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var secret secretResponse
+	decodeData(t, rec, &secret)
+	if secret.Value != "v1" || secret.Version != 3 {
+		t.Errorf("Expected v1 restored as version 3, got %+v", secret)
+	}
+
+	rec = te.do(t, http.MethodGet, base+"API_KEY", nil)
+	decodeData(t, rec, &secret)
+	if secret.Value != "v1" {
+		t.Errorf("Expected current value v1, got %q", secret.Value)
+	}
+
+	rec = te.do(t, http.MethodGet, base+"API_KEY/versions", nil)
+	var versions []secretVersionResponse
+	decodeData(t, rec, &versions)
+	latest := versions[0]
+	if latest.Action != repository.SecretActionRolledBack || latest.Version != 3 || latest.RestoredVersion == nil || *latest.RestoredVersion != 1 {
+		t.Errorf("Expected a rolled_back entry restoring version 1, got %+v", latest)
+	}
+
+	// A regular update after a rollback is logged as an update again
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "v4"})
+	rec = te.do(t, http.MethodGet, base+"API_KEY/versions?limit=1", nil)
+	var newest []secretVersionResponse
+	decodeData(t, rec, &newest)
+	if newest[0].Action != repository.SecretActionUpdated || newest[0].RestoredVersion != nil {
+		t.Errorf("Expected a plain update, got %+v", newest[0])
+	}
+
+	tests := []struct {
+		name    string
+		version int32
+		want    int
+	}{
+		{"Current version", 4, http.StatusBadRequest},
+		{"Missing version", 0, http.StatusBadRequest},
+		{"Unknown version", 7, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := te.do(t, http.MethodPost, base+"API_KEY/rollback", rollbackSecretRequest{Version: tt.version})
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	te.loginAs(t, repository.OrgRoleViewer)
+	if rec := te.do(t, http.MethodPost, base+"API_KEY/rollback", rollbackSecretRequest{Version: 1}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer rollback: expected 403, got %d", rec.Code)
+	}
+	if rec := te.do(t, http.MethodGet, base+"API_KEY/versions/1", nil); rec.Code != http.StatusOK {
+		t.Errorf("viewer get version: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestSwappedVersionValues(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "v1"})
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "v2"})
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "v3"})
+
+	secret, err := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: te.env.ID, Key: "API_KEY"})
+	if err != nil {
+		t.Fatalf("GetSecretByKey failed: %v", err)
+	}
+	history := te.store.History(secret.ID)
+	first, second := history[0], history[1]
+
+	// Someone with database access swaps the values of versions 1 and 2
+	for _, swap := range []struct{ entry, from repository.SecretHistory }{{first, second}, {second, first}} {
+		if _, err := te.store.ResealSecretHistory(t.Context(), repository.ResealSecretHistoryParams{
+			NewValue: *swap.from.EncryptedValue,
+			ID:       swap.entry.ID,
+			OldValue: swap.entry.EncryptedValue,
+		}); err != nil {
+			t.Fatalf("ResealSecretHistory failed: %v", err)
+		}
+	}
+
+	if rec := te.do(t, http.MethodGet, base+"API_KEY/versions/1", nil); rec.Code != http.StatusGone {
+		t.Errorf("get swapped version: expected 410, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := te.do(t, http.MethodPost, base+"API_KEY/rollback", rollbackSecretRequest{Version: 2}); rec.Code != http.StatusGone {
+		t.Errorf("rollback to swapped version: expected 410, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := te.do(t, http.MethodGet, base+"API_KEY/versions/3", nil); rec.Code != http.StatusOK {
+		t.Errorf("get untouched version: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// Restoring an old ciphertext as the current value is caught too
+	if _, err := te.store.ResealSecret(t.Context(), repository.ResealSecretParams{
+		NewValue:  *first.EncryptedValue,
+		UpdatedBy: secret.UpdatedBy,
+		ID:        secret.ID,
+		OldValue:  secret.EncryptedValue,
+	}); err != nil {
+		t.Fatalf("ResealSecret failed: %v", err)
+	}
+	if rec := te.do(t, http.MethodGet, base+"API_KEY", nil); rec.Code != http.StatusInternalServerError {
+		t.Errorf("get restored value: expected 500, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,579 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// maxSecretKeyLength matches secrets.key VARCHAR(255)
+const maxSecretKeyLength = 255
+
+var errSecretsChanged = errors.New("secrets changed since they were compared, retry the request")
+
+// secretResponse is the public representation of a decrypted secret
+type secretResponse struct {
+	ID          uuid.UUID `json:"id"`
+	Key         string    `json:"key"`
+	Value       string    `json:"value"`
+	Description *string   `json:"description,omitempty"`
+	Version     int32     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// ExpiresAt and RotationDueAt are set for secrets with a lifetime (see
+	// setSecretLifetime)
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	RotationDueAt *time.Time `json:"rotation_due_at,omitempty"`
+
+	// InheritedFrom names the ancestor environment the secret is stored in,
+	// for secrets read through inheritance
+	InheritedFrom string `json:"inherited_from,omitempty"`
+
+	// ReferenceError is why the references in a listed secret could not be
+	// expanded, in which case Value is the stored value
+	ReferenceError string `json:"reference_error,omitempty"`
+}
+
+type createSecretRequest struct {
+	Key         string  `json:"key"`
+	Value       string  `json:"value"`
+	Description *string `json:"description"`
+
+	// Reason is recorded on the change request in protected environments
+	Reason *string `json:"reason,omitempty"`
+}
+
+type updateSecretRequest struct {
+	Value       string  `json:"value"`
+	Description *string `json:"description"`
+	Reason      *string `json:"reason,omitempty"`
+}
+
+// listSecrets returns every active secret of an environment, decrypted,
+// with references expanded unless ?raw=true
+func (s *Server) listSecrets(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+	raw, ok := parseRaw(w, r)
+	if !ok {
+		return
+	}
+
+	resp, ok := s.readSecrets(w, r, project, env, raw)
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// readSecrets decrypts every secret env sees, its own and inherited ones,
+// sorted by key, and expands their references unless raw. Secrets whose
+// references cannot be expanded keep their stored value and carry the
+// reason in ReferenceError. It logs the reads.
+// It writes an error response and returns ok=false otherwise.
+func (s *Server) readSecrets(w http.ResponseWriter, r *http.Request, project repository.Project, env repository.Environment, raw bool) ([]secretResponse, bool) {
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return nil, false
+	}
+
+	values, err := s.mergedSecrets(r.Context(), dek, project, env)
+	if err != nil {
+		s.writeReferenceError(w, err)
+		return nil, false
+	}
+
+	resp := make([]secretResponse, 0, len(values))
+	for _, key := range sortedKeys(values, nil) {
+		addAccess(r, accessEntry{resourceSecret, values[key].ID, repository.AccessActionRead})
+		resp = append(resp, values[key])
+	}
+	if raw {
+		return resp, true
+	}
+
+	res := s.newResolver(r.Context(), project, env, values)
+	for _, secret := range resp {
+		res.markRead(secret.ID)
+	}
+	for i := range resp {
+		v, err := res.expand(resp[i])
+		if unresolved(err) {
+			resp[i].ReferenceError = err.Error()
+			continue
+		}
+		if err != nil {
+			s.writeReferenceError(w, err)
+			return nil, false
+		}
+		resp[i].Value = v
+	}
+	addAccess(r, res.accessed()...)
+	return resp, true
+}
+
+// getSecret returns a single decrypted secret by key, falling back to the
+// environment's ancestors, with references expanded unless ?raw=true
+func (s *Server) getSecret(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretRead)
+	if !ok {
+		return
+	}
+	raw, ok := parseRaw(w, r)
+	if !ok {
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	key := chi.URLParam(r, "key")
+	var out secretResponse
+	var values map[string]secretResponse
+	secret, err := s.store.GetSecretByKey(r.Context(), repository.GetSecretByKeyParams{
+		EnvironmentID: env.ID,
+		Key:           key,
+	})
+	switch {
+	case err == nil:
+		if out, err = s.decryptSecret(dek, project.ID, secret); err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+	case errors.Is(err, pgx.ErrNoRows) && env.ParentID.Valid:
+		if values, err = s.mergedSecrets(r.Context(), dek, project, env); err != nil {
+			s.writeReferenceError(w, err)
+			return
+		}
+		if out, ok = values[key]; !ok {
+			s.writeStoreError(w, pgx.ErrNoRows, "secret not found")
+			return
+		}
+	default:
+		s.writeStoreError(w, err, "secret not found")
+		return
+	}
+	setAccessTarget(r, resourceSecret, out.ID, repository.AccessActionRead)
+
+	if !raw {
+		res := s.newResolver(r.Context(), project, env, values)
+		res.markRead(out.ID)
+		if out.Value, err = res.expand(out); err != nil {
+			s.writeReferenceError(w, err)
+			return
+		}
+		addAccess(r, res.accessed()...)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, out)
+}
+
+// createSecret encrypts and stores a new secret. In protected environments
+// the write is proposed as a change request instead (see proposeChanges).
+func (s *Server) createSecret(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretCreate)
+	if !ok {
+		return
+	}
+
+	var req createSecretRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if msg := validateSecretKey(req.Key); msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return
+	}
+	if isProtected(env) {
+		s.proposeChanges(w, r, project, env, req.Reason, proposedChange{
+			Key:         req.Key,
+			Operation:   repository.ChangeOperationCreate,
+			Value:       &req.Value,
+			Description: req.Description,
+		})
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	var secret repository.Secret
+	var replaced bool
+	err = s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		if err := vault.CheckDataKey(r.Context(), q, project.ID, dek); err != nil {
+			return err
+		}
+
+		secret, replaced, err = s.insertSecret(r.Context(), q, project, env, dek, proposedChange{
+			Key:         req.Key,
+			Value:       &req.Value,
+			Description: req.Description,
+		})
+		return err
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "environment not found")
+		return
+	}
+	action := repository.AccessActionCreate
+	if replaced {
+		action = repository.AccessActionUpdate
+	}
+	setAccessTarget(r, resourceSecret, secret.ID, action)
+
+	utils.WriteJSON(w, http.StatusCreated, toSecretResponse(secret, req.Value))
+}
+
+// updateSecret replaces the value of an existing secret and bumps its version
+func (s *Server) updateSecret(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretUpdate)
+	if !ok {
+		return
+	}
+
+	var req updateSecretRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if isProtected(env) {
+		s.proposeChanges(w, r, project, env, req.Reason, proposedChange{
+			Key:         chi.URLParam(r, "key"),
+			Operation:   repository.ChangeOperationUpdate,
+			Value:       &req.Value,
+			Description: req.Description,
+		})
+		return
+	}
+
+	dek, err := s.vault.DataKey(project)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	var updated repository.Secret
+	err = s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		if err := vault.CheckDataKey(r.Context(), q, project.ID, dek); err != nil {
+			return err
+		}
+
+		// Secrets deactivated when they expired can be given a new value
+		secret, err := q.GetSecretByKeyIncludingInactive(r.Context(), repository.GetSecretByKeyIncludingInactiveParams{
+			EnvironmentID: env.ID,
+			Key:           chi.URLParam(r, "key"),
+		})
+		if err != nil {
+			return err
+		}
+		setAccessTarget(r, resourceSecret, secret.ID, repository.AccessActionUpdate)
+
+		id := vault.SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: secret.Key, Version: secret.Version + 1}
+		encrypted, err := s.vault.EncryptValue(dek, id, req.Value)
+		if err != nil {
+			return err
+		}
+
+		updated, err = q.UpdateSecret(r.Context(), repository.UpdateSecretParams{
+			ID:             secret.ID,
+			EncryptedValue: encrypted,
+			Description:    req.Description,
+			UpdatedBy:      auth.UserIDFromContext(r.Context()),
+		})
+		if err != nil {
+			return err
+		}
+		if updated.Version != id.Version {
+			return errSecretsChanged
+		}
+		return recordSecretEvent(r.Context(), q, project, env, eventSecretUpdated, updated, updated.UpdatedBy)
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "secret not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, toSecretResponse(updated, req.Value))
+}
+
+// deleteSecret soft-deletes a secret
+func (s *Server) deleteSecret(w http.ResponseWriter, r *http.Request) {
+	project, env, ok := s.loadEnvironment(w, r, policy.SecretDelete)
+	if !ok {
+		return
+	}
+	if isProtected(env) {
+		s.proposeChanges(w, r, project, env, nil, proposedChange{
+			Key:       chi.URLParam(r, "key"),
+			Operation: repository.ChangeOperationDelete,
+		})
+		return
+	}
+
+	err := s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		secret, err := q.GetSecretByKey(r.Context(), repository.GetSecretByKeyParams{
+			EnvironmentID: env.ID,
+			Key:           chi.URLParam(r, "key"),
+		})
+		if err != nil {
+			return err
+		}
+		setAccessTarget(r, resourceSecret, secret.ID, repository.AccessActionDelete)
+
+		if err := q.SoftDeleteSecret(r.Context(), repository.SoftDeleteSecretParams{
+			ID:        secret.ID,
+			UpdatedBy: auth.UserIDFromContext(r.Context()),
+		}); err != nil {
+			return err
+		}
+		return recordSecretEvent(r.Context(), q, project, env, eventSecretDeleted, secret, auth.UserIDFromContext(r.Context()))
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "secret not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// environmentSecrets returns the decrypted active secrets of an environment
+// by key
+func (s *Server) environmentSecrets(ctx context.Context, dek *crypto.DataKey, projectID, envID uuid.UUID) (map[string]secretResponse, error) {
+	secrets, err := s.store.ListSecretsByEnvironment(ctx, envID)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]secretResponse, len(secrets))
+	for _, secret := range secrets {
+		out, err := s.decryptSecret(dek, projectID, secret)
+		if err != nil {
+			return nil, err
+		}
+		values[secret.Key] = out
+	}
+	return values, nil
+}
+
+// writeSecrets encrypts creates and updates to env and applies them in one
+// transaction. The changes were computed against current (see
+// environmentSecrets); if any of those secrets has been created, changed or
+// deleted since, nothing is written and errSecretsChanged is returned.
+func (s *Server) writeSecrets(ctx context.Context, project repository.Project, env repository.Environment, dek *crypto.DataKey, current map[string]secretResponse, changes []proposedChange) ([]accessEntry, error) {
+	user := auth.UserIDFromContext(ctx)
+	var written []accessEntry
+	err := s.store.ExecTx(ctx, func(q repository.Querier) error {
+		written = written[:0]
+		if err := vault.CheckDataKey(ctx, q, project.ID, dek); err != nil {
+			return err
+		}
+
+		for _, c := range changes {
+			if c.Operation == repository.ChangeOperationCreate {
+				secret, replaced, err := s.insertSecret(ctx, q, project, env, dek, c)
+				if isUniqueViolation(err) {
+					return errSecretsChanged
+				}
+				if err != nil {
+					return err
+				}
+				action := repository.AccessActionCreate
+				if replaced {
+					action = repository.AccessActionUpdate
+				}
+				written = append(written, accessEntry{resourceSecret, secret.ID, action})
+				continue
+			}
+
+			secret, err := q.GetSecretByKey(ctx, repository.GetSecretByKeyParams{
+				EnvironmentID: env.ID,
+				Key:           c.Key,
+			})
+			if errors.Is(err, pgx.ErrNoRows) {
+				return errSecretsChanged
+			}
+			if err != nil {
+				return err
+			}
+			if secret.Version != current[c.Key].Version {
+				return errSecretsChanged
+			}
+			id := vault.SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: c.Key, Version: secret.Version + 1}
+			encrypted, err := s.vault.EncryptValue(dek, id, *c.Value)
+			if err != nil {
+				return err
+			}
+			updated, err := q.UpdateSecret(ctx, repository.UpdateSecretParams{
+				ID:             secret.ID,
+				EncryptedValue: encrypted,
+				Description:    c.Description,
+				UpdatedBy:      user,
+			})
+			if err != nil {
+				return err
+			}
+			if updated.Version != id.Version {
+				return errSecretsChanged
+			}
+			if err := recordSecretEvent(ctx, q, project, env, eventSecretUpdated, updated, user); err != nil {
+				return err
+			}
+			written = append(written, accessEntry{resourceSecret, secret.ID, repository.AccessActionUpdate})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+// insertSecret encrypts and writes the value of a new secret and records
+// its event. A secret with the key that was deactivated when it expired is
+// given the value as a new version instead, which makes it active again;
+// replaced reports that. A key that was deleted continues the versions of
+// the deleted secrets, so no two values of the key share a version. Call
+// it with q in a transaction that checked dek.
+func (s *Server) insertSecret(ctx context.Context, q repository.Querier, project repository.Project, env repository.Environment, dek *crypto.DataKey, c proposedChange) (secret repository.Secret, replaced bool, err error) {
+	user := auth.UserIDFromContext(ctx)
+	expired, replaced, err := expiredSecret(ctx, q, env.ID, c.Key)
+	if err != nil {
+		return secret, false, err
+	}
+
+	id := vault.SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: c.Key, Version: expired.Version + 1}
+	if !replaced {
+		latest, err := q.GetLatestSecretVersion(ctx, repository.GetLatestSecretVersionParams{EnvironmentID: env.ID, Key: c.Key})
+		if err != nil {
+			return secret, false, err
+		}
+		id.Version = latest + 1
+	}
+	encrypted, err := s.vault.EncryptValue(dek, id, *c.Value)
+	if err != nil {
+		return secret, false, err
+	}
+
+	if replaced {
+		secret, err = q.UpdateSecret(ctx, repository.UpdateSecretParams{
+			ID:             expired.ID,
+			EncryptedValue: encrypted,
+			Description:    c.Description,
+			UpdatedBy:      user,
+		})
+		if err == nil && secret.Version != id.Version {
+			err = errSecretsChanged
+		}
+		if err != nil {
+			return secret, false, err
+		}
+		return secret, true, recordSecretEvent(ctx, q, project, env, eventSecretUpdated, secret, user)
+	}
+
+	active := true
+	secret, err = q.CreateSecret(ctx, repository.CreateSecretParams{
+		EnvironmentID:  env.ID,
+		Key:            c.Key,
+		EncryptedValue: encrypted,
+		Description:    c.Description,
+		IsActive:       &active,
+		Version:        id.Version,
+		CreatedBy:      user,
+	})
+	if err != nil {
+		return secret, false, err
+	}
+	return secret, false, recordSecretEvent(ctx, q, project, env, eventSecretCreated, secret, user)
+}
+
+// expiredSecret returns the secret with key in env if it was deactivated
+// when it expired; found is false if there is none, or it is active
+func expiredSecret(ctx context.Context, q repository.Querier, envID uuid.UUID, key string) (secret repository.Secret, found bool, err error) {
+	secret, err = q.GetSecretByKeyIncludingInactive(ctx, repository.GetSecretByKeyIncludingInactiveParams{
+		EnvironmentID: envID,
+		Key:           key,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return secret, false, nil
+	}
+	if err != nil {
+		return secret, false, err
+	}
+	return secret, secret.IsActive != nil && !*secret.IsActive, nil
+}
+
+// writeSecretsError maps writeSecrets errors to HTTP responses
+func (s *Server) writeSecretsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSecretsChanged) {
+		utils.WriteError(w, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	s.writeStoreError(w, err, "environment not found")
+}
+
+// decryptSecret decrypts a stored secret into its public representation
+func (s *Server) decryptSecret(dek *crypto.DataKey, projectID uuid.UUID, secret repository.Secret) (secretResponse, error) {
+	id := vault.SecretIdentity{ProjectID: projectID, EnvironmentID: secret.EnvironmentID, Key: secret.Key, Version: secret.Version}
+	value, err := s.vault.DecryptValue(dek, id, secret.EncryptedValue)
+	if err != nil {
+		return secretResponse{}, err
+	}
+	return toSecretResponse(secret, value), nil
+}
+
+func toSecretResponse(secret repository.Secret, value string) secretResponse {
+	resp := secretResponse{
+		ID:          secret.ID,
+		Key:         secret.Key,
+		Value:       value,
+		Description: secret.Description,
+		Version:     secret.Version,
+		CreatedAt:   secret.CreatedAt,
+		UpdatedAt:   secret.UpdatedAt,
+	}
+	if secret.ExpiresAt.Valid {
+		resp.ExpiresAt = &secret.ExpiresAt.Time
+	}
+	if secret.RotateEvery.Valid {
+		due := addInterval(secret.ValueChangedAt, secret.RotateEvery)
+		resp.RotationDueAt = &due
+	}
+	return resp
+}
+
+// validateSecretKey returns a non-empty message if key is not a valid secret name
+func validateSecretKey(key string) string {
+	switch {
+	case key == "":
+		return "key is required"
+	case len(key) > maxSecretKeyLength:
+		return "key must be at most 255 characters"
+	case strings.ContainsAny(key, "= \t\r\n"):
+		return "key must not contain '=' or whitespace"
+	}
+	return ""
+}
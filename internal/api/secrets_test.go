@@ -0,0 +1,335 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/audit"
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// testEnv is a server seeded with one project and a "dev" environment
+type testEnv struct {
+	store     *repotest.MemStore
+	vault     *vault.Vault
+	accessLog *audit.Logger
+	handler   http.Handler
+	user      repository.User
+	token     string
+	project   repository.Project
+	env       repository.Environment
+}
+
+func newTestEnv(t *testing.T, opts ...Option) *testEnv {
+	t.Helper()
+
+	mk, err := crypto.GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("GenerateMasterKey failed: %v", err)
+	}
+	dek, err := crypto.GenerateDataKey()
+	if err != nil {
+		t.Fatalf("GenerateDataKey failed: %v", err)
+	}
+	encryptedDEK, err := crypto.EncryptDEK(dek, mk)
+	if err != nil {
+		t.Fatalf("EncryptDEK failed: %v", err)
+	}
+	keys, err := crypto.NewKeyRing(mk)
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+
+	store := repotest.NewMemStore()
+	user := store.AddUser(repository.User{Email: "dev@example.com"})
+	token, _, err := auth.IssueToken(t.Context(), store, auth.IssueParams{UserID: user.ID, Name: "test"})
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	orgID := uuid.New()
+	store.AddMember(repository.OrganizationMember{OrganizationID: orgID, UserID: user.ID, Role: repository.OrgRoleOwner})
+	project := store.AddProject(repository.Project{OrganizationID: orgID, Name: "api", EncryptedDek: encryptedDEK})
+	env := store.AddEnvironment(repository.Environment{ProjectID: project.ID, Name: "dev"})
+	accessLog := audit.NewLogger(store)
+	t.Cleanup(func() { _ = accessLog.Close(context.Background()) })
+	v := vault.New(keys)
+
+	return &testEnv{
+		store:     store,
+		vault:     v,
+		accessLog: accessLog,
+		handler:   NewServer(store, v, append(opts, WithAccessLog(accessLog))...).Routes(),
+		user:      user,
+		token:     token,
+		project:   project,
+		env:       env,
+	}
+}
+
+func (te *testEnv) do(t *testing.T, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer "+te.token)
+	rec := httptest.NewRecorder()
+	te.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// loginAs seeds a user with role in the project's organization and
+// switches the test client to a fresh token of theirs
+func (te *testEnv) loginAs(t *testing.T, role repository.OrgRole) repository.User {
+	t.Helper()
+
+	user := te.store.AddUser(repository.User{Email: string(role) + "@example.com"})
+	if role != "" {
+		te.store.AddMember(repository.OrganizationMember{OrganizationID: te.project.OrganizationID, UserID: user.ID, Role: role})
+	}
+	token, _, err := auth.IssueToken(t.Context(), te.store, auth.IssueParams{UserID: user.ID, Name: "test"})
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	te.token = token
+	return user
+}
+
+// accessLogs stops the access logger and returns everything it wrote.
+// Requests made afterwards are not logged.
+func (te *testEnv) accessLogs(t *testing.T) []repository.AccessLog {
+	t.Helper()
+
+	if err := te.accessLog.Close(t.Context()); err != nil {
+		t.Fatalf("Close access log: %v", err)
+	}
+	return te.store.AccessLogs()
+}
+
+func (te *testEnv) secretsPath() string {
+	return "/projects/" + te.project.ID.String() + "/environments/" + te.env.Name + "/secrets/"
+}
+
+func decodeData(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	var envelope struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if !envelope.Success {
+		t.Fatalf("Expected success envelope, got %s", envelope.Data)
+	}
+	if err := json.Unmarshal(envelope.Data, v); err != nil {
+		t.Fatalf("decode data: %v", err)
+	}
+}
+
+func TestSecretCRUD(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+
+	rec := te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "sk_test_123"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// Stored value must be encrypted
+	stored, err := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: te.env.ID, Key: "API_KEY"})
+	if err != nil {
+		t.Fatalf("GetSecretByKey failed: %v", err)
+	}
+	if stored.EncryptedValue == "sk_test_123" {
+		t.Fatalf("secret stored in plaintext")
+	}
+	if !stored.CreatedBy.Valid || stored.CreatedBy.Bytes != te.user.ID {
+		t.Errorf("Expected created_by to be the token owner")
+	}
+
+	rec = te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "sk_live_456"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = te.do(t, http.MethodGet, base+"API_KEY", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got secretResponse
+	decodeData(t, rec, &got)
+	if got.Value != "sk_live_456" || got.Version != 2 {
+		t.Errorf("Expected value sk_live_456 at version 2, got %q at %d", got.Value, got.Version)
+	}
+
+	rec = te.do(t, http.MethodGet, base, nil)
+	var list []secretResponse
+	decodeData(t, rec, &list)
+	if len(list) != 1 {
+		t.Fatalf("Expected 1 secret, got %d", len(list))
+	}
+
+	rec = te.do(t, http.MethodDelete, base+"API_KEY", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", rec.Code)
+	}
+
+	rec = te.do(t, http.MethodGet, base+"API_KEY", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestCreateSecretErrors(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+
+	tests := []struct {
+		name   string
+		path   string
+		body   any
+		status int
+	}{
+		{"Missing key", base, createSecretRequest{Value: "v"}, http.StatusBadRequest},
+		{"Key with equals", base, createSecretRequest{Key: "A=B", Value: "v"}, http.StatusBadRequest},
+		{"Unknown field", base, map[string]string{"key": "A", "val": "v"}, http.StatusBadRequest},
+		{"Bad project id", "/projects/nope/environments/dev/secrets/", createSecretRequest{Key: "A"}, http.StatusBadRequest},
+		{"Unknown environment", "/projects/" + te.project.ID.String() + "/environments/prod/secrets/", createSecretRequest{Key: "A"}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := te.do(t, http.MethodPost, tt.path, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	// Duplicate keys conflict
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "DUP", Value: "1"})
+	rec := te.do(t, http.MethodPost, base, createSecretRequest{Key: "DUP", Value: "2"})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate key, got %d", rec.Code)
+	}
+}
+
+func TestRecreateDeletedSecret(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "old"})
+	te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "older"})
+	deleted, _ := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: te.env.ID, Key: "API_KEY"})
+	if rec := te.do(t, http.MethodDelete, base+"API_KEY", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", rec.Code)
+	}
+
+	// The new secret continues the deleted one's versions
+	rec := te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "new"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("recreate: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var got secretResponse
+	decodeData(t, rec, &got)
+	if got.Value != "new" || got.Version != 3 {
+		t.Errorf("Expected value new at version 3, got %q at %d", got.Value, got.Version)
+	}
+	if h := te.store.History(got.ID); len(h) != 1 || *h[0].Version != 3 {
+		t.Errorf("Expected a history of its own starting at version 3, got %+v", h)
+	}
+
+	// So a value of the deleted secret does not decrypt as one of the new
+	created, _ := te.store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: te.env.ID, Key: "API_KEY"})
+	for _, h := range te.store.History(deleted.ID) {
+		if h.EncryptedValue == nil {
+			continue
+		}
+		if _, err := te.store.ResealSecret(t.Context(), repository.ResealSecretParams{
+			NewValue: *h.EncryptedValue,
+			ID:       created.ID,
+			OldValue: created.EncryptedValue,
+		}); err != nil {
+			t.Fatalf("ResealSecret failed: %v", err)
+		}
+		created.EncryptedValue = *h.EncryptedValue
+		if rec := te.do(t, http.MethodGet, base+"API_KEY", nil); rec.Code != http.StatusInternalServerError {
+			t.Errorf("get value of version %d of the deleted secret: expected 500, got %d: %s", *h.Version, rec.Code, rec.Body)
+		}
+	}
+
+	// Imports recreate deleted keys too
+	te.do(t, http.MethodDelete, base+"API_KEY", nil)
+	if rec := te.doRaw(t, http.MethodPost, base+"import", "API_KEY=imported\n"); rec.Code != http.StatusOK {
+		t.Fatalf("import: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if values := te.values(t); values["API_KEY"] != "imported" || len(values) != 1 {
+		t.Errorf("Expected only the imported secret, got %v", values)
+	}
+
+	// And so do change requests
+	te.protect(1)
+	old, err := te.store.CreateSecret(t.Context(), repository.CreateSecretParams{EnvironmentID: te.env.ID, Key: "API_KEY", EncryptedValue: "sealed", Version: 4})
+	if err != nil {
+		t.Fatalf("CreateSecret failed: %v", err)
+	}
+	if err := te.store.SoftDeleteSecret(t.Context(), repository.SoftDeleteSecretParams{ID: old.ID}); err != nil {
+		t.Fatalf("SoftDeleteSecret failed: %v", err)
+	}
+	rec = te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "proposed"})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("propose: expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var cr changeRequestResponse
+	decodeData(t, rec, &cr)
+	te.loginAs(t, repository.OrgRoleAdmin)
+	if rec := te.do(t, http.MethodPost, te.changeRequestsPath()+cr.ID.String()+"/approve", nil); rec.Code != http.StatusOK {
+		t.Fatalf("approve: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	decodeData(t, te.do(t, http.MethodGet, te.secretsPath()+"API_KEY", nil), &got)
+	if got.Value != "proposed" || got.Version != 5 {
+		t.Errorf("Expected value proposed at version 5, got %q at %d", got.Value, got.Version)
+	}
+}
+
+func TestSecretRoleEnforcement(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "v"})
+
+	te.loginAs(t, repository.OrgRoleViewer)
+	if rec := te.do(t, http.MethodGet, base+"API_KEY", nil); rec.Code != http.StatusOK {
+		t.Errorf("viewer read: expected 200, got %d", rec.Code)
+	}
+	if rec := te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "x"}); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer update: expected 403, got %d", rec.Code)
+	}
+
+	te.loginAs(t, repository.OrgRoleMember)
+	if rec := te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "x"}); rec.Code != http.StatusOK {
+		t.Errorf("member update: expected 200, got %d", rec.Code)
+	}
+
+	// Users outside the organization cannot tell the project exists
+	te.loginAs(t, "")
+	if rec := te.do(t, http.MethodGet, base+"API_KEY", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("non-member read: expected 404, got %d", rec.Code)
+	}
+}
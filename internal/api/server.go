@@ -0,0 +1,338 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/Now-Tiger/envhub/internal/audit"
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+	"github.com/Now-Tiger/envhub/internal/vault"
+	"github.com/Now-Tiger/envhub/pkg/database"
+)
+
+// Error codes returned in the failure envelope
+const (
+	CodeBadRequest = "bad_request"
+	CodeForbidden  = "forbidden"
+	CodeNotFound   = "not_found"
+	CodeConflict   = "conflict"
+	CodeGone       = "gone"
+	CodeInternal   = "internal_error"
+	CodeBadGateway = "bad_gateway"
+
+	CodeInvalidReference = "invalid_reference"
+)
+
+// PostgreSQL error code for unique_violation
+const pgUniqueViolation = "23505"
+
+// Server holds the dependencies shared by the versioned API handlers
+type Server struct {
+	store     repository.Store
+	vault     *vault.Vault
+	verifier  *auth.Verifier
+	accessLog *audit.Logger
+
+	// environments are created with every new project
+	environments []EnvironmentTemplate
+
+	// runStatements creates and drops the roles of dynamic secrets
+	runStatements StatementRunner
+}
+
+// Option configures optional Server dependencies
+type Option func(*Server)
+
+// WithVerifier enables login with IdP-issued JWTs alongside API tokens
+func WithVerifier(v *auth.Verifier) Option {
+	return func(s *Server) { s.verifier = v }
+}
+
+// WithAccessLog records every access to projects, environments, secrets
+// and change requests in access_logs through l
+func WithAccessLog(l *audit.Logger) Option {
+	return func(s *Server) { s.accessLog = l }
+}
+
+// WithEnvironmentTemplates replaces DefaultEnvironments as the environments
+// created with new projects. Templates must pass ValidateEnvironmentTemplates.
+func WithEnvironmentTemplates(templates []EnvironmentTemplate) Option {
+	return func(s *Server) { s.environments = templates }
+}
+
+// WithStatementRunner replaces database.RunStatements as the way dynamic
+// secrets reach their target servers
+func WithStatementRunner(run StatementRunner) Option {
+	return func(s *Server) { s.runStatements = run }
+}
+
+// NewServer creates a new API server
+func NewServer(store repository.Store, v *vault.Vault, opts ...Option) *Server {
+	s := &Server{
+		store:         store,
+		vault:         v,
+		environments:  DefaultEnvironments,
+		runStatements: database.RunStatements,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Routes returns the router for the versioned API.
+// It is meant to be mounted under /v1.
+func (s *Server) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(auth.Authenticate(s.store, s.verifier))
+	r.Use(s.logAccess)
+
+	r.Route("/tokens", func(r chi.Router) {
+		r.Get("/", s.listTokens)
+		r.Post("/", s.createToken)
+		r.Delete("/{tokenID}", s.revokeToken)
+	})
+
+	r.Route("/projects/{projectID}/environments/{envName}/secrets", func(r chi.Router) {
+		r.Get("/", s.listSecrets)
+		r.Post("/", s.createSecret)
+		r.Post("/import", s.importSecrets)
+		r.Get("/export", s.exportSecrets)
+		r.Get("/{key}", s.getSecret)
+		r.Put("/{key}", s.updateSecret)
+		r.Delete("/{key}", s.deleteSecret)
+		r.Get("/{key}/versions", s.listSecretVersions)
+		r.Get("/{key}/versions/{version}", s.getSecretVersion)
+		r.Post("/{key}/rollback", s.rollbackSecret)
+		r.Put("/{key}/lifetime", s.setSecretLifetime)
+	})
+
+	r.Route("/projects/{projectID}/environments/{envName}/dynamic-secrets", func(r chi.Router) {
+		r.Get("/", s.listDynamicSecrets)
+		r.Post("/", s.createDynamicSecret)
+		r.Delete("/{key}", s.deleteDynamicSecret)
+		r.Post("/{key}/credentials", s.issueCredentials)
+		r.Get("/{key}/leases", s.listLeases)
+		r.Delete("/{key}/leases/{leaseID}", s.revokeLease)
+	})
+
+	r.Route("/projects/{projectID}/environments/{envName}/change-requests", func(r chi.Router) {
+		r.Get("/", s.listChangeRequests)
+		r.Post("/", s.createChangeRequest)
+		r.Get("/{changeRequestID}", s.getChangeRequest)
+		r.Post("/{changeRequestID}/approve", s.approveChangeRequest)
+		r.Post("/{changeRequestID}/reject", s.rejectChangeRequest)
+		r.Post("/{changeRequestID}/cancel", s.cancelChangeRequest)
+	})
+
+	r.Get("/projects/{projectID}/environments", s.listEnvironments)
+	r.Post("/projects/{projectID}/environments", s.createEnvironment)
+	r.Put("/projects/{projectID}/environments/{envName}/parent", s.setEnvironmentParent)
+	r.Delete("/projects/{projectID}/environments/{envName}/parent", s.removeEnvironmentParent)
+	r.Get("/projects/{projectID}/environments/{envName}/diff", s.diffEnvironments)
+	r.Post("/projects/{projectID}/environments/{envName}/promote", s.promoteSecrets)
+
+	r.Post("/organizations/{orgID}/projects", s.createProject)
+	r.Post("/projects/{projectID}/clone", s.cloneProject)
+	r.Post("/projects/{projectID}/rotate-key", s.rotateProjectKey)
+	r.Get("/projects/{projectID}/secrets/due", s.listDueSecrets)
+
+	r.Route("/organizations/{orgID}/webhooks", func(r chi.Router) {
+		r.Get("/", s.listWebhooks)
+		r.Post("/", s.createWebhook)
+		r.Get("/{webhookID}", s.getWebhook)
+		r.Put("/{webhookID}", s.updateWebhook)
+		r.Delete("/{webhookID}", s.deleteWebhook)
+		r.Get("/{webhookID}/deliveries", s.listWebhookDeliveries)
+		r.Post("/{webhookID}/deliveries/{deliveryID}/redeliver", s.redeliverWebhook)
+	})
+
+	r.Route("/organizations/{orgID}/audit", func(r chi.Router) {
+		r.Get("/access-logs", s.searchAccessLogs)
+		r.Get("/access-logs/export", s.exportAccessLogs)
+		r.Get("/secret-history", s.searchSecretHistory)
+		r.Get("/secret-history/export", s.exportSecretHistory)
+	})
+
+	return r
+}
+
+// loadProject resolves the project named in the URL and checks that the
+// caller may perform action on it.
+// It writes an error response and returns ok=false otherwise.
+func (s *Server) loadProject(w http.ResponseWriter, r *http.Request, action policy.Action) (project repository.Project, ok bool) {
+	project, ok = s.findProject(w, r)
+	if !ok {
+		return project, false
+	}
+	if a, ok := accessActions[action]; ok {
+		setAccessTarget(r, resourceProject, project.ID, a)
+	}
+
+	target := auth.Target{
+		OrganizationID: project.OrganizationID,
+		ProjectID:      project.ID,
+	}
+	if !s.authorize(w, r, action, target) {
+		return project, false
+	}
+
+	return project, true
+}
+
+// findProject looks up the project named in the URL without authorizing
+func (s *Server) findProject(w http.ResponseWriter, r *http.Request) (repository.Project, bool) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "projectID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid project id")
+		return repository.Project{}, false
+	}
+
+	project, err := s.store.GetProjectByID(r.Context(), projectID)
+	if err != nil {
+		s.writeStoreError(w, err, "project not found")
+		return repository.Project{}, false
+	}
+	setAccessOrganization(r, project.OrganizationID)
+
+	return project, true
+}
+
+// loadEnvironment resolves the project and environment named in the URL and
+// checks that the caller may perform action there.
+// It writes an error response and returns ok=false otherwise.
+func (s *Server) loadEnvironment(w http.ResponseWriter, r *http.Request, action policy.Action) (project repository.Project, env repository.Environment, ok bool) {
+	project, ok = s.findProject(w, r)
+	if !ok {
+		return project, env, false
+	}
+
+	env, err := s.store.GetEnvironmentByName(r.Context(), repository.GetEnvironmentByNameParams{
+		ProjectID: project.ID,
+		Name:      chi.URLParam(r, "envName"),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "environment not found")
+		return project, env, false
+	}
+	if a, ok := accessActions[action]; ok {
+		setAccessTarget(r, resourceEnvironment, env.ID, a)
+	}
+
+	target := auth.Target{
+		OrganizationID: project.OrganizationID,
+		ProjectID:      project.ID,
+		Environment:    env.Name,
+	}
+	if !s.authorize(w, r, action, target) {
+		return project, env, false
+	}
+
+	return project, env, true
+}
+
+// authorize checks that the caller's token scopes and, for resources inside
+// an organization, the caller's role there permit action. It writes a 403
+// (or a 404 for non-members) and returns false if the request is not allowed.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, action policy.Action, target auth.Target) bool {
+	err := s.permit(r.Context(), action, target)
+	if err == nil {
+		return true
+	}
+	var denied *deniedError
+	if errors.As(err, &denied) {
+		utils.WriteError(w, denied.status, denied.code, denied.Error())
+		return false
+	}
+	// Non-members must not learn whether the resource exists
+	s.writeStoreError(w, err, "not found")
+	return false
+}
+
+// deniedError is an action the caller is not allowed to perform
+type deniedError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *deniedError) Error() string { return e.msg }
+
+// permit is authorize without the response. It returns a *deniedError if
+// the caller may not perform action, or the store's error if they are not
+// a member of the target's organization.
+func (s *Server) permit(ctx context.Context, action policy.Action, target auth.Target) error {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return &deniedError{http.StatusUnauthorized, auth.CodeUnauthorized, auth.ErrMissingToken.Error()}
+	}
+
+	scopeAction, resource := policy.Scope(action)
+	if !principal.Allows(scopeAction, resource, target) {
+		required := auth.Scope{Action: scopeAction, Resource: resource, Target: target}
+		// Name the narrowest scope that would have been sufficient
+		if required.ProjectID != uuid.Nil {
+			required.OrganizationID = uuid.Nil
+		}
+		return &deniedError{http.StatusForbidden, CodeForbidden, "token is missing required scope " + required.String()}
+	}
+
+	if target.OrganizationID == uuid.Nil {
+		return nil
+	}
+
+	member, err := s.store.GetOrganizationMember(ctx, repository.GetOrganizationMemberParams{
+		OrganizationID: target.OrganizationID,
+		UserID:         principal.UserID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := policy.Authorize(member.Role, action); err != nil {
+		return &deniedError{http.StatusForbidden, CodeForbidden, fmt.Sprintf("role %s is not allowed to %s", member.Role, action)}
+	}
+
+	return nil
+}
+
+// writeStoreError maps repository errors to HTTP responses
+func (s *Server) writeStoreError(w http.ResponseWriter, err error, notFoundMsg string) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		utils.WriteError(w, http.StatusNotFound, CodeNotFound, notFoundMsg)
+	case isUniqueViolation(err):
+		utils.WriteError(w, http.StatusConflict, CodeConflict, "resource already exists")
+	case errors.Is(err, vault.ErrDataKeyRotated):
+		utils.WriteError(w, http.StatusConflict, CodeConflict, "project key was rotated, retry the request")
+	case errors.Is(err, errSecretsChanged):
+		utils.WriteError(w, http.StatusConflict, CodeConflict, err.Error())
+	default:
+		s.writeInternalError(w, err)
+	}
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique_violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// writeInternalError logs err and writes a generic 500 response
+// so internal details never leak to clients
+func (s *Server) writeInternalError(w http.ResponseWriter, err error) {
+	log.Printf("api: internal error: %v", err)
+	utils.WriteError(w, http.StatusInternalServerError, CodeInternal, "internal server error")
+}
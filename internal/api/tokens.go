@@ -0,0 +1,201 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+)
+
+// maxTokenNameLength matches api_tokens.name VARCHAR(255)
+const maxTokenNameLength = 255
+
+type createTokenRequest struct {
+	Name           string     `json:"name"`
+	Scopes         []string   `json:"scopes"`
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+// tokenResponse never includes the token hash
+type tokenResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	Name           string     `json:"name"`
+	Scopes         []string   `json:"scopes"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	UsageCount     int32      `json:"usage_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// createTokenResponse carries the plaintext token, shown only once
+type createTokenResponse struct {
+	tokenResponse
+	Token string `json:"token"`
+}
+
+// createToken mints a new API token for the caller
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !s.authorize(w, r, policy.TokenCreate, auth.Target{}) {
+		return
+	}
+
+	var req createTokenRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	switch {
+	case req.Name == "":
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "name is required")
+		return
+	case len(req.Name) > maxTokenNameLength:
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "name must be at most 255 characters")
+		return
+	case req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()):
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	scopes, err := auth.ParseScopes(req.Scopes)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if !principal.CanDelegate(scopes) {
+		utils.WriteError(w, http.StatusForbidden, CodeForbidden, "requested scopes exceed the scopes of the calling token")
+		return
+	}
+	if !principal.CanDelegateUntil(req.ExpiresAt) {
+		utils.WriteError(w, http.StatusForbidden, CodeForbidden, "expires_at must not be later than the expiry of the calling token")
+		return
+	}
+
+	// Organization-bound tokens can only mint tokens for the same organization
+	if principal.OrganizationID != uuid.Nil {
+		if req.OrganizationID != nil && *req.OrganizationID != principal.OrganizationID {
+			utils.WriteError(w, http.StatusForbidden, CodeForbidden, "token is bound to a different organization")
+			return
+		}
+		req.OrganizationID = &principal.OrganizationID
+	}
+
+	params := auth.IssueParams{
+		UserID:    principal.UserID,
+		Name:      req.Name,
+		ExpiresAt: req.ExpiresAt,
+	}
+	for _, scope := range scopes {
+		params.Scopes = append(params.Scopes, scope.String())
+	}
+
+	if req.OrganizationID != nil {
+		if !s.authorize(w, r, policy.TokenCreate, auth.Target{OrganizationID: *req.OrganizationID}) {
+			return
+		}
+		params.OrganizationID = *req.OrganizationID
+	}
+
+	plaintext, token, err := auth.IssueToken(r.Context(), s.store, params)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, createTokenResponse{
+		tokenResponse: toTokenResponse(token),
+		Token:         plaintext,
+	})
+}
+
+// listTokens returns the caller's active tokens
+func (s *Server) listTokens(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !s.authorize(w, r, policy.TokenRead, auth.Target{}) {
+		return
+	}
+
+	tokens, err := s.store.ListUserAPITokens(r.Context(), principal.UserID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := make([]tokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		resp = append(resp, toTokenResponse(token))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// revokeToken revokes one of the caller's tokens
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !s.authorize(w, r, policy.TokenRevoke, auth.Target{}) {
+		return
+	}
+
+	tokenID, err := uuid.Parse(chi.URLParam(r, "tokenID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid token id")
+		return
+	}
+
+	err = s.store.ExecTx(r.Context(), func(q repository.Querier) error {
+		tokens, err := q.ListUserAPITokens(r.Context(), principal.UserID)
+		if err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			if token.ID == tokenID {
+				return q.RevokeAPIToken(r.Context(), tokenID)
+			}
+		}
+		return pgx.ErrNoRows
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "token not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toTokenResponse(token repository.ApiToken) tokenResponse {
+	resp := tokenResponse{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+	}
+	if resp.Scopes == nil {
+		resp.Scopes = []string{}
+	}
+	if token.OrganizationID.Valid {
+		orgID := uuid.UUID(token.OrganizationID.Bytes)
+		resp.OrganizationID = &orgID
+	}
+	if token.ExpiresAt.Valid {
+		resp.ExpiresAt = &token.ExpiresAt.Time
+	}
+	if token.LastUsedAt.Valid {
+		resp.LastUsedAt = &token.LastUsedAt.Time
+	}
+	if token.UsageCount != nil {
+		resp.UsageCount = *token.UsageCount
+	}
+	return resp
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+func TestTokenLifecycle(t *testing.T) {
+	te := newTestEnv(t)
+
+	rec := te.do(t, http.MethodPost, "/tokens/", createTokenRequest{Name: "CI/CD Pipeline"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created createTokenResponse
+	decodeData(t, rec, &created)
+	if created.Token == "" {
+		t.Fatalf("Expected plaintext token in create response")
+	}
+
+	// The new token authenticates on its own
+	te.token = created.Token
+	rec = te.do(t, http.MethodGet, "/tokens/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if body := rec.Body.String(); strings.Contains(body, created.Token) || strings.Contains(body, "token_hash") {
+		t.Fatalf("list response leaked token material: %s", body)
+	}
+
+	rec = te.do(t, http.MethodDelete, "/tokens/"+created.ID.String(), nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: expected 204, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = te.do(t, http.MethodGet, "/tokens/", nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked token to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestCreateTokenValidation(t *testing.T) {
+	te := newTestEnv(t)
+	past := time.Now().Add(-time.Hour)
+	otherOrg := uuid.New()
+
+	tests := []struct {
+		name   string
+		body   createTokenRequest
+		status int
+	}{
+		{"Missing name", createTokenRequest{}, http.StatusBadRequest},
+		{"Expired", createTokenRequest{Name: "old", ExpiresAt: &past}, http.StatusBadRequest},
+		{"Not a member", createTokenRequest{Name: "org", OrganizationID: &otherOrg}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := te.do(t, http.MethodPost, "/tokens/", tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	te.store.AddMember(repository.OrganizationMember{OrganizationID: otherOrg, UserID: te.user.ID, Role: repository.OrgRoleMember})
+	rec := te.do(t, http.MethodPost, "/tokens/", createTokenRequest{Name: "org", OrganizationID: &otherOrg})
+	if rec.Code != http.StatusCreated {
+		t.Errorf("Expected member to mint org token, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestScopedTokenEnforcement(t *testing.T) {
+	te := newTestEnv(t)
+	base := te.secretsPath()
+	te.do(t, http.MethodPost, base, createSecretRequest{Key: "API_KEY", Value: "v"})
+
+	scope := "read:secrets:project/" + te.project.ID.String() + "/env/dev"
+	rec := te.do(t, http.MethodPost, "/tokens/", createTokenRequest{Name: "ci", Scopes: []string{scope}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created createTokenResponse
+	decodeData(t, rec, &created)
+	te.token = created.Token
+
+	if rec := te.do(t, http.MethodGet, base+"API_KEY", nil); rec.Code != http.StatusOK {
+		t.Errorf("read: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = te.do(t, http.MethodPut, base+"API_KEY", updateSecretRequest{Value: "x"})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("write: expected 403, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "write:secrets:project/"+te.project.ID.String()+"/env/dev") {
+		t.Errorf("Expected 403 to name the missing scope, got %s", rec.Body)
+	}
+
+	if rec := te.do(t, http.MethodGet, "/tokens/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("list tokens: expected 403, got %d", rec.Code)
+	}
+}
+
+func TestCreateTokenCappedByCallerExpiry(t *testing.T) {
+	te := newTestEnv(t)
+
+	expiry := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	rec := te.do(t, http.MethodPost, "/tokens/", createTokenRequest{Name: "ci", ExpiresAt: &expiry})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created createTokenResponse
+	decodeData(t, rec, &created)
+	te.token = created.Token
+
+	later := expiry.Add(time.Hour)
+	earlier := expiry.Add(-time.Hour)
+	tests := []struct {
+		name   string
+		req    createTokenRequest
+		status int
+	}{
+		{"No expiry", createTokenRequest{Name: "forever"}, http.StatusForbidden},
+		{"Later expiry", createTokenRequest{Name: "later", ExpiresAt: &later}, http.StatusForbidden},
+		{"Same expiry", createTokenRequest{Name: "same", ExpiresAt: &expiry}, http.StatusCreated},
+		{"Earlier expiry", createTokenRequest{Name: "earlier", ExpiresAt: &earlier}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := te.do(t, http.MethodPost, "/tokens/", tt.req); rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestCreateTokenRejectsInvalidScopes(t *testing.T) {
+	te := newTestEnv(t)
+
+	rec := te.do(t, http.MethodPost, "/tokens/", createTokenRequest{Name: "bad", Scopes: []string{"admin:everything"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,402 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/vault"
+)
+
+// Defaults for a WebhookSender
+const (
+	DefaultWebhookInterval  = 10 * time.Second
+	DefaultWebhookBatchSize = 20
+)
+
+// Retries of failed deliveries wait webhookRetryDelay, doubling up to
+// maxWebhookRetryDelay, until maxWebhookAttempts have failed
+const (
+	webhookRetryDelay    = 30 * time.Second
+	maxWebhookRetryDelay = 6 * time.Hour
+	maxWebhookAttempts   = 12
+)
+
+// webhookTimeout bounds each delivery attempt
+const webhookTimeout = 10 * time.Second
+
+// webhookUserAgent is sent with every delivery
+const webhookUserAgent = "envhub-webhooks"
+
+// Headers of webhook deliveries. The signature is "sha256=" and the hex
+// HMAC-SHA256 of the body, keyed with the webhook's secret.
+const (
+	headerWebhookEvent     = "X-Envhub-Event"
+	headerWebhookDelivery  = "X-Envhub-Delivery"
+	headerWebhookSignature = "X-Envhub-Signature-256"
+)
+
+// errWebhookAddress is returned when a webhook URL resolves to an address
+// the server must not be made to call: loopback, private, link-local (which
+// includes cloud metadata endpoints) and other non-public ranges
+var errWebhookAddress = errors.New("destination address not allowed")
+
+// blockedWebhookPrefixes are non-public ranges that netip does not classify
+// as loopback, private, link-local, multicast or unspecified
+var blockedWebhookPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// webhookPayload is the body POSTed to webhooks. ID is the event's, so
+// receivers can tell retries and redeliveries of an event apart from new
+// events.
+type webhookPayload struct {
+	ID             uuid.UUID       `json:"id"`
+	Type           string          `json:"type"`
+	CreatedAt      time.Time       `json:"created_at"`
+	OrganizationID uuid.UUID       `json:"organization_id"`
+	ProjectID      *uuid.UUID      `json:"project_id,omitempty"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// WebhookSender delivers the events outbox to webhooks. Each run first
+// gives every new event a delivery per matching webhook, then POSTs the
+// deliveries that are due. Events are delivered at least once: a delivery
+// that succeeds but cannot be recorded is sent again.
+type WebhookSender struct {
+	store      repository.Store
+	vault      *vault.Vault
+	client     *http.Client
+	batchSize  int
+	retryDelay time.Duration
+}
+
+// WebhookSenderOption configures a WebhookSender
+type WebhookSenderOption func(*WebhookSender)
+
+// WithWebhookBatchSize sets the most events or deliveries handled in one
+// transaction
+func WithWebhookBatchSize(n int) WebhookSenderOption {
+	return func(s *WebhookSender) { s.batchSize = n }
+}
+
+// WithWebhookClient replaces the client deliveries are sent with, which
+// does not follow redirects and refuses non-public addresses
+func WithWebhookClient(c *http.Client) WebhookSenderOption {
+	return func(s *WebhookSender) { s.client = c }
+}
+
+// WithWebhookRetryDelay sets how long the first retry of a failed delivery
+// waits
+func WithWebhookRetryDelay(d time.Duration) WebhookSenderOption {
+	return func(s *WebhookSender) { s.retryDelay = d }
+}
+
+// NewWebhookSender creates a WebhookSender for the events in store, whose
+// webhook secrets v unwraps
+func NewWebhookSender(store repository.Store, v *vault.Vault, opts ...WebhookSenderOption) *WebhookSender {
+	s := &WebhookSender{
+		store:      store,
+		vault:      v,
+		client:     newWebhookClient(),
+		batchSize:  DefaultWebhookBatchSize,
+		retryDelay: webhookRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// newWebhookClient returns the client deliveries are sent with by default.
+// It ignores proxy settings and checks every address it connects to once
+// DNS has resolved it, so a public name pointing at an internal address is
+// refused as well.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: checkWebhookAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkWebhookAddress is a net.Dialer Control hook that returns
+// errWebhookAddress for addresses that are not public
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(ip) {
+		return fmt.Errorf("%w: %s", errWebhookAddress, ip)
+	}
+	return nil
+}
+
+// publicAddr reports whether ip is a public unicast address
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedWebhookPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Send dispatches new events and attempts every due delivery, and returns
+// how many were delivered
+func (s *WebhookSender) Send(ctx context.Context) (int, error) {
+	if _, err := runBatches(ctx, s.dispatchBatch); err != nil {
+		return 0, fmt.Errorf("deliver webhooks: %w", err)
+	}
+	n, err := runBatches(ctx, s.deliverBatch)
+	if err != nil {
+		return n, fmt.Errorf("deliver webhooks: %w", err)
+	}
+	return n, nil
+}
+
+// Run sends every interval until ctx is done
+func (s *WebhookSender) Run(ctx context.Context, interval time.Duration) {
+	runPeriodically(ctx, interval, s.Send)
+}
+
+// dispatchBatch creates the deliveries of the oldest undispatched events in
+// one transaction and returns how many events it dispatched. more reports
+// whether a full batch was dispatched.
+func (s *WebhookSender) dispatchBatch(ctx context.Context) (n int, more bool, err error) {
+	err = s.store.ExecTx(ctx, func(q repository.Querier) error {
+		n, more = 0, false
+		locked, err := q.TryLockWebhookDeliveries(ctx)
+		if err != nil || !locked {
+			return err
+		}
+
+		events, err := q.ListUndispatchedEvents(ctx, int32(s.batchSize))
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			webhooks, err := q.ListWebhooksForEvent(ctx, repository.ListWebhooksForEventParams{
+				OrganizationID: e.OrganizationID,
+				ProjectID:      e.ProjectID,
+			})
+			if err != nil {
+				return err
+			}
+			for _, h := range webhooks {
+				if !matchEvent(h.Events, e.Type) {
+					continue
+				}
+				if _, err := q.CreateWebhookDelivery(ctx, repository.CreateWebhookDeliveryParams{
+					WebhookID: h.ID,
+					EventID:   e.ID,
+				}); err != nil {
+					return err
+				}
+			}
+			if err := q.MarkEventDispatched(ctx, e.ID); err != nil {
+				return err
+			}
+		}
+		n, more = len(events), len(events) == s.batchSize
+		return nil
+	})
+	return n, more, err
+}
+
+// deliverBatch attempts the deliveries that are due first. They are
+// claimed in one transaction and POSTed once it has committed, so no
+// connection or lock is held while receivers respond, and the results are
+// recorded in a second transaction. more reports whether a full batch was
+// delivered; failures end the run, so an unreachable receiver does not
+// hold up the others for long.
+func (s *WebhookSender) deliverBatch(ctx context.Context) (n int, more bool, err error) {
+	rows, err := s.claimBatch(ctx)
+	if err != nil || len(rows) == 0 {
+		return 0, false, err
+	}
+
+	var delivered, failed int
+	attempts := make([]repository.RecordWebhookDeliveryAttemptParams, 0, len(rows))
+	for _, row := range rows {
+		status, err := s.post(ctx, row)
+		params := repository.RecordWebhookDeliveryAttemptParams{
+			ID:            row.ID,
+			Status:        repository.WebhookDeliveryStatusDelivered,
+			NextAttemptAt: time.Now(),
+		}
+		if status != 0 {
+			code := int32(status)
+			params.ResponseStatus = &code
+		}
+		if err != nil {
+			failed++
+			log.Printf("api: delivery %s of event %s to webhook %s: %v", row.ID, row.EventID, row.WebhookID, err)
+			msg := deliveryError(status, err)
+			params.LastError = &msg
+			params.Status, params.NextAttemptAt = s.retry(row.Attempts + 1)
+		} else {
+			delivered++
+		}
+		attempts = append(attempts, params)
+	}
+
+	err = s.store.ExecTx(ctx, func(q repository.Querier) error {
+		for _, params := range attempts {
+			if err := q.RecordWebhookDeliveryAttempt(ctx, params); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	log.Printf("api: delivered %d webhooks (%d failed)", delivered, failed)
+	return delivered, failed == 0 && delivered == s.batchSize, nil
+}
+
+// claimBatch lists the deliveries that are due first and holds them back
+// from other senders for as long as attempting all of them may take
+func (s *WebhookSender) claimBatch(ctx context.Context) ([]repository.ListDueWebhookDeliveriesRow, error) {
+	var rows []repository.ListDueWebhookDeliveriesRow
+	err := s.store.ExecTx(ctx, func(q repository.Querier) error {
+		rows = nil
+		locked, err := q.TryLockWebhookDeliveries(ctx)
+		if err != nil || !locked {
+			return err
+		}
+
+		if rows, err = q.ListDueWebhookDeliveries(ctx, int32(s.batchSize)); err != nil {
+			return err
+		}
+		until := time.Now().Add(time.Duration(len(rows)+1) * webhookTimeout)
+		for _, row := range rows {
+			if err := q.ClaimWebhookDelivery(ctx, repository.ClaimWebhookDeliveryParams{
+				ClaimedUntil: until,
+				ID:           row.ID,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// deliveryError is the error kept for a failed attempt. What the receiver
+// or the network returned is only logged, so the delivery log does not tell
+// webhook owners more about what answers at their URL than its status.
+func deliveryError(status int, err error) string {
+	var netErr net.Error
+	switch {
+	case status != 0:
+		return fmt.Sprintf("unexpected response %d", status)
+	case errors.Is(err, errWebhookAddress):
+		return errWebhookAddress.Error()
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	default:
+		return "connection failed"
+	}
+}
+
+// retry returns the status of a delivery after its attempts-th attempt
+// failed and when it is next attempted
+func (s *WebhookSender) retry(attempts int32) (repository.WebhookDeliveryStatus, time.Time) {
+	if attempts >= maxWebhookAttempts {
+		return repository.WebhookDeliveryStatusFailed, time.Now()
+	}
+	delay := s.retryDelay
+	for i := int32(1); i < attempts && delay < maxWebhookRetryDelay; i++ {
+		delay *= 2
+	}
+	return repository.WebhookDeliveryStatusPending, time.Now().Add(min(delay, maxWebhookRetryDelay))
+}
+
+// post sends a delivery and returns the response status, if there was a
+// response
+func (s *WebhookSender) post(ctx context.Context, row repository.ListDueWebhookDeliveriesRow) (int, error) {
+	secret, err := s.vault.WebhookSecret(row.EncryptedSecret)
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(webhookPayload{
+		ID:             row.EventID,
+		Type:           row.EventType,
+		CreatedAt:      row.EventCreatedAt,
+		OrganizationID: row.OrganizationID,
+		ProjectID:      uuidPtr(row.ProjectID),
+		Data:           row.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, row.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set(headerWebhookEvent, row.EventType)
+	req.Header.Set(headerWebhookDelivery, row.ID.String())
+	req.Header.Set(headerWebhookSignature, signWebhook(secret, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the signature header of body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+func (lockedQuerier) TryLockWebhookDeliveries(context.Context) (bool, error) {
+	return false, nil
+}
+
+func (lockedQuerier) ListUndispatchedEvents(context.Context, int32) ([]repository.Event, error) {
+	return nil, errors.New("listed without the lock")
+}
+
+// receiver is a webhook endpoint that records what it is sent
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T) *receiver {
+	t.Helper()
+
+	rcv := &receiver{status: http.StatusOK}
+	rcv.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, body)
+		w.WriteHeader(rcv.status)
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *receiver) respond(status int) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.status = status
+}
+
+// payloads decodes the bodies received so far
+func (rcv *receiver) payloads(t *testing.T) []webhookPayload {
+	t.Helper()
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	payloads := make([]webhookPayload, len(rcv.bodies))
+	for i, body := range rcv.bodies {
+		if err := json.Unmarshal(body, &payloads[i]); err != nil {
+			t.Fatalf("Invalid webhook body %s: %v", body, err)
+		}
+	}
+	return payloads
+}
+
+func (te *testEnv) sender(rcv *receiver, opts ...WebhookSenderOption) *WebhookSender {
+	return NewWebhookSender(te.store, te.vault, append([]WebhookSenderOption{WithWebhookClient(rcv.Client())}, opts...)...)
+}
+
+func TestWebhookSenderDelivers(t *testing.T) {
+	te := newTestEnv(t)
+	rcv := newReceiver(t)
+	hook := te.createWebhook(t, createWebhookRequest{URL: rcv.URL + "/hooks", Events: []string{"secret.*"}})
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "s3cr3t"})
+
+	if n, err := te.sender(rcv).Send(t.Context()); err != nil || n != 1 {
+		t.Fatalf("Expected 1 delivery, got %d (%v)", n, err)
+	}
+
+	req, body := rcv.requests[0], rcv.bodies[0]
+	if got, want := req.Header.Get(headerWebhookSignature), signWebhook(hook.Secret, body); got != want {
+		t.Errorf("Expected signature %s, got %s", want, got)
+	}
+	if req.Header.Get(headerWebhookEvent) != eventSecretCreated || req.Header.Get("User-Agent") != webhookUserAgent || req.URL.Path != "/hooks" {
+		t.Errorf("Unexpected request headers %v to %s", req.Header, req.URL)
+	}
+	if strings.Contains(string(body), "s3cr3t") {
+		t.Errorf("Expected no secret value in webhook body %s", body)
+	}
+	payload := rcv.payloads(t)[0]
+	var data secretEvent
+	if err := json.Unmarshal(payload.Data, &data); err != nil || data.Key != "API_KEY" || *payload.ProjectID != te.project.ID {
+		t.Errorf("Unexpected payload %s (%v)", body, err)
+	}
+
+	rec := te.do(t, http.MethodGet, te.webhooksPath()+hook.ID.String()+"/deliveries", nil)
+	var log []deliveryResponse
+	decodeData(t, rec, &log)
+	if len(log) != 1 || log[0].Status != repository.WebhookDeliveryStatusDelivered || *log[0].ResponseStatus != http.StatusOK ||
+		log[0].EventType != eventSecretCreated || log[0].ID.String() != req.Header.Get(headerWebhookDelivery) {
+		t.Errorf("Unexpected delivery log %+v", log)
+	}
+
+	// Nothing is sent twice
+	if n, err := te.sender(rcv).Send(t.Context()); err != nil || n != 0 {
+		t.Errorf("Expected nothing left to deliver, got %d (%v)", n, err)
+	}
+}
+
+func TestWebhookSenderFilters(t *testing.T) {
+	te := newTestEnv(t)
+	rcv := newReceiver(t)
+	other := te.store.AddProject(repository.Project{OrganizationID: te.project.OrganizationID, Name: "web"})
+	envs := te.createWebhook(t, createWebhookRequest{URL: rcv.URL, Events: []string{"environment.*"}})
+	web := te.createWebhook(t, createWebhookRequest{URL: rcv.URL, Events: []string{"*"}, ProjectID: &other.ID})
+	paused := te.createWebhook(t, createWebhookRequest{URL: rcv.URL, Events: []string{"*"}})
+	te.do(t, http.MethodPut, te.webhooksPath()+paused.ID.String(), updateWebhookRequest{URL: rcv.URL, Events: []string{"*"}, Active: new(bool)})
+
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "s3cr3t"})
+	te.do(t, http.MethodPost, te.environmentsPath(), createEnvironmentRequest{EnvironmentTemplate: EnvironmentTemplate{Name: "staging"}})
+
+	if n, err := te.sender(rcv).Send(t.Context()); err != nil || n != 1 {
+		t.Fatalf("Expected 1 delivery, got %d (%v)", n, err)
+	}
+	if p := rcv.payloads(t); p[0].Type != eventEnvironmentCreated {
+		t.Errorf("Expected only environment.created, got %+v", p)
+	}
+
+	counts := make(map[string]int)
+	for _, d := range te.store.Deliveries() {
+		switch d.WebhookID {
+		case envs.ID:
+			counts["envs"]++
+		case web.ID:
+			counts["web"]++
+		case paused.ID:
+			counts["paused"]++
+		}
+	}
+	// Paused webhooks miss the events in between
+	if counts["envs"] != 1 || counts["web"] != 0 || counts["paused"] != 0 {
+		t.Errorf("Unexpected deliveries per webhook %v", counts)
+	}
+}
+
+func TestWebhookSenderRetries(t *testing.T) {
+	te := newTestEnv(t)
+	rcv := newReceiver(t)
+	rcv.respond(http.StatusServiceUnavailable)
+	hook := te.createWebhook(t, createWebhookRequest{URL: rcv.URL, Events: []string{"*"}})
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "s3cr3t"})
+
+	// Every retry is due at once, until the delivery gives up
+	retrying := te.sender(rcv, WithWebhookRetryDelay(0))
+	if n, err := retrying.Send(t.Context()); err != nil || n != 0 {
+		t.Fatalf("Expected nothing delivered, got %d (%v)", n, err)
+	}
+	first := te.store.Deliveries()[0]
+	if first.Status != repository.WebhookDeliveryStatusPending || first.Attempts != 1 || *first.ResponseStatus != http.StatusServiceUnavailable ||
+		first.LastError == nil || *first.LastError != "unexpected response 503" {
+		t.Fatalf("Expected the attempt to be recorded, got %+v", first)
+	}
+	for range maxWebhookAttempts - 1 {
+		if _, err := retrying.Send(t.Context()); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if d, _ := te.store.Delivery(first.ID); d.Status != repository.WebhookDeliveryStatusFailed {
+		t.Fatalf("Expected the delivery to fail after %d attempts, got %+v", maxWebhookAttempts, d)
+	}
+
+	// A redelivery starts over as a new delivery
+	rcv.respond(http.StatusNoContent)
+	rec := te.do(t, http.MethodPost, te.webhooksPath()+hook.ID.String()+"/deliveries/"+first.ID.String()+"/redeliver", nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("redeliver: expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var redelivery deliveryResponse
+	decodeData(t, rec, &redelivery)
+	if redelivery.Status != repository.WebhookDeliveryStatusPending || *redelivery.RedeliveryOf != first.ID || *redelivery.CreatedBy != te.user.ID {
+		t.Errorf("Unexpected redelivery %+v", redelivery)
+	}
+	if n, err := te.sender(rcv).Send(t.Context()); err != nil || n != 1 {
+		t.Fatalf("Expected the redelivery to be delivered, got %d (%v)", n, err)
+	}
+	payloads := rcv.payloads(t)
+	if last := payloads[len(payloads)-1]; last.ID != first.EventID {
+		t.Errorf("Expected the same event redelivered, got %+v", last)
+	}
+
+	rec = te.do(t, http.MethodGet, te.webhooksPath()+hook.ID.String()+"/deliveries?status=failed", nil)
+	var failed []deliveryResponse
+	decodeData(t, rec, &failed)
+	if len(failed) != 1 || failed[0].ID != first.ID {
+		t.Errorf("Expected the failed delivery in the log, got %+v", failed)
+	}
+	if rec := te.do(t, http.MethodGet, te.webhooksPath()+hook.ID.String()+"/deliveries?status=lost", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid status: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestWebhookRetryBackoff(t *testing.T) {
+	s := NewWebhookSender(nil, nil)
+	tests := []struct {
+		attempts int32
+		delay    time.Duration
+	}{
+		{1, webhookRetryDelay},
+		{2, 2 * webhookRetryDelay},
+		{3, 4 * webhookRetryDelay},
+		{maxWebhookAttempts - 1, maxWebhookRetryDelay},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		status, next := s.retry(tt.attempts)
+		if status != repository.WebhookDeliveryStatusPending || next.Before(before.Add(tt.delay)) || next.After(time.Now().Add(tt.delay)) {
+			t.Errorf("retry(%d) = %s at %s, want a retry in %s", tt.attempts, status, next, tt.delay)
+		}
+	}
+	if status, _ := s.retry(maxWebhookAttempts); status != repository.WebhookDeliveryStatusFailed {
+		t.Errorf("Expected delivery to fail after %d attempts, got %s", maxWebhookAttempts, status)
+	}
+}
+
+func TestWebhookSenderSkipsWhileLocked(t *testing.T) {
+	te := newTestEnv(t)
+	rcv := newReceiver(t)
+	te.createWebhook(t, createWebhookRequest{URL: rcv.URL, Events: []string{"*"}})
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "s3cr3t"})
+
+	n, err := NewWebhookSender(lockedStore{te.store}, te.vault, WithWebhookClient(rcv.Client())).Send(t.Context())
+	if err != nil || n != 0 {
+		t.Errorf("Expected nothing sent while another replica holds the lock, got %d (%v)", n, err)
+	}
+	if len(te.store.Deliveries()) != 0 || len(rcv.requests) != 0 {
+		t.Errorf("Expected the event to stay in the outbox")
+	}
+}
+
+// txStore reports whether one of its transactions is running
+type txStore struct {
+	repository.Store
+	inTx atomic.Bool
+}
+
+func (s *txStore) ExecTx(ctx context.Context, fn func(q repository.Querier) error) error {
+	s.inTx.Store(true)
+	defer s.inTx.Store(false)
+	return s.Store.ExecTx(ctx, fn)
+}
+
+func TestWebhookSenderPostsOutsideTransactions(t *testing.T) {
+	te := newTestEnv(t)
+	store := &txStore{Store: te.store}
+	var inTx bool
+	var concurrent int
+	rcv := &receiver{}
+	rcv.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inTx = store.inTx.Load()
+		// Another replica running meanwhile finds the delivery claimed
+		concurrent, _ = NewWebhookSender(te.store, te.vault, WithWebhookClient(rcv.Client())).Send(r.Context())
+	}))
+	t.Cleanup(rcv.Close)
+	te.createWebhook(t, createWebhookRequest{URL: rcv.URL, Events: []string{"*"}})
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "s3cr3t"})
+
+	n, err := NewWebhookSender(store, te.vault, WithWebhookClient(rcv.Client())).Send(t.Context())
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 delivery, got %d (%v)", n, err)
+	}
+	if inTx {
+		t.Errorf("Expected the delivery to be POSTed outside a transaction")
+	}
+	if concurrent != 0 {
+		t.Errorf("Expected a concurrent sender to skip the claimed delivery, it sent %d", concurrent)
+	}
+	if d := te.store.Deliveries()[0]; d.Status != repository.WebhookDeliveryStatusDelivered || d.Attempts != 1 {
+		t.Errorf("Expected one recorded attempt, got %+v", d)
+	}
+}
+
+func TestWebhookSenderRefusesInternalAddresses(t *testing.T) {
+	te := newTestEnv(t)
+	rcv := newReceiver(t)
+	te.createWebhook(t, createWebhookRequest{URL: rcv.URL, Events: []string{"*"}})
+	te.do(t, http.MethodPost, te.secretsPath(), createSecretRequest{Key: "API_KEY", Value: "s3cr3t"})
+
+	// The default client, unlike the receiver's, checks where it connects
+	if n, err := NewWebhookSender(te.store, te.vault).Send(t.Context()); err != nil || n != 0 {
+		t.Fatalf("Expected nothing delivered, got %d (%v)", n, err)
+	}
+	if len(rcv.requests) != 0 {
+		t.Errorf("Expected no request to reach %s", rcv.URL)
+	}
+	d := te.store.Deliveries()[0]
+	if d.LastError == nil || *d.LastError != errWebhookAddress.Error() || d.ResponseStatus != nil {
+		t.Errorf("Expected the refused address to be recorded, got %+v", d)
+	}
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("publicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+
+	if err := checkWebhookAddress("tcp4", "169.254.169.254:80", nil); !errors.Is(err, errWebhookAddress) {
+		t.Errorf("Expected errWebhookAddress for the metadata endpoint, got %v", err)
+	}
+	if err := checkWebhookAddress("tcp6", "[2606:2800:220:1:248:1893:25c8:1946]:443", nil); err != nil {
+		t.Errorf("Expected a public address to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/policy"
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+)
+
+// A webhook subscribes a URL to events of an organization or one of its
+// projects. The WebhookSender POSTs each matching event there.
+
+// Bounds on webhook subscriptions
+const (
+	maxWebhookURLLength = 2048
+	maxWebhookEvents    = 20
+)
+
+// Page sizes of the delivery log
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+type createWebhookRequest struct {
+	URL string `json:"url"`
+
+	// Events are event types, "<category>.*" such as "environment.*", or
+	// "*" for every event
+	Events      []string   `json:"events"`
+	ProjectID   *uuid.UUID `json:"project_id,omitempty"`
+	Description *string    `json:"description,omitempty"`
+}
+
+// updateWebhookRequest replaces the subscription; active defaults to the
+// current state
+type updateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+}
+
+type webhookResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	ProjectID      *uuid.UUID `json:"project_id,omitempty"`
+	URL            string     `json:"url"`
+	Events         []string   `json:"events"`
+	Description    *string    `json:"description,omitempty"`
+	Active         bool       `json:"active"`
+	CreatedBy      *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// createWebhookResponse is the new webhook with its signing secret, which
+// is only ever returned here
+type createWebhookResponse struct {
+	webhookResponse
+	Secret string `json:"secret"`
+}
+
+type deliveryResponse struct {
+	ID             uuid.UUID                        `json:"id"`
+	EventID        uuid.UUID                        `json:"event_id"`
+	EventType      string                           `json:"event_type,omitempty"`
+	RedeliveryOf   *uuid.UUID                       `json:"redelivery_of,omitempty"`
+	Status         repository.WebhookDeliveryStatus `json:"status"`
+	Attempts       int32                            `json:"attempts"`
+	NextAttemptAt  *time.Time                       `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time                       `json:"last_attempt_at,omitempty"`
+	ResponseStatus *int32                           `json:"response_status,omitempty"`
+	LastError      *string                          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time                       `json:"delivered_at,omitempty"`
+	CreatedBy      *uuid.UUID                       `json:"created_by,omitempty"`
+	CreatedAt      time.Time                        `json:"created_at"`
+}
+
+// listWebhooks returns the webhooks of an organization
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := s.loadWebhookOrganization(w, r, policy.WebhookRead)
+	if !ok {
+		return
+	}
+
+	webhooks, err := s.store.ListWebhooksByOrganization(r.Context(), orgID)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := make([]webhookResponse, 0, len(webhooks))
+	for _, h := range webhooks {
+		resp = append(resp, toWebhookResponse(h))
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// createWebhook subscribes a URL to events of the organization or, with
+// project_id, of one project. Project webhooks only need the right to
+// manage webhooks of that project.
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(chi.URLParam(r, "orgID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid organization id")
+		return
+	}
+	setAccessOrganization(r, orgID)
+
+	var req createWebhookRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if msg := validateWebhook(req.URL, req.Events); msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return
+	}
+
+	target := auth.Target{OrganizationID: orgID}
+	var projectID pgtype.UUID
+	if req.ProjectID != nil {
+		target.ProjectID = *req.ProjectID
+		projectID = pgtype.UUID{Bytes: *req.ProjectID, Valid: true}
+	}
+	if !s.authorize(w, r, policy.WebhookManage, target) {
+		return
+	}
+	if req.ProjectID != nil {
+		project, err := s.store.GetProjectByID(r.Context(), *req.ProjectID)
+		if errors.Is(err, pgx.ErrNoRows) || err == nil && project.OrganizationID != orgID {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "project not found in organization")
+			return
+		}
+		if err != nil {
+			s.writeInternalError(w, err)
+			return
+		}
+	}
+
+	secret, wrapped, err := s.vault.NewWebhookSecret()
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	webhook, err := s.store.CreateWebhook(r.Context(), repository.CreateWebhookParams{
+		OrganizationID:  orgID,
+		ProjectID:       projectID,
+		Url:             req.URL,
+		Events:          req.Events,
+		EncryptedSecret: wrapped,
+		Description:     req.Description,
+		CreatedBy:       auth.UserIDFromContext(r.Context()),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "project not found")
+		return
+	}
+	setAccessTarget(r, resourceWebhook, webhook.ID, repository.AccessActionCreate)
+
+	utils.WriteJSON(w, http.StatusCreated, createWebhookResponse{
+		webhookResponse: toWebhookResponse(webhook),
+		Secret:          secret,
+	})
+}
+
+// getWebhook returns one webhook
+func (s *Server) getWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.loadWebhook(w, r, policy.WebhookRead)
+	if !ok {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, toWebhookResponse(webhook))
+}
+
+// updateWebhook replaces the URL, events and description of a webhook and
+// pauses or resumes it. Deliveries of a paused webhook wait until it is
+// resumed.
+func (s *Server) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.loadWebhook(w, r, policy.WebhookManage)
+	if !ok {
+		return
+	}
+
+	var req updateWebhookRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid request body")
+		return
+	}
+	if msg := validateWebhook(req.URL, req.Events); msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return
+	}
+	active := webhook.IsActive
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	updated, err := s.store.UpdateWebhook(r.Context(), repository.UpdateWebhookParams{
+		ID:          webhook.ID,
+		Url:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+		IsActive:    active,
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "webhook not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, toWebhookResponse(updated))
+}
+
+// deleteWebhook deletes a webhook with its delivery log
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.loadWebhook(w, r, policy.WebhookManage)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteWebhook(r.Context(), webhook.ID); err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveries returns the delivery log of a webhook, newest
+// first. Pages are requested with ?limit= and ?before=<delivery id>, and
+// ?status= keeps pending, delivered or failed deliveries.
+func (s *Server) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.loadWebhook(w, r, policy.WebhookRead)
+	if !ok {
+		return
+	}
+
+	params := repository.ListWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: defaultDeliveryLimit}
+	q := r.URL.Query()
+	if v := q.Get("status"); v != "" {
+		status := repository.WebhookDeliveryStatus(v)
+		if !status.Valid() {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid status")
+			return
+		}
+		params.Status = repository.NullWebhookDeliveryStatus{WebhookDeliveryStatus: status, Valid: true}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDeliveryLimit {
+			utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		params.Limit = int32(n)
+	}
+	var msg string
+	if params.Before, msg = parseUUIDParam(q.Get("before"), "before cursor"); msg != "" {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, msg)
+		return
+	}
+
+	deliveries, err := s.store.ListWebhookDeliveries(r.Context(), params)
+	if err != nil {
+		s.writeInternalError(w, err)
+		return
+	}
+
+	resp := make([]deliveryResponse, 0, len(deliveries))
+	for _, d := range deliveries {
+		resp = append(resp, toDeliveryResponse(repository.WebhookDelivery{
+			ID:             d.ID,
+			WebhookID:      d.WebhookID,
+			EventID:        d.EventID,
+			RedeliveryOf:   d.RedeliveryOf,
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			NextAttemptAt:  d.NextAttemptAt,
+			LastAttemptAt:  d.LastAttemptAt,
+			ResponseStatus: d.ResponseStatus,
+			LastError:      d.LastError,
+			DeliveredAt:    d.DeliveredAt,
+			CreatedBy:      d.CreatedBy,
+			CreatedAt:      d.CreatedAt,
+		}, d.EventType))
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// redeliverWebhook queues the event of a delivery to be sent again as a
+// new delivery, whatever became of the first
+func (s *Server) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.loadWebhook(w, r, policy.WebhookManage)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid delivery id")
+		return
+	}
+
+	original, err := s.store.GetWebhookDelivery(r.Context(), repository.GetWebhookDeliveryParams{
+		ID:        deliveryID,
+		WebhookID: webhook.ID,
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "delivery not found")
+		return
+	}
+	delivery, err := s.store.CreateWebhookDelivery(r.Context(), repository.CreateWebhookDeliveryParams{
+		WebhookID:    webhook.ID,
+		EventID:      original.EventID,
+		RedeliveryOf: pgtype.UUID{Bytes: original.ID, Valid: true},
+		CreatedBy:    auth.UserIDFromContext(r.Context()),
+	})
+	if err != nil {
+		s.writeStoreError(w, err, "delivery not found")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, toDeliveryResponse(delivery, ""))
+}
+
+// loadWebhookOrganization parses the organization in the URL and checks
+// that the caller may perform action on its webhooks
+func (s *Server) loadWebhookOrganization(w http.ResponseWriter, r *http.Request, action policy.Action) (uuid.UUID, bool) {
+	orgID, err := uuid.Parse(chi.URLParam(r, "orgID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid organization id")
+		return uuid.Nil, false
+	}
+	if !s.authorize(w, r, action, auth.Target{OrganizationID: orgID}) {
+		return uuid.Nil, false
+	}
+	return orgID, true
+}
+
+// loadWebhook looks up the webhook in the URL and checks that the caller
+// may perform action on it. Changes are recorded in the access log.
+func (s *Server) loadWebhook(w http.ResponseWriter, r *http.Request, action policy.Action) (repository.Webhook, bool) {
+	orgID, err := uuid.Parse(chi.URLParam(r, "orgID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid organization id")
+		return repository.Webhook{}, false
+	}
+	webhookID, err := uuid.Parse(chi.URLParam(r, "webhookID"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, CodeBadRequest, "invalid webhook id")
+		return repository.Webhook{}, false
+	}
+
+	webhook, err := s.store.GetWebhook(r.Context(), repository.GetWebhookParams{ID: webhookID, OrganizationID: orgID})
+	if err != nil {
+		s.writeStoreError(w, err, "webhook not found")
+		return webhook, false
+	}
+	if action == policy.WebhookManage {
+		setAccessOrganization(r, orgID)
+		setAccessTarget(r, resourceWebhook, webhook.ID, webhookAccessAction(r))
+	}
+
+	target := auth.Target{OrganizationID: orgID}
+	if webhook.ProjectID.Valid {
+		target.ProjectID = webhook.ProjectID.Bytes
+	}
+	return webhook, s.authorize(w, r, action, target)
+}
+
+// webhookAccessAction is the access log action of a change to a webhook
+func webhookAccessAction(r *http.Request) repository.AccessAction {
+	if r.Method == http.MethodDelete {
+		return repository.AccessActionDelete
+	}
+	return repository.AccessActionUpdate
+}
+
+// validateWebhook returns a non-empty message if rawURL is not an https
+// URL or events are not known event types or patterns
+func validateWebhook(rawURL string, events []string) string {
+	u, err := url.Parse(rawURL)
+	switch {
+	case rawURL == "":
+		return "url is required"
+	case len(rawURL) > maxWebhookURLLength:
+		return "url must be at most 2048 characters"
+	case err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil:
+		return "url must be an https URL without credentials"
+	case len(events) == 0:
+		return "events is required"
+	case len(events) > maxWebhookEvents:
+		return "at most 20 events may be given"
+	}
+	for _, e := range events {
+		if !validEventPattern(e) {
+			return "unknown event " + e + "; use an event type such as secret.updated, environment.* or *"
+		}
+	}
+	return ""
+}
+
+// validEventPattern reports whether pattern is a known event type, a
+// category of them such as "secret.*", or "*"
+func validEventPattern(pattern string) bool {
+	if pattern == "*" || slices.Contains(eventTypes, pattern) {
+		return true
+	}
+	category, ok := strings.CutSuffix(pattern, ".*")
+	return ok && slices.ContainsFunc(eventTypes, func(typ string) bool {
+		return strings.HasPrefix(typ, category+".")
+	})
+}
+
+// matchEvent reports whether a webhook subscribed to patterns receives
+// events of type typ
+func matchEvent(patterns []string, typ string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == typ {
+			return true
+		}
+		if category, ok := strings.CutSuffix(p, ".*"); ok && strings.HasPrefix(typ, category+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func toWebhookResponse(h repository.Webhook) webhookResponse {
+	return webhookResponse{
+		ID:             h.ID,
+		OrganizationID: h.OrganizationID,
+		ProjectID:      uuidPtr(h.ProjectID),
+		URL:            h.Url,
+		Events:         h.Events,
+		Description:    h.Description,
+		Active:         h.IsActive,
+		CreatedBy:      uuidPtr(h.CreatedBy),
+		CreatedAt:      h.CreatedAt,
+		UpdatedAt:      h.UpdatedAt,
+	}
+}
+
+func toDeliveryResponse(d repository.WebhookDelivery, eventType string) deliveryResponse {
+	resp := deliveryResponse{
+		ID:             d.ID,
+		EventID:        d.EventID,
+		EventType:      eventType,
+		RedeliveryOf:   uuidPtr(d.RedeliveryOf),
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      d.LastError,
+		CreatedBy:      uuidPtr(d.CreatedBy),
+		CreatedAt:      d.CreatedAt,
+	}
+	if d.Status == repository.WebhookDeliveryStatusPending {
+		resp.NextAttemptAt = &d.NextAttemptAt
+	}
+	if d.LastAttemptAt.Valid {
+		resp.LastAttemptAt = &d.LastAttemptAt.Time
+	}
+	if d.DeliveredAt.Valid {
+		resp.DeliveredAt = &d.DeliveredAt.Time
+	}
+	return resp
+}
@@ -0,0 +1,284 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// Chain names an audit table whose rows are sealed into a hash chain
+type Chain string
+
+const (
+	ChainAccessLogs    Chain = "access_logs"
+	ChainSecretHistory Chain = "secret_history"
+)
+
+// Chains lists every sealed audit table
+var Chains = []Chain{ChainAccessLogs, ChainSecretHistory}
+
+// Domain separators, so a row hash can never pass for a checkpoint
+// signature input or vice versa
+const (
+	rowHashDomain    = "envhub-audit-row-v1"
+	checkpointDomain = "envhub-audit-checkpoint-v1"
+)
+
+// genesis is the prev_hash of the first row of every chain
+var genesis = make([]byte, sha256.Size)
+
+// link is what the sealer and verifier need of one audit row
+type link struct {
+	id       uuid.UUID
+	seq      *int64
+	prevHash []byte
+	rowHash  []byte
+	content  []byte
+}
+
+// chainTable reads and seals the rows of one chain
+type chainTable struct {
+	head     func(ctx context.Context, q repository.Querier) (link, error)
+	unsealed func(ctx context.Context, q repository.Querier, limit int32) ([]link, error)
+	after    func(ctx context.Context, q repository.Querier, seq int64, limit int32) ([]link, error)
+	seal     func(ctx context.Context, q repository.Querier, id uuid.UUID, seq int64, prev, hash []byte) (int64, error)
+}
+
+var tables = map[Chain]chainTable{
+	ChainAccessLogs: {
+		head: func(ctx context.Context, q repository.Querier) (link, error) {
+			l, err := q.GetAccessLogChainHead(ctx)
+			return accessLogLink(l), err
+		},
+		unsealed: func(ctx context.Context, q repository.Querier, limit int32) ([]link, error) {
+			rows, err := q.ListUnsealedAccessLogs(ctx, limit)
+			return toLinks(rows, err, accessLogLink)
+		},
+		after: func(ctx context.Context, q repository.Querier, seq int64, limit int32) ([]link, error) {
+			rows, err := q.ListAccessLogChain(ctx, repository.ListAccessLogChainParams{After: seq, Limit: limit})
+			return toLinks(rows, err, accessLogLink)
+		},
+		seal: func(ctx context.Context, q repository.Querier, id uuid.UUID, seq int64, prev, hash []byte) (int64, error) {
+			return q.SealAccessLog(ctx, repository.SealAccessLogParams{ID: id, ChainSeq: seq, PrevHash: prev, RowHash: hash})
+		},
+	},
+	ChainSecretHistory: {
+		head: func(ctx context.Context, q repository.Querier) (link, error) {
+			h, err := q.GetSecretHistoryChainHead(ctx)
+			return secretHistoryLink(h), err
+		},
+		unsealed: func(ctx context.Context, q repository.Querier, limit int32) ([]link, error) {
+			rows, err := q.ListUnsealedSecretHistory(ctx, limit)
+			return toLinks(rows, err, secretHistoryLink)
+		},
+		after: func(ctx context.Context, q repository.Querier, seq int64, limit int32) ([]link, error) {
+			rows, err := q.ListSecretHistoryChain(ctx, repository.ListSecretHistoryChainParams{After: seq, Limit: limit})
+			return toLinks(rows, err, secretHistoryLink)
+		},
+		seal: func(ctx context.Context, q repository.Querier, id uuid.UUID, seq int64, prev, hash []byte) (int64, error) {
+			return q.SealSecretHistory(ctx, repository.SealSecretHistoryParams{ID: id, ChainSeq: seq, PrevHash: prev, RowHash: hash, LinkVersion: secretHistoryLinkVersion})
+		},
+	},
+}
+
+// toLinks converts the result of a list query
+func toLinks[T any](rows []T, err error, toLink func(T) link) ([]link, error) {
+	if err != nil {
+		return nil, err
+	}
+	out := make([]link, len(rows))
+	for i, r := range rows {
+		out[i] = toLink(r)
+	}
+	return out, nil
+}
+
+// accessLogLink covers every column of an access log entry
+func accessLogLink(l repository.AccessLog) link {
+	var e encoder
+	e.uuid(l.ID)
+	e.nullUUID(l.UserID)
+	e.nullUUID(l.ApiTokenID)
+	e.string(l.ResourceType)
+	e.uuid(l.ResourceID)
+	e.string(string(l.Action))
+	e.time(l.CreatedAt)
+	e.addr(l.IpAddress)
+	e.stringPtr(l.UserAgent)
+	e.bool(l.Success)
+	e.stringPtr(l.ErrorMessage)
+	e.nullUUID(l.OrganizationID)
+	return link{id: l.ID, seq: l.ChainSeq, prevHash: l.PrevHash, rowHash: l.RowHash, content: e.Bytes()}
+}
+
+// secretHistoryLinkVersion is the link_version of history entries sealed
+// now, whose hash covers their organization and project
+const secretHistoryLinkVersion = 2
+
+// secretHistoryLink covers every column of a history entry except
+// encrypted_value, which key rotation and resealing legitimately rewrite.
+// Values are bound to their secret and version by encryption instead, so a
+// value moved to another entry or restored as the current one fails to
+// decrypt. Entries sealed before link versions leave out organization_id
+// and project_id, which were added later.
+func secretHistoryLink(h repository.SecretHistory) link {
+	var e encoder
+	e.uuid(h.ID)
+	e.uuid(h.SecretID)
+	e.uuid(h.EnvironmentID)
+	e.string(string(h.Action))
+	e.string(h.Key)
+	e.uuid(h.ChangedBy)
+	e.time(h.CreatedAt)
+	e.addr(h.IpAddress)
+	e.stringPtr(h.UserAgent)
+	e.int32Ptr(h.Version)
+	e.int32Ptr(h.RestoredVersion)
+	if h.ChainSeq == nil || h.LinkVersion != nil {
+		e.nullUUID(h.OrganizationID)
+		e.nullUUID(h.ProjectID)
+	}
+	return link{id: h.ID, seq: h.ChainSeq, prevHash: h.PrevHash, rowHash: h.RowHash, content: e.Bytes()}
+}
+
+// rowHash chains a row's content to the row before it
+func rowHash(chain Chain, seq int64, prev, content []byte) []byte {
+	h := sha256.New()
+	h.Write(frame(rowHashDomain, chain, seq, prev))
+	h.Write(content)
+	return h.Sum(nil)
+}
+
+// checkpointMessage is what a checkpoint signs
+func checkpointMessage(chain Chain, seq int64, hash []byte, at time.Time) []byte {
+	msg := frame(checkpointDomain, chain, seq, hash)
+	return binary.BigEndian.AppendUint64(msg, uint64(at.UnixMicro()))
+}
+
+func frame(domain string, chain Chain, seq int64, hash []byte) []byte {
+	var e encoder
+	e.string(domain)
+	e.string(string(chain))
+	e.field(binary.BigEndian.AppendUint64(nil, uint64(seq)), true)
+	e.field(hash, true)
+	return e.Bytes()
+}
+
+// encoder writes fields length-prefixed, so no two rows encode alike
+type encoder struct {
+	bytes.Buffer
+}
+
+// nullLength marks a NULL field
+const nullLength = ^uint32(0)
+
+func (e *encoder) field(b []byte, ok bool) {
+	if !ok {
+		_ = binary.Write(e, binary.BigEndian, nullLength)
+		return
+	}
+	_ = binary.Write(e, binary.BigEndian, uint32(len(b)))
+	e.Write(b)
+}
+
+func (e *encoder) string(s string)   { e.field([]byte(s), true) }
+func (e *encoder) uuid(id uuid.UUID) { e.field(id[:], true) }
+
+func (e *encoder) nullUUID(id pgtype.UUID) { e.field(id.Bytes[:], id.Valid) }
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.field([]byte{1}, true)
+	} else {
+		e.field([]byte{0}, true)
+	}
+}
+
+// time keeps microseconds, the precision of timestamptz
+func (e *encoder) time(t time.Time) {
+	e.field(binary.BigEndian.AppendUint64(nil, uint64(t.UnixMicro())), true)
+}
+
+func (e *encoder) stringPtr(s *string) {
+	if s == nil {
+		e.field(nil, false)
+		return
+	}
+	e.string(*s)
+}
+
+func (e *encoder) int32Ptr(n *int32) {
+	if n == nil {
+		e.field(nil, false)
+		return
+	}
+	e.field(binary.BigEndian.AppendUint32(nil, uint32(*n)), true)
+}
+
+func (e *encoder) addr(a *netip.Addr) {
+	if a == nil {
+		e.field(nil, false)
+		return
+	}
+	b, _ := a.Unmap().MarshalBinary()
+	e.field(b, true)
+}
+
+// KeyID names a checkpoint key: the hex of the first 8 bytes of the
+// SHA-256 of its public key
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadSigningKeyFromEnv loads the checkpoint signing key, a base64 Ed25519
+// seed, from AUDIT_SIGNING_KEY or the file named by AUDIT_SIGNING_KEY_FILE.
+// It returns nil if neither is set.
+func LoadSigningKeyFromEnv() (ed25519.PrivateKey, error) {
+	encoded := os.Getenv("AUDIT_SIGNING_KEY")
+	if path := os.Getenv("AUDIT_SIGNING_KEY_FILE"); encoded == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read AUDIT_SIGNING_KEY_FILE: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("audit signing key is not valid base64: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("audit signing key must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("public key is not valid base64: %w", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
@@ -0,0 +1,232 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+)
+
+// sealedLogs writes n access log entries and seals them
+func sealedLogs(t *testing.T, store *repotest.MemStore, n int, opts ...SealerOption) *Sealer {
+	t.Helper()
+
+	for range n {
+		e := entry()
+		e.CreatedAt = time.Now()
+		if _, err := store.CreateAccessLog(t.Context(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewSealer(store, opts...)
+	if _, err := s.Seal(t.Context()); err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	return s
+}
+
+func verify(t *testing.T, store *repotest.MemStore, chain Chain, pub ed25519.PublicKey) Report {
+	t.Helper()
+
+	report, err := Verify(t.Context(), store, chain, pub)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	return report
+}
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSealAndVerify(t *testing.T) {
+	store := repotest.NewMemStore()
+	key := newKey(t)
+	s := sealedLogs(t, store, 5, WithSigningKey(key), WithSealBatchSize(2))
+
+	logs := store.AccessLogs()
+	for i, l := range logs {
+		if l.ChainSeq == nil || *l.ChainSeq != int64(i+1) {
+			t.Fatalf("Expected entry %d to be sealed as seq %d, got %v", i, i+1, l.ChainSeq)
+		}
+	}
+
+	// Entries written later extend the chain
+	_, _ = store.CreateAccessLog(t.Context(), entry())
+	if n, err := s.Seal(t.Context()); err != nil || n != 1 {
+		t.Fatalf("Expected to seal 1 new entry, got %d, %v", n, err)
+	}
+
+	report := verify(t, store, ChainAccessLogs, key.Public().(ed25519.PublicKey))
+	if report.Broken != nil {
+		t.Fatalf("Expected an intact chain, got %s", report.Broken)
+	}
+	// Batches of 2, 2, 1 and then 1
+	if report.Links != 6 || report.Checkpoints != 4 || report.UnknownKeys != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	report = verify(t, store, ChainAccessLogs, nil)
+	if report.Broken != nil || report.Checkpoints != 0 || report.UnknownKeys != 4 {
+		t.Errorf("Without a key checkpoints should be skipped, got %+v", report)
+	}
+}
+
+func TestVerifyFindsFirstBrokenLink(t *testing.T) {
+	for name, tc := range map[string]struct {
+		tamper func(logs []repository.AccessLog) []repository.AccessLog
+		seq    int64
+		reason string
+	}{
+		"edited": {
+			tamper: func(logs []repository.AccessLog) []repository.AccessLog {
+				logs[2].Success = !logs[2].Success
+				logs[3].Action = repository.AccessActionDelete
+				return logs
+			},
+			seq:    3,
+			reason: "contents do not match",
+		},
+		"deleted": {
+			tamper: func(logs []repository.AccessLog) []repository.AccessLog {
+				return append(logs[:1], logs[2:]...)
+			},
+			seq:    2,
+			reason: "missing",
+		},
+		"rehashed": {
+			// Recomputing the edited row's hash breaks the next link
+			tamper: func(logs []repository.AccessLog) []repository.AccessLog {
+				logs[1].ResourceID = uuid.New()
+				logs[1].RowHash = rowHash(ChainAccessLogs, 2, logs[1].PrevHash, accessLogLink(logs[1]).content)
+				return logs
+			},
+			seq:    3,
+			reason: "prev_hash",
+		},
+		"truncated": {
+			tamper: func(logs []repository.AccessLog) []repository.AccessLog {
+				return logs[:3]
+			},
+			seq:    5,
+			reason: "rows were removed",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := repotest.NewMemStore()
+			key := newKey(t)
+			sealedLogs(t, store, 5, WithSigningKey(key))
+			store.RewriteAccessLogs(tc.tamper)
+
+			broken := verify(t, store, ChainAccessLogs, key.Public().(ed25519.PublicKey)).Broken
+			if broken == nil {
+				t.Fatal("Expected a broken link")
+			}
+			if broken.Seq != tc.seq || !strings.Contains(broken.Reason, tc.reason) {
+				t.Errorf("Expected seq %d (%s), got %s", tc.seq, tc.reason, broken)
+			}
+		})
+	}
+}
+
+func TestVerifyRewrittenChain(t *testing.T) {
+	store := repotest.NewMemStore()
+	key := newKey(t)
+	sealedLogs(t, store, 3, WithSigningKey(key))
+
+	// Whoever rewrites the chain without the key can not sign for it
+	store.RewriteAccessLogs(func(logs []repository.AccessLog) []repository.AccessLog {
+		logs[0].Success = false
+		prev := genesis
+		for i := range logs {
+			logs[i].PrevHash = prev
+			logs[i].RowHash = rowHash(ChainAccessLogs, int64(i+1), prev, accessLogLink(logs[i]).content)
+			prev = logs[i].RowHash
+		}
+		return logs
+	})
+	forger := newKey(t)
+	if err := NewSealer(store, WithSigningKey(forger)).checkpoint(t.Context(), store, ChainAccessLogs, 3, store.AccessLogs()[2].RowHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if report := verify(t, store, ChainAccessLogs, nil); report.Broken != nil {
+		t.Fatalf("Hashes alone can not tell, got %s", report.Broken)
+	}
+	report := verify(t, store, ChainAccessLogs, key.Public().(ed25519.PublicKey))
+	if report.Broken == nil || report.Broken.Seq != 3 || !strings.Contains(report.Broken.Reason, "checkpoint") {
+		t.Errorf("Expected the signed checkpoint to expose the rewrite, got %+v", report)
+	}
+	if report.UnknownKeys != 1 {
+		t.Errorf("Expected the forged checkpoint to be skipped, got %d unknown", report.UnknownKeys)
+	}
+}
+
+func TestSecretHistoryChainSurvivesResealing(t *testing.T) {
+	store := repotest.NewMemStore()
+	env := store.AddEnvironment(repository.Environment{ID: uuid.New(), Name: "dev"})
+	secret, err := store.CreateSecret(t.Context(), repository.CreateSecretParams{EnvironmentID: env.ID, Key: "API_KEY", EncryptedValue: "v1", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateSecret(t.Context(), repository.UpdateSecretParams{ID: secret.ID, EncryptedValue: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := NewSealer(store).Seal(t.Context()); err != nil || n != 2 {
+		t.Fatalf("Expected to seal 2 history entries, got %d, %v", n, err)
+	}
+
+	old := "v1"
+	h := store.History(secret.ID)[0]
+	if n, _ := store.ResealSecretHistory(t.Context(), repository.ResealSecretHistoryParams{ID: h.ID, OldValue: &old, NewValue: "v1-resealed"}); n != 1 {
+		t.Fatal("Expected the entry to be resealed")
+	}
+
+	report := verify(t, store, ChainSecretHistory, nil)
+	if report.Broken != nil || report.Links != 2 {
+		t.Errorf("Expected resealing to keep the chain intact, got %+v", report)
+	}
+}
+
+func TestSecretHistoryChainCoversOwners(t *testing.T) {
+	store := repotest.NewMemStore()
+	project := store.AddProject(repository.Project{ID: uuid.New(), OrganizationID: uuid.New(), Name: "api"})
+	env := store.AddEnvironment(repository.Environment{ID: uuid.New(), ProjectID: project.ID, Name: "dev"})
+	if _, err := store.CreateSecret(t.Context(), repository.CreateSecretParams{EnvironmentID: env.ID, Key: "API_KEY", EncryptedValue: "v1", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := NewSealer(store).Seal(t.Context()); err != nil || n != 1 {
+		t.Fatalf("Expected to seal 1 history entry, got %d, %v", n, err)
+	}
+
+	// Moving an entry to another organization hides it from the search
+	store.RewriteSecretHistory(func(h repository.SecretHistory) repository.SecretHistory {
+		h.OrganizationID = pgtype.UUID{Bytes: uuid.New(), Valid: true}
+		return h
+	})
+	if broken := verify(t, store, ChainSecretHistory, nil).Broken; broken == nil || broken.Seq != 1 {
+		t.Errorf("Expected the moved entry to break the chain, got %v", broken)
+	}
+
+	// Nor can it pass for an entry sealed before link versions
+	store.RewriteSecretHistory(func(h repository.SecretHistory) repository.SecretHistory {
+		h.LinkVersion = nil
+		return h
+	})
+	if broken := verify(t, store, ChainSecretHistory, nil).Broken; broken == nil {
+		t.Errorf("Expected dropping the link version to break the chain")
+	}
+}
@@ -0,0 +1,218 @@
+// Package audit writes access_logs entries in the background and keeps the
+// audit tables tamper-evident.
+//
+// Requests hand entries to a Logger, which batches them and writes each
+// batch in one transaction, so audit logging never waits on the database.
+// A batch that cannot be written stays queued and is retried. Close drains
+// everything that was logged before it.
+//
+// A Sealer then links access_logs and secret_history rows into one hash
+// chain per table, optionally signing checkpoints of the chain, and Verify
+// walks a chain to find the first row that was altered or removed.
+package audit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// Defaults for a Logger's batching
+const (
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+	DefaultBufferSize    = 4096
+)
+
+// Attempts and timeout for writing one batch
+const (
+	writeAttempts = 3
+	writeTimeout  = 10 * time.Second
+)
+
+// Logger batches access log entries and writes them asynchronously
+type Logger struct {
+	store         repository.Store
+	batchSize     int
+	flushInterval time.Duration
+
+	// mu guards closed; Log holds it for reading while it enqueues so
+	// Close cannot close entries under it
+	mu      sync.RWMutex
+	closed  bool
+	entries chan repository.CreateAccessLogParams
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Option configures a Logger
+type Option func(*Logger)
+
+// WithBatchSize sets the most entries written in one transaction
+func WithBatchSize(n int) Option {
+	return func(l *Logger) { l.batchSize = n }
+}
+
+// WithFlushInterval sets how long an entry may wait for its batch to fill
+func WithFlushInterval(d time.Duration) Option {
+	return func(l *Logger) { l.flushInterval = d }
+}
+
+// WithBufferSize sets how many entries may be queued before Log blocks
+func WithBufferSize(n int) Option {
+	return func(l *Logger) { l.entries = make(chan repository.CreateAccessLogParams, n) }
+}
+
+// NewLogger starts a Logger writing to store. Call Close to stop it.
+func NewLogger(store repository.Store, opts ...Option) *Logger {
+	l := &Logger{
+		store:         store,
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		entries:       make(chan repository.CreateAccessLogParams, DefaultBufferSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	go l.run()
+	return l
+}
+
+// Log queues an entry. It only blocks if the queue is full, i.e. the
+// database is falling behind or unreachable. Entries logged after Close are
+// dropped.
+func (l *Logger) Log(entry repository.CreateAccessLogParams) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		log.Printf("audit: logger closed, dropping %s %s entry for %s", entry.Action, entry.ResourceType, entry.ResourceID)
+		return
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	l.entries <- entry
+}
+
+// Close stops accepting entries and waits until every queued entry is
+// written, or could not be written once more, or ctx is done
+func (l *Logger) Close(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.entries)
+		close(l.stop)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects entries into batches and writes a batch when it is full,
+// when the flush interval passes, and when the queue is closed.
+//
+// Entries of a batch that could not be written stay pending and are retried
+// on every flush interval until the database is back. Up to a queue's worth
+// are held back; beyond that run stops taking entries, so Log blocks rather
+// than dropping them.
+func (l *Logger) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	pending := make([]repository.CreateAccessLogParams, 0, l.batchSize)
+	failing := false
+	for {
+		entries := l.entries
+		if failing && len(pending) >= max(cap(l.entries), l.batchSize) {
+			entries = nil
+		}
+
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				l.drain(pending)
+				return
+			}
+			pending = append(pending, entry)
+			if !failing && len(pending) >= l.batchSize {
+				pending, failing = l.flush(pending)
+			}
+		case <-l.stop:
+			// Closed, possibly while run was not taking entries
+			for entry := range l.entries {
+				pending = append(pending, entry)
+			}
+			l.drain(pending)
+			return
+		case <-ticker.C:
+			pending, failing = l.flush(pending)
+		}
+	}
+}
+
+// flush writes pending entries in batches, in the order they were logged,
+// and returns those it could not write and whether a write failed
+func (l *Logger) flush(pending []repository.CreateAccessLogParams) ([]repository.CreateAccessLogParams, bool) {
+	written := 0
+	for written < len(pending) {
+		n := min(l.batchSize, len(pending)-written)
+		if err := l.write(pending[written : written+n]); err != nil {
+			log.Printf("audit: keeping %d access log entries queued: %v", len(pending)-written, err)
+			break
+		}
+		written += n
+	}
+
+	rest := copy(pending, pending[written:])
+	return pending[:rest], rest > 0
+}
+
+// drain makes a last attempt at the pending entries when the logger closes
+func (l *Logger) drain(pending []repository.CreateAccessLogParams) {
+	if rest, _ := l.flush(pending); len(rest) > 0 {
+		log.Printf("audit: dropping %d access log entries on close", len(rest))
+	}
+}
+
+// write stores a batch in one transaction, retrying a few times before
+// giving up on it for now
+func (l *Logger) write(batch []repository.CreateAccessLogParams) error {
+	var err error
+	for attempt := 1; attempt <= writeAttempts; attempt++ {
+		if err = l.writeOnce(batch); err == nil {
+			return nil
+		}
+		if attempt < writeAttempts {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+	}
+	return err
+}
+
+func (l *Logger) writeOnce(batch []repository.CreateAccessLogParams) error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	return l.store.ExecTx(ctx, func(q repository.Querier) error {
+		for _, entry := range batch {
+			if _, err := q.CreateAccessLog(ctx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+)
+
+func entry() repository.CreateAccessLogParams {
+	return repository.CreateAccessLogParams{
+		ResourceType: "secret",
+		ResourceID:   uuid.New(),
+		Action:       repository.AccessActionRead,
+		Success:      true,
+	}
+}
+
+// waitFor polls until store holds n access log entries
+func waitFor(t *testing.T, store *repotest.MemStore, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(store.AccessLogs()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d entries, got %d", n, len(store.AccessLogs()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLoggerWritesFullBatches(t *testing.T) {
+	store := repotest.NewMemStore()
+	l := NewLogger(store, WithBatchSize(2), WithFlushInterval(time.Hour))
+
+	first, second := entry(), entry()
+	l.Log(first)
+	l.Log(second)
+	waitFor(t, store, 2)
+
+	l.Log(entry())
+	time.Sleep(20 * time.Millisecond)
+	if n := len(store.AccessLogs()); n != 2 {
+		t.Errorf("Expected a partial batch to wait, got %d entries", n)
+	}
+
+	if err := l.Close(t.Context()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	logs := store.AccessLogs()
+	if len(logs) != 3 {
+		t.Fatalf("Expected Close to flush the partial batch, got %d entries", len(logs))
+	}
+	if logs[0].ResourceID != first.ResourceID || logs[1].ResourceID != second.ResourceID {
+		t.Error("Expected entries in the order logged")
+	}
+	if logs[0].CreatedAt.IsZero() {
+		t.Error("Expected CreatedAt to default to the time logged")
+	}
+}
+
+func TestLoggerFlushInterval(t *testing.T) {
+	store := repotest.NewMemStore()
+	l := NewLogger(store, WithFlushInterval(10*time.Millisecond))
+	defer func() { _ = l.Close(context.Background()) }()
+
+	l.Log(entry())
+	waitFor(t, store, 1)
+}
+
+func TestLoggerClose(t *testing.T) {
+	store := repotest.NewMemStore()
+	l := NewLogger(store, WithFlushInterval(time.Hour), WithBufferSize(1))
+
+	for range 250 {
+		l.Log(entry())
+	}
+	if err := l.Close(t.Context()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if n := len(store.AccessLogs()); n != 250 {
+		t.Errorf("Expected every entry written on Close, got %d", n)
+	}
+
+	// Closing twice and logging afterwards are harmless
+	if err := l.Close(t.Context()); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+	l.Log(entry())
+	if n := len(store.AccessLogs()); n != 250 {
+		t.Errorf("Expected entries after Close to be dropped, got %d", n)
+	}
+}
+
+// flakyStore fails its first transactions
+type flakyStore struct {
+	*repotest.MemStore
+	failures atomic.Int32
+}
+
+func (s *flakyStore) ExecTx(ctx context.Context, fn func(q repository.Querier) error) error {
+	if s.failures.Add(-1) >= 0 {
+		return errors.New("connection reset")
+	}
+	return s.MemStore.ExecTx(ctx, fn)
+}
+
+func TestLoggerRetriesFailedBatches(t *testing.T) {
+	store := &flakyStore{MemStore: repotest.NewMemStore()}
+	store.failures.Store(writeAttempts - 1)
+	l := NewLogger(store, WithFlushInterval(time.Hour))
+
+	l.Log(entry())
+	if err := l.Close(t.Context()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if n := len(store.AccessLogs()); n != 1 {
+		t.Errorf("Expected the batch written on its last attempt, got %d entries", n)
+	}
+}
+
+// waitForFailures polls until store has failed n transactions
+func waitForFailures(t *testing.T, store *flakyStore, from int32, n int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for from-store.failures.Load() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d failed transactions, got %d", n, from-store.failures.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLoggerKeepsFailedBatches(t *testing.T) {
+	store := &flakyStore{MemStore: repotest.NewMemStore()}
+	store.failures.Store(writeAttempts)
+	l := NewLogger(store, WithFlushInterval(10*time.Millisecond))
+	defer func() { _ = l.Close(context.Background()) }()
+
+	l.Log(entry())
+	waitFor(t, store.MemStore, 1)
+}
+
+func TestLoggerRetriesFailedBatchesOnClose(t *testing.T) {
+	store := &flakyStore{MemStore: repotest.NewMemStore()}
+	store.failures.Store(writeAttempts)
+	l := NewLogger(store, WithBatchSize(1), WithFlushInterval(time.Hour))
+
+	l.Log(entry())
+	waitForFailures(t, store, writeAttempts, writeAttempts)
+
+	if err := l.Close(t.Context()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if n := len(store.AccessLogs()); n != 1 {
+		t.Errorf("Expected Close to write the failed batch, got %d entries", n)
+	}
+}
+
+func TestLoggerBlocksWhileFailing(t *testing.T) {
+	const failures = 1000
+	store := &flakyStore{MemStore: repotest.NewMemStore()}
+	store.failures.Store(failures)
+	l := NewLogger(store, WithBatchSize(1), WithBufferSize(1), WithFlushInterval(10*time.Millisecond))
+	defer func() { _ = l.Close(context.Background()) }()
+
+	l.Log(entry())
+	waitForFailures(t, store, failures, writeAttempts)
+	l.Log(entry())
+
+	logged := make(chan struct{})
+	go func() {
+		l.Log(entry())
+		close(logged)
+	}()
+
+	waitForFailures(t, store, failures, 2*writeAttempts)
+	select {
+	case <-logged:
+		t.Fatal("Expected Log to block while failed entries fill the queue")
+	default:
+	}
+
+	store.failures.Store(0)
+	<-logged
+	waitFor(t, store.MemStore, 3)
+}
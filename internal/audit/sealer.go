@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// Defaults for a Sealer
+const (
+	DefaultSealInterval  = 10 * time.Second
+	DefaultSealBatchSize = 500
+)
+
+// Sealer appends newly written audit rows to their hash chain and, with a
+// signing key, signs a checkpoint of the chain after every batch
+type Sealer struct {
+	store     repository.Store
+	key       ed25519.PrivateKey
+	batchSize int
+}
+
+// SealerOption configures a Sealer
+type SealerOption func(*Sealer)
+
+// WithSigningKey signs a checkpoint with key after every sealed batch
+func WithSigningKey(key ed25519.PrivateKey) SealerOption {
+	return func(s *Sealer) { s.key = key }
+}
+
+// WithSealBatchSize sets the most rows sealed in one transaction
+func WithSealBatchSize(n int) SealerOption {
+	return func(s *Sealer) { s.batchSize = n }
+}
+
+// NewSealer creates a Sealer for the audit tables in store
+func NewSealer(store repository.Store, opts ...SealerOption) *Sealer {
+	s := &Sealer{store: store, batchSize: DefaultSealBatchSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Seal chains every unsealed row of every chain and returns how many rows
+// it sealed. Sealers running concurrently, e.g. in several API replicas,
+// take turns.
+func (s *Sealer) Seal(ctx context.Context) (int, error) {
+	total := 0
+	for _, chain := range Chains {
+		for {
+			n, err := s.sealBatch(ctx, chain)
+			total += n
+			if err != nil {
+				return total, fmt.Errorf("seal %s: %w", chain, err)
+			}
+			if n < s.batchSize {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// Run seals every interval until ctx is done
+func (s *Sealer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Seal(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("audit: %v", err)
+			}
+		}
+	}
+}
+
+// sealBatch seals the oldest unsealed rows of chain in one transaction
+func (s *Sealer) sealBatch(ctx context.Context, chain Chain) (int, error) {
+	t := tables[chain]
+
+	var sealed int
+	err := s.store.ExecTx(ctx, func(q repository.Querier) error {
+		sealed = 0
+		if err := q.LockAuditChain(ctx, string(chain)); err != nil {
+			return err
+		}
+
+		seq, prev := int64(0), genesis
+		head, err := t.head(ctx, q)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+		case err != nil:
+			return err
+		default:
+			seq, prev = *head.seq, head.rowHash
+		}
+
+		rows, err := t.unsealed(ctx, q, int32(s.batchSize))
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			seq++
+			hash := rowHash(chain, seq, prev, r.content)
+			n, err := t.seal(ctx, q, r.id, seq, prev, hash)
+			if err != nil {
+				return err
+			}
+			if n != 1 {
+				return fmt.Errorf("row %s was sealed concurrently", r.id)
+			}
+			prev = hash
+			sealed++
+		}
+
+		if sealed == 0 || s.key == nil {
+			return nil
+		}
+		return s.checkpoint(ctx, q, chain, seq, prev)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sealed, nil
+}
+
+// checkpoint signs the head of chain
+func (s *Sealer) checkpoint(ctx context.Context, q repository.Querier, chain Chain, seq int64, hash []byte) error {
+	at := time.Now().Truncate(time.Microsecond)
+	_, err := q.CreateAuditCheckpoint(ctx, repository.CreateAuditCheckpointParams{
+		Chain:     string(chain),
+		ChainSeq:  seq,
+		RowHash:   hash,
+		KeyID:     KeyID(s.key.Public().(ed25519.PublicKey)),
+		Signature: ed25519.Sign(s.key, checkpointMessage(chain, seq, hash, at)),
+		CreatedAt: at,
+	})
+	return err
+}
@@ -0,0 +1,145 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// verifyPageSize is how many rows Verify reads per query
+const verifyPageSize = 1000
+
+// Report is the outcome of verifying one chain
+type Report struct {
+	Chain Chain
+	// Links is the number of sealed rows found intact
+	Links int64
+	// Checkpoints is the number of signed checkpoints found intact
+	Checkpoints int
+	// UnknownKeys counts checkpoints that were not checked because they
+	// were signed by another key, or because no key was given
+	UnknownKeys int
+	// Broken is the first broken link, or nil if the chain is intact
+	Broken *BrokenLink
+}
+
+// BrokenLink locates where a chain stops verifying
+type BrokenLink struct {
+	Seq int64
+	// RowID is the row at Seq, or uuid.Nil if it is missing
+	RowID  uuid.UUID
+	Reason string
+}
+
+func (b *BrokenLink) String() string {
+	if b.RowID == uuid.Nil {
+		return fmt.Sprintf("seq %d: %s", b.Seq, b.Reason)
+	}
+	return fmt.Sprintf("seq %d (row %s): %s", b.Seq, b.RowID, b.Reason)
+}
+
+// Verify walks chain from its first row and reports the first link whose
+// contents, position or prev_hash do not check out. With pub, checkpoints
+// signed by it must match the chain too; they also reveal rows removed from
+// the end of the chain or a chain rewritten from scratch, which the hashes
+// alone cannot.
+func Verify(ctx context.Context, q repository.Querier, chain Chain, pub ed25519.PublicKey) (Report, error) {
+	t, ok := tables[chain]
+	if !ok {
+		return Report{}, fmt.Errorf("unknown audit chain %q", chain)
+	}
+	report := Report{Chain: chain}
+
+	checkpoints, err := q.ListAuditCheckpoints(ctx, string(chain))
+	if err != nil {
+		return report, err
+	}
+	var keyID string
+	if pub != nil {
+		keyID = KeyID(pub)
+	}
+	trusted := make(map[int64]repository.AuditCheckpoint)
+	for _, cp := range checkpoints {
+		if keyID == "" || cp.KeyID != keyID {
+			report.UnknownKeys++
+			continue
+		}
+		trusted[cp.ChainSeq] = cp
+	}
+
+	seq, prev := int64(0), genesis
+	for {
+		rows, err := t.after(ctx, q, seq, verifyPageSize)
+		if err != nil {
+			return report, err
+		}
+		for _, r := range rows {
+			if broken := checkLink(chain, seq+1, prev, r, trusted, pub); broken != nil {
+				report.Broken = broken
+				return report, nil
+			}
+			if _, ok := trusted[seq+1]; ok {
+				report.Checkpoints++
+				delete(trusted, seq+1)
+			}
+			seq, prev = seq+1, r.rowHash
+			report.Links++
+		}
+		if len(rows) < verifyPageSize {
+			break
+		}
+	}
+
+	// Any checkpoint left covers rows that are gone
+	var first *repository.AuditCheckpoint
+	for _, cp := range trusted {
+		if first == nil || cp.ChainSeq < first.ChainSeq {
+			first = &cp
+		}
+	}
+	if first != nil {
+		reason := fmt.Sprintf("rows were removed: the chain ends at seq %d but a checkpoint covers seq %d", seq, first.ChainSeq)
+		if !validSignature(chain, *first, pub) {
+			reason = "checkpoint signature is invalid"
+		}
+		report.Broken = &BrokenLink{Seq: first.ChainSeq, Reason: reason}
+	}
+	return report, nil
+}
+
+// checkLink verifies the row expected at seq, which must follow prev
+func checkLink(chain Chain, seq int64, prev []byte, r link, trusted map[int64]repository.AuditCheckpoint, pub ed25519.PublicKey) *BrokenLink {
+	if *r.seq != seq {
+		return &BrokenLink{Seq: seq, Reason: fmt.Sprintf("row is missing; the next row has seq %d", *r.seq)}
+	}
+
+	broken := &BrokenLink{Seq: seq, RowID: r.id}
+	switch {
+	case !bytes.Equal(r.prevHash, prev):
+		broken.Reason = "prev_hash does not match the previous row"
+	case !bytes.Equal(rowHash(chain, seq, prev, r.content), r.rowHash):
+		broken.Reason = "contents do not match row_hash"
+	default:
+		cp, ok := trusted[seq]
+		switch {
+		case !ok:
+			return nil
+		case !validSignature(chain, cp, pub):
+			broken.Reason = "checkpoint signature is invalid"
+		case !bytes.Equal(cp.RowHash, r.rowHash):
+			broken.Reason = "row_hash does not match the signed checkpoint"
+		default:
+			return nil
+		}
+	}
+	return broken
+}
+
+func validSignature(chain Chain, cp repository.AuditCheckpoint, pub ed25519.PublicKey) bool {
+	return ed25519.Verify(pub, checkpointMessage(chain, cp.ChainSeq, cp.RowHash, cp.CreatedAt), cp.Signature)
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID uuid.UUID
+
+	// OrganizationID is uuid.Nil when the credential is not bound to an organization
+	OrganizationID uuid.UUID
+
+	// TokenID is uuid.Nil when the caller did not authenticate with an API token
+	TokenID uuid.UUID
+
+	// ExpiresAt is zero when the credential does not expire
+	ExpiresAt time.Time
+
+	Scopes []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the authenticated caller, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// UserIDFromContext returns the caller's user id as a nullable UUID
+// suitable for created_by / updated_by columns
+func UserIDFromContext(ctx context.Context) pgtype.UUID {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return pgtype.UUID{Bytes: p.UserID, Valid: true}
+	}
+	return pgtype.UUID{}
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrInvalidJWT is returned for JWTs that fail parsing or validation
+var ErrInvalidJWT = errors.New("auth: invalid JWT")
+
+// defaultLeeway tolerates small clock skew between EnvHub and the IdP
+const defaultLeeway = time.Minute
+
+// Claims are the JWT claims EnvHub understands
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	NotBefore     int64    `json:"nbf"`
+	IssuedAt      int64    `json:"iat"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	Picture       string   `json:"picture"`
+
+	// UserMetadata carries profile fields in Supabase-issued tokens
+	UserMetadata struct {
+		FullName  string `json:"full_name"`
+		AvatarURL string `json:"avatar_url"`
+	} `json:"user_metadata"`
+}
+
+// DisplayName returns the best available full name
+func (c *Claims) DisplayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.UserMetadata.FullName
+}
+
+// AvatarURL returns the best available avatar URL
+func (c *Claims) AvatarURL() string {
+	if c.Picture != "" {
+		return c.Picture
+	}
+	return c.UserMetadata.AvatarURL
+}
+
+// audience accepts both the string and array forms of "aud"
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(want string) bool {
+	for _, aud := range a {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+// KeySource resolves JWT signing keys by key id
+type KeySource interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// Verifier validates RS256 and ES256 signed JWTs from a trusted issuer
+type Verifier struct {
+	keys     KeySource
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// NewVerifier creates a Verifier that accepts tokens issued by issuer for audience
+func NewVerifier(keys KeySource, issuer, audience string) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   defaultLeeway,
+		now:      time.Now,
+	}
+}
+
+// Verify checks the signature, issuer, audience and validity window of
+// a compact-serialised JWT and returns its claims
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidJWT)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: bad header: %v", ErrInvalidJWT, err)
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJWT, err)
+		}
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidJWT)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if err := verifySignature(header.Alg, key, digest[:], sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: bad claims: %v", ErrInvalidJWT, err)
+	}
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// verifySignature checks sig over digest. Only RS256 and ES256 are
+// accepted, and the key type must match the algorithm, so "none" and
+// HMAC algorithm-confusion tokens are always rejected.
+func verifySignature(alg string, key crypto.PublicKey, digest, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type does not match RS256", ErrInvalidJWT)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig); err != nil {
+			return fmt.Errorf("%w: signature verification failed", ErrInvalidJWT)
+		}
+
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type does not match ES256", ErrInvalidJWT)
+		}
+		// JWS encodes ES256 signatures as the raw 32-byte r and s values
+		if len(sig) != 64 {
+			return fmt.Errorf("%w: bad ES256 signature length", ErrInvalidJWT)
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("%w: signature verification failed", ErrInvalidJWT)
+		}
+
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidJWT, alg)
+	}
+
+	return nil
+}
+
+func (v *Verifier) validateClaims(c *Claims) error {
+	now := v.now()
+
+	switch {
+	case c.Issuer != v.issuer:
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidJWT, c.Issuer)
+	case !c.Audience.contains(v.audience):
+		return fmt.Errorf("%w: token not issued for this audience", ErrInvalidJWT)
+	case c.ExpiresAt == 0:
+		return fmt.Errorf("%w: missing exp", ErrInvalidJWT)
+	case now.After(time.Unix(c.ExpiresAt, 0).Add(v.leeway)):
+		return fmt.Errorf("%w: token expired", ErrInvalidJWT)
+	case c.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(c.NotBefore, 0)):
+		return fmt.Errorf("%w: token not yet valid", ErrInvalidJWT)
+	case c.Subject == "":
+		return fmt.Errorf("%w: missing sub", ErrInvalidJWT)
+	}
+
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+)
+
+const (
+	testIssuer   = "https://idp.example.com/auth/v1"
+	testAudience = "authenticated"
+)
+
+// testIdP is a locally generated identity provider with one RSA and one EC key
+type testIdP struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	jwks   []byte
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey failed: %v", err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	ecX := make([]byte, 32)
+	ecY := make([]byte, 32)
+	ecKey.X.FillBytes(ecX)
+	ecKey.Y.FillBytes(ecY)
+
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA", "kid": "rsa-1", "use": "sig", "alg": "RS256",
+				"n": b64(rsaKey.N.Bytes()),
+				"e": b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC", "kid": "ec-1", "use": "sig", "alg": "ES256", "crv": "P-256",
+				"x": b64(ecX),
+				"y": b64(ecY),
+			},
+			{"kty": "oct", "kid": "hmac-1", "k": b64([]byte("ignored"))},
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal JWKS: %v", err)
+	}
+
+	return &testIdP{rsaKey: rsaKey, ecKey: ecKey, jwks: jwks}
+}
+
+func (idp *testIdP) claims(sub string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            testIssuer,
+		"aud":            testAudience,
+		"sub":            sub,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"email":          sub + "@example.com",
+		"email_verified": true,
+		"user_metadata":  map[string]string{"full_name": "Ada Lovelace"},
+	}
+}
+
+// sign produces a compact JWT. alg may be RS256, ES256, HS256 or none.
+func (idp *testIdP) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("sign RS256: %v", err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		if err != nil {
+			t.Fatalf("sign ES256: %v", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case "HS256":
+		// Algorithm confusion: HMAC keyed with the public JWKS
+		mac := hmac.New(sha256.New, idp.jwks)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	}
+
+	return input + "." + b64(sig)
+}
+
+func (idp *testIdP) verifier(t *testing.T) *Verifier {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, idp.jwks, 0o600); err != nil {
+		t.Fatalf("write JWKS: %v", err)
+	}
+	keys, err := LoadJWKSFile(path)
+	if err != nil {
+		t.Fatalf("LoadJWKSFile failed: %v", err)
+	}
+	return NewVerifier(keys, testIssuer, testAudience)
+}
+
+func TestVerifierValidation(t *testing.T) {
+	idp := newTestIdP(t)
+	v := idp.verifier(t)
+
+	with := func(key string, value any) map[string]any {
+		c := idp.claims("user-1")
+		c[key] = value
+		return c
+	}
+	without := func(key string) map[string]any {
+		c := idp.claims("user-1")
+		delete(c, key)
+		return c
+	}
+
+	valid := idp.sign(t, "RS256", "rsa-1", idp.claims("user-1"))
+	tampered := valid[:len(valid)-4] + "AAAA"
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", valid, true},
+		{"ES256", idp.sign(t, "ES256", "ec-1", idp.claims("user-1")), true},
+		{"Audience array", idp.sign(t, "RS256", "rsa-1", with("aud", []string{"other", testAudience})), true},
+		{"Within leeway", idp.sign(t, "RS256", "rsa-1", with("exp", time.Now().Add(-30*time.Second).Unix())), true},
+		{"Expired", idp.sign(t, "RS256", "rsa-1", with("exp", time.Now().Add(-time.Hour).Unix())), false},
+		{"Missing exp", idp.sign(t, "RS256", "rsa-1", without("exp")), false},
+		{"Not yet valid", idp.sign(t, "RS256", "rsa-1", with("nbf", time.Now().Add(time.Hour).Unix())), false},
+		{"Wrong issuer", idp.sign(t, "RS256", "rsa-1", with("iss", "https://evil.example.com")), false},
+		{"Wrong audience", idp.sign(t, "RS256", "rsa-1", with("aud", "someone-else")), false},
+		{"Missing subject", idp.sign(t, "RS256", "rsa-1", without("sub")), false},
+		{"Unknown kid", idp.sign(t, "RS256", "rsa-2", idp.claims("user-1")), false},
+		{"Key type mismatch", idp.sign(t, "ES256", "rsa-1", idp.claims("user-1")), false},
+		{"Alg none", idp.sign(t, "none", "rsa-1", idp.claims("user-1")), false},
+		{"HS256 confusion", idp.sign(t, "HS256", "rsa-1", idp.claims("user-1")), false},
+		{"Tampered signature", tampered, false},
+		{"Malformed", "not.a.jwt.at.all", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Verify(t.Context(), tt.token)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("Verify failed: %v", err)
+				}
+				if claims.Subject != "user-1" {
+					t.Errorf("Expected sub user-1, got %q", claims.Subject)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidJWT) {
+				t.Errorf("Expected ErrInvalidJWT, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	idp := newTestIdP(t)
+
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(idp.jwks)
+	}))
+	defer srv.Close()
+
+	v := NewVerifier(NewRemoteJWKS(srv.URL, srv.Client()), testIssuer, testAudience)
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(t.Context(), idp.sign(t, "ES256", "ec-1", idp.claims("user-1"))); err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected JWKS to be fetched once and cached, got %d fetches", n)
+	}
+
+	// Unknown key ids do not hammer the IdP
+	_, _ = v.Verify(t.Context(), idp.sign(t, "RS256", "rotated", idp.claims("user-1")))
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected refetch to be rate limited, got %d fetches", n)
+	}
+}
+
+func TestAuthenticateProvisionsUser(t *testing.T) {
+	idp := newTestIdP(t)
+	store := repotest.NewMemStore()
+
+	var got *Principal
+	handler := Authenticate(store, idp.verifier(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = PrincipalFromContext(r.Context())
+	}))
+
+	call := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := call(idp.sign(t, "RS256", "rsa-1", idp.claims("supabase-123"))); code != http.StatusOK {
+		t.Fatalf("first login: expected 200, got %d", code)
+	}
+	subject := "supabase-123"
+	user, err := store.GetUserByAuthProviderID(t.Context(), &subject)
+	if err != nil {
+		t.Fatalf("Expected user to be provisioned: %v", err)
+	}
+	if got == nil || got.UserID != user.ID || got.Restricted() {
+		t.Errorf("Unexpected principal %+v", got)
+	}
+	if user.Email != "supabase-123@example.com" || user.FullName == nil || *user.FullName != "Ada Lovelace" {
+		t.Errorf("Profile not copied from claims: %+v", user)
+	}
+	if !user.LastLoginAt.Valid {
+		t.Errorf("Expected last_login_at to be set")
+	}
+
+	// Second login reuses the same user
+	if code := call(idp.sign(t, "ES256", "ec-1", idp.claims("supabase-123"))); code != http.StatusOK {
+		t.Fatalf("second login: expected 200, got %d", code)
+	}
+	if got.UserID != user.ID {
+		t.Errorf("Expected the existing user to be reused")
+	}
+
+	// Same email, different identity
+	conflict := idp.claims("other-subject")
+	conflict["email"] = "supabase-123@example.com"
+	if code := call(idp.sign(t, "RS256", "rsa-1", conflict)); code != http.StatusUnauthorized {
+		t.Errorf("identity conflict: expected 401, got %d", code)
+	}
+
+	// API tokens keep working next to JWTs
+	token, _, err := IssueToken(t.Context(), store, IssueParams{UserID: user.ID, Name: "cli"})
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	if code := call(token); code != http.StatusOK {
+		t.Errorf("API token: expected 200, got %d", code)
+	}
+
+	if code := call(idp.sign(t, "RS256", "rsa-1", map[string]any{"iss": testIssuer})); code != http.StatusUnauthorized {
+		t.Errorf("invalid JWT: expected 401, got %d", code)
+	}
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/utils"
+)
+
+// CodeUnauthorized is the error code returned for missing or invalid credentials
+const CodeUnauthorized = "unauthorized"
+
+var (
+	ErrMissingToken = errors.New("auth: missing bearer token")
+	ErrInvalidToken = errors.New("auth: invalid, revoked or expired token")
+	ErrInactiveUser = errors.New("auth: user is inactive")
+)
+
+// RequireToken returns middleware that authenticates requests with an
+// API token only. See Authenticate.
+func RequireToken(q repository.Querier) func(http.Handler) http.Handler {
+	return Authenticate(q, nil)
+}
+
+// Authenticate returns middleware that authenticates requests sent with
+// "Authorization: Bearer <credential>". EnvHub API tokens are looked up by
+// hash; revoked and expired tokens are rejected, and each successful use is
+// recorded in api_tokens.usage_count / last_used_at. Any other bearer
+// credential is treated as an IdP-issued JWT when v is non-nil.
+func Authenticate(q repository.Querier, v *Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, ok := BearerToken(r)
+			if !ok {
+				writeAuthError(w, ErrMissingToken)
+				return
+			}
+
+			var (
+				p   *Principal
+				err error
+			)
+			if LooksLikeToken(raw) || v == nil {
+				p, err = authenticateToken(r.Context(), q, raw)
+			} else {
+				p, err = authenticateJWT(r.Context(), q, v, raw)
+			}
+			if err != nil {
+				writeAuthError(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// authenticateToken resolves an API token to a Principal
+func authenticateToken(ctx context.Context, q repository.Querier, raw string) (*Principal, error) {
+	if !LooksLikeToken(raw) {
+		return nil, ErrInvalidToken
+	}
+
+	token, err := q.GetAPITokenByHash(ctx, HashToken(raw))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	// The query already filters these, but never trust a single check
+	if token.RevokedAt.Valid || (token.ExpiresAt.Valid && !token.ExpiresAt.Time.After(time.Now())) {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := q.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if user.IsActive != nil && !*user.IsActive {
+		return nil, ErrInactiveUser
+	}
+
+	if err := q.UpdateTokenUsage(ctx, token.ID); err != nil {
+		log.Printf("auth: failed to record usage for token %s: %v", token.ID, err)
+	}
+
+	p := &Principal{
+		UserID:  user.ID,
+		TokenID: token.ID,
+		Scopes:  token.Scopes,
+	}
+	if token.OrganizationID.Valid {
+		p.OrganizationID = uuid.UUID(token.OrganizationID.Bytes)
+	}
+	if token.ExpiresAt.Valid {
+		p.ExpiresAt = token.ExpiresAt.Time
+	}
+
+	return p, nil
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer" header
+func BearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// writeAuthError writes a 401 for credential problems and a 500 for storage failures
+func writeAuthError(w http.ResponseWriter, err error) {
+	if !isCredentialError(err) {
+		// Storage failures are logged, never echoed to the client
+		log.Printf("auth: token lookup failed: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="envhub"`)
+	utils.WriteError(w, http.StatusUnauthorized, CodeUnauthorized, err.Error())
+}
+
+// isCredentialError reports whether err is the caller's fault (401)
+// rather than a server-side failure
+func isCredentialError(err error) bool {
+	for _, target := range []error{ErrMissingToken, ErrInvalidToken, ErrInactiveUser, ErrInvalidJWT, ErrIdentityConflict} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
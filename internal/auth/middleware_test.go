@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+)
+
+func TestHashToken(t *testing.T) {
+	token, err := GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	if !LooksLikeToken(token) {
+		t.Errorf("Generated token %q lacks prefix", token)
+	}
+
+	hash := HashToken(token)
+	if len(hash) != 64 {
+		t.Errorf("Expected 64 hex chars, got %d", len(hash))
+	}
+	if hash != HashToken(token) {
+		t.Errorf("HashToken is not deterministic")
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	store := repotest.NewMemStore()
+	orgID := uuid.New()
+	user := store.AddUser(repository.User{Email: "ci@example.com"})
+	inactive := false
+	disabled := store.AddUser(repository.User{Email: "gone@example.com", IsActive: &inactive})
+
+	valid, validToken, err := IssueToken(t.Context(), store, IssueParams{UserID: user.ID, Name: "ci", OrganizationID: orgID, Scopes: []string{"read:secrets"}})
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	revoked, revokedToken, _ := IssueToken(t.Context(), store, IssueParams{UserID: user.ID, Name: "old"})
+	_ = store.RevokeAPIToken(t.Context(), revokedToken.ID)
+	past := time.Now().Add(-time.Minute)
+	expired, _, _ := IssueToken(t.Context(), store, IssueParams{UserID: user.ID, Name: "expired", ExpiresAt: &past})
+	inactiveUser, _, _ := IssueToken(t.Context(), store, IssueParams{UserID: disabled.ID, Name: "inactive"})
+
+	var got *Principal
+	handler := RequireToken(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"Valid", "Bearer " + valid, http.StatusOK},
+		{"Lowercase scheme", "bearer " + valid, http.StatusOK},
+		{"Missing", "", http.StatusUnauthorized},
+		{"Basic auth", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"Unknown", "Bearer ehp_unknown", http.StatusUnauthorized},
+		{"Revoked", "Bearer " + revoked, http.StatusUnauthorized},
+		{"Expired", "Bearer " + expired, http.StatusUnauthorized},
+		{"Inactive user", "Bearer " + inactiveUser, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Expected WWW-Authenticate header on 401")
+			}
+			if tt.status == http.StatusOK {
+				if got == nil || got.UserID != user.ID || got.OrganizationID != orgID || got.TokenID != validToken.ID {
+					t.Errorf("Unexpected principal %+v", got)
+				}
+			}
+		})
+	}
+
+	stored, _ := store.Token(validToken.ID)
+	if *stored.UsageCount != 2 || !stored.LastUsedAt.Valid {
+		t.Errorf("Expected usage to be recorded twice, got %d", *stored.UsageCount)
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// ErrIdentityConflict is returned when a first-time login's email already
+// belongs to a user linked to a different identity
+var ErrIdentityConflict = errors.New("auth: email is already registered to another identity")
+
+// OIDCConfig configures JWT login against an external identity provider
+type OIDCConfig struct {
+	Issuer   string
+	Audience string
+
+	// Exactly one of JWKSURL and JWKSFile must be set
+	JWKSURL  string
+	JWKSFile string
+}
+
+// LoadOIDCConfigFromEnv loads OIDC settings from environment variables.
+// JWT login is disabled (enabled=false) when OIDC_ISSUER is unset.
+func LoadOIDCConfigFromEnv() (cfg OIDCConfig, enabled bool, err error) {
+	cfg = OIDCConfig{
+		Issuer:   os.Getenv("OIDC_ISSUER"),
+		Audience: os.Getenv("OIDC_AUDIENCE"),
+		JWKSURL:  os.Getenv("OIDC_JWKS_URL"),
+		JWKSFile: os.Getenv("OIDC_JWKS_FILE"),
+	}
+	if cfg.Issuer == "" {
+		return cfg, false, nil
+	}
+
+	if cfg.Audience == "" {
+		return cfg, true, fmt.Errorf("OIDC_AUDIENCE is required when OIDC_ISSUER is set")
+	}
+	if (cfg.JWKSURL == "") == (cfg.JWKSFile == "") {
+		return cfg, true, fmt.Errorf("exactly one of OIDC_JWKS_URL and OIDC_JWKS_FILE is required")
+	}
+
+	return cfg, true, nil
+}
+
+// NewVerifierFromConfig builds a Verifier with a remote or file-backed JWKS
+func NewVerifierFromConfig(cfg OIDCConfig) (*Verifier, error) {
+	if cfg.JWKSFile != "" {
+		keys, err := LoadJWKSFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewVerifier(keys, cfg.Issuer, cfg.Audience), nil
+	}
+
+	return NewVerifier(NewRemoteJWKS(cfg.JWKSURL, nil), cfg.Issuer, cfg.Audience), nil
+}
+
+// authenticateJWT verifies an IdP-issued JWT and resolves it to a user,
+// provisioning the user on first login
+func authenticateJWT(ctx context.Context, q repository.Querier, v *Verifier, raw string) (*Principal, error) {
+	claims, err := v.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ProvisionUser(ctx, q, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{UserID: user.ID}, nil
+}
+
+// ProvisionUser returns the user linked to the token's subject, creating it
+// via CreateUser on first login. last_login_at is bumped once per newly
+// issued token (i.e. each time the user signs in with the IdP).
+func ProvisionUser(ctx context.Context, q repository.Querier, claims *Claims) (repository.User, error) {
+	subject := claims.Subject
+
+	user, err := q.GetUserByAuthProviderID(ctx, &subject)
+	if errors.Is(err, pgx.ErrNoRows) {
+		user, err = createUserFromClaims(ctx, q, claims)
+	}
+	if err != nil {
+		return repository.User{}, err
+	}
+
+	if user.IsActive != nil && !*user.IsActive {
+		return repository.User{}, ErrInactiveUser
+	}
+
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	if !user.LastLoginAt.Valid || user.LastLoginAt.Time.Before(issuedAt) {
+		if err := q.UpdateUserLastLogin(ctx, user.ID); err != nil {
+			return repository.User{}, fmt.Errorf("failed to update last login: %w", err)
+		}
+	}
+
+	return user, nil
+}
+
+func createUserFromClaims(ctx context.Context, q repository.Querier, claims *Claims) (repository.User, error) {
+	if claims.Email == "" {
+		return repository.User{}, fmt.Errorf("%w: missing email claim", ErrInvalidJWT)
+	}
+
+	subject := claims.Subject
+	active := true
+	emailVerified := claims.EmailVerified
+
+	arg := repository.CreateUserParams{
+		Email:          claims.Email,
+		AuthProviderID: &subject,
+		IsActive:       &active,
+		EmailVerified:  &emailVerified,
+	}
+	if name := claims.DisplayName(); name != "" {
+		arg.FullName = &name
+	}
+	if avatar := claims.AvatarURL(); avatar != "" {
+		arg.AvatarUrl = &avatar
+	}
+
+	user, err := q.CreateUser(ctx, arg)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		// A concurrent first request may have provisioned the same subject
+		if existing, getErr := q.GetUserByAuthProviderID(ctx, &subject); getErr == nil {
+			return existing, nil
+		}
+		return repository.User{}, ErrIdentityConflict
+	}
+	if err != nil {
+		return repository.User{}, fmt.Errorf("failed to provision user: %w", err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes narrow what an API token may do. The grammar is
+//
+//	scope  = action ":" resource [ ":" target ]
+//	action = "read" | "write"
+//	target = "org/" <uuid>
+//	       | "project/" <uuid>
+//	       | "project/" <uuid> "/env/" <environment name>
+//
+// For example "read:secrets:project/3f2c.../env/production" lets a CI token
+// read only the production secrets of one project. A scope without a target
+// applies to everything the token's owner can reach. A token with no scopes
+// at all is unrestricted.
+
+// Action is the verb half of a scope
+type Action string
+
+const (
+	ActionRead  Action = "read"
+	ActionWrite Action = "write"
+)
+
+// Resource is the noun half of a scope
+type Resource string
+
+const (
+	ResourceSecrets       Resource = "secrets"
+	ResourceEnvironments  Resource = "environments"
+	ResourceProjects      Resource = "projects"
+	ResourceOrganizations Resource = "organizations"
+	ResourceMembers       Resource = "members"
+	ResourceTokens        Resource = "tokens"
+	ResourceAudit         Resource = "audit"
+	ResourceWebhooks      Resource = "webhooks"
+)
+
+// ErrInvalidScope is returned for scopes that do not follow the grammar
+var ErrInvalidScope = errors.New("auth: invalid scope")
+
+var (
+	validActions   = map[Action]bool{ActionRead: true, ActionWrite: true}
+	validResources = map[Resource]bool{
+		ResourceSecrets:       true,
+		ResourceEnvironments:  true,
+		ResourceProjects:      true,
+		ResourceOrganizations: true,
+		ResourceMembers:       true,
+		ResourceTokens:        true,
+		ResourceAudit:         true,
+		ResourceWebhooks:      true,
+	}
+)
+
+// Target identifies the resource a request operates on.
+// Zero fields mean "not applicable".
+type Target struct {
+	OrganizationID uuid.UUID
+	ProjectID      uuid.UUID
+	Environment    string
+}
+
+// Scope is a parsed scope string
+type Scope struct {
+	Action   Action
+	Resource Resource
+	Target
+}
+
+// ParseScope parses a scope string such as "write:secrets:project/<id>"
+func ParseScope(s string) (Scope, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 {
+		return Scope{}, fmt.Errorf("%w %q: expected action:resource", ErrInvalidScope, s)
+	}
+
+	scope := Scope{Action: Action(parts[0]), Resource: Resource(parts[1])}
+	if !validActions[scope.Action] {
+		return Scope{}, fmt.Errorf("%w %q: unknown action %q", ErrInvalidScope, s, parts[0])
+	}
+	if !validResources[scope.Resource] {
+		return Scope{}, fmt.Errorf("%w %q: unknown resource %q", ErrInvalidScope, s, parts[1])
+	}
+	if len(parts) == 2 {
+		return scope, nil
+	}
+
+	target, err := parseTarget(parts[2])
+	if err != nil {
+		return Scope{}, fmt.Errorf("%w %q: %v", ErrInvalidScope, s, err)
+	}
+	scope.Target = target
+
+	return scope, nil
+}
+
+func parseTarget(s string) (Target, error) {
+	segs := strings.Split(s, "/")
+
+	switch {
+	case len(segs) == 2 && segs[0] == "org":
+		id, err := uuid.Parse(segs[1])
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid organization id")
+		}
+		return Target{OrganizationID: id}, nil
+
+	case (len(segs) == 2 || len(segs) == 4) && segs[0] == "project":
+		id, err := uuid.Parse(segs[1])
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid project id")
+		}
+		t := Target{ProjectID: id}
+		if len(segs) == 4 {
+			if segs[2] != "env" || segs[3] == "" {
+				return Target{}, fmt.Errorf("expected project/<id>/env/<name>")
+			}
+			t.Environment = segs[3]
+		}
+		return t, nil
+	}
+
+	return Target{}, fmt.Errorf("unknown target %q", s)
+}
+
+// String formats the scope in its canonical form
+func (s Scope) String() string {
+	base := string(s.Action) + ":" + string(s.Resource)
+
+	switch {
+	case s.ProjectID != uuid.Nil && s.Environment != "":
+		return base + ":project/" + s.ProjectID.String() + "/env/" + s.Environment
+	case s.ProjectID != uuid.Nil:
+		return base + ":project/" + s.ProjectID.String()
+	case s.OrganizationID != uuid.Nil:
+		return base + ":org/" + s.OrganizationID.String()
+	}
+	return base
+}
+
+// Matches reports whether the scope grants action on resource at target
+func (s Scope) Matches(action Action, resource Resource, target Target) bool {
+	return s.Covers(Scope{Action: action, Resource: resource, Target: target})
+}
+
+// Covers reports whether s grants at least everything other grants
+func (s Scope) Covers(other Scope) bool {
+	if s.Action != other.Action || s.Resource != other.Resource {
+		return false
+	}
+	if s.OrganizationID != uuid.Nil && s.OrganizationID != other.OrganizationID {
+		return false
+	}
+	if s.ProjectID != uuid.Nil && s.ProjectID != other.ProjectID {
+		return false
+	}
+	if s.Environment != "" && s.Environment != other.Environment {
+		return false
+	}
+	return true
+}
+
+// ParseScopes parses and canonicalises a list of scope strings
+func ParseScopes(raw []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(raw))
+	for _, r := range raw {
+		scope, err := ParseScope(strings.TrimSpace(r))
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// Restricted reports whether the principal is limited by scopes
+func (p *Principal) Restricted() bool {
+	return len(p.Scopes) > 0
+}
+
+// Allows reports whether the principal's credential permits action on
+// resource at target. Organization-bound tokens never reach other
+// organizations. Scopes that fail to parse grant nothing.
+func (p *Principal) Allows(action Action, resource Resource, target Target) bool {
+	if p.OrganizationID != uuid.Nil && target.OrganizationID != uuid.Nil && p.OrganizationID != target.OrganizationID {
+		return false
+	}
+	if !p.Restricted() {
+		return true
+	}
+
+	for _, raw := range p.Scopes {
+		scope, err := ParseScope(raw)
+		if err != nil {
+			continue
+		}
+		if scope.Matches(action, resource, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanDelegate reports whether the principal may mint a token with the
+// requested scopes. Restricted principals cannot mint unrestricted tokens
+// or widen their own scopes.
+func (p *Principal) CanDelegate(requested []Scope) bool {
+	if !p.Restricted() {
+		return true
+	}
+	if len(requested) == 0 {
+		return false
+	}
+
+	held := make([]Scope, 0, len(p.Scopes))
+	for _, raw := range p.Scopes {
+		if scope, err := ParseScope(raw); err == nil {
+			held = append(held, scope)
+		}
+	}
+
+	for _, want := range requested {
+		covered := false
+		for _, have := range held {
+			if have.Covers(want) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// CanDelegateUntil reports whether the principal may mint a token that
+// expires at expiresAt, or never if it is nil. A token cannot outlive the
+// credential that minted it.
+func (p *Principal) CanDelegateUntil(expiresAt *time.Time) bool {
+	if p.ExpiresAt.IsZero() {
+		return true
+	}
+	return expiresAt != nil && !expiresAt.After(p.ExpiresAt)
+}
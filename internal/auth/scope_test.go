@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseScope(t *testing.T) {
+	projectID := uuid.New()
+	orgID := uuid.New()
+
+	tests := []struct {
+		name    string
+		input   string
+		want    Scope
+		wantErr bool
+	}{
+		{"Unscoped", "read:secrets", Scope{Action: ActionRead, Resource: ResourceSecrets}, false},
+		{"Organization", "write:projects:org/" + orgID.String(), Scope{Action: ActionWrite, Resource: ResourceProjects, Target: Target{OrganizationID: orgID}}, false},
+		{"Project", "read:secrets:project/" + projectID.String(), Scope{Action: ActionRead, Resource: ResourceSecrets, Target: Target{ProjectID: projectID}}, false},
+		{"Environment", "read:secrets:project/" + projectID.String() + "/env/production", Scope{Action: ActionRead, Resource: ResourceSecrets, Target: Target{ProjectID: projectID, Environment: "production"}}, false},
+		{"Unknown action", "delete:secrets", Scope{}, true},
+		{"Unknown resource", "read:users", Scope{}, true},
+		{"Missing resource", "read", Scope{}, true},
+		{"Bad org id", "read:secrets:org/acme", Scope{}, true},
+		{"Bad target", "read:secrets:team/" + orgID.String(), Scope{}, true},
+		{"Empty env", "read:secrets:project/" + projectID.String() + "/env/", Scope{}, true},
+		{"Env without project", "read:secrets:env/production", Scope{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScope(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidScope) {
+					t.Fatalf("Expected ErrInvalidScope, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseScope failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+			if got.String() != tt.input {
+				t.Errorf("String() = %q, want %q", got.String(), tt.input)
+			}
+		})
+	}
+}
+
+func TestPrincipalAllows(t *testing.T) {
+	orgID, otherOrg := uuid.New(), uuid.New()
+	projectID, otherProject := uuid.New(), uuid.New()
+	prod := Target{OrganizationID: orgID, ProjectID: projectID, Environment: "production"}
+	staging := Target{OrganizationID: orgID, ProjectID: projectID, Environment: "staging"}
+	elsewhere := Target{OrganizationID: orgID, ProjectID: otherProject, Environment: "production"}
+
+	ciToken := &Principal{Scopes: []string{"read:secrets:project/" + projectID.String() + "/env/production"}}
+	orgToken := &Principal{OrganizationID: orgID}
+
+	tests := []struct {
+		name     string
+		p        *Principal
+		action   Action
+		resource Resource
+		target   Target
+		want     bool
+	}{
+		{"Unrestricted", &Principal{}, ActionWrite, ResourceSecrets, prod, true},
+		{"CI reads production", ciToken, ActionRead, ResourceSecrets, prod, true},
+		{"CI cannot read staging", ciToken, ActionRead, ResourceSecrets, staging, false},
+		{"CI cannot read other project", ciToken, ActionRead, ResourceSecrets, elsewhere, false},
+		{"CI cannot write", ciToken, ActionWrite, ResourceSecrets, prod, false},
+		{"CI cannot manage tokens", ciToken, ActionWrite, ResourceTokens, Target{}, false},
+		{"Org token in its org", orgToken, ActionWrite, ResourceSecrets, prod, true},
+		{"Org token outside its org", orgToken, ActionRead, ResourceSecrets, Target{OrganizationID: otherOrg}, false},
+		{"Invalid scopes grant nothing", &Principal{Scopes: []string{"bogus"}}, ActionRead, ResourceSecrets, prod, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Allows(tt.action, tt.resource, tt.target); got != tt.want {
+				t.Errorf("Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalCanDelegate(t *testing.T) {
+	projectID := uuid.New()
+	project, _ := ParseScope("read:secrets:project/" + projectID.String())
+	env, _ := ParseScope("read:secrets:project/" + projectID.String() + "/env/dev")
+	broad, _ := ParseScope("read:secrets")
+
+	p := &Principal{Scopes: []string{project.String()}}
+
+	if !p.CanDelegate([]Scope{env}) {
+		t.Errorf("Expected narrower scope to be delegable")
+	}
+	if p.CanDelegate([]Scope{broad}) {
+		t.Errorf("Expected broader scope to be refused")
+	}
+	if p.CanDelegate(nil) {
+		t.Errorf("Expected restricted principal to be unable to mint unrestricted tokens")
+	}
+	if !(&Principal{}).CanDelegate([]Scope{broad}) {
+		t.Errorf("Expected unrestricted principal to delegate anything")
+	}
+}
+
+func TestPrincipalCanDelegateUntil(t *testing.T) {
+	expiry := time.Now().Add(24 * time.Hour)
+	earlier := expiry.Add(-time.Hour)
+	later := expiry.Add(time.Hour)
+
+	p := &Principal{ExpiresAt: expiry}
+
+	if !p.CanDelegateUntil(&earlier) {
+		t.Errorf("Expected earlier expiry to be delegable")
+	}
+	if !p.CanDelegateUntil(&expiry) {
+		t.Errorf("Expected matching expiry to be delegable")
+	}
+	if p.CanDelegateUntil(&later) {
+		t.Errorf("Expected later expiry to be refused")
+	}
+	if p.CanDelegateUntil(nil) {
+		t.Errorf("Expected expiring principal to be unable to mint non-expiring tokens")
+	}
+	if !(&Principal{}).CanDelegateUntil(nil) {
+		t.Errorf("Expected non-expiring principal to mint non-expiring tokens")
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// TokenPrefix identifies EnvHub API tokens (helps secret scanners)
+const TokenPrefix = "ehp_"
+
+// tokenEntropyBytes is the number of random bytes in a token
+const tokenEntropyBytes = 32
+
+// GenerateToken creates a new random API token.
+// Only its hash should ever be persisted.
+func GenerateToken() (string, error) {
+	b := make([]byte, tokenEntropyBytes)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 hash stored in api_tokens.token_hash
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// LooksLikeToken reports whether s has the shape of an EnvHub API token
+func LooksLikeToken(s string) bool {
+	return strings.HasPrefix(s, TokenPrefix) && len(s) > len(TokenPrefix)
+}
+
+// IssueParams describes a new API token
+type IssueParams struct {
+	UserID         uuid.UUID
+	Name           string
+	Scopes         []string
+	OrganizationID uuid.UUID
+	ExpiresAt      *time.Time
+}
+
+// IssueToken mints a token, stores only its hash via CreateAPIToken,
+// and returns the plaintext, which must be shown to the user exactly once
+func IssueToken(ctx context.Context, q repository.Querier, p IssueParams) (string, repository.ApiToken, error) {
+	plaintext, err := GenerateToken()
+	if err != nil {
+		return "", repository.ApiToken{}, err
+	}
+
+	arg := repository.CreateAPITokenParams{
+		UserID:    p.UserID,
+		Name:      p.Name,
+		TokenHash: HashToken(plaintext),
+		Scopes:    p.Scopes,
+	}
+	if p.OrganizationID != uuid.Nil {
+		arg.OrganizationID = pgtype.UUID{Bytes: p.OrganizationID, Valid: true}
+	}
+	if p.ExpiresAt != nil {
+		arg.ExpiresAt = pgtype.Timestamptz{Time: *p.ExpiresAt, Valid: true}
+	}
+
+	token, err := q.CreateAPIToken(ctx, arg)
+	if err != nil {
+		return "", repository.ApiToken{}, fmt.Errorf("failed to store token: %w", err)
+	}
+
+	return plaintext, token, nil
+}
@@ -0,0 +1,175 @@
+// Package policy decides what each organization role may do.
+//
+// Roles come from organization_members.role (the org_role enum). Handlers
+// look up the caller's role in the resource's organization and ask the
+// policy before touching the repository.
+package policy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Now-Tiger/envhub/internal/auth"
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// ErrForbidden is returned when a role is not allowed to perform an action
+var ErrForbidden = errors.New("policy: action not permitted for role")
+
+// Action is an operation on a resource type
+type Action string
+
+const (
+	OrganizationRead   Action = "organization:read"
+	OrganizationUpdate Action = "organization:update"
+	OrganizationDelete Action = "organization:delete"
+
+	ProjectRead      Action = "project:read"
+	ProjectCreate    Action = "project:create"
+	ProjectUpdate    Action = "project:update"
+	ProjectDelete    Action = "project:delete"
+	ProjectRotateKey Action = "project:rotate_key"
+
+	EnvironmentRead   Action = "environment:read"
+	EnvironmentCreate Action = "environment:create"
+	EnvironmentUpdate Action = "environment:update"
+	EnvironmentDelete Action = "environment:delete"
+
+	SecretRead   Action = "secret:read"
+	SecretCreate Action = "secret:create"
+	SecretUpdate Action = "secret:update"
+	SecretDelete Action = "secret:delete"
+
+	// ChangeRequestApprove covers approving and rejecting changes to
+	// protected environments
+	ChangeRequestApprove Action = "change_request:approve"
+
+	TokenRead   Action = "token:read"
+	TokenCreate Action = "token:create"
+	TokenRevoke Action = "token:revoke"
+
+	MemberRead   Action = "member:read"
+	MemberInvite Action = "member:invite"
+	MemberUpdate Action = "member:update"
+	MemberRemove Action = "member:remove"
+
+	// AuditRead covers searching and exporting an organization's access
+	// logs and secret history
+	AuditRead Action = "audit:read"
+
+	// WebhookRead covers listing an organization's webhooks and their
+	// deliveries; WebhookManage covers changing them and redelivering
+	WebhookRead   Action = "webhook:read"
+	WebhookManage Action = "webhook:manage"
+)
+
+// AllActions returns every action known to the policy
+func AllActions() []Action {
+	return []Action{
+		OrganizationRead, OrganizationUpdate, OrganizationDelete,
+		ProjectRead, ProjectCreate, ProjectUpdate, ProjectDelete, ProjectRotateKey,
+		EnvironmentRead, EnvironmentCreate, EnvironmentUpdate, EnvironmentDelete,
+		SecretRead, SecretCreate, SecretUpdate, SecretDelete,
+		ChangeRequestApprove,
+		TokenRead, TokenCreate, TokenRevoke,
+		MemberRead, MemberInvite, MemberUpdate, MemberRemove,
+		AuditRead,
+		WebhookRead, WebhookManage,
+	}
+}
+
+// readOnly are the actions every member of an organization may perform
+var readOnly = []Action{
+	OrganizationRead,
+	ProjectRead,
+	EnvironmentRead,
+	SecretRead,
+	TokenRead, TokenCreate, TokenRevoke,
+	MemberRead,
+}
+
+// contributor adds day-to-day write access to projects and secrets
+var contributor = append(append([]Action{}, readOnly...),
+	ProjectCreate, ProjectUpdate,
+	EnvironmentCreate, EnvironmentUpdate,
+	SecretCreate, SecretUpdate, SecretDelete,
+)
+
+// administrator adds destructive and membership actions
+var administrator = append(append([]Action{}, contributor...),
+	OrganizationUpdate,
+	ProjectDelete, ProjectRotateKey,
+	EnvironmentDelete,
+	ChangeRequestApprove,
+	MemberInvite, MemberUpdate, MemberRemove,
+	AuditRead,
+	WebhookRead, WebhookManage,
+)
+
+// rolePermissions maps each role to its allowed actions
+var rolePermissions = map[repository.OrgRole]map[Action]bool{
+	repository.OrgRoleViewer: set(readOnly),
+	repository.OrgRoleMember: set(contributor),
+	repository.OrgRoleAdmin:  set(administrator),
+	repository.OrgRoleOwner:  set(append(append([]Action{}, administrator...), OrganizationDelete)),
+}
+
+func set(actions []Action) map[Action]bool {
+	m := make(map[Action]bool, len(actions))
+	for _, a := range actions {
+		m[a] = true
+	}
+	return m
+}
+
+// Can reports whether role may perform action.
+// Unknown roles and actions are denied.
+func Can(role repository.OrgRole, action Action) bool {
+	return rolePermissions[role][action]
+}
+
+// Authorize returns ErrForbidden if role may not perform action
+func Authorize(role repository.OrgRole, action Action) error {
+	if !Can(role, action) {
+		return fmt.Errorf("%w: %s cannot %s", ErrForbidden, role, action)
+	}
+	return nil
+}
+
+// Scope returns the token scope (action and resource) that guards action,
+// so handlers can check role and token scope with a single name
+func Scope(action Action) (auth.Action, auth.Resource) {
+	switch action {
+	case OrganizationRead:
+		return auth.ActionRead, auth.ResourceOrganizations
+	case OrganizationUpdate, OrganizationDelete:
+		return auth.ActionWrite, auth.ResourceOrganizations
+	case ProjectRead:
+		return auth.ActionRead, auth.ResourceProjects
+	case ProjectCreate, ProjectUpdate, ProjectDelete, ProjectRotateKey:
+		return auth.ActionWrite, auth.ResourceProjects
+	case EnvironmentRead:
+		return auth.ActionRead, auth.ResourceEnvironments
+	case EnvironmentCreate, EnvironmentUpdate, EnvironmentDelete:
+		return auth.ActionWrite, auth.ResourceEnvironments
+	case SecretRead:
+		return auth.ActionRead, auth.ResourceSecrets
+	case SecretCreate, SecretUpdate, SecretDelete, ChangeRequestApprove:
+		return auth.ActionWrite, auth.ResourceSecrets
+	case TokenRead:
+		return auth.ActionRead, auth.ResourceTokens
+	case TokenCreate, TokenRevoke:
+		return auth.ActionWrite, auth.ResourceTokens
+	case MemberRead:
+		return auth.ActionRead, auth.ResourceMembers
+	case MemberInvite, MemberUpdate, MemberRemove:
+		return auth.ActionWrite, auth.ResourceMembers
+	case AuditRead:
+		return auth.ActionRead, auth.ResourceAudit
+	case WebhookRead:
+		return auth.ActionRead, auth.ResourceWebhooks
+	case WebhookManage:
+		return auth.ActionWrite, auth.ResourceWebhooks
+	}
+	return "", ""
+}
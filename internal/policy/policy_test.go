@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// matrix is the expected permission for every role × action pair.
+// Columns: owner, admin, member, viewer.
+var matrix = map[Action][4]bool{
+	OrganizationRead:   {true, true, true, true},
+	OrganizationUpdate: {true, true, false, false},
+	OrganizationDelete: {true, false, false, false},
+
+	ProjectRead:      {true, true, true, true},
+	ProjectCreate:    {true, true, true, false},
+	ProjectUpdate:    {true, true, true, false},
+	ProjectDelete:    {true, true, false, false},
+	ProjectRotateKey: {true, true, false, false},
+
+	EnvironmentRead:   {true, true, true, true},
+	EnvironmentCreate: {true, true, true, false},
+	EnvironmentUpdate: {true, true, true, false},
+	EnvironmentDelete: {true, true, false, false},
+
+	SecretRead:   {true, true, true, true},
+	SecretCreate: {true, true, true, false},
+	SecretUpdate: {true, true, true, false},
+	SecretDelete: {true, true, true, false},
+
+	ChangeRequestApprove: {true, true, false, false},
+
+	TokenRead:   {true, true, true, true},
+	TokenCreate: {true, true, true, true},
+	TokenRevoke: {true, true, true, true},
+
+	MemberRead:   {true, true, true, true},
+	MemberInvite: {true, true, false, false},
+	MemberUpdate: {true, true, false, false},
+	MemberRemove: {true, true, false, false},
+
+	AuditRead: {true, true, false, false},
+
+	WebhookRead:   {true, true, false, false},
+	WebhookManage: {true, true, false, false},
+}
+
+var roles = [4]repository.OrgRole{
+	repository.OrgRoleOwner,
+	repository.OrgRoleAdmin,
+	repository.OrgRoleMember,
+	repository.OrgRoleViewer,
+}
+
+func TestRoleActionMatrix(t *testing.T) {
+	for _, action := range AllActions() {
+		want, ok := matrix[action]
+		if !ok {
+			t.Errorf("action %s missing from test matrix", action)
+			continue
+		}
+
+		for i, role := range roles {
+			t.Run(string(role)+"/"+string(action), func(t *testing.T) {
+				if got := Can(role, action); got != want[i] {
+					t.Errorf("Can(%s, %s) = %v, want %v", role, action, got, want[i])
+				}
+
+				err := Authorize(role, action)
+				if want[i] && err != nil {
+					t.Errorf("Authorize returned unexpected error: %v", err)
+				}
+				if !want[i] && !errors.Is(err, ErrForbidden) {
+					t.Errorf("Expected ErrForbidden, got %v", err)
+				}
+			})
+		}
+	}
+
+	if len(matrix) != len(AllActions()) {
+		t.Errorf("matrix has %d actions, policy has %d", len(matrix), len(AllActions()))
+	}
+}
+
+func TestUnknownRoleAndAction(t *testing.T) {
+	if Can(repository.OrgRole("superuser"), SecretRead) {
+		t.Errorf("Expected unknown role to be denied")
+	}
+	if Can(repository.OrgRoleOwner, Action("secret:exfiltrate")) {
+		t.Errorf("Expected unknown action to be denied")
+	}
+}
+
+func TestEveryActionHasScope(t *testing.T) {
+	for _, action := range AllActions() {
+		verb, resource := Scope(action)
+		if verb == "" || resource == "" {
+			t.Errorf("action %s has no token scope", action)
+		}
+	}
+}
+
+func TestRoleHierarchy(t *testing.T) {
+	// Each role must be able to do everything the role below it can
+	for _, action := range AllActions() {
+		for i := 0; i < len(roles)-1; i++ {
+			if Can(roles[i+1], action) && !Can(roles[i], action) {
+				t.Errorf("%s can %s but %s cannot", roles[i+1], action, roles[i])
+			}
+		}
+	}
+}
@@ -8,6 +8,7 @@ package repository
 import (
 	"context"
 	"net/netip"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -23,22 +24,26 @@ INSERT INTO access_logs (
     ip_address,
     user_agent,
     success,
-    error_message
+    error_message,
+    created_at,
+    organization_id
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9
-) RETURNING id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+) RETURNING id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message, organization_id, chain_seq, prev_hash, row_hash
 `
 
 type CreateAccessLogParams struct {
-	UserID       pgtype.UUID  `json:"user_id"`
-	ApiTokenID   pgtype.UUID  `json:"api_token_id"`
-	ResourceType string       `json:"resource_type"`
-	ResourceID   uuid.UUID    `json:"resource_id"`
-	Action       AccessAction `json:"action"`
-	IpAddress    *netip.Addr  `json:"ip_address"`
-	UserAgent    *string      `json:"user_agent"`
-	Success      bool         `json:"success"`
-	ErrorMessage *string      `json:"error_message"`
+	UserID         pgtype.UUID  `json:"user_id"`
+	ApiTokenID     pgtype.UUID  `json:"api_token_id"`
+	ResourceType   string       `json:"resource_type"`
+	ResourceID     uuid.UUID    `json:"resource_id"`
+	Action         AccessAction `json:"action"`
+	IpAddress      *netip.Addr  `json:"ip_address"`
+	UserAgent      *string      `json:"user_agent"`
+	Success        bool         `json:"success"`
+	ErrorMessage   *string      `json:"error_message"`
+	CreatedAt      time.Time    `json:"created_at"`
+	OrganizationID pgtype.UUID  `json:"organization_id"`
 }
 
 func (q *Queries) CreateAccessLog(ctx context.Context, arg CreateAccessLogParams) (AccessLog, error) {
@@ -52,6 +57,8 @@ func (q *Queries) CreateAccessLog(ctx context.Context, arg CreateAccessLogParams
 		arg.UserAgent,
 		arg.Success,
 		arg.ErrorMessage,
+		arg.CreatedAt,
+		arg.OrganizationID,
 	)
 	var i AccessLog
 	err := row.Scan(
@@ -66,31 +73,58 @@ func (q *Queries) CreateAccessLog(ctx context.Context, arg CreateAccessLogParams
 		&i.UserAgent,
 		&i.Success,
 		&i.ErrorMessage,
+		&i.OrganizationID,
+		&i.ChainSeq,
+		&i.PrevHash,
+		&i.RowHash,
 	)
 	return i, err
 }
 
-const ListAccessLogsByResource = `-- name: ListAccessLogsByResource :many
-SELECT id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message FROM access_logs
-WHERE resource_type = $1 AND resource_id = $2
-ORDER BY created_at DESC
-LIMIT $3 OFFSET $4
+const GetAccessLogChainHead = `-- name: GetAccessLogChainHead :one
+SELECT id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message, organization_id, chain_seq, prev_hash, row_hash FROM access_logs
+WHERE chain_seq IS NOT NULL
+ORDER BY chain_seq DESC
+LIMIT 1
 `
 
-type ListAccessLogsByResourceParams struct {
-	ResourceType string    `json:"resource_type"`
-	ResourceID   uuid.UUID `json:"resource_id"`
-	Limit        int32     `json:"limit"`
-	Offset       int32     `json:"offset"`
+func (q *Queries) GetAccessLogChainHead(ctx context.Context) (AccessLog, error) {
+	row := q.db.QueryRow(ctx, GetAccessLogChainHead)
+	var i AccessLog
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ApiTokenID,
+		&i.ResourceType,
+		&i.ResourceID,
+		&i.Action,
+		&i.CreatedAt,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.Success,
+		&i.ErrorMessage,
+		&i.OrganizationID,
+		&i.ChainSeq,
+		&i.PrevHash,
+		&i.RowHash,
+	)
+	return i, err
 }
 
-func (q *Queries) ListAccessLogsByResource(ctx context.Context, arg ListAccessLogsByResourceParams) ([]AccessLog, error) {
-	rows, err := q.db.Query(ctx, ListAccessLogsByResource,
-		arg.ResourceType,
-		arg.ResourceID,
-		arg.Limit,
-		arg.Offset,
-	)
+const ListAccessLogChain = `-- name: ListAccessLogChain :many
+SELECT id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message, organization_id, chain_seq, prev_hash, row_hash FROM access_logs
+WHERE chain_seq > $1::bigint
+ORDER BY chain_seq
+LIMIT $2
+`
+
+type ListAccessLogChainParams struct {
+	After int64 `json:"after"`
+	Limit int32 `json:"limit"`
+}
+
+func (q *Queries) ListAccessLogChain(ctx context.Context, arg ListAccessLogChainParams) ([]AccessLog, error) {
+	rows, err := q.db.Query(ctx, ListAccessLogChain, arg.After, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +144,10 @@ func (q *Queries) ListAccessLogsByResource(ctx context.Context, arg ListAccessLo
 			&i.UserAgent,
 			&i.Success,
 			&i.ErrorMessage,
+			&i.OrganizationID,
+			&i.ChainSeq,
+			&i.PrevHash,
+			&i.RowHash,
 		); err != nil {
 			return nil, err
 		}
@@ -121,21 +159,15 @@ func (q *Queries) ListAccessLogsByResource(ctx context.Context, arg ListAccessLo
 	return items, nil
 }
 
-const ListAccessLogsByUser = `-- name: ListAccessLogsByUser :many
-SELECT id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message FROM access_logs
-WHERE user_id = $1
-ORDER BY created_at DESC
-LIMIT $2 OFFSET $3
+const ListUnsealedAccessLogs = `-- name: ListUnsealedAccessLogs :many
+SELECT id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message, organization_id, chain_seq, prev_hash, row_hash FROM access_logs
+WHERE chain_seq IS NULL
+ORDER BY created_at, id
+LIMIT $1
 `
 
-type ListAccessLogsByUserParams struct {
-	UserID pgtype.UUID `json:"user_id"`
-	Limit  int32       `json:"limit"`
-	Offset int32       `json:"offset"`
-}
-
-func (q *Queries) ListAccessLogsByUser(ctx context.Context, arg ListAccessLogsByUserParams) ([]AccessLog, error) {
-	rows, err := q.db.Query(ctx, ListAccessLogsByUser, arg.UserID, arg.Limit, arg.Offset)
+func (q *Queries) ListUnsealedAccessLogs(ctx context.Context, limit int32) ([]AccessLog, error) {
+	rows, err := q.db.Query(ctx, ListUnsealedAccessLogs, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +187,10 @@ func (q *Queries) ListAccessLogsByUser(ctx context.Context, arg ListAccessLogsBy
 			&i.UserAgent,
 			&i.Success,
 			&i.ErrorMessage,
+			&i.OrganizationID,
+			&i.ChainSeq,
+			&i.PrevHash,
+			&i.RowHash,
 		); err != nil {
 			return nil, err
 		}
@@ -166,20 +202,79 @@ func (q *Queries) ListAccessLogsByUser(ctx context.Context, arg ListAccessLogsBy
 	return items, nil
 }
 
-const ListFailedAccessLogs = `-- name: ListFailedAccessLogs :many
-SELECT id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message FROM access_logs
-WHERE success = false
-ORDER BY created_at DESC
-LIMIT $1 OFFSET $2
+const SealAccessLog = `-- name: SealAccessLog :execrows
+UPDATE access_logs
+SET chain_seq = $1::bigint,
+    prev_hash = $2,
+    row_hash = $3
+WHERE id = $4 AND chain_seq IS NULL
+`
+
+type SealAccessLogParams struct {
+	ChainSeq int64     `json:"chain_seq"`
+	PrevHash []byte    `json:"prev_hash"`
+	RowHash  []byte    `json:"row_hash"`
+	ID       uuid.UUID `json:"id"`
+}
+
+func (q *Queries) SealAccessLog(ctx context.Context, arg SealAccessLogParams) (int64, error) {
+	result, err := q.db.Exec(ctx, SealAccessLog,
+		arg.ChainSeq,
+		arg.PrevHash,
+		arg.RowHash,
+		arg.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const SearchAccessLogs = `-- name: SearchAccessLogs :many
+SELECT id, user_id, api_token_id, resource_type, resource_id, action, created_at, ip_address, user_agent, success, error_message, organization_id, chain_seq, prev_hash, row_hash FROM access_logs
+WHERE organization_id = $1::uuid
+  AND ($2::uuid IS NULL OR user_id = $2)
+  AND ($3::text IS NULL OR resource_type = $3)
+  AND ($4::uuid IS NULL OR resource_id = $4)
+  AND ($5::access_action IS NULL OR action = $5)
+  AND ($6::boolean IS NULL OR success = $6)
+  AND ($7::timestamptz IS NULL OR created_at >= $7)
+  AND ($8::timestamptz IS NULL OR created_at < $8)
+  AND (
+      $9::uuid IS NULL
+      OR (created_at, id) < (SELECT b.created_at, b.id FROM access_logs b WHERE b.id = $9)
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT $10
 `
 
-type ListFailedAccessLogsParams struct {
-	Limit  int32 `json:"limit"`
-	Offset int32 `json:"offset"`
+type SearchAccessLogsParams struct {
+	OrganizationID uuid.UUID          `json:"organization_id"`
+	UserID         pgtype.UUID        `json:"user_id"`
+	ResourceType   *string            `json:"resource_type"`
+	ResourceID     pgtype.UUID        `json:"resource_id"`
+	Action         NullAccessAction   `json:"action"`
+	Success        *bool              `json:"success"`
+	Since          pgtype.Timestamptz `json:"since"`
+	Until          pgtype.Timestamptz `json:"until"`
+	Before         pgtype.UUID        `json:"before"`
+	Limit          int32              `json:"limit"`
 }
 
-func (q *Queries) ListFailedAccessLogs(ctx context.Context, arg ListFailedAccessLogsParams) ([]AccessLog, error) {
-	rows, err := q.db.Query(ctx, ListFailedAccessLogs, arg.Limit, arg.Offset)
+// Newest first; page with before = the id of the last entry seen
+func (q *Queries) SearchAccessLogs(ctx context.Context, arg SearchAccessLogsParams) ([]AccessLog, error) {
+	rows, err := q.db.Query(ctx, SearchAccessLogs,
+		arg.OrganizationID,
+		arg.UserID,
+		arg.ResourceType,
+		arg.ResourceID,
+		arg.Action,
+		arg.Success,
+		arg.Since,
+		arg.Until,
+		arg.Before,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -199,6 +294,10 @@ func (q *Queries) ListFailedAccessLogs(ctx context.Context, arg ListFailedAccess
 			&i.UserAgent,
 			&i.Success,
 			&i.ErrorMessage,
+			&i.OrganizationID,
+			&i.ChainSeq,
+			&i.PrevHash,
+			&i.RowHash,
 		); err != nil {
 			return nil, err
 		}
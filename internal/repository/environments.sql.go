@@ -9,6 +9,7 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 const CreateEnvironment = `-- name: CreateEnvironment :one
@@ -17,18 +18,24 @@ INSERT INTO environments (
     name,
     description,
     is_protected,
-    color
+    color,
+    required_approvals,
+    parent_id,
+    expires_at
 ) VALUES (
-    $1, $2, $3, $4, $5
-) RETURNING id, project_id, name, description, is_protected, color, created_at, updated_at
+    $1, $2, $3, $4, $5, $6, $7, $8
+) RETURNING id, project_id, name, description, is_protected, color, created_at, updated_at, required_approvals, parent_id, expires_at
 `
 
 type CreateEnvironmentParams struct {
-	ProjectID   uuid.UUID `json:"project_id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description"`
-	IsProtected *bool     `json:"is_protected"`
-	Color       *string   `json:"color"`
+	ProjectID         uuid.UUID          `json:"project_id"`
+	Name              string             `json:"name"`
+	Description       *string            `json:"description"`
+	IsProtected       *bool              `json:"is_protected"`
+	Color             *string            `json:"color"`
+	RequiredApprovals int32              `json:"required_approvals"`
+	ParentID          pgtype.UUID        `json:"parent_id"`
+	ExpiresAt         pgtype.Timestamptz `json:"expires_at"`
 }
 
 func (q *Queries) CreateEnvironment(ctx context.Context, arg CreateEnvironmentParams) (Environment, error) {
@@ -38,6 +45,9 @@ func (q *Queries) CreateEnvironment(ctx context.Context, arg CreateEnvironmentPa
 		arg.Description,
 		arg.IsProtected,
 		arg.Color,
+		arg.RequiredApprovals,
+		arg.ParentID,
+		arg.ExpiresAt,
 	)
 	var i Environment
 	err := row.Scan(
@@ -49,6 +59,9 @@ func (q *Queries) CreateEnvironment(ctx context.Context, arg CreateEnvironmentPa
 		&i.Color,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RequiredApprovals,
+		&i.ParentID,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
@@ -64,7 +77,7 @@ func (q *Queries) DeleteEnvironment(ctx context.Context, id uuid.UUID) error {
 }
 
 const GetEnvironmentByID = `-- name: GetEnvironmentByID :one
-SELECT id, project_id, name, description, is_protected, color, created_at, updated_at FROM environments
+SELECT id, project_id, name, description, is_protected, color, created_at, updated_at, required_approvals, parent_id, expires_at FROM environments
 WHERE id = $1
 LIMIT 1
 `
@@ -81,12 +94,15 @@ func (q *Queries) GetEnvironmentByID(ctx context.Context, id uuid.UUID) (Environ
 		&i.Color,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RequiredApprovals,
+		&i.ParentID,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
 const GetEnvironmentByName = `-- name: GetEnvironmentByName :one
-SELECT id, project_id, name, description, is_protected, color, created_at, updated_at FROM environments
+SELECT id, project_id, name, description, is_protected, color, created_at, updated_at, required_approvals, parent_id, expires_at FROM environments
 WHERE project_id = $1 AND name = $2
 LIMIT 1
 `
@@ -108,12 +124,15 @@ func (q *Queries) GetEnvironmentByName(ctx context.Context, arg GetEnvironmentBy
 		&i.Color,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RequiredApprovals,
+		&i.ParentID,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
 const ListEnvironmentsByProject = `-- name: ListEnvironmentsByProject :many
-SELECT id, project_id, name, description, is_protected, color, created_at, updated_at FROM environments
+SELECT id, project_id, name, description, is_protected, color, created_at, updated_at, required_approvals, parent_id, expires_at FROM environments
 WHERE project_id = $1
 ORDER BY created_at ASC
 `
@@ -136,6 +155,62 @@ func (q *Queries) ListEnvironmentsByProject(ctx context.Context, projectID uuid.
 			&i.Color,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.RequiredApprovals,
+			&i.ParentID,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListExpiredEnvironments = `-- name: ListExpiredEnvironments :many
+SELECT e.id, e.project_id, e.name, e.expires_at, p.organization_id
+FROM environments e
+JOIN projects p ON p.id = e.project_id
+WHERE e.expires_at <= NOW()
+  AND NOT EXISTS (SELECT 1 FROM environments c WHERE c.parent_id = e.id)
+  AND NOT EXISTS (
+      SELECT 1 FROM dynamic_secret_leases l
+      JOIN dynamic_secrets d ON d.id = l.dynamic_secret_id
+      WHERE d.environment_id = e.id AND l.revoked_at IS NULL
+  )
+ORDER BY e.expires_at
+LIMIT $1
+FOR UPDATE OF e SKIP LOCKED
+`
+
+type ListExpiredEnvironmentsRow struct {
+	ID             uuid.UUID          `json:"id"`
+	ProjectID      uuid.UUID          `json:"project_id"`
+	Name           string             `json:"name"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	OrganizationID uuid.UUID          `json:"organization_id"`
+}
+
+// Environments still inherited from, or with dynamic credentials not yet
+// revoked, are skipped until those are gone. Rows another transaction holds
+// are skipped rather than waited for.
+func (q *Queries) ListExpiredEnvironments(ctx context.Context, limit int32) ([]ListExpiredEnvironmentsRow, error) {
+	rows, err := q.db.Query(ctx, ListExpiredEnvironments, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListExpiredEnvironmentsRow{}
+	for rows.Next() {
+		var i ListExpiredEnvironmentsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProjectID,
+			&i.Name,
+			&i.ExpiresAt,
+			&i.OrganizationID,
 		); err != nil {
 			return nil, err
 		}
@@ -147,6 +222,52 @@ func (q *Queries) ListEnvironmentsByProject(ctx context.Context, projectID uuid.
 	return items, nil
 }
 
+const SetEnvironmentParent = `-- name: SetEnvironmentParent :one
+UPDATE environments
+SET
+    parent_id = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, project_id, name, description, is_protected, color, created_at, updated_at, required_approvals, parent_id, expires_at
+`
+
+type SetEnvironmentParentParams struct {
+	ID       uuid.UUID   `json:"id"`
+	ParentID pgtype.UUID `json:"parent_id"`
+}
+
+func (q *Queries) SetEnvironmentParent(ctx context.Context, arg SetEnvironmentParentParams) (Environment, error) {
+	row := q.db.QueryRow(ctx, SetEnvironmentParent, arg.ID, arg.ParentID)
+	var i Environment
+	err := row.Scan(
+		&i.ID,
+		&i.ProjectID,
+		&i.Name,
+		&i.Description,
+		&i.IsProtected,
+		&i.Color,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RequiredApprovals,
+		&i.ParentID,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const TryLockEnvironmentReaper = `-- name: TryLockEnvironmentReaper :one
+SELECT pg_try_advisory_xact_lock(hashtext('environment_reaper'))
+`
+
+// Lets one reaper at a time delete expired environments until the
+// transaction ends; the others skip their run
+func (q *Queries) TryLockEnvironmentReaper(ctx context.Context) (bool, error) {
+	row := q.db.QueryRow(ctx, TryLockEnvironmentReaper)
+	var pg_try_advisory_xact_lock bool
+	err := row.Scan(&pg_try_advisory_xact_lock)
+	return pg_try_advisory_xact_lock, err
+}
+
 const UpdateEnvironment = `-- name: UpdateEnvironment :one
 UPDATE environments
 SET 
@@ -155,7 +276,7 @@ SET
     color = COALESCE($4, color),
     updated_at = NOW()
 WHERE id = $1
-RETURNING id, project_id, name, description, is_protected, color, created_at, updated_at
+RETURNING id, project_id, name, description, is_protected, color, created_at, updated_at, required_approvals, parent_id, expires_at
 `
 
 type UpdateEnvironmentParams struct {
@@ -182,6 +303,9 @@ func (q *Queries) UpdateEnvironment(ctx context.Context, arg UpdateEnvironmentPa
 		&i.Color,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RequiredApprovals,
+		&i.ParentID,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
@@ -17,10 +17,14 @@ import (
 type AccessAction string
 
 const (
-	AccessActionRead   AccessAction = "read"
-	AccessActionCreate AccessAction = "create"
-	AccessActionUpdate AccessAction = "update"
-	AccessActionDelete AccessAction = "delete"
+	AccessActionRead    AccessAction = "read"
+	AccessActionCreate  AccessAction = "create"
+	AccessActionUpdate  AccessAction = "update"
+	AccessActionDelete  AccessAction = "delete"
+	AccessActionApprove AccessAction = "approve"
+	AccessActionReject  AccessAction = "reject"
+	AccessActionCancel  AccessAction = "cancel"
+	AccessActionApply   AccessAction = "apply"
 )
 
 func (e *AccessAction) Scan(src interface{}) error {
@@ -63,7 +67,11 @@ func (e AccessAction) Valid() bool {
 	case AccessActionRead,
 		AccessActionCreate,
 		AccessActionUpdate,
-		AccessActionDelete:
+		AccessActionDelete,
+		AccessActionApprove,
+		AccessActionReject,
+		AccessActionCancel,
+		AccessActionApply:
 		return true
 	}
 	return false
@@ -75,6 +83,196 @@ func AllAccessActionValues() []AccessAction {
 		AccessActionCreate,
 		AccessActionUpdate,
 		AccessActionDelete,
+		AccessActionApprove,
+		AccessActionReject,
+		AccessActionCancel,
+		AccessActionApply,
+	}
+}
+
+type ChangeOperation string
+
+const (
+	ChangeOperationCreate ChangeOperation = "create"
+	ChangeOperationUpdate ChangeOperation = "update"
+	ChangeOperationDelete ChangeOperation = "delete"
+)
+
+func (e *ChangeOperation) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = ChangeOperation(s)
+	case string:
+		*e = ChangeOperation(s)
+	default:
+		return fmt.Errorf("unsupported scan type for ChangeOperation: %T", src)
+	}
+	return nil
+}
+
+type NullChangeOperation struct {
+	ChangeOperation ChangeOperation `json:"change_operation"`
+	Valid           bool            `json:"valid"` // Valid is true if ChangeOperation is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullChangeOperation) Scan(value interface{}) error {
+	if value == nil {
+		ns.ChangeOperation, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.ChangeOperation.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullChangeOperation) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.ChangeOperation), nil
+}
+
+func (e ChangeOperation) Valid() bool {
+	switch e {
+	case ChangeOperationCreate,
+		ChangeOperationUpdate,
+		ChangeOperationDelete:
+		return true
+	}
+	return false
+}
+
+func AllChangeOperationValues() []ChangeOperation {
+	return []ChangeOperation{
+		ChangeOperationCreate,
+		ChangeOperationUpdate,
+		ChangeOperationDelete,
+	}
+}
+
+type ChangeRequestStatus string
+
+const (
+	ChangeRequestStatusPending   ChangeRequestStatus = "pending"
+	ChangeRequestStatusApplied   ChangeRequestStatus = "applied"
+	ChangeRequestStatusRejected  ChangeRequestStatus = "rejected"
+	ChangeRequestStatusCancelled ChangeRequestStatus = "cancelled"
+)
+
+func (e *ChangeRequestStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = ChangeRequestStatus(s)
+	case string:
+		*e = ChangeRequestStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for ChangeRequestStatus: %T", src)
+	}
+	return nil
+}
+
+type NullChangeRequestStatus struct {
+	ChangeRequestStatus ChangeRequestStatus `json:"change_request_status"`
+	Valid               bool                `json:"valid"` // Valid is true if ChangeRequestStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullChangeRequestStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.ChangeRequestStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.ChangeRequestStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullChangeRequestStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.ChangeRequestStatus), nil
+}
+
+func (e ChangeRequestStatus) Valid() bool {
+	switch e {
+	case ChangeRequestStatusPending,
+		ChangeRequestStatusApplied,
+		ChangeRequestStatusRejected,
+		ChangeRequestStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func AllChangeRequestStatusValues() []ChangeRequestStatus {
+	return []ChangeRequestStatus{
+		ChangeRequestStatusPending,
+		ChangeRequestStatusApplied,
+		ChangeRequestStatusRejected,
+		ChangeRequestStatusCancelled,
+	}
+}
+
+type MasterKeyRotationStatus string
+
+const (
+	MasterKeyRotationStatusRunning   MasterKeyRotationStatus = "running"
+	MasterKeyRotationStatusCompleted MasterKeyRotationStatus = "completed"
+	MasterKeyRotationStatusFailed    MasterKeyRotationStatus = "failed"
+)
+
+func (e *MasterKeyRotationStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = MasterKeyRotationStatus(s)
+	case string:
+		*e = MasterKeyRotationStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for MasterKeyRotationStatus: %T", src)
+	}
+	return nil
+}
+
+type NullMasterKeyRotationStatus struct {
+	MasterKeyRotationStatus MasterKeyRotationStatus `json:"master_key_rotation_status"`
+	Valid                   bool                    `json:"valid"` // Valid is true if MasterKeyRotationStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullMasterKeyRotationStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.MasterKeyRotationStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.MasterKeyRotationStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullMasterKeyRotationStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.MasterKeyRotationStatus), nil
+}
+
+func (e MasterKeyRotationStatus) Valid() bool {
+	switch e {
+	case MasterKeyRotationStatusRunning,
+		MasterKeyRotationStatusCompleted,
+		MasterKeyRotationStatusFailed:
+		return true
+	}
+	return false
+}
+
+func AllMasterKeyRotationStatusValues() []MasterKeyRotationStatus {
+	return []MasterKeyRotationStatus{
+		MasterKeyRotationStatusRunning,
+		MasterKeyRotationStatusCompleted,
+		MasterKeyRotationStatusFailed,
 	}
 }
 
@@ -145,10 +343,11 @@ func AllOrgRoleValues() []OrgRole {
 type SecretAction string
 
 const (
-	SecretActionCreated SecretAction = "created"
-	SecretActionUpdated SecretAction = "updated"
-	SecretActionDeleted SecretAction = "deleted"
-	SecretActionRotated SecretAction = "rotated"
+	SecretActionCreated    SecretAction = "created"
+	SecretActionUpdated    SecretAction = "updated"
+	SecretActionDeleted    SecretAction = "deleted"
+	SecretActionRotated    SecretAction = "rotated"
+	SecretActionRolledBack SecretAction = "rolled_back"
 )
 
 func (e *SecretAction) Scan(src interface{}) error {
@@ -191,7 +390,8 @@ func (e SecretAction) Valid() bool {
 	case SecretActionCreated,
 		SecretActionUpdated,
 		SecretActionDeleted,
-		SecretActionRotated:
+		SecretActionRotated,
+		SecretActionRolledBack:
 		return true
 	}
 	return false
@@ -203,21 +403,87 @@ func AllSecretActionValues() []SecretAction {
 		SecretActionUpdated,
 		SecretActionDeleted,
 		SecretActionRotated,
+		SecretActionRolledBack,
+	}
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryStatusDelivered WebhookDeliveryStatus = "delivered"
+	WebhookDeliveryStatusFailed    WebhookDeliveryStatus = "failed"
+)
+
+func (e *WebhookDeliveryStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = WebhookDeliveryStatus(s)
+	case string:
+		*e = WebhookDeliveryStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for WebhookDeliveryStatus: %T", src)
+	}
+	return nil
+}
+
+type NullWebhookDeliveryStatus struct {
+	WebhookDeliveryStatus WebhookDeliveryStatus `json:"webhook_delivery_status"`
+	Valid                 bool                  `json:"valid"` // Valid is true if WebhookDeliveryStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullWebhookDeliveryStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.WebhookDeliveryStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.WebhookDeliveryStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullWebhookDeliveryStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.WebhookDeliveryStatus), nil
+}
+
+func (e WebhookDeliveryStatus) Valid() bool {
+	switch e {
+	case WebhookDeliveryStatusPending,
+		WebhookDeliveryStatusDelivered,
+		WebhookDeliveryStatusFailed:
+		return true
+	}
+	return false
+}
+
+func AllWebhookDeliveryStatusValues() []WebhookDeliveryStatus {
+	return []WebhookDeliveryStatus{
+		WebhookDeliveryStatusPending,
+		WebhookDeliveryStatusDelivered,
+		WebhookDeliveryStatusFailed,
 	}
 }
 
 type AccessLog struct {
-	ID           uuid.UUID    `json:"id"`
-	UserID       pgtype.UUID  `json:"user_id"`
-	ApiTokenID   pgtype.UUID  `json:"api_token_id"`
-	ResourceType string       `json:"resource_type"`
-	ResourceID   uuid.UUID    `json:"resource_id"`
-	Action       AccessAction `json:"action"`
-	CreatedAt    time.Time    `json:"created_at"`
-	IpAddress    *netip.Addr  `json:"ip_address"`
-	UserAgent    *string      `json:"user_agent"`
-	Success      bool         `json:"success"`
-	ErrorMessage *string      `json:"error_message"`
+	ID             uuid.UUID    `json:"id"`
+	UserID         pgtype.UUID  `json:"user_id"`
+	ApiTokenID     pgtype.UUID  `json:"api_token_id"`
+	ResourceType   string       `json:"resource_type"`
+	ResourceID     uuid.UUID    `json:"resource_id"`
+	Action         AccessAction `json:"action"`
+	CreatedAt      time.Time    `json:"created_at"`
+	IpAddress      *netip.Addr  `json:"ip_address"`
+	UserAgent      *string      `json:"user_agent"`
+	Success        bool         `json:"success"`
+	ErrorMessage   *string      `json:"error_message"`
+	OrganizationID pgtype.UUID  `json:"organization_id"`
+	ChainSeq       *int64       `json:"chain_seq"`
+	PrevHash       []byte       `json:"prev_hash"`
+	RowHash        []byte       `json:"row_hash"`
 }
 
 type ActiveSecretsByEnvironment struct {
@@ -242,15 +508,110 @@ type ApiToken struct {
 	RevokedAt      pgtype.Timestamptz `json:"revoked_at"`
 }
 
+type AuditCheckpoint struct {
+	ID        uuid.UUID `json:"id"`
+	Chain     string    `json:"chain"`
+	ChainSeq  int64     `json:"chain_seq"`
+	RowHash   []byte    `json:"row_hash"`
+	KeyID     string    `json:"key_id"`
+	Signature []byte    `json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ChangeRequest struct {
+	ID                uuid.UUID           `json:"id"`
+	EnvironmentID     uuid.UUID           `json:"environment_id"`
+	Status            ChangeRequestStatus `json:"status"`
+	Reason            *string             `json:"reason"`
+	RequiredApprovals int32               `json:"required_approvals"`
+	RequestedBy       uuid.UUID           `json:"requested_by"`
+	DecidedBy         pgtype.UUID         `json:"decided_by"`
+	DecisionComment   *string             `json:"decision_comment"`
+	CreatedAt         time.Time           `json:"created_at"`
+	UpdatedAt         time.Time           `json:"updated_at"`
+	DecidedAt         pgtype.Timestamptz  `json:"decided_at"`
+}
+
+type ChangeRequestApproval struct {
+	ChangeRequestID uuid.UUID `json:"change_request_id"`
+	ApprovedBy      uuid.UUID `json:"approved_by"`
+	Comment         *string   `json:"comment"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type ChangeRequestItem struct {
+	ID              uuid.UUID       `json:"id"`
+	ChangeRequestID uuid.UUID       `json:"change_request_id"`
+	Key             string          `json:"key"`
+	Operation       ChangeOperation `json:"operation"`
+	EncryptedValue  *string         `json:"encrypted_value"`
+	Description     *string         `json:"description"`
+	BaseVersion     *int32          `json:"base_version"`
+	RestoredVersion *int32          `json:"restored_version"`
+}
+
+type DynamicSecret struct {
+	ID                   uuid.UUID       `json:"id"`
+	EnvironmentID        uuid.UUID       `json:"environment_id"`
+	Key                  string          `json:"key"`
+	EncryptedConnection  string          `json:"encrypted_connection"`
+	CreationStatements   string          `json:"creation_statements"`
+	RevocationStatements string          `json:"revocation_statements"`
+	DefaultTtl           pgtype.Interval `json:"default_ttl"`
+	MaxTtl               pgtype.Interval `json:"max_ttl"`
+	CreatedBy            pgtype.UUID     `json:"created_by"`
+	UpdatedBy            pgtype.UUID     `json:"updated_by"`
+	CreatedAt            time.Time       `json:"created_at"`
+	UpdatedAt            time.Time       `json:"updated_at"`
+}
+
+type DynamicSecretLease struct {
+	ID              uuid.UUID          `json:"id"`
+	DynamicSecretID uuid.UUID          `json:"dynamic_secret_id"`
+	RoleName        string             `json:"role_name"`
+	ExpiresAt       time.Time          `json:"expires_at"`
+	RevokedAt       pgtype.Timestamptz `json:"revoked_at"`
+	RevokeError     *string            `json:"revoke_error"`
+	CreatedBy       pgtype.UUID        `json:"created_by"`
+	CreatedAt       time.Time          `json:"created_at"`
+}
+
 type Environment struct {
-	ID          uuid.UUID `json:"id"`
-	ProjectID   uuid.UUID `json:"project_id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description"`
-	IsProtected *bool     `json:"is_protected"`
-	Color       *string   `json:"color"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                uuid.UUID          `json:"id"`
+	ProjectID         uuid.UUID          `json:"project_id"`
+	Name              string             `json:"name"`
+	Description       *string            `json:"description"`
+	IsProtected       *bool              `json:"is_protected"`
+	Color             *string            `json:"color"`
+	CreatedAt         time.Time          `json:"created_at"`
+	UpdatedAt         time.Time          `json:"updated_at"`
+	RequiredApprovals int32              `json:"required_approvals"`
+	ParentID          pgtype.UUID        `json:"parent_id"`
+	ExpiresAt         pgtype.Timestamptz `json:"expires_at"`
+}
+
+type Event struct {
+	ID             uuid.UUID          `json:"id"`
+	OrganizationID uuid.UUID          `json:"organization_id"`
+	ProjectID      pgtype.UUID        `json:"project_id"`
+	Type           string             `json:"type"`
+	Payload        []byte             `json:"payload"`
+	CreatedAt      time.Time          `json:"created_at"`
+	DispatchedAt   pgtype.Timestamptz `json:"dispatched_at"`
+}
+
+type MasterKeyRotation struct {
+	ID              uuid.UUID               `json:"id"`
+	TargetVersion   int32                   `json:"target_version"`
+	Status          MasterKeyRotationStatus `json:"status"`
+	LastProjectID   pgtype.UUID             `json:"last_project_id"`
+	ProjectsScanned int32                   `json:"projects_scanned"`
+	ProjectsRotated int32                   `json:"projects_rotated"`
+	LastError       *string                 `json:"last_error"`
+	StartedBy       uuid.UUID               `json:"started_by"`
+	StartedAt       time.Time               `json:"started_at"`
+	UpdatedAt       time.Time               `json:"updated_at"`
+	CompletedAt     pgtype.Timestamptz      `json:"completed_at"`
 }
 
 type Organization struct {
@@ -306,19 +667,38 @@ type Secret struct {
 	CreatedAt         time.Time          `json:"created_at"`
 	UpdatedAt         time.Time          `json:"updated_at"`
 	DeletedAt         pgtype.Timestamptz `json:"deleted_at"`
+	RestoredVersion   *int32             `json:"restored_version"`
+	ExpiresAt         pgtype.Timestamptz `json:"expires_at"`
+	RotateEvery       pgtype.Interval    `json:"rotate_every"`
+	ValueChangedAt    time.Time          `json:"value_changed_at"`
 }
 
 type SecretHistory struct {
-	ID             uuid.UUID    `json:"id"`
-	SecretID       uuid.UUID    `json:"secret_id"`
-	EnvironmentID  uuid.UUID    `json:"environment_id"`
-	Action         SecretAction `json:"action"`
-	Key            string       `json:"key"`
-	EncryptedValue *string      `json:"encrypted_value"`
-	ChangedBy      uuid.UUID    `json:"changed_by"`
-	CreatedAt      time.Time    `json:"created_at"`
-	IpAddress      *netip.Addr  `json:"ip_address"`
-	UserAgent      *string      `json:"user_agent"`
+	ID              uuid.UUID    `json:"id"`
+	SecretID        uuid.UUID    `json:"secret_id"`
+	EnvironmentID   uuid.UUID    `json:"environment_id"`
+	Action          SecretAction `json:"action"`
+	Key             string       `json:"key"`
+	EncryptedValue  *string      `json:"encrypted_value"`
+	ChangedBy       uuid.UUID    `json:"changed_by"`
+	CreatedAt       time.Time    `json:"created_at"`
+	IpAddress       *netip.Addr  `json:"ip_address"`
+	UserAgent       *string      `json:"user_agent"`
+	Version         *int32       `json:"version"`
+	RestoredVersion *int32       `json:"restored_version"`
+	ChainSeq        *int64       `json:"chain_seq"`
+	PrevHash        []byte       `json:"prev_hash"`
+	RowHash         []byte       `json:"row_hash"`
+	OrganizationID  pgtype.UUID  `json:"organization_id"`
+	ProjectID       pgtype.UUID  `json:"project_id"`
+	LinkVersion     *int16       `json:"link_version"`
+}
+
+type SecretReminder struct {
+	SecretID  uuid.UUID `json:"secret_id"`
+	Event     string    `json:"event"`
+	DueAt     time.Time `json:"due_at"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type User struct {
@@ -343,3 +723,33 @@ type UserAccessibleProject struct {
 	UserID           uuid.UUID `json:"user_id"`
 	UserRole         OrgRole   `json:"user_role"`
 }
+
+type Webhook struct {
+	ID              uuid.UUID   `json:"id"`
+	OrganizationID  uuid.UUID   `json:"organization_id"`
+	ProjectID       pgtype.UUID `json:"project_id"`
+	Url             string      `json:"url"`
+	Events          []string    `json:"events"`
+	EncryptedSecret string      `json:"encrypted_secret"`
+	Description     *string     `json:"description"`
+	IsActive        bool        `json:"is_active"`
+	CreatedBy       pgtype.UUID `json:"created_by"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id"`
+	WebhookID      uuid.UUID             `json:"webhook_id"`
+	EventID        uuid.UUID             `json:"event_id"`
+	RedeliveryOf   pgtype.UUID           `json:"redelivery_of"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int32                 `json:"attempts"`
+	NextAttemptAt  time.Time             `json:"next_attempt_at"`
+	LastAttemptAt  pgtype.Timestamptz    `json:"last_attempt_at"`
+	ResponseStatus *int32                `json:"response_status"`
+	LastError      *string               `json:"last_error"`
+	DeliveredAt    pgtype.Timestamptz    `json:"delivered_at"`
+	CreatedBy      pgtype.UUID           `json:"created_by"`
+	CreatedAt      time.Time             `json:"created_at"`
+}
@@ -99,6 +99,61 @@ func (q *Queries) GetProjectByID(ctx context.Context, id uuid.UUID) (Project, er
 	return i, err
 }
 
+const GetProjectByName = `-- name: GetProjectByName :one
+SELECT id, organization_id, name, description, encrypted_dek, dek_version, color, icon, created_at, updated_at, deleted_at FROM projects
+WHERE organization_id = $1 AND name = $2 AND deleted_at IS NULL
+LIMIT 1
+`
+
+type GetProjectByNameParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Name           string    `json:"name"`
+}
+
+func (q *Queries) GetProjectByName(ctx context.Context, arg GetProjectByNameParams) (Project, error) {
+	row := q.db.QueryRow(ctx, GetProjectByName, arg.OrganizationID, arg.Name)
+	var i Project
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Name,
+		&i.Description,
+		&i.EncryptedDek,
+		&i.DekVersion,
+		&i.Color,
+		&i.Icon,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const GetProjectForUpdate = `-- name: GetProjectForUpdate :one
+SELECT id, organization_id, name, description, encrypted_dek, dek_version, color, icon, created_at, updated_at, deleted_at FROM projects
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE
+`
+
+func (q *Queries) GetProjectForUpdate(ctx context.Context, id uuid.UUID) (Project, error) {
+	row := q.db.QueryRow(ctx, GetProjectForUpdate, id)
+	var i Project
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Name,
+		&i.Description,
+		&i.EncryptedDek,
+		&i.DekVersion,
+		&i.Color,
+		&i.Icon,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const ListProjectsByOrganization = `-- name: ListProjectsByOrganization :many
 SELECT id, organization_id, name, description, encrypted_dek, dek_version, color, icon, created_at, updated_at, deleted_at FROM projects
 WHERE organization_id = $1 AND deleted_at IS NULL
@@ -137,6 +192,76 @@ func (q *Queries) ListProjectsByOrganization(ctx context.Context, organizationID
 	return items, nil
 }
 
+const ListProjectsForRewrap = `-- name: ListProjectsForRewrap :many
+SELECT id, encrypted_dek FROM projects
+WHERE id > $1
+ORDER BY id
+LIMIT $2
+`
+
+type ListProjectsForRewrapParams struct {
+	ID    uuid.UUID `json:"id"`
+	Limit int32     `json:"limit"`
+}
+
+type ListProjectsForRewrapRow struct {
+	ID           uuid.UUID `json:"id"`
+	EncryptedDek string    `json:"encrypted_dek"`
+}
+
+func (q *Queries) ListProjectsForRewrap(ctx context.Context, arg ListProjectsForRewrapParams) ([]ListProjectsForRewrapRow, error) {
+	rows, err := q.db.Query(ctx, ListProjectsForRewrap, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListProjectsForRewrapRow{}
+	for rows.Next() {
+		var i ListProjectsForRewrapRow
+		if err := rows.Scan(&i.ID, &i.EncryptedDek); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const LockProjectDEK = `-- name: LockProjectDEK :one
+SELECT dek_version FROM projects
+WHERE id = $1 AND deleted_at IS NULL
+FOR SHARE
+`
+
+func (q *Queries) LockProjectDEK(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRow(ctx, LockProjectDEK, id)
+	var dek_version int32
+	err := row.Scan(&dek_version)
+	return dek_version, err
+}
+
+const RewrapProjectDEK = `-- name: RewrapProjectDEK :execrows
+UPDATE projects
+SET encrypted_dek = $1
+WHERE id = $2 AND encrypted_dek = $3
+`
+
+type RewrapProjectDEKParams struct {
+	NewDek string    `json:"new_dek"`
+	ID     uuid.UUID `json:"id"`
+	OldDek string    `json:"old_dek"`
+}
+
+func (q *Queries) RewrapProjectDEK(ctx context.Context, arg RewrapProjectDEKParams) (int64, error) {
+	result, err := q.db.Exec(ctx, RewrapProjectDEK, arg.NewDek, arg.ID, arg.OldDek)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const RotateProjectDEK = `-- name: RotateProjectDEK :one
 UPDATE projects
 SET 
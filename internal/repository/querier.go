@@ -11,50 +11,176 @@ import (
 )
 
 type Querier interface {
+	// Holds a due delivery back from other senders while it is attempted
+	// outside the transaction. If the attempt is never recorded, the delivery
+	// is due again once the claim runs out.
+	ClaimWebhookDelivery(ctx context.Context, arg ClaimWebhookDeliveryParams) error
 	CountProjectsByOrganization(ctx context.Context, organizationID uuid.UUID) (int64, error)
 	CountSecretsByEnvironment(ctx context.Context, environmentID uuid.UUID) (int64, error)
 	CreateAPIToken(ctx context.Context, arg CreateAPITokenParams) (ApiToken, error)
 	CreateAccessLog(ctx context.Context, arg CreateAccessLogParams) (AccessLog, error)
+	CreateAuditCheckpoint(ctx context.Context, arg CreateAuditCheckpointParams) (AuditCheckpoint, error)
+	CreateChangeRequest(ctx context.Context, arg CreateChangeRequestParams) (ChangeRequest, error)
+	CreateChangeRequestApproval(ctx context.Context, arg CreateChangeRequestApprovalParams) (ChangeRequestApproval, error)
+	CreateChangeRequestItem(ctx context.Context, arg CreateChangeRequestItemParams) (ChangeRequestItem, error)
+	CreateDynamicSecret(ctx context.Context, arg CreateDynamicSecretParams) (DynamicSecret, error)
+	CreateDynamicSecretLease(ctx context.Context, arg CreateDynamicSecretLeaseParams) (DynamicSecretLease, error)
 	CreateEnvironment(ctx context.Context, arg CreateEnvironmentParams) (Environment, error)
+	CreateEvent(ctx context.Context, arg CreateEventParams) (Event, error)
+	CreateMasterKeyRotation(ctx context.Context, arg CreateMasterKeyRotationParams) (MasterKeyRotation, error)
 	CreateOrganization(ctx context.Context, arg CreateOrganizationParams) (Organization, error)
 	CreateProject(ctx context.Context, arg CreateProjectParams) (Project, error)
 	CreateSecret(ctx context.Context, arg CreateSecretParams) (Secret, error)
+	CreateSecretReminder(ctx context.Context, arg CreateSecretReminderParams) error
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	CreateWebhook(ctx context.Context, arg CreateWebhookParams) (Webhook, error)
+	CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) (WebhookDelivery, error)
 	DeactivateSecret(ctx context.Context, arg DeactivateSecretParams) error
+	DecideChangeRequest(ctx context.Context, arg DecideChangeRequestParams) (ChangeRequest, error)
+	DeleteDynamicSecret(ctx context.Context, id uuid.UUID) error
 	DeleteEnvironment(ctx context.Context, id uuid.UUID) error
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	FailDynamicSecretLeaseRevocation(ctx context.Context, arg FailDynamicSecretLeaseRevocationParams) error
+	FinishMasterKeyRotation(ctx context.Context, arg FinishMasterKeyRotationParams) (MasterKeyRotation, error)
 	GetAPITokenByHash(ctx context.Context, tokenHash string) (ApiToken, error)
+	GetAccessLogChainHead(ctx context.Context) (AccessLog, error)
+	GetChangeRequest(ctx context.Context, id uuid.UUID) (ChangeRequest, error)
+	GetChangeRequestForUpdate(ctx context.Context, id uuid.UUID) (ChangeRequest, error)
+	GetDynamicSecretByKey(ctx context.Context, arg GetDynamicSecretByKeyParams) (DynamicSecret, error)
+	// Serializes revocations of the same lease
+	GetDynamicSecretLeaseForUpdate(ctx context.Context, arg GetDynamicSecretLeaseForUpdateParams) (DynamicSecretLease, error)
 	GetEnvironmentByID(ctx context.Context, id uuid.UUID) (Environment, error)
 	GetEnvironmentByName(ctx context.Context, arg GetEnvironmentByNameParams) (Environment, error)
+	GetLatestMasterKeyRotation(ctx context.Context) (MasterKeyRotation, error)
+	// Counts deleted secrets, so a key created again continues their versions
+	GetLatestSecretVersion(ctx context.Context, arg GetLatestSecretVersionParams) (int32, error)
 	GetOrganizationByID(ctx context.Context, id uuid.UUID) (Organization, error)
 	GetOrganizationBySlug(ctx context.Context, slug string) (Organization, error)
+	GetOrganizationMember(ctx context.Context, arg GetOrganizationMemberParams) (OrganizationMember, error)
 	GetProjectByID(ctx context.Context, id uuid.UUID) (Project, error)
+	GetProjectByName(ctx context.Context, arg GetProjectByNameParams) (Project, error)
+	GetProjectForUpdate(ctx context.Context, id uuid.UUID) (Project, error)
 	GetSecretByID(ctx context.Context, id uuid.UUID) (Secret, error)
 	GetSecretByKey(ctx context.Context, arg GetSecretByKeyParams) (Secret, error)
+	// Also finds secrets deactivated when they expired
+	GetSecretByKeyIncludingInactive(ctx context.Context, arg GetSecretByKeyIncludingInactiveParams) (Secret, error)
+	GetSecretHistoryChainHead(ctx context.Context) (SecretHistory, error)
+	// Later entries of a version (re-seals) hold the same value under the newest key
+	GetSecretHistoryVersion(ctx context.Context, arg GetSecretHistoryVersionParams) (SecretHistory, error)
+	GetUnfinishedMasterKeyRotation(ctx context.Context, targetVersion int32) (MasterKeyRotation, error)
 	GetUserByAuthProviderID(ctx context.Context, authProviderID *string) (User, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
 	GetUserByID(ctx context.Context, id uuid.UUID) (User, error)
-	ListAccessLogsByResource(ctx context.Context, arg ListAccessLogsByResourceParams) ([]AccessLog, error)
-	ListAccessLogsByUser(ctx context.Context, arg ListAccessLogsByUserParams) ([]AccessLog, error)
+	GetWebhook(ctx context.Context, arg GetWebhookParams) (Webhook, error)
+	GetWebhookDelivery(ctx context.Context, arg GetWebhookDeliveryParams) (WebhookDelivery, error)
+	ListAccessLogChain(ctx context.Context, arg ListAccessLogChainParams) ([]AccessLog, error)
+	ListActiveDynamicSecretLeases(ctx context.Context, dynamicSecretID uuid.UUID) ([]DynamicSecretLease, error)
+	ListAuditCheckpoints(ctx context.Context, chain string) ([]AuditCheckpoint, error)
+	ListChangeRequestApprovals(ctx context.Context, changeRequestID uuid.UUID) ([]ChangeRequestApproval, error)
+	ListChangeRequestItems(ctx context.Context, changeRequestID uuid.UUID) ([]ChangeRequestItem, error)
+	ListChangeRequestsByEnvironment(ctx context.Context, arg ListChangeRequestsByEnvironmentParams) ([]ChangeRequest, error)
+	// Secrets that expire or are due for rotation before the given time,
+	// including expired ones that were deactivated
+	ListDueSecretsByProject(ctx context.Context, arg ListDueSecretsByProjectParams) ([]ListDueSecretsByProjectRow, error)
+	// Deliveries of paused webhooks wait until they are active again. Rows
+	// another transaction holds are skipped rather than waited for.
+	ListDueWebhookDeliveries(ctx context.Context, limit int32) ([]ListDueWebhookDeliveriesRow, error)
+	ListDynamicSecretsByEnvironment(ctx context.Context, environmentID uuid.UUID) ([]DynamicSecret, error)
 	ListEnvironmentsByProject(ctx context.Context, projectID uuid.UUID) ([]Environment, error)
-	ListFailedAccessLogs(ctx context.Context, arg ListFailedAccessLogsParams) ([]AccessLog, error)
+	// Leases whose role could not be dropped stay listed and are retried. Rows
+	// another transaction holds are skipped rather than waited for.
+	ListExpiredDynamicSecretLeases(ctx context.Context, limit int32) ([]ListExpiredDynamicSecretLeasesRow, error)
+	// Environments still inherited from, or with dynamic credentials not yet
+	// revoked, are skipped until those are gone. Rows another transaction holds
+	// are skipped rather than waited for.
+	ListExpiredEnvironments(ctx context.Context, limit int32) ([]ListExpiredEnvironmentsRow, error)
+	// Expired secrets not yet flagged for their current expiry. Rows another
+	// transaction holds are skipped rather than waited for.
+	ListExpiredSecrets(ctx context.Context, limit int32) ([]ListExpiredSecretsRow, error)
+	// version is what the secret becomes once the item is applied
+	ListProjectChangeRequestItemsForRotation(ctx context.Context, arg ListProjectChangeRequestItemsForRotationParams) ([]ListProjectChangeRequestItemsForRotationRow, error)
+	// Keyset-paginated by id, for re-encrypting admin connections under a new DEK
+	ListProjectDynamicSecretsForRotation(ctx context.Context, arg ListProjectDynamicSecretsForRotationParams) ([]ListProjectDynamicSecretsForRotationRow, error)
+	// Includes the history of deleted environments
+	ListProjectSecretHistoryForRotation(ctx context.Context, arg ListProjectSecretHistoryForRotationParams) ([]ListProjectSecretHistoryForRotationRow, error)
+	ListProjectSecretsForRotation(ctx context.Context, arg ListProjectSecretsForRotationParams) ([]ListProjectSecretsForRotationRow, error)
 	ListProjectsByOrganization(ctx context.Context, organizationID uuid.UUID) ([]Project, error)
+	ListProjectsForRewrap(ctx context.Context, arg ListProjectsForRewrapParams) ([]ListProjectsForRewrapRow, error)
+	ListSecretHistory(ctx context.Context, arg ListSecretHistoryParams) ([]SecretHistory, error)
+	ListSecretHistoryChain(ctx context.Context, arg ListSecretHistoryChainParams) ([]SecretHistory, error)
+	// Includes the history of deleted environments
+	ListSecretHistoryForReseal(ctx context.Context, arg ListSecretHistoryForResealParams) ([]ListSecretHistoryForResealRow, error)
 	ListSecretsByEnvironment(ctx context.Context, environmentID uuid.UUID) ([]Secret, error)
+	// Active secrets whose current value is older than rotate_every and that
+	// were not yet flagged for it
+	ListSecretsDueForRotation(ctx context.Context, limit int32) ([]ListSecretsDueForRotationRow, error)
+	ListSecretsForReseal(ctx context.Context, arg ListSecretsForResealParams) ([]ListSecretsForResealRow, error)
+	// Oldest first. Rows another transaction holds are skipped rather than
+	// waited for.
+	ListUndispatchedEvents(ctx context.Context, limit int32) ([]Event, error)
+	ListUnsealedAccessLogs(ctx context.Context, limit int32) ([]AccessLog, error)
+	ListUnsealedSecretHistory(ctx context.Context, limit int32) ([]SecretHistory, error)
 	ListUserAPITokens(ctx context.Context, userID uuid.UUID) ([]ApiToken, error)
 	ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]Organization, error)
 	ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error)
+	// Newest first; page with before = the id of the last delivery seen
+	ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]ListWebhookDeliveriesRow, error)
+	ListWebhookSecrets(ctx context.Context) ([]ListWebhookSecretsRow, error)
+	ListWebhooksByOrganization(ctx context.Context, organizationID uuid.UUID) ([]Webhook, error)
+	// Active webhooks of the organization, or of the event's project. Events
+	// without a project only reach webhooks of the whole organization.
+	ListWebhooksForEvent(ctx context.Context, arg ListWebhooksForEventParams) ([]ListWebhooksForEventRow, error)
+	// Serializes sealers of one chain until the transaction ends
+	LockAuditChain(ctx context.Context, chain string) error
+	LockProjectDEK(ctx context.Context, id uuid.UUID) (int32, error)
+	MarkEventDispatched(ctx context.Context, id uuid.UUID) error
+	RecordWebhookDeliveryAttempt(ctx context.Context, arg RecordWebhookDeliveryAttemptParams) error
+	ResealChangeRequestItem(ctx context.Context, arg ResealChangeRequestItemParams) (int64, error)
+	// Conditional on the old ciphertext so a concurrent change is not overwritten
+	ResealDynamicSecret(ctx context.Context, arg ResealDynamicSecretParams) (int64, error)
+	ResealSecret(ctx context.Context, arg ResealSecretParams) (int64, error)
+	ResealSecretHistory(ctx context.Context, arg ResealSecretHistoryParams) (int64, error)
 	RevokeAPIToken(ctx context.Context, id uuid.UUID) error
+	RevokeDynamicSecretLease(ctx context.Context, id uuid.UUID) error
+	RewrapProjectDEK(ctx context.Context, arg RewrapProjectDEKParams) (int64, error)
+	RewrapWebhookSecret(ctx context.Context, arg RewrapWebhookSecretParams) (int64, error)
+	RollbackSecret(ctx context.Context, arg RollbackSecretParams) (Secret, error)
 	RotateProjectDEK(ctx context.Context, arg RotateProjectDEKParams) (Project, error)
+	SealAccessLog(ctx context.Context, arg SealAccessLogParams) (int64, error)
+	SealSecretHistory(ctx context.Context, arg SealSecretHistoryParams) (int64, error)
+	// Newest first; page with before = the id of the last entry seen
+	SearchAccessLogs(ctx context.Context, arg SearchAccessLogsParams) ([]AccessLog, error)
+	// Newest first; page with before = the id of the last entry seen
+	// Filters on the stored organization, which outlives the environment
+	SearchSecretHistory(ctx context.Context, arg SearchSecretHistoryParams) ([]SecretHistory, error)
+	SetEnvironmentParent(ctx context.Context, arg SetEnvironmentParentParams) (Environment, error)
+	SetSecretLifetime(ctx context.Context, arg SetSecretLifetimeParams) (Secret, error)
 	SoftDeleteOrganization(ctx context.Context, id uuid.UUID) error
 	SoftDeleteProject(ctx context.Context, id uuid.UUID) error
 	SoftDeleteSecret(ctx context.Context, arg SoftDeleteSecretParams) error
 	SoftDeleteUser(ctx context.Context, id uuid.UUID) error
+	// Lets one revoker at a time drop expired roles until the transaction ends;
+	// the others skip their run
+	TryLockDynamicSecretLeases(ctx context.Context) (bool, error)
+	// Lets one reaper at a time delete expired environments until the
+	// transaction ends; the others skip their run
+	TryLockEnvironmentReaper(ctx context.Context) (bool, error)
+	// Lets one scheduler at a time flag secrets until the transaction ends
+	TryLockSecretReminders(ctx context.Context) (bool, error)
+	// Lets one sender at a time dispatch events and claim deliveries until the
+	// transaction ends; the others skip their run
+	TryLockWebhookDeliveries(ctx context.Context) (bool, error)
 	UpdateEnvironment(ctx context.Context, arg UpdateEnvironmentParams) (Environment, error)
+	UpdateMasterKeyRotationProgress(ctx context.Context, arg UpdateMasterKeyRotationProgressParams) (MasterKeyRotation, error)
 	UpdateOrganization(ctx context.Context, arg UpdateOrganizationParams) (Organization, error)
 	UpdateProject(ctx context.Context, arg UpdateProjectParams) (Project, error)
+	// A new value replaces an expired one: an expiry that has passed is
+	// cleared and a secret deactivated by it is active again
 	UpdateSecret(ctx context.Context, arg UpdateSecretParams) (Secret, error)
 	UpdateTokenUsage(ctx context.Context, id uuid.UUID) error
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
 	UpdateUserLastLogin(ctx context.Context, id uuid.UUID) error
+	UpdateWebhook(ctx context.Context, arg UpdateWebhookParams) (Webhook, error)
 }
 
 var _ Querier = (*Queries)(nil)
@@ -8,25 +8,52 @@ INSERT INTO access_logs (
     ip_address,
     user_agent,
     success,
-    error_message
+    error_message,
+    created_at,
+    organization_id
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
 ) RETURNING *;
 
--- name: ListAccessLogsByUser :many
+-- name: SearchAccessLogs :many
+-- Newest first; page with before = the id of the last entry seen
 SELECT * FROM access_logs
-WHERE user_id = $1
-ORDER BY created_at DESC
-LIMIT $2 OFFSET $3;
+WHERE organization_id = sqlc.arg(organization_id)::uuid
+  AND (sqlc.narg(user_id)::uuid IS NULL OR user_id = sqlc.narg(user_id))
+  AND (sqlc.narg(resource_type)::text IS NULL OR resource_type = sqlc.narg(resource_type))
+  AND (sqlc.narg(resource_id)::uuid IS NULL OR resource_id = sqlc.narg(resource_id))
+  AND (sqlc.narg(action)::access_action IS NULL OR action = sqlc.narg(action))
+  AND (sqlc.narg(success)::boolean IS NULL OR success = sqlc.narg(success))
+  AND (sqlc.narg(since)::timestamptz IS NULL OR created_at >= sqlc.narg(since))
+  AND (sqlc.narg(until)::timestamptz IS NULL OR created_at < sqlc.narg(until))
+  AND (
+      sqlc.narg(before)::uuid IS NULL
+      OR (created_at, id) < (SELECT b.created_at, b.id FROM access_logs b WHERE b.id = sqlc.narg(before))
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
 
--- name: ListAccessLogsByResource :many
+-- name: GetAccessLogChainHead :one
 SELECT * FROM access_logs
-WHERE resource_type = $1 AND resource_id = $2
-ORDER BY created_at DESC
-LIMIT $3 OFFSET $4;
+WHERE chain_seq IS NOT NULL
+ORDER BY chain_seq DESC
+LIMIT 1;
 
--- name: ListFailedAccessLogs :many
+-- name: ListUnsealedAccessLogs :many
 SELECT * FROM access_logs
-WHERE success = false
-ORDER BY created_at DESC
-LIMIT $1 OFFSET $2;
+WHERE chain_seq IS NULL
+ORDER BY created_at, id
+LIMIT $1;
+
+-- name: SealAccessLog :execrows
+UPDATE access_logs
+SET chain_seq = sqlc.arg(chain_seq)::bigint,
+    prev_hash = sqlc.arg(prev_hash),
+    row_hash = sqlc.arg(row_hash)
+WHERE id = sqlc.arg(id) AND chain_seq IS NULL;
+
+-- name: ListAccessLogChain :many
+SELECT * FROM access_logs
+WHERE chain_seq > sqlc.arg(after)::bigint
+ORDER BY chain_seq
+LIMIT sqlc.arg('limit');
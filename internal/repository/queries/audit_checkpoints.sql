@@ -0,0 +1,20 @@
+-- name: LockAuditChain :exec
+-- Serializes sealers of one chain until the transaction ends
+SELECT pg_advisory_xact_lock(hashtext(sqlc.arg(chain)::text));
+
+-- name: CreateAuditCheckpoint :one
+INSERT INTO audit_checkpoints (
+    chain,
+    chain_seq,
+    row_hash,
+    key_id,
+    signature,
+    created_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+) RETURNING *;
+
+-- name: ListAuditCheckpoints :many
+SELECT * FROM audit_checkpoints
+WHERE chain = $1
+ORDER BY chain_seq;
@@ -0,0 +1,83 @@
+-- name: CreateChangeRequest :one
+INSERT INTO change_requests (
+    environment_id,
+    reason,
+    required_approvals,
+    requested_by
+) VALUES (
+    $1, $2, $3, $4
+) RETURNING *;
+
+-- name: GetChangeRequest :one
+SELECT * FROM change_requests
+WHERE id = $1
+LIMIT 1;
+
+-- name: GetChangeRequestForUpdate :one
+SELECT * FROM change_requests
+WHERE id = $1
+FOR UPDATE;
+
+-- name: ListChangeRequestsByEnvironment :many
+SELECT * FROM change_requests
+WHERE environment_id = sqlc.arg(environment_id)
+  AND (sqlc.narg(status)::change_request_status IS NULL OR status = sqlc.narg(status))
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit');
+
+-- name: DecideChangeRequest :one
+UPDATE change_requests
+SET
+    status = sqlc.arg(status),
+    decided_by = sqlc.arg(decided_by),
+    decision_comment = sqlc.narg(decision_comment),
+    decided_at = NOW()
+WHERE id = sqlc.arg(id) AND status = 'pending'
+RETURNING *;
+
+-- name: CreateChangeRequestItem :one
+INSERT INTO change_request_items (
+    change_request_id,
+    key,
+    operation,
+    encrypted_value,
+    description,
+    base_version,
+    restored_version
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7
+) RETURNING *;
+
+-- name: ListChangeRequestItems :many
+SELECT * FROM change_request_items
+WHERE change_request_id = $1
+ORDER BY key ASC;
+
+-- name: ListProjectChangeRequestItemsForRotation :many
+-- version is what the secret becomes once the item is applied
+SELECT i.id, cr.environment_id, i.key, i.encrypted_value, (COALESCE(i.base_version, 0) + 1)::int AS version
+FROM change_request_items i
+JOIN change_requests cr ON cr.id = i.change_request_id
+JOIN environments e ON e.id = cr.environment_id
+WHERE e.project_id = $1 AND cr.status = 'pending' AND i.id > $2 AND i.encrypted_value IS NOT NULL
+ORDER BY i.id
+LIMIT $3;
+
+-- name: ResealChangeRequestItem :execrows
+UPDATE change_request_items
+SET encrypted_value = sqlc.arg(new_value)
+WHERE id = sqlc.arg(id) AND encrypted_value = sqlc.arg(old_value);
+
+-- name: CreateChangeRequestApproval :one
+INSERT INTO change_request_approvals (
+    change_request_id,
+    approved_by,
+    comment
+) VALUES (
+    $1, $2, $3
+) RETURNING *;
+
+-- name: ListChangeRequestApprovals :many
+SELECT * FROM change_request_approvals
+WHERE change_request_id = $1
+ORDER BY created_at ASC;
@@ -0,0 +1,105 @@
+-- name: CreateDynamicSecret :one
+INSERT INTO dynamic_secrets (
+    environment_id,
+    key,
+    encrypted_connection,
+    creation_statements,
+    revocation_statements,
+    default_ttl,
+    max_ttl,
+    created_by
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8
+) RETURNING *;
+
+-- name: GetDynamicSecretByKey :one
+SELECT * FROM dynamic_secrets
+WHERE environment_id = $1 AND key = $2;
+
+-- name: ListDynamicSecretsByEnvironment :many
+SELECT * FROM dynamic_secrets
+WHERE environment_id = $1
+ORDER BY key;
+
+-- name: DeleteDynamicSecret :exec
+DELETE FROM dynamic_secrets
+WHERE id = $1;
+
+-- name: ListProjectDynamicSecretsForRotation :many
+-- Keyset-paginated by id, for re-encrypting admin connections under a new DEK
+SELECT d.id, d.environment_id, d.key, d.encrypted_connection
+FROM dynamic_secrets d
+JOIN environments e ON e.id = d.environment_id
+WHERE e.project_id = $1 AND d.id > $2
+ORDER BY d.id
+LIMIT $3;
+
+-- name: ResealDynamicSecret :execrows
+-- Conditional on the old ciphertext so a concurrent change is not overwritten
+UPDATE dynamic_secrets
+SET
+    encrypted_connection = sqlc.arg(new_connection),
+    updated_by = sqlc.arg(updated_by)
+WHERE id = sqlc.arg(id) AND encrypted_connection = sqlc.arg(old_connection);
+
+-- name: CreateDynamicSecretLease :one
+INSERT INTO dynamic_secret_leases (
+    dynamic_secret_id,
+    role_name,
+    expires_at,
+    created_by
+) VALUES (
+    $1, $2, $3, $4
+) RETURNING *;
+
+-- name: GetDynamicSecretLeaseForUpdate :one
+-- Serializes revocations of the same lease
+SELECT * FROM dynamic_secret_leases
+WHERE id = $1 AND dynamic_secret_id = $2
+FOR UPDATE;
+
+-- name: ListActiveDynamicSecretLeases :many
+SELECT * FROM dynamic_secret_leases
+WHERE dynamic_secret_id = $1 AND revoked_at IS NULL
+ORDER BY created_at
+FOR UPDATE;
+
+-- name: RevokeDynamicSecretLease :exec
+UPDATE dynamic_secret_leases
+SET
+    revoked_at = NOW(),
+    revoke_error = NULL
+WHERE id = $1;
+
+-- name: FailDynamicSecretLeaseRevocation :exec
+UPDATE dynamic_secret_leases
+SET revoke_error = $2
+WHERE id = $1;
+
+-- name: ListExpiredDynamicSecretLeases :many
+-- Leases whose role could not be dropped stay listed and are retried. Rows
+-- another transaction holds are skipped rather than waited for.
+SELECT
+    l.id,
+    l.dynamic_secret_id,
+    l.role_name,
+    l.expires_at,
+    d.key,
+    d.encrypted_connection,
+    d.revocation_statements,
+    d.environment_id,
+    e.project_id,
+    p.organization_id
+FROM dynamic_secret_leases l
+JOIN dynamic_secrets d ON d.id = l.dynamic_secret_id
+JOIN environments e ON e.id = d.environment_id
+JOIN projects p ON p.id = e.project_id
+WHERE l.revoked_at IS NULL AND l.expires_at <= NOW()
+ORDER BY l.expires_at
+LIMIT $1
+FOR UPDATE OF l SKIP LOCKED;
+
+-- name: TryLockDynamicSecretLeases :one
+-- Lets one revoker at a time drop expired roles until the transaction ends;
+-- the others skip their run
+SELECT pg_try_advisory_xact_lock(hashtext('dynamic_secret_leases'));
@@ -14,9 +14,12 @@ INSERT INTO environments (
     name,
     description,
     is_protected,
-    color
+    color,
+    required_approvals,
+    parent_id,
+    expires_at
 ) VALUES (
-    $1, $2, $3, $4, $5
+    $1, $2, $3, $4, $5, $6, $7, $8
 ) RETURNING *;
 
 -- name: UpdateEnvironment :one
@@ -29,6 +32,14 @@ SET
 WHERE id = $1
 RETURNING *;
 
+-- name: SetEnvironmentParent :one
+UPDATE environments
+SET
+    parent_id = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
 -- name: ListEnvironmentsByProject :many
 SELECT * FROM environments
 WHERE project_id = $1
@@ -37,3 +48,26 @@ ORDER BY created_at ASC;
 -- name: DeleteEnvironment :exec
 DELETE FROM environments
 WHERE id = $1;
+
+-- name: ListExpiredEnvironments :many
+-- Environments still inherited from, or with dynamic credentials not yet
+-- revoked, are skipped until those are gone. Rows another transaction holds
+-- are skipped rather than waited for.
+SELECT e.id, e.project_id, e.name, e.expires_at, p.organization_id
+FROM environments e
+JOIN projects p ON p.id = e.project_id
+WHERE e.expires_at <= NOW()
+  AND NOT EXISTS (SELECT 1 FROM environments c WHERE c.parent_id = e.id)
+  AND NOT EXISTS (
+      SELECT 1 FROM dynamic_secret_leases l
+      JOIN dynamic_secrets d ON d.id = l.dynamic_secret_id
+      WHERE d.environment_id = e.id AND l.revoked_at IS NULL
+  )
+ORDER BY e.expires_at
+LIMIT $1
+FOR UPDATE OF e SKIP LOCKED;
+
+-- name: TryLockEnvironmentReaper :one
+-- Lets one reaper at a time delete expired environments until the
+-- transaction ends; the others skip their run
+SELECT pg_try_advisory_xact_lock(hashtext('environment_reaper'));
@@ -0,0 +1,23 @@
+-- name: CreateEvent :one
+INSERT INTO events (
+    organization_id,
+    project_id,
+    type,
+    payload
+) VALUES (
+    $1, $2, $3, $4
+) RETURNING *;
+
+-- name: ListUndispatchedEvents :many
+-- Oldest first. Rows another transaction holds are skipped rather than
+-- waited for.
+SELECT * FROM events
+WHERE dispatched_at IS NULL
+ORDER BY created_at, id
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkEventDispatched :exec
+UPDATE events
+SET dispatched_at = NOW()
+WHERE id = $1;
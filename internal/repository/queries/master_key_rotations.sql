@@ -0,0 +1,37 @@
+-- name: CreateMasterKeyRotation :one
+INSERT INTO master_key_rotations (
+    target_version,
+    started_by
+) VALUES (
+    $1, $2
+) RETURNING *;
+
+-- name: GetLatestMasterKeyRotation :one
+SELECT * FROM master_key_rotations
+ORDER BY started_at DESC
+LIMIT 1;
+
+-- name: GetUnfinishedMasterKeyRotation :one
+SELECT * FROM master_key_rotations
+WHERE target_version = $1 AND status <> 'completed'
+LIMIT 1;
+
+-- name: UpdateMasterKeyRotationProgress :one
+UPDATE master_key_rotations
+SET
+    status = 'running',
+    last_project_id = $2,
+    projects_scanned = $3,
+    projects_rotated = $4,
+    last_error = NULL
+WHERE id = $1
+RETURNING *;
+
+-- name: FinishMasterKeyRotation :one
+UPDATE master_key_rotations
+SET
+    status = $2,
+    last_error = $3,
+    completed_at = CASE WHEN $2 = 'completed'::master_key_rotation_status THEN NOW() END
+WHERE id = $1
+RETURNING *;
@@ -0,0 +1,4 @@
+-- name: GetOrganizationMember :one
+SELECT * FROM organization_members
+WHERE organization_id = $1 AND user_id = $2
+LIMIT 1;
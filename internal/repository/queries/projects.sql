@@ -3,6 +3,21 @@ SELECT * FROM projects
 WHERE id = $1 AND deleted_at IS NULL
 LIMIT 1;
 
+-- name: GetProjectByName :one
+SELECT * FROM projects
+WHERE organization_id = $1 AND name = $2 AND deleted_at IS NULL
+LIMIT 1;
+
+-- name: GetProjectForUpdate :one
+SELECT * FROM projects
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE;
+
+-- name: LockProjectDEK :one
+SELECT dek_version FROM projects
+WHERE id = $1 AND deleted_at IS NULL
+FOR SHARE;
+
 -- name: CreateProject :one
 INSERT INTO projects (
     organization_id,
@@ -49,3 +64,14 @@ WHERE id = $1;
 -- name: CountProjectsByOrganization :one
 SELECT COUNT(*) FROM projects
 WHERE organization_id = $1 AND deleted_at IS NULL;
+
+-- name: ListProjectsForRewrap :many
+SELECT id, encrypted_dek FROM projects
+WHERE id > $1
+ORDER BY id
+LIMIT $2;
+
+-- name: RewrapProjectDEK :execrows
+UPDATE projects
+SET encrypted_dek = sqlc.arg(new_dek)
+WHERE id = sqlc.arg(id) AND encrypted_dek = sqlc.arg(old_dek);
@@ -0,0 +1,81 @@
+-- name: ListSecretHistory :many
+SELECT * FROM secret_history
+WHERE secret_id = sqlc.arg(secret_id)
+  AND (
+      sqlc.narg(before)::uuid IS NULL
+      OR (created_at, id) < (SELECT b.created_at, b.id FROM secret_history b WHERE b.id = sqlc.narg(before))
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: GetSecretHistoryVersion :one
+-- Later entries of a version (re-seals) hold the same value under the newest key
+SELECT * FROM secret_history
+WHERE secret_id = sqlc.arg(secret_id) AND version = sqlc.arg(version)::int AND encrypted_value IS NOT NULL
+ORDER BY created_at DESC, id DESC
+LIMIT 1;
+
+-- name: ListSecretHistoryForReseal :many
+-- Includes the history of deleted environments
+SELECT h.id, h.environment_id, h.key, h.encrypted_value, h.version, h.project_id
+FROM secret_history h
+WHERE h.id > $1 AND h.encrypted_value IS NOT NULL AND h.project_id IS NOT NULL
+ORDER BY h.id
+LIMIT $2;
+
+-- name: ResealSecretHistory :execrows
+UPDATE secret_history
+SET encrypted_value = sqlc.arg(new_value)
+WHERE id = sqlc.arg(id) AND encrypted_value = sqlc.arg(old_value);
+
+-- name: ListProjectSecretHistoryForRotation :many
+-- Includes the history of deleted environments
+SELECT h.id, h.environment_id, h.key, h.encrypted_value, h.version
+FROM secret_history h
+WHERE h.project_id = sqlc.arg(project_id)::uuid AND h.id > sqlc.arg(id) AND h.encrypted_value IS NOT NULL
+ORDER BY h.id
+LIMIT sqlc.arg('limit');
+
+-- name: SearchSecretHistory :many
+-- Newest first; page with before = the id of the last entry seen
+-- Filters on the stored organization, which outlives the environment
+SELECT h.* FROM secret_history h
+WHERE h.organization_id = sqlc.arg(organization_id)
+  AND (sqlc.narg(changed_by)::uuid IS NULL OR h.changed_by = sqlc.narg(changed_by))
+  AND (sqlc.narg(secret_id)::uuid IS NULL OR h.secret_id = sqlc.narg(secret_id))
+  AND (sqlc.narg(environment_id)::uuid IS NULL OR h.environment_id = sqlc.narg(environment_id))
+  AND (sqlc.narg(action)::secret_action IS NULL OR h.action = sqlc.narg(action))
+  AND (sqlc.narg(since)::timestamptz IS NULL OR h.created_at >= sqlc.narg(since))
+  AND (sqlc.narg(until)::timestamptz IS NULL OR h.created_at < sqlc.narg(until))
+  AND (
+      sqlc.narg(before)::uuid IS NULL
+      OR (h.created_at, h.id) < (SELECT b.created_at, b.id FROM secret_history b WHERE b.id = sqlc.narg(before))
+  )
+ORDER BY h.created_at DESC, h.id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: GetSecretHistoryChainHead :one
+SELECT * FROM secret_history
+WHERE chain_seq IS NOT NULL
+ORDER BY chain_seq DESC
+LIMIT 1;
+
+-- name: ListUnsealedSecretHistory :many
+SELECT * FROM secret_history
+WHERE chain_seq IS NULL
+ORDER BY created_at, id
+LIMIT $1;
+
+-- name: SealSecretHistory :execrows
+UPDATE secret_history
+SET chain_seq = sqlc.arg(chain_seq)::bigint,
+    prev_hash = sqlc.arg(prev_hash),
+    row_hash = sqlc.arg(row_hash),
+    link_version = sqlc.arg(link_version)::smallint
+WHERE id = sqlc.arg(id) AND chain_seq IS NULL;
+
+-- name: ListSecretHistoryChain :many
+SELECT * FROM secret_history
+WHERE chain_seq > sqlc.arg(after)::bigint
+ORDER BY chain_seq
+LIMIT sqlc.arg('limit');
@@ -22,16 +22,32 @@ INSERT INTO secrets (
 ) RETURNING *;
 
 -- name: UpdateSecret :one
+-- A new value replaces an expired one: an expiry that has passed is
+-- cleared and a secret deactivated by it is active again
 UPDATE secrets
 SET 
     encrypted_value = $2,
     description = COALESCE($3, description),
     version = version + 1,
+    restored_version = NULL,
+    is_active = true,
+    expires_at = CASE WHEN expires_at <= NOW() THEN NULL ELSE expires_at END,
     updated_by = $4,
     updated_at = NOW()
 WHERE id = $1 AND deleted_at IS NULL
 RETURNING *;
 
+-- name: RollbackSecret :one
+UPDATE secrets
+SET
+    encrypted_value = sqlc.arg(encrypted_value),
+    version = version + 1,
+    restored_version = sqlc.arg(restored_version)::int,
+    updated_by = sqlc.arg(updated_by),
+    updated_at = NOW()
+WHERE id = sqlc.arg(id) AND deleted_at IS NULL
+RETURNING *;
+
 -- name: ListSecretsByEnvironment :many
 SELECT * FROM secrets
 WHERE environment_id = $1 AND deleted_at IS NULL AND is_active = true
@@ -53,3 +69,99 @@ WHERE environment_id = $1 AND deleted_at IS NULL AND is_active = true;
 UPDATE secrets
 SET is_active = false, updated_by = $2
 WHERE id = $1;
+
+-- name: ListSecretsForReseal :many
+SELECT s.id, s.environment_id, s.key, s.encrypted_value, s.version, e.project_id
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+WHERE s.id > $1
+ORDER BY s.id
+LIMIT $2;
+
+-- name: ResealSecret :execrows
+UPDATE secrets
+SET
+    encrypted_value = sqlc.arg(new_value),
+    updated_by = sqlc.arg(updated_by)
+WHERE id = sqlc.arg(id) AND encrypted_value = sqlc.arg(old_value);
+
+-- name: ListProjectSecretsForRotation :many
+SELECT s.id, s.environment_id, s.key, s.encrypted_value, s.version
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+WHERE e.project_id = $1 AND s.id > $2
+ORDER BY s.id
+LIMIT $3;
+
+-- name: GetSecretByKeyIncludingInactive :one
+-- Also finds secrets deactivated when they expired
+SELECT * FROM secrets
+WHERE environment_id = $1 AND key = $2 AND deleted_at IS NULL
+LIMIT 1;
+
+-- name: GetLatestSecretVersion :one
+-- Counts deleted secrets, so a key created again continues their versions
+SELECT COALESCE(MAX(version), 0)::int AS version FROM secrets
+WHERE environment_id = $1 AND key = $2;
+
+-- name: SetSecretLifetime :one
+UPDATE secrets
+SET
+    expires_at = sqlc.narg(expires_at),
+    rotate_every = sqlc.narg(rotate_every),
+    is_active = sqlc.arg(is_active),
+    updated_by = sqlc.arg(updated_by)
+WHERE id = sqlc.arg(id) AND deleted_at IS NULL
+RETURNING *;
+
+-- name: ListDueSecretsByProject :many
+-- Secrets that expire or are due for rotation before the given time,
+-- including expired ones that were deactivated
+SELECT s.id, e.name AS environment_name, s.key, s.version, s.is_active, s.expires_at, s.rotate_every, s.value_changed_at
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+WHERE e.project_id = sqlc.arg(project_id) AND s.deleted_at IS NULL
+  AND (s.expires_at <= sqlc.arg(before) OR s.value_changed_at + s.rotate_every <= sqlc.arg(before))
+ORDER BY e.name, s.key;
+
+-- name: ListExpiredSecrets :many
+-- Expired secrets not yet flagged for their current expiry. Rows another
+-- transaction holds are skipped rather than waited for.
+SELECT s.id, s.environment_id, s.key, s.version, s.is_active, s.updated_by, s.expires_at AS due_at,
+       e.name AS environment_name, e.project_id, p.organization_id
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+JOIN projects p ON p.id = e.project_id
+WHERE s.deleted_at IS NULL AND s.expires_at <= NOW()
+  AND NOT EXISTS (
+      SELECT 1 FROM secret_reminders r
+      WHERE r.secret_id = s.id AND r.event = 'secret.expired' AND r.due_at = s.expires_at
+  )
+ORDER BY s.expires_at
+LIMIT $1
+FOR UPDATE OF s SKIP LOCKED;
+
+-- name: ListSecretsDueForRotation :many
+-- Active secrets whose current value is older than rotate_every and that
+-- were not yet flagged for it
+SELECT s.id, s.environment_id, s.key, s.version, s.is_active, s.updated_by, (s.value_changed_at + s.rotate_every)::timestamptz AS due_at,
+       e.name AS environment_name, e.project_id, p.organization_id
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+JOIN projects p ON p.id = e.project_id
+WHERE s.deleted_at IS NULL AND s.is_active = true AND s.value_changed_at + s.rotate_every <= NOW()
+  AND NOT EXISTS (
+      SELECT 1 FROM secret_reminders r
+      WHERE r.secret_id = s.id AND r.event = 'secret.rotation_due' AND r.due_at = s.value_changed_at + s.rotate_every
+  )
+ORDER BY due_at
+LIMIT $1
+FOR UPDATE OF s SKIP LOCKED;
+
+-- name: CreateSecretReminder :exec
+INSERT INTO secret_reminders (secret_id, event, due_at)
+VALUES ($1, $2, $3);
+
+-- name: TryLockSecretReminders :one
+-- Lets one scheduler at a time flag secrets until the transaction ends
+SELECT pg_try_advisory_xact_lock(hashtext('secret_reminders'));
@@ -0,0 +1,143 @@
+-- name: CreateWebhook :one
+INSERT INTO webhooks (
+    organization_id,
+    project_id,
+    url,
+    events,
+    encrypted_secret,
+    description,
+    created_by
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7
+) RETURNING *;
+
+-- name: GetWebhook :one
+SELECT * FROM webhooks
+WHERE id = $1 AND organization_id = $2;
+
+-- name: ListWebhooksByOrganization :many
+SELECT * FROM webhooks
+WHERE organization_id = $1
+ORDER BY created_at, id;
+
+-- name: UpdateWebhook :one
+UPDATE webhooks
+SET
+    url = $2,
+    events = $3,
+    description = $4,
+    is_active = $5
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteWebhook :exec
+DELETE FROM webhooks
+WHERE id = $1;
+
+-- name: ListWebhooksForEvent :many
+-- Active webhooks of the organization, or of the event's project. Events
+-- without a project only reach webhooks of the whole organization.
+SELECT id, events FROM webhooks
+WHERE organization_id = sqlc.arg(organization_id)
+  AND is_active
+  AND (project_id IS NULL OR project_id = sqlc.narg(project_id))
+ORDER BY id;
+
+-- name: ListWebhookSecrets :many
+SELECT id, encrypted_secret FROM webhooks
+ORDER BY id;
+
+-- name: RewrapWebhookSecret :execrows
+UPDATE webhooks
+SET encrypted_secret = sqlc.arg(new_secret)
+WHERE id = sqlc.arg(id) AND encrypted_secret = sqlc.arg(old_secret);
+
+-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_deliveries (
+    webhook_id,
+    event_id,
+    redelivery_of,
+    created_by
+) VALUES (
+    $1, $2, $3, $4
+) RETURNING *;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries
+WHERE id = $1 AND webhook_id = $2;
+
+-- name: ListWebhookDeliveries :many
+-- Newest first; page with before = the id of the last delivery seen
+SELECT
+    d.id,
+    d.webhook_id,
+    d.event_id,
+    d.redelivery_of,
+    d.status,
+    d.attempts,
+    d.next_attempt_at,
+    d.last_attempt_at,
+    d.response_status,
+    d.last_error,
+    d.delivered_at,
+    d.created_by,
+    d.created_at,
+    e.type AS event_type
+FROM webhook_deliveries d
+JOIN events e ON e.id = d.event_id
+WHERE d.webhook_id = sqlc.arg(webhook_id)
+  AND (sqlc.narg(status)::webhook_delivery_status IS NULL OR d.status = sqlc.narg(status))
+  AND (
+      sqlc.narg(before)::uuid IS NULL
+      OR (d.created_at, d.id) < (SELECT b.created_at, b.id FROM webhook_deliveries b WHERE b.id = sqlc.narg(before))
+  )
+ORDER BY d.created_at DESC, d.id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ListDueWebhookDeliveries :many
+-- Deliveries of paused webhooks wait until they are active again. Rows
+-- another transaction holds are skipped rather than waited for.
+SELECT
+    d.id,
+    d.webhook_id,
+    d.attempts,
+    w.url,
+    w.encrypted_secret,
+    e.id AS event_id,
+    e.type AS event_type,
+    e.payload,
+    e.organization_id,
+    e.project_id,
+    e.created_at AS event_created_at
+FROM webhook_deliveries d
+JOIN webhooks w ON w.id = d.webhook_id
+JOIN events e ON e.id = d.event_id
+WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND w.is_active
+ORDER BY d.next_attempt_at
+LIMIT $1
+FOR UPDATE OF d SKIP LOCKED;
+
+-- name: ClaimWebhookDelivery :exec
+-- Holds a due delivery back from other senders while it is attempted
+-- outside the transaction. If the attempt is never recorded, the delivery
+-- is due again once the claim runs out.
+UPDATE webhook_deliveries
+SET next_attempt_at = sqlc.arg(claimed_until)
+WHERE id = sqlc.arg(id);
+
+-- name: RecordWebhookDeliveryAttempt :exec
+UPDATE webhook_deliveries
+SET
+    status = sqlc.arg(status),
+    attempts = attempts + 1,
+    next_attempt_at = sqlc.arg(next_attempt_at),
+    last_attempt_at = NOW(),
+    response_status = sqlc.narg(response_status),
+    last_error = sqlc.narg(last_error),
+    delivered_at = CASE WHEN sqlc.arg(status)::webhook_delivery_status = 'delivered' THEN NOW() END
+WHERE id = sqlc.arg(id);
+
+-- name: TryLockWebhookDeliveries :one
+-- Lets one sender at a time dispatch events and claim deliveries until the
+-- transaction ends; the others skip their run
+SELECT pg_try_advisory_xact_lock(hashtext('webhook_deliveries'));
@@ -0,0 +1,2405 @@
+// Package repotest provides an in-memory repository.Store for tests.
+//
+// MemStore implements only the queries exercised by the HTTP layer and the
+// vault; secret history is recorded the way the database trigger does. Calling
+// any other Querier method panics via the embedded nil interface.
+package repotest
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+)
+
+// MemStore is a goroutine-safe, in-memory repository.Store
+type MemStore struct {
+	repository.Querier
+
+	mu           sync.Mutex
+	users        map[uuid.UUID]repository.User
+	members      map[uuid.UUID]repository.OrganizationMember
+	tokens       map[uuid.UUID]repository.ApiToken
+	projects     map[uuid.UUID]repository.Project
+	environments map[uuid.UUID]repository.Environment
+	secrets      map[uuid.UUID]repository.Secret
+	history      map[uuid.UUID]repository.SecretHistory
+	lastAt       time.Time
+	rotations    map[uuid.UUID]repository.MasterKeyRotation
+	changes      map[uuid.UUID]repository.ChangeRequest
+	changeItems  map[uuid.UUID]repository.ChangeRequestItem
+	approvals    map[approvalKey]repository.ChangeRequestApproval
+	checkpoints  []repository.AuditCheckpoint
+	reminders    map[reminderKey]repository.SecretReminder
+	events       []repository.Event
+	dynamic      map[uuid.UUID]repository.DynamicSecret
+	leases       map[uuid.UUID]repository.DynamicSecretLease
+	webhooks     map[uuid.UUID]repository.Webhook
+	deliveries   map[uuid.UUID]repository.WebhookDelivery
+
+	// Access logs are written by the audit logger outside request
+	// transactions, so ExecTx never rolls them back
+	accessLogs []repository.AccessLog
+}
+
+// approvalKey is the primary key of change_request_approvals
+type approvalKey struct {
+	changeRequestID uuid.UUID
+	approvedBy      uuid.UUID
+}
+
+// reminderKey is the primary key of secret_reminders
+type reminderKey struct {
+	secretID uuid.UUID
+	event    string
+	dueAt    time.Time
+}
+
+var _ repository.Store = (*MemStore)(nil)
+
+// NewMemStore creates an empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{
+		users:        make(map[uuid.UUID]repository.User),
+		members:      make(map[uuid.UUID]repository.OrganizationMember),
+		tokens:       make(map[uuid.UUID]repository.ApiToken),
+		projects:     make(map[uuid.UUID]repository.Project),
+		environments: make(map[uuid.UUID]repository.Environment),
+		secrets:      make(map[uuid.UUID]repository.Secret),
+		history:      make(map[uuid.UUID]repository.SecretHistory),
+		rotations:    make(map[uuid.UUID]repository.MasterKeyRotation),
+		changes:      make(map[uuid.UUID]repository.ChangeRequest),
+		changeItems:  make(map[uuid.UUID]repository.ChangeRequestItem),
+		approvals:    make(map[approvalKey]repository.ChangeRequestApproval),
+		reminders:    make(map[reminderKey]repository.SecretReminder),
+		dynamic:      make(map[uuid.UUID]repository.DynamicSecret),
+		leases:       make(map[uuid.UUID]repository.DynamicSecretLease),
+		webhooks:     make(map[uuid.UUID]repository.Webhook),
+		deliveries:   make(map[uuid.UUID]repository.WebhookDelivery),
+	}
+}
+
+// ExecTx runs fn against the store itself and restores the previous state if
+// fn fails. Transactions are not isolated from concurrent callers.
+func (m *MemStore) ExecTx(ctx context.Context, fn func(q repository.Querier) error) error {
+	m.mu.Lock()
+	snapshot := m.snapshot()
+	m.mu.Unlock()
+
+	if err := fn(m); err != nil {
+		m.mu.Lock()
+		m.restore(snapshot)
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// memState is a copy of every table, taken at the start of ExecTx
+type memState struct {
+	users        map[uuid.UUID]repository.User
+	members      map[uuid.UUID]repository.OrganizationMember
+	tokens       map[uuid.UUID]repository.ApiToken
+	projects     map[uuid.UUID]repository.Project
+	environments map[uuid.UUID]repository.Environment
+	secrets      map[uuid.UUID]repository.Secret
+	history      map[uuid.UUID]repository.SecretHistory
+	rotations    map[uuid.UUID]repository.MasterKeyRotation
+	changes      map[uuid.UUID]repository.ChangeRequest
+	changeItems  map[uuid.UUID]repository.ChangeRequestItem
+	approvals    map[approvalKey]repository.ChangeRequestApproval
+	checkpoints  []repository.AuditCheckpoint
+	reminders    map[reminderKey]repository.SecretReminder
+	events       []repository.Event
+	dynamic      map[uuid.UUID]repository.DynamicSecret
+	leases       map[uuid.UUID]repository.DynamicSecretLease
+	webhooks     map[uuid.UUID]repository.Webhook
+	deliveries   map[uuid.UUID]repository.WebhookDelivery
+}
+
+// snapshot copies the tables. Callers hold m.mu.
+func (m *MemStore) snapshot() memState {
+	return memState{
+		users:        maps.Clone(m.users),
+		members:      maps.Clone(m.members),
+		tokens:       maps.Clone(m.tokens),
+		projects:     maps.Clone(m.projects),
+		environments: maps.Clone(m.environments),
+		secrets:      maps.Clone(m.secrets),
+		history:      maps.Clone(m.history),
+		rotations:    maps.Clone(m.rotations),
+		changes:      maps.Clone(m.changes),
+		changeItems:  maps.Clone(m.changeItems),
+		approvals:    maps.Clone(m.approvals),
+		checkpoints:  slices.Clone(m.checkpoints),
+		reminders:    maps.Clone(m.reminders),
+		events:       slices.Clone(m.events),
+		dynamic:      maps.Clone(m.dynamic),
+		leases:       maps.Clone(m.leases),
+		webhooks:     maps.Clone(m.webhooks),
+		deliveries:   maps.Clone(m.deliveries),
+	}
+}
+
+// restore puts back a snapshot. Callers hold m.mu.
+func (m *MemStore) restore(s memState) {
+	m.users = s.users
+	m.members = s.members
+	m.tokens = s.tokens
+	m.projects = s.projects
+	m.environments = s.environments
+	m.secrets = s.secrets
+	m.history = s.history
+	m.rotations = s.rotations
+	m.changes = s.changes
+	m.changeItems = s.changeItems
+	m.approvals = s.approvals
+	m.checkpoints = s.checkpoints
+	m.reminders = s.reminders
+	m.events = s.events
+	m.dynamic = s.dynamic
+	m.leases = s.leases
+	m.webhooks = s.webhooks
+	m.deliveries = s.deliveries
+}
+
+// AddUser seeds a user
+func (m *MemStore) AddUser(u repository.User) repository.User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	u.CreatedAt, u.UpdatedAt = now(), now()
+	m.users[u.ID] = u
+	return u
+}
+
+// AddMember seeds an organization membership
+func (m *MemStore) AddMember(om repository.OrganizationMember) repository.OrganizationMember {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if om.ID == uuid.Nil {
+		om.ID = uuid.New()
+	}
+	om.CreatedAt, om.UpdatedAt = now(), now()
+	m.members[om.ID] = om
+	return om
+}
+
+// Token returns a stored API token by id, including revoked ones
+func (m *MemStore) Token(id uuid.UUID) (repository.ApiToken, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[id]
+	return t, ok
+}
+
+// AddProject seeds a project
+func (m *MemStore) AddProject(p repository.Project) repository.Project {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	if p.DekVersion == 0 {
+		p.DekVersion = 1
+	}
+	p.CreatedAt, p.UpdatedAt = now(), now()
+	m.projects[p.ID] = p
+	return p
+}
+
+// AddEnvironment seeds an environment
+func (m *MemStore) AddEnvironment(e repository.Environment) repository.Environment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	if e.RequiredApprovals == 0 {
+		e.RequiredApprovals = 1
+	}
+	e.CreatedAt = m.tick()
+	e.UpdatedAt = e.CreatedAt
+	m.environments[e.ID] = e
+	return e
+}
+
+func (m *MemStore) GetUserByID(ctx context.Context, id uuid.UUID) (repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.DeletedAt.Valid {
+		return repository.User{}, pgx.ErrNoRows
+	}
+	return u, nil
+}
+
+func (m *MemStore) GetUserByAuthProviderID(ctx context.Context, authProviderID *string) (repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if authProviderID != nil && u.AuthProviderID != nil && *u.AuthProviderID == *authProviderID && !u.DeletedAt.Valid {
+			return u, nil
+		}
+	}
+	return repository.User{}, pgx.ErrNoRows
+}
+
+func (m *MemStore) CreateUser(ctx context.Context, arg repository.CreateUserParams) (repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == arg.Email || (arg.AuthProviderID != nil && u.AuthProviderID != nil && *u.AuthProviderID == *arg.AuthProviderID) {
+			return repository.User{}, &pgconn.PgError{Code: "23505"}
+		}
+	}
+
+	u := repository.User{
+		ID:             uuid.New(),
+		Email:          arg.Email,
+		FullName:       arg.FullName,
+		AvatarUrl:      arg.AvatarUrl,
+		AuthProviderID: arg.AuthProviderID,
+		IsActive:       arg.IsActive,
+		EmailVerified:  arg.EmailVerified,
+		CreatedAt:      now(),
+		UpdatedAt:      now(),
+	}
+	m.users[u.ID] = u
+	return u, nil
+}
+
+func (m *MemStore) UpdateUserLastLogin(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		u.LastLoginAt.Time, u.LastLoginAt.Valid = now(), true
+		m.users[id] = u
+	}
+	return nil
+}
+
+func (m *MemStore) GetOrganizationMember(ctx context.Context, arg repository.GetOrganizationMemberParams) (repository.OrganizationMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, om := range m.members {
+		if om.OrganizationID == arg.OrganizationID && om.UserID == arg.UserID {
+			return om, nil
+		}
+	}
+	return repository.OrganizationMember{}, pgx.ErrNoRows
+}
+
+func (m *MemStore) CreateAPIToken(ctx context.Context, arg repository.CreateAPITokenParams) (repository.ApiToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if t.TokenHash == arg.TokenHash {
+			return repository.ApiToken{}, &pgconn.PgError{Code: "23505"}
+		}
+	}
+
+	var usage int32
+	t := repository.ApiToken{
+		ID:             uuid.New(),
+		UserID:         arg.UserID,
+		Name:           arg.Name,
+		TokenHash:      arg.TokenHash,
+		Scopes:         arg.Scopes,
+		OrganizationID: arg.OrganizationID,
+		ExpiresAt:      arg.ExpiresAt,
+		UsageCount:     &usage,
+		CreatedAt:      now(),
+	}
+	m.tokens[t.ID] = t
+	return t, nil
+}
+
+func (m *MemStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (repository.ApiToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if t.TokenHash != tokenHash || t.RevokedAt.Valid {
+			continue
+		}
+		if t.ExpiresAt.Valid && !t.ExpiresAt.Time.After(now()) {
+			continue
+		}
+		return t, nil
+	}
+	return repository.ApiToken{}, pgx.ErrNoRows
+}
+
+func (m *MemStore) ListUserAPITokens(ctx context.Context, userID uuid.UUID) ([]repository.ApiToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ApiToken{}
+	for _, t := range m.tokens {
+		if t.UserID == userID && !t.RevokedAt.Valid {
+			items = append(items, t)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.After(items[j].CreatedAt) })
+	return items, nil
+}
+
+func (m *MemStore) RevokeAPIToken(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tokens[id]; ok {
+		t.RevokedAt.Time, t.RevokedAt.Valid = now(), true
+		m.tokens[id] = t
+	}
+	return nil
+}
+
+func (m *MemStore) UpdateTokenUsage(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tokens[id]; ok {
+		usage := *t.UsageCount + 1
+		t.UsageCount = &usage
+		t.LastUsedAt.Time, t.LastUsedAt.Valid = now(), true
+		m.tokens[id] = t
+	}
+	return nil
+}
+
+func (m *MemStore) GetProjectByID(ctx context.Context, id uuid.UUID) (repository.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.projects[id]
+	if !ok || p.DeletedAt.Valid {
+		return repository.Project{}, pgx.ErrNoRows
+	}
+	return p, nil
+}
+
+func (m *MemStore) CreateProject(ctx context.Context, arg repository.CreateProjectParams) (repository.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// UNIQUE(organization_id, name) also covers soft-deleted rows
+	for _, p := range m.projects {
+		if p.OrganizationID == arg.OrganizationID && p.Name == arg.Name {
+			return repository.Project{}, &pgconn.PgError{Code: "23505"}
+		}
+	}
+
+	p := repository.Project{
+		ID:             uuid.New(),
+		OrganizationID: arg.OrganizationID,
+		Name:           arg.Name,
+		Description:    arg.Description,
+		EncryptedDek:   arg.EncryptedDek,
+		DekVersion:     arg.DekVersion,
+		Color:          arg.Color,
+		Icon:           arg.Icon,
+		CreatedAt:      now(),
+		UpdatedAt:      now(),
+	}
+	m.projects[p.ID] = p
+	return p, nil
+}
+
+func (m *MemStore) GetProjectByName(ctx context.Context, arg repository.GetProjectByNameParams) (repository.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.projects {
+		if p.OrganizationID == arg.OrganizationID && p.Name == arg.Name && !p.DeletedAt.Valid {
+			return p, nil
+		}
+	}
+	return repository.Project{}, pgx.ErrNoRows
+}
+
+func (m *MemStore) GetProjectForUpdate(ctx context.Context, id uuid.UUID) (repository.Project, error) {
+	return m.GetProjectByID(ctx, id)
+}
+
+func (m *MemStore) LockProjectDEK(ctx context.Context, id uuid.UUID) (int32, error) {
+	p, err := m.GetProjectByID(ctx, id)
+	return p.DekVersion, err
+}
+
+func (m *MemStore) RotateProjectDEK(ctx context.Context, arg repository.RotateProjectDEKParams) (repository.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.projects[arg.ID]
+	if !ok || p.DeletedAt.Valid {
+		return repository.Project{}, pgx.ErrNoRows
+	}
+	p.EncryptedDek = arg.EncryptedDek
+	p.DekVersion++
+	p.UpdatedAt = now()
+	m.projects[p.ID] = p
+	return p, nil
+}
+
+// Project returns a stored project by id, including deleted ones
+func (m *MemStore) Project(id uuid.UUID) (repository.Project, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.projects[id]
+	return p, ok
+}
+
+func (m *MemStore) ListProjectsForRewrap(ctx context.Context, arg repository.ListProjectsForRewrapParams) ([]repository.ListProjectsForRewrapRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListProjectsForRewrapRow{}
+	for _, p := range m.projects {
+		if bytes.Compare(p.ID[:], arg.ID[:]) <= 0 {
+			continue
+		}
+		items = append(items, repository.ListProjectsForRewrapRow{ID: p.ID, EncryptedDek: p.EncryptedDek})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) RewrapProjectDEK(ctx context.Context, arg repository.RewrapProjectDEKParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.projects[arg.ID]
+	if !ok || p.EncryptedDek != arg.OldDek {
+		return 0, nil
+	}
+	p.EncryptedDek = arg.NewDek
+	p.UpdatedAt = now()
+	m.projects[p.ID] = p
+	return 1, nil
+}
+
+func (m *MemStore) CreateMasterKeyRotation(ctx context.Context, arg repository.CreateMasterKeyRotationParams) (repository.MasterKeyRotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Partial unique index on unfinished jobs per target version
+	for _, r := range m.rotations {
+		if r.TargetVersion == arg.TargetVersion && r.Status != repository.MasterKeyRotationStatusCompleted {
+			return repository.MasterKeyRotation{}, &pgconn.PgError{Code: "23505"}
+		}
+	}
+
+	r := repository.MasterKeyRotation{
+		ID:            uuid.New(),
+		TargetVersion: arg.TargetVersion,
+		Status:        repository.MasterKeyRotationStatusRunning,
+		StartedBy:     arg.StartedBy,
+		StartedAt:     now(),
+		UpdatedAt:     now(),
+	}
+	m.rotations[r.ID] = r
+	return r, nil
+}
+
+func (m *MemStore) GetLatestMasterKeyRotation(ctx context.Context) (repository.MasterKeyRotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest repository.MasterKeyRotation
+	for _, r := range m.rotations {
+		if latest.ID == uuid.Nil || r.StartedAt.After(latest.StartedAt) {
+			latest = r
+		}
+	}
+	if latest.ID == uuid.Nil {
+		return latest, pgx.ErrNoRows
+	}
+	return latest, nil
+}
+
+func (m *MemStore) GetUnfinishedMasterKeyRotation(ctx context.Context, targetVersion int32) (repository.MasterKeyRotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.rotations {
+		if r.TargetVersion == targetVersion && r.Status != repository.MasterKeyRotationStatusCompleted {
+			return r, nil
+		}
+	}
+	return repository.MasterKeyRotation{}, pgx.ErrNoRows
+}
+
+func (m *MemStore) UpdateMasterKeyRotationProgress(ctx context.Context, arg repository.UpdateMasterKeyRotationProgressParams) (repository.MasterKeyRotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.rotations[arg.ID]
+	if !ok {
+		return r, pgx.ErrNoRows
+	}
+	r.Status = repository.MasterKeyRotationStatusRunning
+	r.LastProjectID = arg.LastProjectID
+	r.ProjectsScanned = arg.ProjectsScanned
+	r.ProjectsRotated = arg.ProjectsRotated
+	r.LastError = nil
+	r.UpdatedAt = now()
+	m.rotations[r.ID] = r
+	return r, nil
+}
+
+func (m *MemStore) FinishMasterKeyRotation(ctx context.Context, arg repository.FinishMasterKeyRotationParams) (repository.MasterKeyRotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.rotations[arg.ID]
+	if !ok {
+		return r, pgx.ErrNoRows
+	}
+	r.Status = arg.Status
+	r.LastError = arg.LastError
+	r.CompletedAt = pgtype.Timestamptz{}
+	if arg.Status == repository.MasterKeyRotationStatusCompleted {
+		r.CompletedAt = pgtype.Timestamptz{Time: now(), Valid: true}
+	}
+	r.UpdatedAt = now()
+	m.rotations[r.ID] = r
+	return r, nil
+}
+
+func (m *MemStore) GetEnvironmentByID(ctx context.Context, id uuid.UUID) (repository.Environment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.environments[id]
+	if !ok {
+		return repository.Environment{}, pgx.ErrNoRows
+	}
+	return e, nil
+}
+
+func (m *MemStore) GetEnvironmentByName(ctx context.Context, arg repository.GetEnvironmentByNameParams) (repository.Environment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.environments {
+		if e.ProjectID == arg.ProjectID && e.Name == arg.Name {
+			return e, nil
+		}
+	}
+	return repository.Environment{}, pgx.ErrNoRows
+}
+
+func (m *MemStore) CreateEnvironment(ctx context.Context, arg repository.CreateEnvironmentParams) (repository.Environment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.projects[arg.ProjectID]; !ok {
+		return repository.Environment{}, pgx.ErrNoRows
+	}
+	for _, e := range m.environments {
+		if e.ProjectID == arg.ProjectID && e.Name == arg.Name {
+			return repository.Environment{}, &pgconn.PgError{Code: "23505"}
+		}
+	}
+
+	e := repository.Environment{
+		ID:                uuid.New(),
+		ProjectID:         arg.ProjectID,
+		Name:              arg.Name,
+		Description:       arg.Description,
+		IsProtected:       arg.IsProtected,
+		Color:             arg.Color,
+		RequiredApprovals: arg.RequiredApprovals,
+		ParentID:          arg.ParentID,
+		ExpiresAt:         arg.ExpiresAt,
+		CreatedAt:         m.tick(),
+	}
+	e.UpdatedAt = e.CreatedAt
+	if err := m.checkParent(e); err != nil {
+		return repository.Environment{}, err
+	}
+	m.environments[e.ID] = e
+	return e, nil
+}
+
+func (m *MemStore) SetEnvironmentParent(ctx context.Context, arg repository.SetEnvironmentParentParams) (repository.Environment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.environments[arg.ID]
+	if !ok {
+		return repository.Environment{}, pgx.ErrNoRows
+	}
+	e.ParentID = arg.ParentID
+	if err := m.checkParent(e); err != nil {
+		return repository.Environment{}, err
+	}
+	e.UpdatedAt = m.tick()
+	m.environments[e.ID] = e
+	return e, nil
+}
+
+// DeleteEnvironment cascades like the foreign keys do: secrets, which are
+// logged as deleted, change requests and dynamic secrets go with the
+// environment
+func (m *MemStore) DeleteEnvironment(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.environments {
+		if e.ParentID.Valid && e.ParentID.Bytes == id {
+			return &pgconn.PgError{Code: "23503"}
+		}
+	}
+	if _, ok := m.environments[id]; !ok {
+		return nil
+	}
+	delete(m.environments, id)
+
+	for _, s := range m.secrets {
+		if s.EnvironmentID != id {
+			continue
+		}
+		delete(m.secrets, s.ID)
+		for k := range m.reminders {
+			if k.secretID == s.ID {
+				delete(m.reminders, k)
+			}
+		}
+		// Like the trigger, falls back to the nil user ID when neither is set
+		by := s.UpdatedBy
+		if !by.Valid {
+			by = s.CreatedBy
+		}
+		// Unlike a soft delete, the row is gone and no value is logged
+		h := m.recordHistory(s, repository.SecretActionDeleted, by)
+		h.EncryptedValue = nil
+		m.history[h.ID] = h
+	}
+	for _, d := range m.dynamic {
+		if d.EnvironmentID == id {
+			m.deleteDynamicSecret(d.ID)
+		}
+	}
+	for _, cr := range m.changes {
+		if cr.EnvironmentID != id {
+			continue
+		}
+		delete(m.changes, cr.ID)
+		for _, it := range m.changeItems {
+			if it.ChangeRequestID == cr.ID {
+				delete(m.changeItems, it.ID)
+			}
+		}
+		for k := range m.approvals {
+			if k.changeRequestID == cr.ID {
+				delete(m.approvals, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) ListExpiredEnvironments(ctx context.Context, limit int32) ([]repository.ListExpiredEnvironmentsRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Environments that must be kept: parents and holders of active leases
+	keep := make(map[uuid.UUID]bool)
+	for _, e := range m.environments {
+		if e.ParentID.Valid {
+			keep[e.ParentID.Bytes] = true
+		}
+	}
+	for _, l := range m.leases {
+		if !l.RevokedAt.Valid {
+			keep[m.dynamic[l.DynamicSecretID].EnvironmentID] = true
+		}
+	}
+	items := []repository.ListExpiredEnvironmentsRow{}
+	for _, e := range m.environments {
+		if !e.ExpiresAt.Valid || e.ExpiresAt.Time.After(now()) || keep[e.ID] {
+			continue
+		}
+		items = append(items, repository.ListExpiredEnvironmentsRow{
+			ID:             e.ID,
+			ProjectID:      e.ProjectID,
+			Name:           e.Name,
+			ExpiresAt:      e.ExpiresAt,
+			OrganizationID: m.projects[e.ProjectID].OrganizationID,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ExpiresAt.Time.Before(items[j].ExpiresAt.Time) })
+	if len(items) > int(limit) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// TryLockEnvironmentReaper always succeeds: MemStore transactions are not
+// isolated, so there is nothing to serialize
+func (m *MemStore) TryLockEnvironmentReaper(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// checkParent does what check_environment_parent() does: the parent must be
+// in the same project and must not inherit from e. Callers hold m.mu.
+func (m *MemStore) checkParent(e repository.Environment) error {
+	if !e.ParentID.Valid {
+		return nil
+	}
+	parent, ok := m.environments[uuid.UUID(e.ParentID.Bytes)]
+	if !ok {
+		return &pgconn.PgError{Code: "23503"}
+	}
+	if parent.ProjectID != e.ProjectID {
+		return &pgconn.PgError{Code: "23514"}
+	}
+	for seen := map[uuid.UUID]bool{}; ; {
+		if parent.ID == e.ID {
+			return &pgconn.PgError{Code: "23514"}
+		}
+		if seen[parent.ID] || !parent.ParentID.Valid {
+			return nil
+		}
+		seen[parent.ID] = true
+		parent = m.environments[uuid.UUID(parent.ParentID.Bytes)]
+	}
+}
+
+func (m *MemStore) ListEnvironmentsByProject(ctx context.Context, projectID uuid.UUID) ([]repository.Environment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.Environment{}
+	for _, e := range m.environments {
+		if e.ProjectID == projectID {
+			items = append(items, e)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	return items, nil
+}
+
+func (m *MemStore) GetSecretByKey(ctx context.Context, arg repository.GetSecretByKeyParams) (repository.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.secrets {
+		if s.EnvironmentID == arg.EnvironmentID && s.Key == arg.Key && isLive(s) {
+			return s, nil
+		}
+	}
+	return repository.Secret{}, pgx.ErrNoRows
+}
+
+func (m *MemStore) ListSecretsByEnvironment(ctx context.Context, environmentID uuid.UUID) ([]repository.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.Secret{}
+	for _, s := range m.secrets {
+		if s.EnvironmentID == environmentID && isLive(s) {
+			items = append(items, s)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items, nil
+}
+
+func (m *MemStore) GetLatestSecretVersion(ctx context.Context, arg repository.GetLatestSecretVersionParams) (int32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var version int32
+	for _, s := range m.secrets {
+		if s.EnvironmentID == arg.EnvironmentID && s.Key == arg.Key {
+			version = max(version, s.Version)
+		}
+	}
+	return version, nil
+}
+
+func (m *MemStore) CreateSecret(ctx context.Context, arg repository.CreateSecretParams) (repository.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.environments[arg.EnvironmentID]; !ok {
+		return repository.Secret{}, pgx.ErrNoRows
+	}
+	// The unique index on (environment_id, key) skips soft-deleted rows
+	for _, s := range m.secrets {
+		if s.EnvironmentID == arg.EnvironmentID && s.Key == arg.Key && !s.DeletedAt.Valid {
+			return repository.Secret{}, &pgconn.PgError{Code: "23505"}
+		}
+	}
+
+	s := repository.Secret{
+		ID:             uuid.New(),
+		EnvironmentID:  arg.EnvironmentID,
+		Key:            arg.Key,
+		EncryptedValue: arg.EncryptedValue,
+		Description:    arg.Description,
+		IsActive:       arg.IsActive,
+		Version:        arg.Version,
+		CreatedBy:      arg.CreatedBy,
+		CreatedAt:      now(),
+		UpdatedAt:      now(),
+		ValueChangedAt: now(),
+	}
+	m.secrets[s.ID] = s
+	m.recordHistory(s, repository.SecretActionCreated, s.CreatedBy)
+	return s, nil
+}
+
+func (m *MemStore) UpdateSecret(ctx context.Context, arg repository.UpdateSecretParams) (repository.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.secrets[arg.ID]
+	if !ok || s.DeletedAt.Valid {
+		return repository.Secret{}, pgx.ErrNoRows
+	}
+	s.EncryptedValue = arg.EncryptedValue
+	if arg.Description != nil {
+		s.Description = arg.Description
+	}
+	s.Version++
+	s.RestoredVersion = nil
+	active := true
+	s.IsActive = &active
+	if s.ExpiresAt.Valid && !s.ExpiresAt.Time.After(now()) {
+		s.ExpiresAt = pgtype.Timestamptz{}
+	}
+	s.UpdatedBy = arg.UpdatedBy
+	s.UpdatedAt = now()
+	s.ValueChangedAt = s.UpdatedAt
+	m.secrets[s.ID] = s
+	m.recordHistory(s, repository.SecretActionUpdated, s.UpdatedBy)
+	return s, nil
+}
+
+func (m *MemStore) RollbackSecret(ctx context.Context, arg repository.RollbackSecretParams) (repository.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.secrets[arg.ID]
+	if !ok || s.DeletedAt.Valid {
+		return repository.Secret{}, pgx.ErrNoRows
+	}
+	restored := arg.RestoredVersion
+	s.EncryptedValue = arg.EncryptedValue
+	s.Version++
+	s.RestoredVersion = &restored
+	s.UpdatedBy = arg.UpdatedBy
+	s.UpdatedAt = now()
+	s.ValueChangedAt = s.UpdatedAt
+	m.secrets[s.ID] = s
+	m.recordHistory(s, repository.SecretActionRolledBack, s.UpdatedBy)
+	return s, nil
+}
+
+func (m *MemStore) SoftDeleteSecret(ctx context.Context, arg repository.SoftDeleteSecretParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.secrets[arg.ID]
+	if !ok {
+		return nil
+	}
+	inactive := false
+	s.IsActive = &inactive
+	s.DeletedAt.Time, s.DeletedAt.Valid = now(), true
+	s.UpdatedBy = arg.UpdatedBy
+	m.secrets[s.ID] = s
+	m.recordHistory(s, repository.SecretActionDeleted, s.UpdatedBy)
+	return nil
+}
+
+func (m *MemStore) ListSecretsForReseal(ctx context.Context, arg repository.ListSecretsForResealParams) ([]repository.ListSecretsForResealRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListSecretsForResealRow{}
+	for _, s := range m.secrets {
+		if bytes.Compare(s.ID[:], arg.ID[:]) <= 0 {
+			continue
+		}
+		items = append(items, repository.ListSecretsForResealRow{
+			ID:             s.ID,
+			EnvironmentID:  s.EnvironmentID,
+			Key:            s.Key,
+			EncryptedValue: s.EncryptedValue,
+			Version:        s.Version,
+			ProjectID:      m.environments[s.EnvironmentID].ProjectID,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) ListProjectSecretsForRotation(ctx context.Context, arg repository.ListProjectSecretsForRotationParams) ([]repository.ListProjectSecretsForRotationRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListProjectSecretsForRotationRow{}
+	for _, s := range m.secrets {
+		if m.environments[s.EnvironmentID].ProjectID != arg.ProjectID || bytes.Compare(s.ID[:], arg.ID[:]) <= 0 {
+			continue
+		}
+		items = append(items, repository.ListProjectSecretsForRotationRow{
+			ID:             s.ID,
+			EnvironmentID:  s.EnvironmentID,
+			Key:            s.Key,
+			EncryptedValue: s.EncryptedValue,
+			Version:        s.Version,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) ResealSecret(ctx context.Context, arg repository.ResealSecretParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.secrets[arg.ID]
+	if !ok || s.EncryptedValue != arg.OldValue {
+		return 0, nil
+	}
+	s.EncryptedValue = arg.NewValue
+	s.UpdatedBy = arg.UpdatedBy
+	s.UpdatedAt = now()
+	m.secrets[s.ID] = s
+	m.recordHistory(s, repository.SecretActionRotated, s.UpdatedBy)
+	return 1, nil
+}
+
+func (m *MemStore) GetSecretByKeyIncludingInactive(ctx context.Context, arg repository.GetSecretByKeyIncludingInactiveParams) (repository.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.secrets {
+		if s.EnvironmentID == arg.EnvironmentID && s.Key == arg.Key && !s.DeletedAt.Valid {
+			return s, nil
+		}
+	}
+	return repository.Secret{}, pgx.ErrNoRows
+}
+
+// Like the log_secret_changes trigger, changes that keep the value, version
+// and deletion record no history
+func (m *MemStore) DeactivateSecret(ctx context.Context, arg repository.DeactivateSecretParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.secrets[arg.ID]
+	if !ok {
+		return nil
+	}
+	inactive := false
+	s.IsActive = &inactive
+	s.UpdatedBy = arg.UpdatedBy
+	s.UpdatedAt = now()
+	m.secrets[s.ID] = s
+	return nil
+}
+
+func (m *MemStore) SetSecretLifetime(ctx context.Context, arg repository.SetSecretLifetimeParams) (repository.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.secrets[arg.ID]
+	if !ok || s.DeletedAt.Valid {
+		return repository.Secret{}, pgx.ErrNoRows
+	}
+	s.ExpiresAt = arg.ExpiresAt
+	s.RotateEvery = arg.RotateEvery
+	s.IsActive = arg.IsActive
+	s.UpdatedBy = arg.UpdatedBy
+	s.UpdatedAt = now()
+	m.secrets[s.ID] = s
+	return s, nil
+}
+
+func (m *MemStore) ListDueSecretsByProject(ctx context.Context, arg repository.ListDueSecretsByProjectParams) ([]repository.ListDueSecretsByProjectRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListDueSecretsByProjectRow{}
+	for _, s := range m.secrets {
+		env := m.environments[s.EnvironmentID]
+		if env.ProjectID != arg.ProjectID || s.DeletedAt.Valid {
+			continue
+		}
+		expires := s.ExpiresAt.Valid && !s.ExpiresAt.Time.After(arg.Before.Time)
+		rotate, ok := rotationDueAt(s)
+		if !expires && !(ok && !rotate.After(arg.Before.Time)) {
+			continue
+		}
+		items = append(items, repository.ListDueSecretsByProjectRow{
+			ID:              s.ID,
+			EnvironmentName: env.Name,
+			Key:             s.Key,
+			Version:         s.Version,
+			IsActive:        s.IsActive,
+			ExpiresAt:       s.ExpiresAt,
+			RotateEvery:     s.RotateEvery,
+			ValueChangedAt:  s.ValueChangedAt,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].EnvironmentName != items[j].EnvironmentName {
+			return items[i].EnvironmentName < items[j].EnvironmentName
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items, nil
+}
+
+func (m *MemStore) ListExpiredSecrets(ctx context.Context, n int32) ([]repository.ListExpiredSecretsRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListExpiredSecretsRow{}
+	for _, s := range m.secrets {
+		if s.DeletedAt.Valid || !s.ExpiresAt.Valid || s.ExpiresAt.Time.After(now()) {
+			continue
+		}
+		if _, ok := m.reminders[reminderKey{s.ID, "secret.expired", s.ExpiresAt.Time}]; ok {
+			continue
+		}
+		items = append(items, repository.ListExpiredSecretsRow(m.dueSecret(s, s.ExpiresAt.Time)))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].DueAt.Time.Before(items[j].DueAt.Time) })
+	return limit(items, n), nil
+}
+
+func (m *MemStore) ListSecretsDueForRotation(ctx context.Context, n int32) ([]repository.ListSecretsDueForRotationRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListSecretsDueForRotationRow{}
+	for _, s := range m.secrets {
+		due, ok := rotationDueAt(s)
+		if !isLive(s) || !ok || due.After(now()) {
+			continue
+		}
+		if _, ok := m.reminders[reminderKey{s.ID, "secret.rotation_due", due}]; ok {
+			continue
+		}
+		items = append(items, m.dueSecret(s, due))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].DueAt.Time.Before(items[j].DueAt.Time) })
+	return limit(items, n), nil
+}
+
+// dueSecret is the row the reminder queries return for s. Callers hold m.mu.
+func (m *MemStore) dueSecret(s repository.Secret, due time.Time) repository.ListSecretsDueForRotationRow {
+	env := m.environments[s.EnvironmentID]
+	return repository.ListSecretsDueForRotationRow{
+		ID:              s.ID,
+		EnvironmentID:   s.EnvironmentID,
+		Key:             s.Key,
+		Version:         s.Version,
+		IsActive:        s.IsActive,
+		UpdatedBy:       s.UpdatedBy,
+		DueAt:           pgtype.Timestamptz{Time: due, Valid: true},
+		EnvironmentName: env.Name,
+		ProjectID:       env.ProjectID,
+		OrganizationID:  m.projects[env.ProjectID].OrganizationID,
+	}
+}
+
+func (m *MemStore) CreateSecretReminder(ctx context.Context, arg repository.CreateSecretReminderParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := reminderKey{arg.SecretID, arg.Event, arg.DueAt}
+	if _, ok := m.reminders[k]; ok {
+		return &pgconn.PgError{Code: "23505"}
+	}
+	m.reminders[k] = repository.SecretReminder{SecretID: arg.SecretID, Event: arg.Event, DueAt: arg.DueAt, CreatedAt: now()}
+	return nil
+}
+
+// TryLockSecretReminders always succeeds; there is only one MemStore client
+func (m *MemStore) TryLockSecretReminders(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (m *MemStore) CreateDynamicSecret(ctx context.Context, arg repository.CreateDynamicSecretParams) (repository.DynamicSecret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.dynamic {
+		if d.EnvironmentID == arg.EnvironmentID && d.Key == arg.Key {
+			return repository.DynamicSecret{}, &pgconn.PgError{Code: "23505"}
+		}
+	}
+	at := m.tick()
+	d := repository.DynamicSecret{
+		ID:                   uuid.New(),
+		EnvironmentID:        arg.EnvironmentID,
+		Key:                  arg.Key,
+		EncryptedConnection:  arg.EncryptedConnection,
+		CreationStatements:   arg.CreationStatements,
+		RevocationStatements: arg.RevocationStatements,
+		DefaultTtl:           arg.DefaultTtl,
+		MaxTtl:               arg.MaxTtl,
+		CreatedBy:            arg.CreatedBy,
+		CreatedAt:            at,
+		UpdatedAt:            at,
+	}
+	m.dynamic[d.ID] = d
+	return d, nil
+}
+
+func (m *MemStore) GetDynamicSecretByKey(ctx context.Context, arg repository.GetDynamicSecretByKeyParams) (repository.DynamicSecret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.dynamic {
+		if d.EnvironmentID == arg.EnvironmentID && d.Key == arg.Key {
+			return d, nil
+		}
+	}
+	return repository.DynamicSecret{}, pgx.ErrNoRows
+}
+
+func (m *MemStore) ListDynamicSecretsByEnvironment(ctx context.Context, environmentID uuid.UUID) ([]repository.DynamicSecret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.DynamicSecret{}
+	for _, d := range m.dynamic {
+		if d.EnvironmentID == environmentID {
+			items = append(items, d)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items, nil
+}
+
+func (m *MemStore) DeleteDynamicSecret(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteDynamicSecret(id)
+	return nil
+}
+
+// deleteDynamicSecret deletes a dynamic secret and, like the foreign key,
+// its leases. Callers hold m.mu.
+func (m *MemStore) deleteDynamicSecret(id uuid.UUID) {
+	delete(m.dynamic, id)
+	for _, l := range m.leases {
+		if l.DynamicSecretID == id {
+			delete(m.leases, l.ID)
+		}
+	}
+}
+
+func (m *MemStore) ListProjectDynamicSecretsForRotation(ctx context.Context, arg repository.ListProjectDynamicSecretsForRotationParams) ([]repository.ListProjectDynamicSecretsForRotationRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListProjectDynamicSecretsForRotationRow{}
+	for _, d := range m.dynamic {
+		if m.environments[d.EnvironmentID].ProjectID != arg.ProjectID || bytes.Compare(d.ID[:], arg.ID[:]) <= 0 {
+			continue
+		}
+		items = append(items, repository.ListProjectDynamicSecretsForRotationRow{
+			ID:                  d.ID,
+			EnvironmentID:       d.EnvironmentID,
+			Key:                 d.Key,
+			EncryptedConnection: d.EncryptedConnection,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) ResealDynamicSecret(ctx context.Context, arg repository.ResealDynamicSecretParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.dynamic[arg.ID]
+	if !ok || d.EncryptedConnection != arg.OldConnection {
+		return 0, nil
+	}
+	d.EncryptedConnection = arg.NewConnection
+	d.UpdatedBy = arg.UpdatedBy
+	d.UpdatedAt = now()
+	m.dynamic[d.ID] = d
+	return 1, nil
+}
+
+func (m *MemStore) CreateDynamicSecretLease(ctx context.Context, arg repository.CreateDynamicSecretLeaseParams) (repository.DynamicSecretLease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.dynamic[arg.DynamicSecretID]; !ok {
+		return repository.DynamicSecretLease{}, &pgconn.PgError{Code: "23503"}
+	}
+	l := repository.DynamicSecretLease{
+		ID:              uuid.New(),
+		DynamicSecretID: arg.DynamicSecretID,
+		RoleName:        arg.RoleName,
+		ExpiresAt:       arg.ExpiresAt,
+		CreatedBy:       arg.CreatedBy,
+		CreatedAt:       m.tick(),
+	}
+	m.leases[l.ID] = l
+	return l, nil
+}
+
+func (m *MemStore) GetDynamicSecretLeaseForUpdate(ctx context.Context, arg repository.GetDynamicSecretLeaseForUpdateParams) (repository.DynamicSecretLease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.leases[arg.ID]
+	if !ok || l.DynamicSecretID != arg.DynamicSecretID {
+		return repository.DynamicSecretLease{}, pgx.ErrNoRows
+	}
+	return l, nil
+}
+
+func (m *MemStore) ListActiveDynamicSecretLeases(ctx context.Context, dynamicSecretID uuid.UUID) ([]repository.DynamicSecretLease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.DynamicSecretLease{}
+	for _, l := range m.leases {
+		if l.DynamicSecretID == dynamicSecretID && !l.RevokedAt.Valid {
+			items = append(items, l)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	return items, nil
+}
+
+func (m *MemStore) RevokeDynamicSecretLease(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.leases[id]
+	if !ok {
+		return nil
+	}
+	l.RevokedAt = pgtype.Timestamptz{Time: now(), Valid: true}
+	l.RevokeError = nil
+	m.leases[id] = l
+	return nil
+}
+
+func (m *MemStore) FailDynamicSecretLeaseRevocation(ctx context.Context, arg repository.FailDynamicSecretLeaseRevocationParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.leases[arg.ID]
+	if !ok {
+		return nil
+	}
+	l.RevokeError = arg.RevokeError
+	m.leases[l.ID] = l
+	return nil
+}
+
+func (m *MemStore) ListExpiredDynamicSecretLeases(ctx context.Context, n int32) ([]repository.ListExpiredDynamicSecretLeasesRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListExpiredDynamicSecretLeasesRow{}
+	for _, l := range m.leases {
+		if l.RevokedAt.Valid || l.ExpiresAt.After(now()) {
+			continue
+		}
+		d := m.dynamic[l.DynamicSecretID]
+		env := m.environments[d.EnvironmentID]
+		items = append(items, repository.ListExpiredDynamicSecretLeasesRow{
+			ID:                   l.ID,
+			DynamicSecretID:      d.ID,
+			RoleName:             l.RoleName,
+			ExpiresAt:            l.ExpiresAt,
+			Key:                  d.Key,
+			EncryptedConnection:  d.EncryptedConnection,
+			RevocationStatements: d.RevocationStatements,
+			EnvironmentID:        env.ID,
+			ProjectID:            env.ProjectID,
+			OrganizationID:       m.projects[env.ProjectID].OrganizationID,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ExpiresAt.Before(items[j].ExpiresAt) })
+	return limit(items, n), nil
+}
+
+// TryLockDynamicSecretLeases always succeeds; there is only one MemStore
+// client
+func (m *MemStore) TryLockDynamicSecretLeases(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Lease returns a lease, revoked or not
+func (m *MemStore) Lease(id uuid.UUID) (repository.DynamicSecretLease, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.leases[id]
+	return l, ok
+}
+
+// History returns the history entries of a secret, oldest first
+func (m *MemStore) History(secretID uuid.UUID) []repository.SecretHistory {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.SecretHistory{}
+	for _, h := range m.history {
+		if h.SecretID == secretID {
+			items = append(items, h)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	return items
+}
+
+func (m *MemStore) ListSecretHistory(ctx context.Context, arg repository.ListSecretHistoryParams) ([]repository.SecretHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, found := m.history[uuid.UUID(arg.Before.Bytes)]
+
+	items := []repository.SecretHistory{}
+	for _, h := range m.history {
+		if h.SecretID != arg.SecretID {
+			continue
+		}
+		if arg.Before.Valid && (!found || !historyBefore(h, before)) {
+			continue
+		}
+		items = append(items, h)
+	}
+	sort.Slice(items, func(i, j int) bool { return historyBefore(items[j], items[i]) })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) SearchSecretHistory(ctx context.Context, arg repository.SearchSecretHistoryParams) ([]repository.SecretHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, found := m.history[uuid.UUID(arg.Before.Bytes)]
+
+	items := []repository.SecretHistory{}
+	for _, h := range m.history {
+		switch {
+		case !h.OrganizationID.Valid || h.OrganizationID.Bytes != arg.OrganizationID,
+			arg.ChangedBy.Valid && h.ChangedBy != uuid.UUID(arg.ChangedBy.Bytes),
+			arg.SecretID.Valid && h.SecretID != uuid.UUID(arg.SecretID.Bytes),
+			arg.EnvironmentID.Valid && h.EnvironmentID != uuid.UUID(arg.EnvironmentID.Bytes),
+			arg.Action.Valid && h.Action != arg.Action.SecretAction,
+			!inWindow(h.CreatedAt, arg.Since, arg.Until),
+			arg.Before.Valid && (!found || !historyBefore(h, before)):
+			continue
+		}
+		items = append(items, h)
+	}
+	sort.Slice(items, func(i, j int) bool { return historyBefore(items[j], items[i]) })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) GetSecretHistoryVersion(ctx context.Context, arg repository.GetSecretHistoryVersionParams) (repository.SecretHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		found repository.SecretHistory
+		ok    bool
+	)
+	for _, h := range m.history {
+		if h.SecretID != arg.SecretID || h.Version == nil || *h.Version != arg.Version || h.EncryptedValue == nil {
+			continue
+		}
+		if !ok || historyBefore(found, h) {
+			found, ok = h, true
+		}
+	}
+	if !ok {
+		return repository.SecretHistory{}, pgx.ErrNoRows
+	}
+	return found, nil
+}
+
+func (m *MemStore) ListSecretHistoryForReseal(ctx context.Context, arg repository.ListSecretHistoryForResealParams) ([]repository.ListSecretHistoryForResealRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListSecretHistoryForResealRow{}
+	for _, h := range m.history {
+		if h.EncryptedValue == nil || !h.ProjectID.Valid || bytes.Compare(h.ID[:], arg.ID[:]) <= 0 {
+			continue
+		}
+		items = append(items, repository.ListSecretHistoryForResealRow{
+			ID:             h.ID,
+			EnvironmentID:  h.EnvironmentID,
+			Key:            h.Key,
+			EncryptedValue: h.EncryptedValue,
+			Version:        h.Version,
+			ProjectID:      h.ProjectID,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) ListProjectSecretHistoryForRotation(ctx context.Context, arg repository.ListProjectSecretHistoryForRotationParams) ([]repository.ListProjectSecretHistoryForRotationRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListProjectSecretHistoryForRotationRow{}
+	for _, h := range m.history {
+		if h.EncryptedValue == nil || !h.ProjectID.Valid || h.ProjectID.Bytes != arg.ProjectID || bytes.Compare(h.ID[:], arg.ID[:]) <= 0 {
+			continue
+		}
+		items = append(items, repository.ListProjectSecretHistoryForRotationRow{
+			ID:             h.ID,
+			EnvironmentID:  h.EnvironmentID,
+			Key:            h.Key,
+			EncryptedValue: h.EncryptedValue,
+			Version:        h.Version,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) ResealSecretHistory(ctx context.Context, arg repository.ResealSecretHistoryParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.history[arg.ID]
+	if !ok || h.EncryptedValue == nil || arg.OldValue == nil || *h.EncryptedValue != *arg.OldValue {
+		return 0, nil
+	}
+	value := arg.NewValue
+	h.EncryptedValue = &value
+	m.history[h.ID] = h
+	return 1, nil
+}
+
+// AccessLogs returns every access log entry in the order written
+func (m *MemStore) AccessLogs() []repository.AccessLog {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.accessLogs)
+}
+
+// Events returns the recorded events, oldest first
+func (m *MemStore) Events() []repository.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.events)
+}
+
+func (m *MemStore) CreateEvent(ctx context.Context, arg repository.CreateEventParams) (repository.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := repository.Event{
+		ID:             uuid.New(),
+		OrganizationID: arg.OrganizationID,
+		ProjectID:      arg.ProjectID,
+		Type:           arg.Type,
+		Payload:        arg.Payload,
+		CreatedAt:      m.tick(),
+	}
+	m.events = append(m.events, e)
+	return e, nil
+}
+
+func (m *MemStore) ListUndispatchedEvents(ctx context.Context, n int32) ([]repository.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.Event{}
+	for _, e := range m.events {
+		if !e.DispatchedAt.Valid {
+			items = append(items, e)
+		}
+	}
+	return limit(items, n), nil
+}
+
+func (m *MemStore) MarkEventDispatched(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, e := range m.events {
+		if e.ID == id {
+			m.events[i].DispatchedAt = pgtype.Timestamptz{Time: now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+// event returns the event with id. Callers hold m.mu.
+func (m *MemStore) event(id uuid.UUID) (repository.Event, bool) {
+	for _, e := range m.events {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return repository.Event{}, false
+}
+
+func (m *MemStore) CreateWebhook(ctx context.Context, arg repository.CreateWebhookParams) (repository.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if arg.ProjectID.Valid {
+		if _, ok := m.projects[arg.ProjectID.Bytes]; !ok {
+			return repository.Webhook{}, &pgconn.PgError{Code: "23503"}
+		}
+	}
+	at := m.tick()
+	w := repository.Webhook{
+		ID:              uuid.New(),
+		OrganizationID:  arg.OrganizationID,
+		ProjectID:       arg.ProjectID,
+		Url:             arg.Url,
+		Events:          slices.Clone(arg.Events),
+		EncryptedSecret: arg.EncryptedSecret,
+		Description:     arg.Description,
+		IsActive:        true,
+		CreatedBy:       arg.CreatedBy,
+		CreatedAt:       at,
+		UpdatedAt:       at,
+	}
+	m.webhooks[w.ID] = w
+	return w, nil
+}
+
+func (m *MemStore) GetWebhook(ctx context.Context, arg repository.GetWebhookParams) (repository.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.webhooks[arg.ID]
+	if !ok || w.OrganizationID != arg.OrganizationID {
+		return repository.Webhook{}, pgx.ErrNoRows
+	}
+	return w, nil
+}
+
+func (m *MemStore) ListWebhooksByOrganization(ctx context.Context, organizationID uuid.UUID) ([]repository.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.Webhook{}
+	for _, w := range m.webhooks {
+		if w.OrganizationID == organizationID {
+			items = append(items, w)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	return items, nil
+}
+
+func (m *MemStore) UpdateWebhook(ctx context.Context, arg repository.UpdateWebhookParams) (repository.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.webhooks[arg.ID]
+	if !ok {
+		return repository.Webhook{}, pgx.ErrNoRows
+	}
+	w.Url = arg.Url
+	w.Events = slices.Clone(arg.Events)
+	w.Description = arg.Description
+	w.IsActive = arg.IsActive
+	w.UpdatedAt = m.tick()
+	m.webhooks[w.ID] = w
+	return w, nil
+}
+
+// DeleteWebhook deletes a webhook along with its deliveries
+func (m *MemStore) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.webhooks, id)
+	for _, d := range m.deliveries {
+		if d.WebhookID == id {
+			delete(m.deliveries, d.ID)
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) ListWebhooksForEvent(ctx context.Context, arg repository.ListWebhooksForEventParams) ([]repository.ListWebhooksForEventRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListWebhooksForEventRow{}
+	for _, w := range m.webhooks {
+		if w.OrganizationID != arg.OrganizationID || !w.IsActive {
+			continue
+		}
+		if w.ProjectID.Valid && (!arg.ProjectID.Valid || w.ProjectID.Bytes != arg.ProjectID.Bytes) {
+			continue
+		}
+		items = append(items, repository.ListWebhooksForEventRow{ID: w.ID, Events: slices.Clone(w.Events)})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return items, nil
+}
+
+func (m *MemStore) ListWebhookSecrets(ctx context.Context) ([]repository.ListWebhookSecretsRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListWebhookSecretsRow{}
+	for _, w := range m.webhooks {
+		items = append(items, repository.ListWebhookSecretsRow{ID: w.ID, EncryptedSecret: w.EncryptedSecret})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return items, nil
+}
+
+func (m *MemStore) RewrapWebhookSecret(ctx context.Context, arg repository.RewrapWebhookSecretParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.webhooks[arg.ID]
+	if !ok || w.EncryptedSecret != arg.OldSecret {
+		return 0, nil
+	}
+	w.EncryptedSecret = arg.NewSecret
+	m.webhooks[w.ID] = w
+	return 1, nil
+}
+
+func (m *MemStore) CreateWebhookDelivery(ctx context.Context, arg repository.CreateWebhookDeliveryParams) (repository.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[arg.WebhookID]; !ok {
+		return repository.WebhookDelivery{}, &pgconn.PgError{Code: "23503"}
+	}
+	if _, ok := m.event(arg.EventID); !ok {
+		return repository.WebhookDelivery{}, &pgconn.PgError{Code: "23503"}
+	}
+	at := m.tick()
+	d := repository.WebhookDelivery{
+		ID:            uuid.New(),
+		WebhookID:     arg.WebhookID,
+		EventID:       arg.EventID,
+		RedeliveryOf:  arg.RedeliveryOf,
+		Status:        repository.WebhookDeliveryStatusPending,
+		NextAttemptAt: now(),
+		CreatedBy:     arg.CreatedBy,
+		CreatedAt:     at,
+	}
+	m.deliveries[d.ID] = d
+	return d, nil
+}
+
+func (m *MemStore) GetWebhookDelivery(ctx context.Context, arg repository.GetWebhookDeliveryParams) (repository.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[arg.ID]
+	if !ok || d.WebhookID != arg.WebhookID {
+		return repository.WebhookDelivery{}, pgx.ErrNoRows
+	}
+	return d, nil
+}
+
+func (m *MemStore) ListWebhookDeliveries(ctx context.Context, arg repository.ListWebhookDeliveriesParams) ([]repository.ListWebhookDeliveriesRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before, found := m.deliveries[uuid.UUID(arg.Before.Bytes)]
+
+	items := []repository.ListWebhookDeliveriesRow{}
+	for _, d := range m.deliveries {
+		if d.WebhookID != arg.WebhookID {
+			continue
+		}
+		if arg.Status.Valid && d.Status != arg.Status.WebhookDeliveryStatus {
+			continue
+		}
+		if arg.Before.Valid && (!found || !deliveryBefore(d, before)) {
+			continue
+		}
+		e, _ := m.event(d.EventID)
+		items = append(items, repository.ListWebhookDeliveriesRow{
+			ID:             d.ID,
+			WebhookID:      d.WebhookID,
+			EventID:        d.EventID,
+			RedeliveryOf:   d.RedeliveryOf,
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			NextAttemptAt:  d.NextAttemptAt,
+			LastAttemptAt:  d.LastAttemptAt,
+			ResponseStatus: d.ResponseStatus,
+			LastError:      d.LastError,
+			DeliveredAt:    d.DeliveredAt,
+			CreatedBy:      d.CreatedBy,
+			CreatedAt:      d.CreatedAt,
+			EventType:      e.Type,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return deliveryBefore(m.deliveries[items[j].ID], m.deliveries[items[i].ID])
+	})
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) ListDueWebhookDeliveries(ctx context.Context, n int32) ([]repository.ListDueWebhookDeliveriesRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListDueWebhookDeliveriesRow{}
+	for _, d := range m.deliveries {
+		w := m.webhooks[d.WebhookID]
+		if d.Status != repository.WebhookDeliveryStatusPending || d.NextAttemptAt.After(now()) || !w.IsActive {
+			continue
+		}
+		e, _ := m.event(d.EventID)
+		items = append(items, repository.ListDueWebhookDeliveriesRow{
+			ID:              d.ID,
+			WebhookID:       w.ID,
+			Attempts:        d.Attempts,
+			Url:             w.Url,
+			EncryptedSecret: w.EncryptedSecret,
+			EventID:         e.ID,
+			EventType:       e.Type,
+			Payload:         e.Payload,
+			OrganizationID:  e.OrganizationID,
+			ProjectID:       e.ProjectID,
+			EventCreatedAt:  e.CreatedAt,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := m.deliveries[items[i].ID], m.deliveries[items[j].ID]
+		if !a.NextAttemptAt.Equal(b.NextAttemptAt) {
+			return a.NextAttemptAt.Before(b.NextAttemptAt)
+		}
+		return deliveryBefore(a, b)
+	})
+	return limit(items, n), nil
+}
+
+func (m *MemStore) ClaimWebhookDelivery(ctx context.Context, arg repository.ClaimWebhookDeliveryParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d, ok := m.deliveries[arg.ID]; ok {
+		d.NextAttemptAt = arg.ClaimedUntil
+		m.deliveries[arg.ID] = d
+	}
+	return nil
+}
+
+func (m *MemStore) RecordWebhookDeliveryAttempt(ctx context.Context, arg repository.RecordWebhookDeliveryAttemptParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[arg.ID]
+	if !ok {
+		return nil
+	}
+	at := pgtype.Timestamptz{Time: now(), Valid: true}
+	d.Status = arg.Status
+	d.Attempts++
+	d.NextAttemptAt = arg.NextAttemptAt
+	d.LastAttemptAt = at
+	d.ResponseStatus = arg.ResponseStatus
+	d.LastError = arg.LastError
+	d.DeliveredAt = pgtype.Timestamptz{}
+	if arg.Status == repository.WebhookDeliveryStatusDelivered {
+		d.DeliveredAt = at
+	}
+	m.deliveries[d.ID] = d
+	return nil
+}
+
+// TryLockWebhookDeliveries always succeeds; there is only one MemStore
+// client
+func (m *MemStore) TryLockWebhookDeliveries(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Delivery returns a webhook delivery
+func (m *MemStore) Delivery(id uuid.UUID) (repository.WebhookDelivery, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.deliveries[id]
+	return d, ok
+}
+
+// Deliveries returns every webhook delivery, oldest first
+func (m *MemStore) Deliveries() []repository.WebhookDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := slices.Collect(maps.Values(m.deliveries))
+	sort.Slice(items, func(i, j int) bool { return deliveryBefore(items[i], items[j]) })
+	return items
+}
+
+func (m *MemStore) CreateAccessLog(ctx context.Context, arg repository.CreateAccessLogParams) (repository.AccessLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := repository.AccessLog{
+		ID:             uuid.New(),
+		UserID:         arg.UserID,
+		ApiTokenID:     arg.ApiTokenID,
+		ResourceType:   arg.ResourceType,
+		ResourceID:     arg.ResourceID,
+		Action:         arg.Action,
+		CreatedAt:      arg.CreatedAt,
+		IpAddress:      arg.IpAddress,
+		UserAgent:      arg.UserAgent,
+		Success:        arg.Success,
+		ErrorMessage:   arg.ErrorMessage,
+		OrganizationID: arg.OrganizationID,
+	}
+	m.accessLogs = append(m.accessLogs, l)
+	return l, nil
+}
+
+func (m *MemStore) SearchAccessLogs(ctx context.Context, arg repository.SearchAccessLogsParams) ([]repository.AccessLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		before repository.AccessLog
+		found  bool
+	)
+	for _, l := range m.accessLogs {
+		if arg.Before.Valid && l.ID == uuid.UUID(arg.Before.Bytes) {
+			before, found = l, true
+		}
+	}
+
+	items := []repository.AccessLog{}
+	for _, l := range m.accessLogs {
+		switch {
+		case !l.OrganizationID.Valid || l.OrganizationID.Bytes != arg.OrganizationID,
+			arg.UserID.Valid && l.UserID != arg.UserID,
+			arg.ResourceType != nil && l.ResourceType != *arg.ResourceType,
+			arg.ResourceID.Valid && l.ResourceID != uuid.UUID(arg.ResourceID.Bytes),
+			arg.Action.Valid && l.Action != arg.Action.AccessAction,
+			arg.Success != nil && l.Success != *arg.Success,
+			!inWindow(l.CreatedAt, arg.Since, arg.Until),
+			arg.Before.Valid && (!found || !accessLogBefore(l, before)):
+			continue
+		}
+		items = append(items, l)
+	}
+	sort.Slice(items, func(i, j int) bool { return accessLogBefore(items[j], items[i]) })
+	return limit(items, arg.Limit), nil
+}
+
+// RewriteSecretHistory replaces each stored history entry with what fn
+// returns, so tests can tamper with the audit trail
+func (m *MemStore) RewriteSecretHistory(fn func(repository.SecretHistory) repository.SecretHistory) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, h := range m.history {
+		m.history[id] = fn(h)
+	}
+}
+
+// RewriteAccessLogs replaces the stored access log entries with what fn
+// returns, so tests can tamper with the audit trail
+func (m *MemStore) RewriteAccessLogs(fn func([]repository.AccessLog) []repository.AccessLog) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accessLogs = fn(slices.Clone(m.accessLogs))
+}
+
+func (m *MemStore) GetAccessLogChainHead(ctx context.Context) (repository.AccessLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		head repository.AccessLog
+		ok   bool
+	)
+	for _, l := range m.accessLogs {
+		if l.ChainSeq != nil && (!ok || *l.ChainSeq > *head.ChainSeq) {
+			head, ok = l, true
+		}
+	}
+	if !ok {
+		return repository.AccessLog{}, pgx.ErrNoRows
+	}
+	return head, nil
+}
+
+func (m *MemStore) ListUnsealedAccessLogs(ctx context.Context, n int32) ([]repository.AccessLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.AccessLog{}
+	for _, l := range m.accessLogs {
+		if l.ChainSeq == nil {
+			items = append(items, l)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return accessLogBefore(items[i], items[j]) })
+	return limit(items, n), nil
+}
+
+func (m *MemStore) SealAccessLog(ctx context.Context, arg repository.SealAccessLogParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, l := range m.accessLogs {
+		if l.ID != arg.ID || l.ChainSeq != nil {
+			continue
+		}
+		seq := arg.ChainSeq
+		l.ChainSeq, l.PrevHash, l.RowHash = &seq, arg.PrevHash, arg.RowHash
+		m.accessLogs[i] = l
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m *MemStore) ListAccessLogChain(ctx context.Context, arg repository.ListAccessLogChainParams) ([]repository.AccessLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.AccessLog{}
+	for _, l := range m.accessLogs {
+		if l.ChainSeq != nil && *l.ChainSeq > arg.After {
+			items = append(items, l)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return *items[i].ChainSeq < *items[j].ChainSeq })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) GetSecretHistoryChainHead(ctx context.Context) (repository.SecretHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		head repository.SecretHistory
+		ok   bool
+	)
+	for _, h := range m.history {
+		if h.ChainSeq != nil && (!ok || *h.ChainSeq > *head.ChainSeq) {
+			head, ok = h, true
+		}
+	}
+	if !ok {
+		return repository.SecretHistory{}, pgx.ErrNoRows
+	}
+	return head, nil
+}
+
+func (m *MemStore) ListUnsealedSecretHistory(ctx context.Context, n int32) ([]repository.SecretHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.SecretHistory{}
+	for _, h := range m.history {
+		if h.ChainSeq == nil {
+			items = append(items, h)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return historyBefore(items[i], items[j]) })
+	return limit(items, n), nil
+}
+
+func (m *MemStore) SealSecretHistory(ctx context.Context, arg repository.SealSecretHistoryParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.history[arg.ID]
+	if !ok || h.ChainSeq != nil {
+		return 0, nil
+	}
+	seq, version := arg.ChainSeq, arg.LinkVersion
+	h.ChainSeq, h.PrevHash, h.RowHash, h.LinkVersion = &seq, arg.PrevHash, arg.RowHash, &version
+	m.history[h.ID] = h
+	return 1, nil
+}
+
+func (m *MemStore) ListSecretHistoryChain(ctx context.Context, arg repository.ListSecretHistoryChainParams) ([]repository.SecretHistory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.SecretHistory{}
+	for _, h := range m.history {
+		if h.ChainSeq != nil && *h.ChainSeq > arg.After {
+			items = append(items, h)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return *items[i].ChainSeq < *items[j].ChainSeq })
+	return limit(items, arg.Limit), nil
+}
+
+// LockAuditChain is a no-op; MemStore transactions are not isolated
+func (m *MemStore) LockAuditChain(ctx context.Context, chain string) error {
+	return nil
+}
+
+func (m *MemStore) CreateAuditCheckpoint(ctx context.Context, arg repository.CreateAuditCheckpointParams) (repository.AuditCheckpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cp := repository.AuditCheckpoint{
+		ID:        uuid.New(),
+		Chain:     arg.Chain,
+		ChainSeq:  arg.ChainSeq,
+		RowHash:   arg.RowHash,
+		KeyID:     arg.KeyID,
+		Signature: arg.Signature,
+		CreatedAt: arg.CreatedAt,
+	}
+	m.checkpoints = append(m.checkpoints, cp)
+	return cp, nil
+}
+
+func (m *MemStore) ListAuditCheckpoints(ctx context.Context, chain string) ([]repository.AuditCheckpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.AuditCheckpoint{}
+	for _, cp := range m.checkpoints {
+		if cp.Chain == chain {
+			items = append(items, cp)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ChainSeq < items[j].ChainSeq })
+	return items, nil
+}
+
+func (m *MemStore) CreateChangeRequest(ctx context.Context, arg repository.CreateChangeRequestParams) (repository.ChangeRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.environments[arg.EnvironmentID]; !ok {
+		return repository.ChangeRequest{}, pgx.ErrNoRows
+	}
+	cr := repository.ChangeRequest{
+		ID:                uuid.New(),
+		EnvironmentID:     arg.EnvironmentID,
+		Status:            repository.ChangeRequestStatusPending,
+		Reason:            arg.Reason,
+		RequiredApprovals: arg.RequiredApprovals,
+		RequestedBy:       arg.RequestedBy,
+		CreatedAt:         m.tick(),
+		UpdatedAt:         now(),
+	}
+	m.changes[cr.ID] = cr
+	return cr, nil
+}
+
+func (m *MemStore) GetChangeRequest(ctx context.Context, id uuid.UUID) (repository.ChangeRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cr, ok := m.changes[id]
+	if !ok {
+		return repository.ChangeRequest{}, pgx.ErrNoRows
+	}
+	return cr, nil
+}
+
+func (m *MemStore) GetChangeRequestForUpdate(ctx context.Context, id uuid.UUID) (repository.ChangeRequest, error) {
+	return m.GetChangeRequest(ctx, id)
+}
+
+func (m *MemStore) ListChangeRequestsByEnvironment(ctx context.Context, arg repository.ListChangeRequestsByEnvironmentParams) ([]repository.ChangeRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ChangeRequest{}
+	for _, cr := range m.changes {
+		if cr.EnvironmentID != arg.EnvironmentID || (arg.Status.Valid && cr.Status != arg.Status.ChangeRequestStatus) {
+			continue
+		}
+		items = append(items, cr)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.After(items[j].CreatedAt) })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) DecideChangeRequest(ctx context.Context, arg repository.DecideChangeRequestParams) (repository.ChangeRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cr, ok := m.changes[arg.ID]
+	if !ok || cr.Status != repository.ChangeRequestStatusPending {
+		return repository.ChangeRequest{}, pgx.ErrNoRows
+	}
+	cr.Status = arg.Status
+	cr.DecidedBy = arg.DecidedBy
+	cr.DecisionComment = arg.DecisionComment
+	cr.DecidedAt.Time, cr.DecidedAt.Valid = now(), true
+	cr.UpdatedAt = now()
+	m.changes[cr.ID] = cr
+	return cr, nil
+}
+
+func (m *MemStore) CreateChangeRequestItem(ctx context.Context, arg repository.CreateChangeRequestItemParams) (repository.ChangeRequestItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.changes[arg.ChangeRequestID]; !ok {
+		return repository.ChangeRequestItem{}, pgx.ErrNoRows
+	}
+	for _, it := range m.changeItems {
+		if it.ChangeRequestID == arg.ChangeRequestID && it.Key == arg.Key {
+			return repository.ChangeRequestItem{}, &pgconn.PgError{Code: "23505"}
+		}
+	}
+	it := repository.ChangeRequestItem{
+		ID:              uuid.New(),
+		ChangeRequestID: arg.ChangeRequestID,
+		Key:             arg.Key,
+		Operation:       arg.Operation,
+		EncryptedValue:  arg.EncryptedValue,
+		Description:     arg.Description,
+		BaseVersion:     arg.BaseVersion,
+		RestoredVersion: arg.RestoredVersion,
+	}
+	m.changeItems[it.ID] = it
+	return it, nil
+}
+
+func (m *MemStore) ListChangeRequestItems(ctx context.Context, changeRequestID uuid.UUID) ([]repository.ChangeRequestItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ChangeRequestItem{}
+	for _, it := range m.changeItems {
+		if it.ChangeRequestID == changeRequestID {
+			items = append(items, it)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items, nil
+}
+
+func (m *MemStore) ListProjectChangeRequestItemsForRotation(ctx context.Context, arg repository.ListProjectChangeRequestItemsForRotationParams) ([]repository.ListProjectChangeRequestItemsForRotationRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ListProjectChangeRequestItemsForRotationRow{}
+	for _, it := range m.changeItems {
+		cr := m.changes[it.ChangeRequestID]
+		if it.EncryptedValue == nil || cr.Status != repository.ChangeRequestStatusPending ||
+			m.environments[cr.EnvironmentID].ProjectID != arg.ProjectID || bytes.Compare(it.ID[:], arg.ID[:]) <= 0 {
+			continue
+		}
+		row := repository.ListProjectChangeRequestItemsForRotationRow{
+			ID:             it.ID,
+			EnvironmentID:  cr.EnvironmentID,
+			Key:            it.Key,
+			EncryptedValue: it.EncryptedValue,
+			Version:        1,
+		}
+		if it.BaseVersion != nil {
+			row.Version = *it.BaseVersion + 1
+		}
+		items = append(items, row)
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0 })
+	return limit(items, arg.Limit), nil
+}
+
+func (m *MemStore) ResealChangeRequestItem(ctx context.Context, arg repository.ResealChangeRequestItemParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, ok := m.changeItems[arg.ID]
+	if !ok || it.EncryptedValue == nil || arg.OldValue == nil || *it.EncryptedValue != *arg.OldValue {
+		return 0, nil
+	}
+	value := arg.NewValue
+	it.EncryptedValue = &value
+	m.changeItems[it.ID] = it
+	return 1, nil
+}
+
+func (m *MemStore) CreateChangeRequestApproval(ctx context.Context, arg repository.CreateChangeRequestApprovalParams) (repository.ChangeRequestApproval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cr, ok := m.changes[arg.ChangeRequestID]
+	if !ok {
+		return repository.ChangeRequestApproval{}, pgx.ErrNoRows
+	}
+	// Mirrors the prevent_self_approval trigger
+	if cr.RequestedBy == arg.ApprovedBy {
+		return repository.ChangeRequestApproval{}, &pgconn.PgError{Code: "23514"}
+	}
+	key := approvalKey{changeRequestID: arg.ChangeRequestID, approvedBy: arg.ApprovedBy}
+	if _, ok := m.approvals[key]; ok {
+		return repository.ChangeRequestApproval{}, &pgconn.PgError{Code: "23505"}
+	}
+	a := repository.ChangeRequestApproval{
+		ChangeRequestID: arg.ChangeRequestID,
+		ApprovedBy:      arg.ApprovedBy,
+		Comment:         arg.Comment,
+		CreatedAt:       m.tick(),
+	}
+	m.approvals[key] = a
+	return a, nil
+}
+
+func (m *MemStore) ListChangeRequestApprovals(ctx context.Context, changeRequestID uuid.UUID) ([]repository.ChangeRequestApproval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := []repository.ChangeRequestApproval{}
+	for _, a := range m.approvals {
+		if a.ChangeRequestID == changeRequestID {
+			items = append(items, a)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	return items, nil
+}
+
+// recordHistory mirrors the log_secret_changes trigger. Callers hold m.mu.
+func (m *MemStore) recordHistory(s repository.Secret, action repository.SecretAction, by pgtype.UUID) repository.SecretHistory {
+	at := m.tick()
+	value, version := s.EncryptedValue, s.Version
+	h := repository.SecretHistory{
+		ID:             uuid.New(),
+		SecretID:       s.ID,
+		EnvironmentID:  s.EnvironmentID,
+		Action:         action,
+		Key:            s.Key,
+		EncryptedValue: &value,
+		ChangedBy:      by.Bytes,
+		CreatedAt:      at,
+		Version:        &version,
+	}
+	h.ProjectID, h.OrganizationID = m.historyOwner(s.EnvironmentID)
+	if action == repository.SecretActionRolledBack {
+		h.RestoredVersion = s.RestoredVersion
+	}
+	m.history[h.ID] = h
+	return h
+}
+
+// historyOwner mirrors set_secret_history_owner: once the environment is
+// deleted, its earlier entries hold the project and organization.
+// Callers hold m.mu.
+func (m *MemStore) historyOwner(envID uuid.UUID) (project, org pgtype.UUID) {
+	if env, ok := m.environments[envID]; ok {
+		return pgtype.UUID{Bytes: env.ProjectID, Valid: true}, pgtype.UUID{Bytes: m.projects[env.ProjectID].OrganizationID, Valid: true}
+	}
+	for _, h := range m.history {
+		if h.EnvironmentID == envID && h.ProjectID.Valid {
+			return h.ProjectID, h.OrganizationID
+		}
+	}
+	return pgtype.UUID{}, pgtype.UUID{}
+}
+
+// tick returns the current time, strictly after the previous call, so rows
+// ordered by creation time stay ordered when the clock does not advance.
+// Callers hold m.mu.
+func (m *MemStore) tick() time.Time {
+	at := now()
+	if !at.After(m.lastAt) {
+		at = m.lastAt.Add(time.Nanosecond)
+	}
+	m.lastAt = at
+	return at
+}
+
+// deliveryBefore orders webhook deliveries by (created_at, id)
+func deliveryBefore(a, b repository.WebhookDelivery) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+// historyBefore orders history entries by (created_at, id)
+func historyBefore(a, b repository.SecretHistory) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+// accessLogBefore orders access log entries by (created_at, id)
+func accessLogBefore(a, b repository.AccessLog) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+// inWindow reports whether t is in [since, until); invalid bounds are open
+func inWindow(t time.Time, since, until pgtype.Timestamptz) bool {
+	return (!since.Valid || !t.Before(since.Time)) && (!until.Valid || t.Before(until.Time))
+}
+
+func limit[T any](items []T, n int32) []T {
+	if n > 0 && len(items) > int(n) {
+		return items[:n]
+	}
+	return items
+}
+
+// rotationDueAt returns when the current value of s is due for rotation,
+// the way value_changed_at + rotate_every adds up in Postgres
+func rotationDueAt(s repository.Secret) (time.Time, bool) {
+	iv := s.RotateEvery
+	if !iv.Valid {
+		return time.Time{}, false
+	}
+	due := s.ValueChangedAt.AddDate(0, int(iv.Months), int(iv.Days))
+	return due.Add(time.Duration(iv.Microseconds) * time.Microsecond), true
+}
+
+func isLive(s repository.Secret) bool {
+	return !s.DeletedAt.Valid && (s.IsActive == nil || *s.IsActive)
+}
+
+func now() time.Time { return time.Now().UTC() }
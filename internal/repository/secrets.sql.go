@@ -7,6 +7,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -35,7 +36,7 @@ INSERT INTO secrets (
     created_by
 ) VALUES (
     $1, $2, $3, $4, $5, $6, $7
-) RETURNING id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at
+) RETURNING id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at, restored_version, expires_at, rotate_every, value_changed_at
 `
 
 type CreateSecretParams struct {
@@ -73,10 +74,30 @@ func (q *Queries) CreateSecret(ctx context.Context, arg CreateSecretParams) (Sec
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.RestoredVersion,
+		&i.ExpiresAt,
+		&i.RotateEvery,
+		&i.ValueChangedAt,
 	)
 	return i, err
 }
 
+const CreateSecretReminder = `-- name: CreateSecretReminder :exec
+INSERT INTO secret_reminders (secret_id, event, due_at)
+VALUES ($1, $2, $3)
+`
+
+type CreateSecretReminderParams struct {
+	SecretID uuid.UUID `json:"secret_id"`
+	Event    string    `json:"event"`
+	DueAt    time.Time `json:"due_at"`
+}
+
+func (q *Queries) CreateSecretReminder(ctx context.Context, arg CreateSecretReminderParams) error {
+	_, err := q.db.Exec(ctx, CreateSecretReminder, arg.SecretID, arg.Event, arg.DueAt)
+	return err
+}
+
 const DeactivateSecret = `-- name: DeactivateSecret :exec
 UPDATE secrets
 SET is_active = false, updated_by = $2
@@ -93,8 +114,26 @@ func (q *Queries) DeactivateSecret(ctx context.Context, arg DeactivateSecretPara
 	return err
 }
 
+const GetLatestSecretVersion = `-- name: GetLatestSecretVersion :one
+SELECT COALESCE(MAX(version), 0)::int AS version FROM secrets
+WHERE environment_id = $1 AND key = $2
+`
+
+type GetLatestSecretVersionParams struct {
+	EnvironmentID uuid.UUID `json:"environment_id"`
+	Key           string    `json:"key"`
+}
+
+// Counts deleted secrets, so a key created again continues their versions
+func (q *Queries) GetLatestSecretVersion(ctx context.Context, arg GetLatestSecretVersionParams) (int32, error) {
+	row := q.db.QueryRow(ctx, GetLatestSecretVersion, arg.EnvironmentID, arg.Key)
+	var version int32
+	err := row.Scan(&version)
+	return version, err
+}
+
 const GetSecretByID = `-- name: GetSecretByID :one
-SELECT id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at FROM secrets
+SELECT id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at, restored_version, expires_at, rotate_every, value_changed_at FROM secrets
 WHERE id = $1 AND deleted_at IS NULL
 LIMIT 1
 `
@@ -116,12 +155,16 @@ func (q *Queries) GetSecretByID(ctx context.Context, id uuid.UUID) (Secret, erro
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.RestoredVersion,
+		&i.ExpiresAt,
+		&i.RotateEvery,
+		&i.ValueChangedAt,
 	)
 	return i, err
 }
 
 const GetSecretByKey = `-- name: GetSecretByKey :one
-SELECT id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at FROM secrets
+SELECT id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at, restored_version, expires_at, rotate_every, value_changed_at FROM secrets
 WHERE environment_id = $1 AND key = $2 AND deleted_at IS NULL AND is_active = true
 LIMIT 1
 `
@@ -148,12 +191,220 @@ func (q *Queries) GetSecretByKey(ctx context.Context, arg GetSecretByKeyParams)
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.RestoredVersion,
+		&i.ExpiresAt,
+		&i.RotateEvery,
+		&i.ValueChangedAt,
+	)
+	return i, err
+}
+
+const GetSecretByKeyIncludingInactive = `-- name: GetSecretByKeyIncludingInactive :one
+SELECT id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at, restored_version, expires_at, rotate_every, value_changed_at FROM secrets
+WHERE environment_id = $1 AND key = $2 AND deleted_at IS NULL
+LIMIT 1
+`
+
+type GetSecretByKeyIncludingInactiveParams struct {
+	EnvironmentID uuid.UUID `json:"environment_id"`
+	Key           string    `json:"key"`
+}
+
+// Also finds secrets deactivated when they expired
+func (q *Queries) GetSecretByKeyIncludingInactive(ctx context.Context, arg GetSecretByKeyIncludingInactiveParams) (Secret, error) {
+	row := q.db.QueryRow(ctx, GetSecretByKeyIncludingInactive, arg.EnvironmentID, arg.Key)
+	var i Secret
+	err := row.Scan(
+		&i.ID,
+		&i.EnvironmentID,
+		&i.Key,
+		&i.EncryptedValue,
+		&i.Description,
+		&i.IsActive,
+		&i.Version,
+		&i.PreviousVersionID,
+		&i.CreatedBy,
+		&i.UpdatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.RestoredVersion,
+		&i.ExpiresAt,
+		&i.RotateEvery,
+		&i.ValueChangedAt,
 	)
 	return i, err
 }
 
+const ListDueSecretsByProject = `-- name: ListDueSecretsByProject :many
+SELECT s.id, e.name AS environment_name, s.key, s.version, s.is_active, s.expires_at, s.rotate_every, s.value_changed_at
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+WHERE e.project_id = $1 AND s.deleted_at IS NULL
+  AND (s.expires_at <= $2 OR s.value_changed_at + s.rotate_every <= $2)
+ORDER BY e.name, s.key
+`
+
+type ListDueSecretsByProjectParams struct {
+	ProjectID uuid.UUID          `json:"project_id"`
+	Before    pgtype.Timestamptz `json:"before"`
+}
+
+type ListDueSecretsByProjectRow struct {
+	ID              uuid.UUID          `json:"id"`
+	EnvironmentName string             `json:"environment_name"`
+	Key             string             `json:"key"`
+	Version         int32              `json:"version"`
+	IsActive        *bool              `json:"is_active"`
+	ExpiresAt       pgtype.Timestamptz `json:"expires_at"`
+	RotateEvery     pgtype.Interval    `json:"rotate_every"`
+	ValueChangedAt  time.Time          `json:"value_changed_at"`
+}
+
+// Secrets that expire or are due for rotation before the given time,
+// including expired ones that were deactivated
+func (q *Queries) ListDueSecretsByProject(ctx context.Context, arg ListDueSecretsByProjectParams) ([]ListDueSecretsByProjectRow, error) {
+	rows, err := q.db.Query(ctx, ListDueSecretsByProject, arg.ProjectID, arg.Before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListDueSecretsByProjectRow{}
+	for rows.Next() {
+		var i ListDueSecretsByProjectRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EnvironmentName,
+			&i.Key,
+			&i.Version,
+			&i.IsActive,
+			&i.ExpiresAt,
+			&i.RotateEvery,
+			&i.ValueChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListExpiredSecrets = `-- name: ListExpiredSecrets :many
+SELECT s.id, s.environment_id, s.key, s.version, s.is_active, s.updated_by, s.expires_at AS due_at,
+       e.name AS environment_name, e.project_id, p.organization_id
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+JOIN projects p ON p.id = e.project_id
+WHERE s.deleted_at IS NULL AND s.expires_at <= NOW()
+  AND NOT EXISTS (
+      SELECT 1 FROM secret_reminders r
+      WHERE r.secret_id = s.id AND r.event = 'secret.expired' AND r.due_at = s.expires_at
+  )
+ORDER BY s.expires_at
+LIMIT $1
+FOR UPDATE OF s SKIP LOCKED
+`
+
+type ListExpiredSecretsRow struct {
+	ID              uuid.UUID          `json:"id"`
+	EnvironmentID   uuid.UUID          `json:"environment_id"`
+	Key             string             `json:"key"`
+	Version         int32              `json:"version"`
+	IsActive        *bool              `json:"is_active"`
+	UpdatedBy       pgtype.UUID        `json:"updated_by"`
+	DueAt           pgtype.Timestamptz `json:"due_at"`
+	EnvironmentName string             `json:"environment_name"`
+	ProjectID       uuid.UUID          `json:"project_id"`
+	OrganizationID  uuid.UUID          `json:"organization_id"`
+}
+
+// Expired secrets not yet flagged for their current expiry. Rows another
+// transaction holds are skipped rather than waited for.
+func (q *Queries) ListExpiredSecrets(ctx context.Context, limit int32) ([]ListExpiredSecretsRow, error) {
+	rows, err := q.db.Query(ctx, ListExpiredSecrets, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListExpiredSecretsRow{}
+	for rows.Next() {
+		var i ListExpiredSecretsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EnvironmentID,
+			&i.Key,
+			&i.Version,
+			&i.IsActive,
+			&i.UpdatedBy,
+			&i.DueAt,
+			&i.EnvironmentName,
+			&i.ProjectID,
+			&i.OrganizationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListProjectSecretsForRotation = `-- name: ListProjectSecretsForRotation :many
+SELECT s.id, s.environment_id, s.key, s.encrypted_value, s.version
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+WHERE e.project_id = $1 AND s.id > $2
+ORDER BY s.id
+LIMIT $3
+`
+
+type ListProjectSecretsForRotationParams struct {
+	ProjectID uuid.UUID `json:"project_id"`
+	ID        uuid.UUID `json:"id"`
+	Limit     int32     `json:"limit"`
+}
+
+type ListProjectSecretsForRotationRow struct {
+	ID             uuid.UUID `json:"id"`
+	EnvironmentID  uuid.UUID `json:"environment_id"`
+	Key            string    `json:"key"`
+	EncryptedValue string    `json:"encrypted_value"`
+	Version        int32     `json:"version"`
+}
+
+func (q *Queries) ListProjectSecretsForRotation(ctx context.Context, arg ListProjectSecretsForRotationParams) ([]ListProjectSecretsForRotationRow, error) {
+	rows, err := q.db.Query(ctx, ListProjectSecretsForRotation, arg.ProjectID, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListProjectSecretsForRotationRow{}
+	for rows.Next() {
+		var i ListProjectSecretsForRotationRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EnvironmentID,
+			&i.Key,
+			&i.EncryptedValue,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const ListSecretsByEnvironment = `-- name: ListSecretsByEnvironment :many
-SELECT id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at FROM secrets
+SELECT id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at, restored_version, expires_at, rotate_every, value_changed_at FROM secrets
 WHERE environment_id = $1 AND deleted_at IS NULL AND is_active = true
 ORDER BY key ASC
 `
@@ -181,6 +432,10 @@ func (q *Queries) ListSecretsByEnvironment(ctx context.Context, environmentID uu
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.DeletedAt,
+			&i.RestoredVersion,
+			&i.ExpiresAt,
+			&i.RotateEvery,
+			&i.ValueChangedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -192,6 +447,245 @@ func (q *Queries) ListSecretsByEnvironment(ctx context.Context, environmentID uu
 	return items, nil
 }
 
+const ListSecretsDueForRotation = `-- name: ListSecretsDueForRotation :many
+SELECT s.id, s.environment_id, s.key, s.version, s.is_active, s.updated_by, (s.value_changed_at + s.rotate_every)::timestamptz AS due_at,
+       e.name AS environment_name, e.project_id, p.organization_id
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+JOIN projects p ON p.id = e.project_id
+WHERE s.deleted_at IS NULL AND s.is_active = true AND s.value_changed_at + s.rotate_every <= NOW()
+  AND NOT EXISTS (
+      SELECT 1 FROM secret_reminders r
+      WHERE r.secret_id = s.id AND r.event = 'secret.rotation_due' AND r.due_at = s.value_changed_at + s.rotate_every
+  )
+ORDER BY due_at
+LIMIT $1
+FOR UPDATE OF s SKIP LOCKED
+`
+
+type ListSecretsDueForRotationRow struct {
+	ID              uuid.UUID          `json:"id"`
+	EnvironmentID   uuid.UUID          `json:"environment_id"`
+	Key             string             `json:"key"`
+	Version         int32              `json:"version"`
+	IsActive        *bool              `json:"is_active"`
+	UpdatedBy       pgtype.UUID        `json:"updated_by"`
+	DueAt           pgtype.Timestamptz `json:"due_at"`
+	EnvironmentName string             `json:"environment_name"`
+	ProjectID       uuid.UUID          `json:"project_id"`
+	OrganizationID  uuid.UUID          `json:"organization_id"`
+}
+
+// Active secrets whose current value is older than rotate_every and that
+// were not yet flagged for it
+func (q *Queries) ListSecretsDueForRotation(ctx context.Context, limit int32) ([]ListSecretsDueForRotationRow, error) {
+	rows, err := q.db.Query(ctx, ListSecretsDueForRotation, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListSecretsDueForRotationRow{}
+	for rows.Next() {
+		var i ListSecretsDueForRotationRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EnvironmentID,
+			&i.Key,
+			&i.Version,
+			&i.IsActive,
+			&i.UpdatedBy,
+			&i.DueAt,
+			&i.EnvironmentName,
+			&i.ProjectID,
+			&i.OrganizationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListSecretsForReseal = `-- name: ListSecretsForReseal :many
+SELECT s.id, s.environment_id, s.key, s.encrypted_value, s.version, e.project_id
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+WHERE s.id > $1
+ORDER BY s.id
+LIMIT $2
+`
+
+type ListSecretsForResealParams struct {
+	ID    uuid.UUID `json:"id"`
+	Limit int32     `json:"limit"`
+}
+
+type ListSecretsForResealRow struct {
+	ID             uuid.UUID `json:"id"`
+	EnvironmentID  uuid.UUID `json:"environment_id"`
+	Key            string    `json:"key"`
+	EncryptedValue string    `json:"encrypted_value"`
+	Version        int32     `json:"version"`
+	ProjectID      uuid.UUID `json:"project_id"`
+}
+
+func (q *Queries) ListSecretsForReseal(ctx context.Context, arg ListSecretsForResealParams) ([]ListSecretsForResealRow, error) {
+	rows, err := q.db.Query(ctx, ListSecretsForReseal, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListSecretsForResealRow{}
+	for rows.Next() {
+		var i ListSecretsForResealRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.EnvironmentID,
+			&i.Key,
+			&i.EncryptedValue,
+			&i.Version,
+			&i.ProjectID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ResealSecret = `-- name: ResealSecret :execrows
+UPDATE secrets
+SET
+    encrypted_value = $1,
+    updated_by = $2
+WHERE id = $3 AND encrypted_value = $4
+`
+
+type ResealSecretParams struct {
+	NewValue  string      `json:"new_value"`
+	UpdatedBy pgtype.UUID `json:"updated_by"`
+	ID        uuid.UUID   `json:"id"`
+	OldValue  string      `json:"old_value"`
+}
+
+func (q *Queries) ResealSecret(ctx context.Context, arg ResealSecretParams) (int64, error) {
+	result, err := q.db.Exec(ctx, ResealSecret,
+		arg.NewValue,
+		arg.UpdatedBy,
+		arg.ID,
+		arg.OldValue,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const RollbackSecret = `-- name: RollbackSecret :one
+UPDATE secrets
+SET
+    encrypted_value = $1,
+    version = version + 1,
+    restored_version = $2::int,
+    updated_by = $3,
+    updated_at = NOW()
+WHERE id = $4 AND deleted_at IS NULL
+RETURNING id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at, restored_version, expires_at, rotate_every, value_changed_at
+`
+
+type RollbackSecretParams struct {
+	EncryptedValue  string      `json:"encrypted_value"`
+	RestoredVersion int32       `json:"restored_version"`
+	UpdatedBy       pgtype.UUID `json:"updated_by"`
+	ID              uuid.UUID   `json:"id"`
+}
+
+func (q *Queries) RollbackSecret(ctx context.Context, arg RollbackSecretParams) (Secret, error) {
+	row := q.db.QueryRow(ctx, RollbackSecret,
+		arg.EncryptedValue,
+		arg.RestoredVersion,
+		arg.UpdatedBy,
+		arg.ID,
+	)
+	var i Secret
+	err := row.Scan(
+		&i.ID,
+		&i.EnvironmentID,
+		&i.Key,
+		&i.EncryptedValue,
+		&i.Description,
+		&i.IsActive,
+		&i.Version,
+		&i.PreviousVersionID,
+		&i.CreatedBy,
+		&i.UpdatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.RestoredVersion,
+		&i.ExpiresAt,
+		&i.RotateEvery,
+		&i.ValueChangedAt,
+	)
+	return i, err
+}
+
+const SetSecretLifetime = `-- name: SetSecretLifetime :one
+UPDATE secrets
+SET
+    expires_at = $1,
+    rotate_every = $2,
+    is_active = $3,
+    updated_by = $4
+WHERE id = $5 AND deleted_at IS NULL
+RETURNING id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at, restored_version, expires_at, rotate_every, value_changed_at
+`
+
+type SetSecretLifetimeParams struct {
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+	RotateEvery pgtype.Interval    `json:"rotate_every"`
+	IsActive    *bool              `json:"is_active"`
+	UpdatedBy   pgtype.UUID        `json:"updated_by"`
+	ID          uuid.UUID          `json:"id"`
+}
+
+func (q *Queries) SetSecretLifetime(ctx context.Context, arg SetSecretLifetimeParams) (Secret, error) {
+	row := q.db.QueryRow(ctx, SetSecretLifetime,
+		arg.ExpiresAt,
+		arg.RotateEvery,
+		arg.IsActive,
+		arg.UpdatedBy,
+		arg.ID,
+	)
+	var i Secret
+	err := row.Scan(
+		&i.ID,
+		&i.EnvironmentID,
+		&i.Key,
+		&i.EncryptedValue,
+		&i.Description,
+		&i.IsActive,
+		&i.Version,
+		&i.PreviousVersionID,
+		&i.CreatedBy,
+		&i.UpdatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.RestoredVersion,
+		&i.ExpiresAt,
+		&i.RotateEvery,
+		&i.ValueChangedAt,
+	)
+	return i, err
+}
+
 const SoftDeleteSecret = `-- name: SoftDeleteSecret :exec
 UPDATE secrets
 SET 
@@ -211,16 +705,31 @@ func (q *Queries) SoftDeleteSecret(ctx context.Context, arg SoftDeleteSecretPara
 	return err
 }
 
+const TryLockSecretReminders = `-- name: TryLockSecretReminders :one
+SELECT pg_try_advisory_xact_lock(hashtext('secret_reminders'))
+`
+
+// Lets one scheduler at a time flag secrets until the transaction ends
+func (q *Queries) TryLockSecretReminders(ctx context.Context) (bool, error) {
+	row := q.db.QueryRow(ctx, TryLockSecretReminders)
+	var pg_try_advisory_xact_lock bool
+	err := row.Scan(&pg_try_advisory_xact_lock)
+	return pg_try_advisory_xact_lock, err
+}
+
 const UpdateSecret = `-- name: UpdateSecret :one
 UPDATE secrets
 SET 
     encrypted_value = $2,
     description = COALESCE($3, description),
     version = version + 1,
+    restored_version = NULL,
+    is_active = true,
+    expires_at = CASE WHEN expires_at <= NOW() THEN NULL ELSE expires_at END,
     updated_by = $4,
     updated_at = NOW()
 WHERE id = $1 AND deleted_at IS NULL
-RETURNING id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at
+RETURNING id, environment_id, key, encrypted_value, description, is_active, version, previous_version_id, created_by, updated_by, created_at, updated_at, deleted_at, restored_version, expires_at, rotate_every, value_changed_at
 `
 
 type UpdateSecretParams struct {
@@ -230,6 +739,8 @@ type UpdateSecretParams struct {
 	UpdatedBy      pgtype.UUID `json:"updated_by"`
 }
 
+// A new value replaces an expired one: an expiry that has passed is
+// cleared and a secret deactivated by it is active again
 func (q *Queries) UpdateSecret(ctx context.Context, arg UpdateSecretParams) (Secret, error) {
 	row := q.db.QueryRow(ctx, UpdateSecret,
 		arg.ID,
@@ -252,6 +763,10 @@ func (q *Queries) UpdateSecret(ctx context.Context, arg UpdateSecretParams) (Sec
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.RestoredVersion,
+		&i.ExpiresAt,
+		&i.RotateEvery,
+		&i.ValueChangedAt,
 	)
 	return i, err
 }
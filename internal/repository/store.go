@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Store provides all queries plus transactional execution
+type Store interface {
+	Querier
+	ExecTx(ctx context.Context, fn func(q Querier) error) error
+}
+
+// SQLStore is the PostgreSQL implementation of Store
+type SQLStore struct {
+	*Queries
+	pool *pgxpool.Pool
+}
+
+// NewStore creates a Store backed by the given connection pool
+func NewStore(pool *pgxpool.Pool) *SQLStore {
+	return &SQLStore{
+		Queries: New(pool),
+		pool:    pool,
+	}
+}
+
+// ExecTx runs fn inside a database transaction.
+// The transaction is committed if fn returns nil and rolled back otherwise.
+func (s *SQLStore) ExecTx(ctx context.Context, fn func(q Querier) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(s.WithTx(tx)); err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -5,3 +5,17 @@ type ErrorResponse struct {
 	StatusCode uint16 `json:"status"`
 	Message    string `json:"message"`
 }
+
+// APIError is the failure envelope returned by the versioned API.
+// It extends ErrorResponse with a machine-readable error code.
+type APIError struct {
+	ErrorResponse
+	Code string `json:"code,omitempty"`
+}
+
+// Response is the success envelope returned by the versioned API.
+type Response struct {
+	Success    bool   `json:"success"`
+	StatusCode uint16 `json:"status"`
+	Data       any    `json:"data"`
+}
@@ -1 +1,40 @@
 package utils
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// WriteJSON writes data wrapped in the success envelope
+func WriteJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(Response{
+		Success:    true,
+		StatusCode: uint16(status),
+		Data:       data,
+	})
+}
+
+// WriteError writes a failure envelope with the given code and message
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(APIError{
+		ErrorResponse: ErrorResponse{
+			Success:    false,
+			StatusCode: uint16(status),
+			Message:    message,
+		},
+		Code: code,
+	})
+}
+
+// DecodeJSON decodes a JSON request body into v, rejecting unknown fields
+func DecodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,316 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// ErrDataKeyRotated is returned when a project's DEK was rotated after a
+// value was encrypted with it; the caller should retry with the new DEK
+var ErrDataKeyRotated = errors.New("vault: project data key was rotated")
+
+// DefaultDataKeyBatchSize is the number of rows re-encrypted per query
+const DefaultDataKeyBatchSize = 500
+
+// DataKeyRotationOptions configures a project DEK rotation
+type DataKeyRotationOptions struct {
+	// BatchSize is the number of rows read per query (DefaultDataKeyBatchSize if zero)
+	BatchSize int
+
+	// Actor is recorded as updated_by and in secret history
+	Actor uuid.UUID
+
+	// SkipHistory leaves historical values encrypted with the old DEK.
+	// They become unreadable once the rotation commits.
+	SkipHistory bool
+
+	// OnRotated, if set, runs in the rotation's transaction once the new
+	// DEK is stored, so whatever it writes commits with the rotation
+	OnRotated func(ctx context.Context, q repository.Querier, project repository.Project, result crypto.RotationResult) error
+}
+
+// CheckDataKey locks the project's DEK against rotation until the
+// transaction q belongs to ends, and returns ErrDataKeyRotated if dek is no
+// longer the project's current DEK. Call it before writing values
+// encrypted with dek.
+func CheckDataKey(ctx context.Context, q repository.Querier, projectID uuid.UUID, dek *crypto.DataKey) error {
+	version, err := q.LockProjectDEK(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	if int(version) != dek.Version {
+		return fmt.Errorf("%w: encrypted with v%d, current is v%d", ErrDataKeyRotated, dek.Version, version)
+	}
+	return nil
+}
+
+// RotateDataKey replaces a project's DEK and re-encrypts every secret
+// value of the project, in every environment, under the new one. Values
+// proposed in pending change requests and the admin connections of dynamic
+// secrets are re-encrypted too.
+//
+// Everything happens in one transaction holding the project row lock:
+// rows are streamed in batches, each rewrite is logged as 'rotated' in
+// secret history, and dek_version is bumped at the end. Any failure rolls
+// the whole rotation back and the old DEK stays in place.
+func (v *Vault) RotateDataKey(ctx context.Context, store repository.Store, projectID uuid.UUID, opts DataKeyRotationOptions) (crypto.RotationResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultDataKeyBatchSize
+	}
+
+	var result crypto.RotationResult
+	err := store.ExecTx(ctx, func(q repository.Querier) error {
+		result = crypto.RotationResult{}
+
+		// Blocks writers that hold the DEK (see CheckDataKey) until we commit
+		project, err := q.GetProjectForUpdate(ctx, projectID)
+		if err != nil {
+			return err
+		}
+
+		oldDEK, err := v.DataKey(project)
+		if err != nil {
+			return err
+		}
+		newDEK, err := crypto.GenerateDataKey()
+		if err != nil {
+			return err
+		}
+		newDEK.Version = oldDEK.Version + 1
+
+		r := &dataKeyRotation{vault: v, q: q, project: project, oldDEK: oldDEK, newDEK: newDEK, opts: opts}
+
+		// History first: rotating the secrets appends history entries that
+		// are already encrypted with the new DEK
+		if !opts.SkipHistory {
+			if err := r.history(ctx); err != nil {
+				return err
+			}
+		}
+		if err := r.changeRequests(ctx); err != nil {
+			return err
+		}
+		if err := r.secrets(ctx); err != nil {
+			return err
+		}
+		if err := r.dynamicSecrets(ctx); err != nil {
+			return err
+		}
+
+		wrapped, err := v.WrapDataKey(newDEK)
+		if err != nil {
+			return err
+		}
+		rotated, err := q.RotateProjectDEK(ctx, repository.RotateProjectDEKParams{
+			ID:           project.ID,
+			EncryptedDek: wrapped,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store new DEK: %w", err)
+		}
+
+		result.OldKeyVersion = oldDEK.Version
+		result.NewKeyVersion = int(rotated.DekVersion)
+		result.ItemsRotated = r.rotated
+		if opts.OnRotated != nil {
+			return opts.OnRotated(ctx, q, project, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return crypto.RotationResult{}, err
+	}
+
+	return result, nil
+}
+
+// dataKeyRotation re-encrypts one project's values inside a transaction
+type dataKeyRotation struct {
+	vault   *Vault
+	q       repository.Querier
+	project repository.Project
+	oldDEK  *crypto.DataKey
+	newDEK  *crypto.DataKey
+	opts    DataKeyRotationOptions
+	rotated int
+}
+
+func (r *dataKeyRotation) secrets(ctx context.Context) error {
+	cursor := uuid.Nil
+	for {
+		rows, err := r.q.ListProjectSecretsForRotation(ctx, repository.ListProjectSecretsForRotationParams{
+			ProjectID: r.project.ID,
+			ID:        cursor,
+			Limit:     int32(r.opts.BatchSize),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list secrets: %w", err)
+		}
+
+		for _, row := range rows {
+			cursor = row.ID
+			id := SecretIdentity{ProjectID: r.project.ID, EnvironmentID: row.EnvironmentID, Key: row.Key, Version: row.Version}
+
+			sealed, err := r.reencrypt(id, row.EncryptedValue)
+			if err != nil {
+				return fmt.Errorf("secret %s: %w", row.ID, err)
+			}
+
+			n, err := r.q.ResealSecret(ctx, repository.ResealSecretParams{
+				NewValue:  sealed,
+				UpdatedBy: pgtype.UUID{Bytes: r.opts.Actor, Valid: true},
+				ID:        row.ID,
+				OldValue:  row.EncryptedValue,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to store secret %s: %w", row.ID, err)
+			}
+			if n == 0 {
+				return fmt.Errorf("secret %s changed during rotation", row.ID)
+			}
+			r.rotated++
+		}
+
+		if len(rows) < r.opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (r *dataKeyRotation) history(ctx context.Context) error {
+	cursor := uuid.Nil
+	for {
+		rows, err := r.q.ListProjectSecretHistoryForRotation(ctx, repository.ListProjectSecretHistoryForRotationParams{
+			ProjectID: r.project.ID,
+			ID:        cursor,
+			Limit:     int32(r.opts.BatchSize),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list secret history: %w", err)
+		}
+
+		for _, row := range rows {
+			cursor = row.ID
+			if row.EncryptedValue == nil {
+				continue
+			}
+			id := SecretIdentity{ProjectID: r.project.ID, EnvironmentID: row.EnvironmentID, Key: row.Key}
+			if row.Version != nil {
+				id.Version = *row.Version
+			}
+
+			sealed, err := r.reencrypt(id, *row.EncryptedValue)
+			if err != nil {
+				return fmt.Errorf("history entry %s: %w", row.ID, err)
+			}
+
+			if _, err := r.q.ResealSecretHistory(ctx, repository.ResealSecretHistoryParams{
+				NewValue: sealed,
+				ID:       row.ID,
+				OldValue: row.EncryptedValue,
+			}); err != nil {
+				return fmt.Errorf("failed to store history entry %s: %w", row.ID, err)
+			}
+		}
+
+		if len(rows) < r.opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (r *dataKeyRotation) changeRequests(ctx context.Context) error {
+	cursor := uuid.Nil
+	for {
+		rows, err := r.q.ListProjectChangeRequestItemsForRotation(ctx, repository.ListProjectChangeRequestItemsForRotationParams{
+			ProjectID: r.project.ID,
+			ID:        cursor,
+			Limit:     int32(r.opts.BatchSize),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list change requests: %w", err)
+		}
+
+		for _, row := range rows {
+			cursor = row.ID
+			if row.EncryptedValue == nil {
+				continue
+			}
+			id := SecretIdentity{ProjectID: r.project.ID, EnvironmentID: row.EnvironmentID, Key: row.Key, Version: row.Version}
+
+			sealed, err := r.reencrypt(id, *row.EncryptedValue)
+			if err != nil {
+				return fmt.Errorf("change request item %s: %w", row.ID, err)
+			}
+
+			if _, err := r.q.ResealChangeRequestItem(ctx, repository.ResealChangeRequestItemParams{
+				NewValue: sealed,
+				ID:       row.ID,
+				OldValue: row.EncryptedValue,
+			}); err != nil {
+				return fmt.Errorf("failed to store change request item %s: %w", row.ID, err)
+			}
+		}
+
+		if len(rows) < r.opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (r *dataKeyRotation) dynamicSecrets(ctx context.Context) error {
+	cursor := uuid.Nil
+	for {
+		rows, err := r.q.ListProjectDynamicSecretsForRotation(ctx, repository.ListProjectDynamicSecretsForRotationParams{
+			ProjectID: r.project.ID,
+			ID:        cursor,
+			Limit:     int32(r.opts.BatchSize),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list dynamic secrets: %w", err)
+		}
+
+		for _, row := range rows {
+			cursor = row.ID
+			id := DynamicSecretIdentity{ProjectID: r.project.ID, EnvironmentID: row.EnvironmentID, Key: row.Key}
+
+			sealed, err := r.reencrypt(id, row.EncryptedConnection)
+			if err != nil {
+				return fmt.Errorf("dynamic secret %s: %w", row.ID, err)
+			}
+
+			n, err := r.q.ResealDynamicSecret(ctx, repository.ResealDynamicSecretParams{
+				NewConnection: sealed,
+				UpdatedBy:     pgtype.UUID{Bytes: r.opts.Actor, Valid: true},
+				ID:            row.ID,
+				OldConnection: row.EncryptedConnection,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to store dynamic secret %s: %w", row.ID, err)
+			}
+			if n == 0 {
+				return fmt.Errorf("dynamic secret %s changed during rotation", row.ID)
+			}
+		}
+
+		if len(rows) < r.opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+// reencrypt decrypts value with the old DEK and seals it with the new one
+func (r *dataKeyRotation) reencrypt(id Identity, value string) (string, error) {
+	plaintext, err := r.vault.DecryptValue(r.oldDEK, id, value)
+	if err != nil {
+		return "", err
+	}
+	return r.vault.EncryptValue(r.newDEK, id, plaintext)
+}
@@ -0,0 +1,200 @@
+package vault
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// seedSecrets stores one secret per key, each updated once so it has history
+func seedSecrets(t *testing.T, store *repotest.MemStore, v *Vault, dek *crypto.DataKey, project repository.Project, env repository.Environment, actor repository.User, keys ...string) []repository.Secret {
+	t.Helper()
+
+	by := pgtype.UUID{Bytes: actor.ID, Valid: true}
+	var secrets []repository.Secret
+	for _, key := range keys {
+		id := SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: key, Version: 1}
+		first, _ := v.EncryptValue(dek, id, "old-"+key)
+		s, err := store.CreateSecret(t.Context(), repository.CreateSecretParams{
+			EnvironmentID:  env.ID,
+			Key:            key,
+			EncryptedValue: first,
+			Version:        1,
+			CreatedBy:      by,
+		})
+		if err != nil {
+			t.Fatalf("CreateSecret failed: %v", err)
+		}
+		id.Version = 2
+		second, _ := v.EncryptValue(dek, id, "value-"+key)
+		if s, err = store.UpdateSecret(t.Context(), repository.UpdateSecretParams{ID: s.ID, EncryptedValue: second, UpdatedBy: by}); err != nil {
+			t.Fatalf("UpdateSecret failed: %v", err)
+		}
+		secrets = append(secrets, s)
+	}
+	return secrets
+}
+
+func TestRotateDataKey(t *testing.T) {
+	v, project, oldDEK := newTestVault(t)
+
+	store := repotest.NewMemStore()
+	actor := store.AddUser(repository.User{Email: "ops@example.com"})
+	project = store.AddProject(project)
+	dev := store.AddEnvironment(repository.Environment{ProjectID: project.ID, Name: "dev"})
+	prod := store.AddEnvironment(repository.Environment{ProjectID: project.ID, Name: "prod"})
+
+	secrets := seedSecrets(t, store, v, oldDEK, project, dev, actor, "A", "B", "C")
+	secrets = append(secrets, seedSecrets(t, store, v, oldDEK, project, prod, actor, "A", "D")...)
+	if err := store.SoftDeleteSecret(t.Context(), repository.SoftDeleteSecretParams{
+		ID:        secrets[1].ID,
+		UpdatedBy: pgtype.UUID{Bytes: actor.ID, Valid: true},
+	}); err != nil {
+		t.Fatalf("SoftDeleteSecret failed: %v", err)
+	}
+
+	result, err := v.RotateDataKey(t.Context(), store, project.ID, DataKeyRotationOptions{BatchSize: 2, Actor: actor.ID})
+	if err != nil {
+		t.Fatalf("RotateDataKey failed: %v", err)
+	}
+	if result.OldKeyVersion != 1 || result.NewKeyVersion != 2 || result.ItemsRotated != 5 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	rotated, _ := store.Project(project.ID)
+	newDEK, err := v.DataKey(rotated)
+	if err != nil {
+		t.Fatalf("DataKey failed: %v", err)
+	}
+	if rotated.DekVersion != 2 || newDEK.Version != 2 {
+		t.Errorf("Expected dek_version 2, got %d", rotated.DekVersion)
+	}
+
+	strict := New(v.keys, WithRequiredBinding())
+	for _, s := range secrets {
+		id := SecretIdentity{ProjectID: project.ID, EnvironmentID: s.EnvironmentID, Key: s.Key}
+		history := store.History(s.ID)
+
+		last := history[len(history)-1]
+		if last.Action != repository.SecretActionRotated || last.ChangedBy != actor.ID {
+			t.Errorf("%s: expected a rotated history entry by the actor, got %s", s.Key, last.Action)
+		}
+
+		// Current and historical values decrypt with the new DEK only
+		for _, h := range history {
+			id.Version = *h.Version
+			if _, err := strict.DecryptValue(newDEK, id, *h.EncryptedValue); err != nil {
+				t.Errorf("%s: %s entry not re-encrypted: %v", s.Key, h.Action, err)
+			}
+			if _, err := strict.DecryptValue(oldDEK, id, *h.EncryptedValue); err == nil {
+				t.Errorf("%s: %s entry still decrypts with the old DEK", s.Key, h.Action)
+			}
+		}
+		got, _ := strict.DecryptValue(newDEK, id, *last.EncryptedValue)
+		if got != "value-"+s.Key {
+			t.Errorf("%s: expected value-%s, got %q", s.Key, s.Key, got)
+		}
+	}
+}
+
+func TestRotateDataKeyDeletedEnvironment(t *testing.T) {
+	v, project, oldDEK := newTestVault(t)
+
+	store := repotest.NewMemStore()
+	actor := store.AddUser(repository.User{Email: "ops@example.com"})
+	project = store.AddProject(project)
+	preview := store.AddEnvironment(repository.Environment{ProjectID: project.ID, Name: "pr-1"})
+	secrets := seedSecrets(t, store, v, oldDEK, project, preview, actor, "A")
+	if err := store.DeleteEnvironment(t.Context(), preview.ID); err != nil {
+		t.Fatalf("DeleteEnvironment failed: %v", err)
+	}
+
+	if _, err := v.RotateDataKey(t.Context(), store, project.ID, DataKeyRotationOptions{Actor: actor.ID}); err != nil {
+		t.Fatalf("RotateDataKey failed: %v", err)
+	}
+	rotated, _ := store.Project(project.ID)
+	newDEK, err := v.DataKey(rotated)
+	if err != nil {
+		t.Fatalf("DataKey failed: %v", err)
+	}
+
+	// The history outlives the environment and stays readable
+	strict := New(v.keys, WithRequiredBinding())
+	for _, h := range store.History(secrets[0].ID) {
+		if h.EncryptedValue == nil {
+			continue
+		}
+		id := SecretIdentity{ProjectID: project.ID, EnvironmentID: preview.ID, Key: h.Key, Version: *h.Version}
+		if _, err := strict.DecryptValue(newDEK, id, *h.EncryptedValue); err != nil {
+			t.Errorf("%s entry of version %d not re-encrypted: %v", h.Action, id.Version, err)
+		}
+	}
+}
+
+func TestRotateDataKeyRollsBack(t *testing.T) {
+	v, project, dek := newTestVault(t)
+
+	store := repotest.NewMemStore()
+	actor := store.AddUser(repository.User{Email: "ops@example.com"})
+	project = store.AddProject(project)
+	env := store.AddEnvironment(repository.Environment{ProjectID: project.ID, Name: "dev"})
+	seedSecrets(t, store, v, dek, project, env, actor, "A", "B", "C", "D")
+
+	// A value that does not decrypt aborts the rotation part-way through
+	if _, err := store.CreateSecret(t.Context(), repository.CreateSecretParams{
+		EnvironmentID:  env.ID,
+		Key:            "CORRUPT",
+		EncryptedValue: "bm90IGEgY2lwaGVydGV4dCBhdCBhbGw=",
+		Version:        1,
+		CreatedBy:      pgtype.UUID{Bytes: actor.ID, Valid: true},
+	}); err != nil {
+		t.Fatalf("CreateSecret failed: %v", err)
+	}
+
+	before, _ := store.Project(project.ID)
+	beforeSecrets, _ := store.ListSecretsByEnvironment(t.Context(), env.ID)
+
+	if _, err := v.RotateDataKey(t.Context(), store, project.ID, DataKeyRotationOptions{BatchSize: 2, Actor: actor.ID}); err == nil {
+		t.Fatalf("Expected rotation to fail")
+	}
+
+	after, _ := store.Project(project.ID)
+	if after.DekVersion != before.DekVersion || after.EncryptedDek != before.EncryptedDek {
+		t.Errorf("Project DEK changed despite the failed rotation")
+	}
+	afterSecrets, _ := store.ListSecretsByEnvironment(t.Context(), env.ID)
+	for i := range beforeSecrets {
+		if afterSecrets[i].EncryptedValue != beforeSecrets[i].EncryptedValue {
+			t.Errorf("%s: value changed despite the failed rotation", beforeSecrets[i].Key)
+		}
+		for _, h := range store.History(beforeSecrets[i].ID) {
+			if h.Action == repository.SecretActionRotated {
+				t.Errorf("%s: rotated history entry survived the rollback", beforeSecrets[i].Key)
+			}
+		}
+	}
+}
+
+func TestCheckDataKey(t *testing.T) {
+	v, project, dek := newTestVault(t)
+
+	store := repotest.NewMemStore()
+	actor := store.AddUser(repository.User{Email: "ops@example.com"})
+	project = store.AddProject(project)
+
+	if err := CheckDataKey(t.Context(), store, project.ID, dek); err != nil {
+		t.Errorf("Current DEK: unexpected error %v", err)
+	}
+
+	if _, err := v.RotateDataKey(t.Context(), store, project.ID, DataKeyRotationOptions{Actor: actor.ID}); err != nil {
+		t.Fatalf("RotateDataKey failed: %v", err)
+	}
+	if err := CheckDataKey(t.Context(), store, project.ID, dek); !errors.Is(err, ErrDataKeyRotated) {
+		t.Errorf("Stale DEK: expected ErrDataKeyRotated, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// DefaultResealBatchSize is the number of rows read per batch
+const DefaultResealBatchSize = 500
+
+// ResealOptions configures a re-seal run
+type ResealOptions struct {
+	// BatchSize is the number of rows read per query (DefaultResealBatchSize if zero)
+	BatchSize int
+
+	// DryRun counts the rows that would change without writing them
+	DryRun bool
+
+	// Actor is recorded as updated_by and in secret history
+	Actor uuid.UUID
+
+	// Progress, if set, is called after every batch
+	Progress func(ResealStats)
+}
+
+// ResealStats counts what a re-seal run did
+type ResealStats struct {
+	Scanned  int // rows read
+	Resealed int // rows bound to their identity and version (or that would be, in a dry run)
+	Bound    int // rows that were already bound
+	Skipped  int // rows changed concurrently or whose project is gone
+	Failed   int // rows that could not be decrypted
+}
+
+// Reseal binds every secret value, and every historical value, that was
+// encrypted before identity binding to its project, environment, key and
+// version. Values bound without their version are re-sealed too.
+// Rows are processed in id order and each write is conditional on the old
+// ciphertext, so the run is safe alongside live traffic and can simply be
+// repeated if interrupted.
+func (v *Vault) Reseal(ctx context.Context, q repository.Querier, opts ResealOptions) (ResealStats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultResealBatchSize
+	}
+
+	var stats ResealStats
+	keys := newDataKeyCache(v, q)
+
+	// Current values
+	cursor := uuid.Nil
+	for {
+		rows, err := q.ListSecretsForReseal(ctx, repository.ListSecretsForResealParams{
+			ID:    cursor,
+			Limit: int32(opts.BatchSize),
+		})
+		if err != nil {
+			return stats, fmt.Errorf("failed to list secrets: %w", err)
+		}
+
+		for _, row := range rows {
+			cursor = row.ID
+			id := SecretIdentity{ProjectID: row.ProjectID, EnvironmentID: row.EnvironmentID, Key: row.Key, Version: row.Version}
+
+			sealed, err := v.reseal(ctx, keys, id, row.EncryptedValue, &stats)
+			if err != nil {
+				return stats, err
+			}
+			if sealed == "" {
+				continue
+			}
+			if opts.DryRun {
+				stats.Resealed++
+				continue
+			}
+
+			n, err := q.ResealSecret(ctx, repository.ResealSecretParams{
+				NewValue:  sealed,
+				UpdatedBy: pgtype.UUID{Bytes: opts.Actor, Valid: true},
+				ID:        row.ID,
+				OldValue:  row.EncryptedValue,
+			})
+			if err != nil {
+				return stats, fmt.Errorf("failed to reseal secret %s: %w", row.ID, err)
+			}
+			if n == 0 {
+				stats.Skipped++
+			} else {
+				stats.Resealed++
+			}
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+		if len(rows) < opts.BatchSize {
+			break
+		}
+	}
+
+	// Historical values, so they remain readable once binding is required
+	cursor = uuid.Nil
+	for {
+		rows, err := q.ListSecretHistoryForReseal(ctx, repository.ListSecretHistoryForResealParams{
+			ID:    cursor,
+			Limit: int32(opts.BatchSize),
+		})
+		if err != nil {
+			return stats, fmt.Errorf("failed to list secret history: %w", err)
+		}
+
+		for _, row := range rows {
+			cursor = row.ID
+			if row.EncryptedValue == nil {
+				continue
+			}
+			id := SecretIdentity{ProjectID: row.ProjectID.Bytes, EnvironmentID: row.EnvironmentID, Key: row.Key}
+			if row.Version != nil {
+				id.Version = *row.Version
+			}
+
+			sealed, err := v.reseal(ctx, keys, id, *row.EncryptedValue, &stats)
+			if err != nil {
+				return stats, err
+			}
+			if sealed == "" {
+				continue
+			}
+			if opts.DryRun {
+				stats.Resealed++
+				continue
+			}
+
+			n, err := q.ResealSecretHistory(ctx, repository.ResealSecretHistoryParams{
+				NewValue: sealed,
+				ID:       row.ID,
+				OldValue: row.EncryptedValue,
+			})
+			if err != nil {
+				return stats, fmt.Errorf("failed to reseal history entry %s: %w", row.ID, err)
+			}
+			if n == 0 {
+				stats.Skipped++
+			} else {
+				stats.Resealed++
+			}
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+		if len(rows) < opts.BatchSize {
+			break
+		}
+	}
+
+	return stats, nil
+}
+
+// reseal returns the bound ciphertext for value, or "" if nothing should be
+// written. Rows that need no write are counted in stats.
+func (v *Vault) reseal(ctx context.Context, keys *dataKeyCache, id SecretIdentity, value string, stats *ResealStats) (string, error) {
+	stats.Scanned++
+
+	dek, err := keys.get(ctx, id.ProjectID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		stats.Skipped++
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		stats.Failed++
+		return "", nil
+	}
+	_, err = crypto.DecryptWithDEKAndAAD(raw, dek, id.AAD())
+	if err == nil {
+		stats.Bound++
+		return "", nil
+	}
+	plaintext, err := decryptLegacy(dek, id, raw, err)
+	if err != nil {
+		stats.Failed++
+		return "", nil
+	}
+
+	return v.EncryptValue(dek, id, string(plaintext))
+}
+
+// dataKeyCache unwraps each project's DEK once per run
+type dataKeyCache struct {
+	vault *Vault
+	q     repository.Querier
+	keys  map[uuid.UUID]*crypto.DataKey
+}
+
+func newDataKeyCache(v *Vault, q repository.Querier) *dataKeyCache {
+	return &dataKeyCache{vault: v, q: q, keys: make(map[uuid.UUID]*crypto.DataKey)}
+}
+
+func (c *dataKeyCache) get(ctx context.Context, projectID uuid.UUID) (*crypto.DataKey, error) {
+	if dek, ok := c.keys[projectID]; ok {
+		return dek, nil
+	}
+
+	project, err := c.q.GetProjectByID(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	dek, err := c.vault.DataKey(project)
+	if err != nil {
+		return nil, err
+	}
+
+	c.keys[projectID] = dek
+	return dek, nil
+}
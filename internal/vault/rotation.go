@@ -0,0 +1,175 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// DefaultRotationBatchSize is the number of projects re-wrapped per transaction
+const DefaultRotationBatchSize = 100
+
+// RotationOptions configures a master key rotation
+type RotationOptions struct {
+	// BatchSize is the number of projects per transaction (DefaultRotationBatchSize if zero)
+	BatchSize int
+
+	// Actor is recorded as the user who started the job
+	Actor uuid.UUID
+
+	// Progress, if set, is called after every committed batch
+	Progress func(repository.MasterKeyRotation)
+}
+
+// RotateMasterKey re-wraps every project DEK, and then every webhook signing
+// secret, with the active master key.
+//
+// Projects are walked in id order in batches; each batch and the job's
+// checkpoint are committed in one transaction. If an unfinished job for the
+// active version exists it is resumed from its checkpoint, so an interrupted
+// rotation is continued by simply running it again. Previous keys must stay
+// in the ring until the job completes.
+func (v *Vault) RotateMasterKey(ctx context.Context, store repository.Store, opts RotationOptions) (crypto.RotationResult, error) {
+	if v.keys == nil {
+		return crypto.RotationResult{}, ErrNoMasterKey
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultRotationBatchSize
+	}
+
+	active := v.keys.Active().Version
+	result := crypto.RotationResult{NewKeyVersion: active}
+
+	job, err := store.GetUnfinishedMasterKeyRotation(ctx, int32(active))
+	if errors.Is(err, pgx.ErrNoRows) {
+		job, err = store.CreateMasterKeyRotation(ctx, repository.CreateMasterKeyRotationParams{
+			TargetVersion: int32(active),
+			StartedBy:     opts.Actor,
+		})
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to start rotation job: %w", err)
+	}
+
+	for {
+		var (
+			checkpoint repository.MasterKeyRotation
+			done       bool
+		)
+		err := store.ExecTx(ctx, func(q repository.Querier) error {
+			rows, err := q.ListProjectsForRewrap(ctx, repository.ListProjectsForRewrapParams{
+				ID:    uuid.UUID(job.LastProjectID.Bytes),
+				Limit: int32(opts.BatchSize),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to list projects: %w", err)
+			}
+			if len(rows) == 0 {
+				done = true
+				return nil
+			}
+
+			progress := repository.UpdateMasterKeyRotationProgressParams{
+				ID:              job.ID,
+				ProjectsScanned: job.ProjectsScanned,
+				ProjectsRotated: job.ProjectsRotated,
+			}
+			for _, row := range rows {
+				progress.LastProjectID = pgtype.UUID{Bytes: row.ID, Valid: true}
+				progress.ProjectsScanned++
+
+				rotated, from, err := v.rewrap(ctx, q, row)
+				if err != nil {
+					return err
+				}
+				if rotated {
+					progress.ProjectsRotated++
+					result.ItemsRotated++
+					result.OldKeyVersion = max(result.OldKeyVersion, from)
+				}
+			}
+
+			checkpoint, err = q.UpdateMasterKeyRotationProgress(ctx, progress)
+			if err != nil {
+				return fmt.Errorf("failed to checkpoint rotation job: %w", err)
+			}
+			done = len(rows) < opts.BatchSize
+			return nil
+		})
+		if err != nil {
+			return result, v.failRotation(ctx, store, job, err)
+		}
+
+		// Only advance past the checkpoint once it is committed
+		if checkpoint.ID != uuid.Nil {
+			job = checkpoint
+			if opts.Progress != nil {
+				opts.Progress(job)
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	if err := v.rewrapWebhookSecrets(ctx, store); err != nil {
+		return result, v.failRotation(ctx, store, job, err)
+	}
+
+	if _, err := store.FinishMasterKeyRotation(ctx, repository.FinishMasterKeyRotationParams{
+		ID:     job.ID,
+		Status: repository.MasterKeyRotationStatusCompleted,
+	}); err != nil {
+		return result, fmt.Errorf("failed to complete rotation job: %w", err)
+	}
+
+	return result, nil
+}
+
+// rewrap re-wraps one project's DEK with the active key if it is not already
+// wrapped with it, and returns the version it was wrapped with before
+func (v *Vault) rewrap(ctx context.Context, q repository.Querier, row repository.ListProjectsForRewrapRow) (bool, int, error) {
+	if !v.keys.NeedsRewrap(row.EncryptedDek) {
+		return false, 0, nil
+	}
+
+	dek, from, err := v.keys.DecryptDEK(row.EncryptedDek)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to unwrap DEK for project %s: %w", row.ID, err)
+	}
+	wrapped, err := v.keys.EncryptDEK(dek)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to wrap DEK for project %s: %w", row.ID, err)
+	}
+
+	// Conditional on the old value so a concurrent DEK rotation is not undone
+	n, err := q.RewrapProjectDEK(ctx, repository.RewrapProjectDEKParams{
+		NewDek: wrapped,
+		ID:     row.ID,
+		OldDek: row.EncryptedDek,
+	})
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to store DEK for project %s: %w", row.ID, err)
+	}
+	return n > 0, from, nil
+}
+
+// failRotation records err on the job and returns it
+func (v *Vault) failRotation(ctx context.Context, store repository.Store, job repository.MasterKeyRotation, err error) error {
+	msg := err.Error()
+	if _, ferr := store.FinishMasterKeyRotation(context.WithoutCancel(ctx), repository.FinishMasterKeyRotationParams{
+		ID:        job.ID,
+		Status:    repository.MasterKeyRotationStatusFailed,
+		LastError: &msg,
+	}); ferr != nil {
+		return fmt.Errorf("%w (recording failure: %v)", err, ferr)
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package vault
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+func TestRotateMasterKey(t *testing.T) {
+	v1, _ := crypto.GenerateMasterKey()
+	v2, _ := crypto.GenerateMasterKey()
+	v2.Version = 2
+
+	store := repotest.NewMemStore()
+	actor := store.AddUser(repository.User{Email: "ops@example.com"})
+
+	// Five projects wrapped with v1, one already wrapped with v2
+	deks := make(map[uuid.UUID]*crypto.DataKey)
+	for i := range 6 {
+		dek, _ := crypto.GenerateDataKey()
+		mk := v1
+		if i == 0 {
+			mk = v2
+		}
+		wrapped, _ := crypto.EncryptDEK(dek, mk)
+		p := store.AddProject(repository.Project{Name: "p", EncryptedDek: wrapped})
+		deks[p.ID] = dek
+	}
+
+	// And a webhook signing secret wrapped with v1
+	onlyV1, _ := crypto.NewKeyRing(v1)
+	secret, wrapped, err := New(onlyV1).NewWebhookSecret()
+	if err != nil {
+		t.Fatalf("NewWebhookSecret failed: %v", err)
+	}
+	hook, _ := store.CreateWebhook(t.Context(), repository.CreateWebhookParams{
+		OrganizationID:  uuid.New(),
+		Url:             "https://deploy.example.com/hooks",
+		Events:          []string{"*"},
+		EncryptedSecret: wrapped,
+	})
+
+	ring, err := crypto.NewKeyRing(v2, v1)
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	v := New(ring)
+
+	var checkpoints []repository.MasterKeyRotation
+	result, err := v.RotateMasterKey(t.Context(), store, RotationOptions{
+		BatchSize: 2,
+		Actor:     actor.ID,
+		Progress:  func(job repository.MasterKeyRotation) { checkpoints = append(checkpoints, job) },
+	})
+	if err != nil {
+		t.Fatalf("RotateMasterKey failed: %v", err)
+	}
+	if result.ItemsRotated != 5 || result.OldKeyVersion != 1 || result.NewKeyVersion != 2 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if len(checkpoints) != 3 || checkpoints[2].ProjectsScanned != 6 {
+		t.Errorf("Expected 3 checkpoints ending at 6 projects, got %+v", checkpoints)
+	}
+
+	job, err := store.GetLatestMasterKeyRotation(t.Context())
+	if err != nil {
+		t.Fatalf("GetLatestMasterKeyRotation failed: %v", err)
+	}
+	if job.Status != repository.MasterKeyRotationStatusCompleted || job.ProjectsRotated != 5 || job.StartedBy != actor.ID {
+		t.Errorf("Unexpected job %+v", job)
+	}
+
+	// Every DEK now unwraps with v2 alone and is unchanged
+	onlyV2, _ := crypto.NewKeyRing(v2)
+	for id, dek := range deks {
+		p, _ := store.Project(id)
+		got, err := New(onlyV2).DataKey(p)
+		if err != nil {
+			t.Fatalf("project %s: DataKey failed: %v", id, err)
+		}
+		if !bytes.Equal(got.Key, dek.Key) {
+			t.Errorf("project %s: DEK changed during rotation", id)
+		}
+	}
+
+	hook, _ = store.GetWebhook(t.Context(), repository.GetWebhookParams{ID: hook.ID, OrganizationID: hook.OrganizationID})
+	if got, err := New(onlyV2).WebhookSecret(hook.EncryptedSecret); err != nil || got != secret {
+		t.Errorf("Expected the webhook secret to unwrap unchanged with v2, got %q (%v)", got, err)
+	}
+
+	// A second run finds nothing to do
+	result, err = v.RotateMasterKey(t.Context(), store, RotationOptions{Actor: actor.ID})
+	if err != nil || result.ItemsRotated != 0 {
+		t.Errorf("Second run = %+v, %v", result, err)
+	}
+}
+
+func TestRotateMasterKeyResumes(t *testing.T) {
+	v1, _ := crypto.GenerateMasterKey()
+	v2, _ := crypto.GenerateMasterKey()
+	v2.Version = 2
+	lost, _ := crypto.GenerateMasterKey()
+
+	store := repotest.NewMemStore()
+	actor := store.AddUser(repository.User{Email: "ops@example.com"})
+
+	var ids []uuid.UUID
+	for range 3 {
+		dek, _ := crypto.GenerateDataKey()
+		wrapped, _ := crypto.EncryptDEK(dek, v1)
+		ids = append(ids, store.AddProject(repository.Project{Name: "p", EncryptedDek: wrapped}).ID)
+	}
+
+	// A ring missing v1 cannot unwrap anything: the job fails and says why
+	broken, _ := crypto.NewKeyRing(v2, lost)
+	if _, err := New(broken).RotateMasterKey(t.Context(), store, RotationOptions{Actor: actor.ID}); err == nil {
+		t.Fatalf("Expected rotation to fail without the previous key")
+	}
+	job, _ := store.GetLatestMasterKeyRotation(t.Context())
+	if job.Status != repository.MasterKeyRotationStatusFailed || job.LastError == nil {
+		t.Errorf("Expected a failed job with an error, got %+v", job)
+	}
+
+	// With the right ring the same job is resumed and completed
+	ring, _ := crypto.NewKeyRing(v2, v1)
+	result, err := New(ring).RotateMasterKey(t.Context(), store, RotationOptions{Actor: actor.ID})
+	if err != nil {
+		t.Fatalf("RotateMasterKey failed: %v", err)
+	}
+	resumed, _ := store.GetLatestMasterKeyRotation(t.Context())
+	if resumed.ID != job.ID || resumed.Status != repository.MasterKeyRotationStatusCompleted || resumed.LastError != nil {
+		t.Errorf("Expected job %s to be resumed and completed, got %+v", job.ID, resumed)
+	}
+	if result.ItemsRotated != 3 {
+		t.Errorf("Expected 3 projects re-wrapped, got %d", result.ItemsRotated)
+	}
+	for _, id := range ids {
+		p, _ := store.Project(id)
+		if ring.NeedsRewrap(p.EncryptedDek) {
+			t.Errorf("project %s still wrapped with an old key", id)
+		}
+	}
+}
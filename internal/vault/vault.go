@@ -0,0 +1,182 @@
+package vault
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// ErrNoMasterKey is returned when the vault is used without a master key
+var ErrNoMasterKey = errors.New("vault: master key not configured")
+
+// Associated data layouts for secret values. v1 values, sealed before
+// versions were bound, are read unless the vault requires binding.
+const (
+	secretAADPrefix   = "envhub/secret/v2\x00"
+	secretAADPrefixV1 = "envhub/secret/v1\x00"
+)
+
+// SecretIdentity is what a secret value's ciphertext is bound to. A value
+// sealed for one identity does not decrypt under another, so ciphertexts
+// cannot be swapped between secrets, environments or projects, nor between
+// versions of a secret. A deleted key created again continues the deleted
+// secret's versions, so their values do not decrypt as each other's.
+type SecretIdentity struct {
+	ProjectID     uuid.UUID
+	EnvironmentID uuid.UUID
+	Key           string
+
+	// Version is the version of the secret that holds the value. Proposed
+	// values are bound to the version they would become. Zero selects the
+	// v1 layout, which has no version.
+	Version int32
+}
+
+// AAD returns the associated data for the identity:
+// prefix || project id (16) || environment id (16) || version (4) || key,
+// without the version for v1
+func (id SecretIdentity) AAD() []byte {
+	if id.Version == 0 {
+		aad := make([]byte, 0, len(secretAADPrefixV1)+32+len(id.Key))
+		aad = append(aad, secretAADPrefixV1...)
+		aad = append(aad, id.ProjectID[:]...)
+		aad = append(aad, id.EnvironmentID[:]...)
+		return append(aad, id.Key...)
+	}
+
+	aad := make([]byte, 0, len(secretAADPrefix)+36+len(id.Key))
+	aad = append(aad, secretAADPrefix...)
+	aad = append(aad, id.ProjectID[:]...)
+	aad = append(aad, id.EnvironmentID[:]...)
+	aad = binary.BigEndian.AppendUint32(aad, uint32(id.Version))
+	return append(aad, id.Key...)
+}
+
+// dynamicSecretAADPrefix versions the associated data layout for the admin
+// connections of dynamic secrets
+const dynamicSecretAADPrefix = "envhub/dynamic-secret/v1\x00"
+
+// DynamicSecretIdentity is what a dynamic secret's admin connection is bound
+// to. Its associated data differs from that of a secret with the same key,
+// so neither ciphertext decrypts as the other.
+type DynamicSecretIdentity struct {
+	ProjectID     uuid.UUID
+	EnvironmentID uuid.UUID
+	Key           string
+}
+
+// AAD returns the associated data for the identity:
+// prefix || project id (16) || environment id (16) || key
+func (id DynamicSecretIdentity) AAD() []byte {
+	aad := make([]byte, 0, len(dynamicSecretAADPrefix)+32+len(id.Key))
+	aad = append(aad, dynamicSecretAADPrefix...)
+	aad = append(aad, id.ProjectID[:]...)
+	aad = append(aad, id.EnvironmentID[:]...)
+	return append(aad, id.Key...)
+}
+
+// Identity is what a ciphertext is bound to
+type Identity interface {
+	AAD() []byte
+}
+
+// Vault unwraps project DEKs with the master key ring and uses them
+// to encrypt and decrypt secret values
+type Vault struct {
+	keys           *crypto.KeyRing
+	requireBinding bool
+}
+
+// Option configures a Vault
+type Option func(*Vault)
+
+// WithRequiredBinding rejects secret values that are not bound to their
+// identity. Enable it once existing rows have been re-sealed.
+func WithRequiredBinding() Option {
+	return func(v *Vault) {
+		v.requireBinding = true
+	}
+}
+
+// New creates a Vault that wraps DEKs with the ring's active master key
+func New(keys *crypto.KeyRing, opts ...Option) *Vault {
+	v := &Vault{keys: keys}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// DataKey decrypts the project's DEK with whichever master key wrapped it
+func (v *Vault) DataKey(project repository.Project) (*crypto.DataKey, error) {
+	if v.keys == nil {
+		return nil, ErrNoMasterKey
+	}
+
+	dek, _, err := v.keys.DecryptDEK(project.EncryptedDek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap DEK for project %s: %w", project.ID, err)
+	}
+	dek.Version = int(project.DekVersion)
+
+	return dek, nil
+}
+
+// WrapDataKey encrypts a DEK with the active master key
+func (v *Vault) WrapDataKey(dek *crypto.DataKey) (string, error) {
+	if v.keys == nil {
+		return "", ErrNoMasterKey
+	}
+	return v.keys.EncryptDEK(dek)
+}
+
+// EncryptValue encrypts a plaintext secret value with the project's DEK,
+// bound to the secret's identity
+func (v *Vault) EncryptValue(dek *crypto.DataKey, id Identity, plaintext string) (string, error) {
+	ciphertext, err := crypto.EncryptWithDEKAndAAD([]byte(plaintext), dek, id.AAD())
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptValue decrypts a stored secret value with the project's DEK.
+// Values sealed before binding existed, or before versions were bound, are
+// accepted unless the vault requires binding.
+func (v *Vault) DecryptValue(dek *crypto.DataKey, id Identity, ciphertext string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %w", err)
+	}
+
+	plaintext, err := crypto.DecryptWithDEKAndAAD(raw, dek, id.AAD())
+	if err != nil && !v.requireBinding {
+		plaintext, err = decryptLegacy(dek, id, raw, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// decryptLegacy decrypts a value sealed for id in an older way, given the
+// error decrypting it bound to id: an unbound envelope is read as is, and
+// a bound one only with the v1 layout. A value bound to another identity
+// is rejected with boundErr.
+func decryptLegacy(dek *crypto.DataKey, id Identity, raw []byte, boundErr error) ([]byte, error) {
+	if errors.Is(boundErr, crypto.ErrUnboundCiphertext) || errors.Is(boundErr, crypto.ErrUnsupportedFormat) {
+		return crypto.DecryptWithDEK(raw, dek)
+	}
+	if secret, ok := id.(SecretIdentity); ok && secret.Version != 0 && errors.Is(boundErr, crypto.ErrDecryptionFailed) {
+		secret.Version = 0
+		return crypto.DecryptWithDEKAndAAD(raw, dek, secret.AAD())
+	}
+	return nil, boundErr
+}
@@ -0,0 +1,202 @@
+package vault
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/internal/repository/repotest"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// newTestVault returns a vault and a project whose DEK it can unwrap
+func newTestVault(t *testing.T, opts ...Option) (*Vault, repository.Project, *crypto.DataKey) {
+	t.Helper()
+
+	mk, err := crypto.GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("GenerateMasterKey failed: %v", err)
+	}
+	dek, err := crypto.GenerateDataKey()
+	if err != nil {
+		t.Fatalf("GenerateDataKey failed: %v", err)
+	}
+	dek.Version = 1
+	encryptedDEK, err := crypto.EncryptDEK(dek, mk)
+	if err != nil {
+		t.Fatalf("EncryptDEK failed: %v", err)
+	}
+
+	keys, err := crypto.NewKeyRing(mk)
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+
+	project := repository.Project{ID: uuid.New(), Name: "api", EncryptedDek: encryptedDEK, DekVersion: 1}
+	return New(keys, opts...), project, dek
+}
+
+func TestSecretBinding(t *testing.T) {
+	v, project, dek := newTestVault(t)
+
+	id := SecretIdentity{ProjectID: project.ID, EnvironmentID: uuid.New(), Key: "DATABASE_URL", Version: 2}
+	sealed, err := v.EncryptValue(dek, id, "postgres://prod")
+	if err != nil {
+		t.Fatalf("EncryptValue failed: %v", err)
+	}
+
+	got, err := v.DecryptValue(dek, id, sealed)
+	if err != nil || got != "postgres://prod" {
+		t.Fatalf("DecryptValue = %q, %v", got, err)
+	}
+
+	// The same ciphertext under any other identity must not decrypt
+	others := map[string]SecretIdentity{
+		"Other key":         {ProjectID: id.ProjectID, EnvironmentID: id.EnvironmentID, Key: "API_KEY", Version: id.Version},
+		"Other environment": {ProjectID: id.ProjectID, EnvironmentID: uuid.New(), Key: id.Key, Version: id.Version},
+		"Other project":     {ProjectID: uuid.New(), EnvironmentID: id.EnvironmentID, Key: id.Key, Version: id.Version},
+		"Other version":     {ProjectID: id.ProjectID, EnvironmentID: id.EnvironmentID, Key: id.Key, Version: 1},
+	}
+	for name, other := range others {
+		t.Run(name, func(t *testing.T) {
+			if _, err := v.DecryptValue(dek, other, sealed); !errors.Is(err, crypto.ErrDecryptionFailed) {
+				t.Errorf("Expected ErrDecryptionFailed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnboundValues(t *testing.T) {
+	v, project, dek := newTestVault(t)
+	id := SecretIdentity{ProjectID: project.ID, EnvironmentID: uuid.New(), Key: "API_KEY", Version: 3}
+
+	unbound, err := crypto.EncryptStringWithDEK("value", dek)
+	if err != nil {
+		t.Fatalf("EncryptStringWithDEK failed: %v", err)
+	}
+
+	if got, err := v.DecryptValue(dek, id, unbound); err != nil || got != "value" {
+		t.Errorf("Lenient vault: DecryptValue = %q, %v", got, err)
+	}
+
+	strict := New(v.keys, WithRequiredBinding())
+	if _, err := strict.DecryptValue(dek, id, unbound); !errors.Is(err, crypto.ErrUnboundCiphertext) {
+		t.Errorf("Strict vault: expected ErrUnboundCiphertext, got %v", err)
+	}
+
+	// Values bound before versions were read the same way
+	unversioned := id
+	unversioned.Version = 0
+	legacy, err := v.EncryptValue(dek, unversioned, "legacy")
+	if err != nil {
+		t.Fatalf("EncryptValue failed: %v", err)
+	}
+	if got, err := v.DecryptValue(dek, id, legacy); err != nil || got != "legacy" {
+		t.Errorf("Lenient vault: DecryptValue of unversioned value = %q, %v", got, err)
+	}
+	if _, err := strict.DecryptValue(dek, id, legacy); !errors.Is(err, crypto.ErrDecryptionFailed) {
+		t.Errorf("Strict vault: expected ErrDecryptionFailed for unversioned value, got %v", err)
+	}
+}
+
+func TestReseal(t *testing.T) {
+	v, project, dek := newTestVault(t)
+
+	store := repotest.NewMemStore()
+	actor := store.AddUser(repository.User{Email: "ops@example.com"})
+	project = store.AddProject(project)
+	env := store.AddEnvironment(repository.Environment{ProjectID: project.ID, Name: "prod"})
+
+	// Secrets written before binding, one of them updated so it has history,
+	// and one bound before versions were
+	var secrets []repository.Secret
+	for _, key := range []string{"A", "B", "C"} {
+		value, _ := crypto.EncryptStringWithDEK("value-"+key, dek)
+		if key == "C" {
+			value, _ = v.EncryptValue(dek, SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: key}, "value-"+key)
+		}
+		s, err := store.CreateSecret(t.Context(), repository.CreateSecretParams{
+			EnvironmentID:  env.ID,
+			Key:            key,
+			EncryptedValue: value,
+			Version:        1,
+			CreatedBy:      pgtype.UUID{Bytes: actor.ID, Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("CreateSecret failed: %v", err)
+		}
+		secrets = append(secrets, s)
+	}
+	updated, _ := crypto.EncryptStringWithDEK("value-A2", dek)
+	if _, err := store.UpdateSecret(t.Context(), repository.UpdateSecretParams{
+		ID:             secrets[0].ID,
+		EncryptedValue: updated,
+		UpdatedBy:      pgtype.UUID{Bytes: actor.ID, Valid: true},
+	}); err != nil {
+		t.Fatalf("UpdateSecret failed: %v", err)
+	}
+
+	// 3 current values + 4 history entries
+	opts := ResealOptions{BatchSize: 2, Actor: actor.ID, DryRun: true}
+	stats, err := v.Reseal(t.Context(), store, opts)
+	if err != nil {
+		t.Fatalf("Reseal (dry run) failed: %v", err)
+	}
+	if stats.Scanned != 7 || stats.Resealed != 7 {
+		t.Errorf("Dry run: unexpected stats %+v", stats)
+	}
+	if h := store.History(secrets[1].ID); len(h) != 1 {
+		t.Errorf("Dry run wrote history: %d entries", len(h))
+	}
+
+	var batches int
+	opts.DryRun = false
+	opts.Progress = func(ResealStats) { batches++ }
+	stats, err = v.Reseal(t.Context(), store, opts)
+	if err != nil {
+		t.Fatalf("Reseal failed: %v", err)
+	}
+	if stats.Resealed != 7 || stats.Failed != 0 || stats.Skipped != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if batches == 0 {
+		t.Errorf("Progress was never reported")
+	}
+
+	// Every value now decrypts under a strict vault, and only under its own identity
+	strict := New(v.keys, WithRequiredBinding())
+	for _, s := range secrets {
+		got, err := store.GetSecretByKey(t.Context(), repository.GetSecretByKeyParams{EnvironmentID: env.ID, Key: s.Key})
+		if err != nil {
+			t.Fatalf("GetSecretByKey failed: %v", err)
+		}
+		id := SecretIdentity{ProjectID: project.ID, EnvironmentID: env.ID, Key: s.Key, Version: got.Version}
+		if _, err := strict.DecryptValue(dek, id, got.EncryptedValue); err != nil {
+			t.Errorf("%s: strict DecryptValue failed: %v", s.Key, err)
+		}
+
+		history := store.History(s.ID)
+		last := history[len(history)-1]
+		if last.Action != repository.SecretActionRotated || last.ChangedBy != actor.ID {
+			t.Errorf("%s: expected a rotated history entry by the actor, got %s", s.Key, last.Action)
+		}
+		for _, h := range history {
+			id.Version = *h.Version
+			if _, err := strict.DecryptValue(dek, id, *h.EncryptedValue); err != nil {
+				t.Errorf("%s: history entry %s not resealed: %v", s.Key, h.Action, err)
+			}
+		}
+	}
+
+	// A second run finds nothing to do
+	stats, err = v.Reseal(t.Context(), store, ResealOptions{Actor: actor.ID})
+	if err != nil {
+		t.Fatalf("Second Reseal failed: %v", err)
+	}
+	if stats.Resealed != 0 || stats.Bound != stats.Scanned {
+		t.Errorf("Second run: unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/Now-Tiger/envhub/internal/repository"
+	"github.com/Now-Tiger/envhub/pkg/crypto"
+)
+
+// webhookSecretPrefix makes webhook signing secrets recognizable, e.g. to
+// secret scanners
+const webhookSecretPrefix = "whsec_"
+
+// NewWebhookSecret generates a webhook signing secret. It returns the
+// secret, which is shown to its creator once, and the form to store, which
+// is wrapped with the active master key like a project DEK.
+func (v *Vault) NewWebhookSecret() (secret, wrapped string, err error) {
+	key, err := crypto.GenerateDataKey()
+	if err != nil {
+		return "", "", err
+	}
+	if wrapped, err = v.WrapDataKey(key); err != nil {
+		return "", "", err
+	}
+	return webhookSecret(key), wrapped, nil
+}
+
+// WebhookSecret unwraps a signing secret stored by NewWebhookSecret
+func (v *Vault) WebhookSecret(wrapped string) (string, error) {
+	if v.keys == nil {
+		return "", ErrNoMasterKey
+	}
+	key, _, err := v.keys.DecryptDEK(wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap webhook secret: %w", err)
+	}
+	return webhookSecret(key), nil
+}
+
+func webhookSecret(key *crypto.DataKey) string {
+	return webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(key.Key)
+}
+
+// rewrapWebhookSecrets re-wraps the webhook signing secrets that are not
+// wrapped with the active key. There are few of them, so it takes a single
+// transaction.
+func (v *Vault) rewrapWebhookSecrets(ctx context.Context, store repository.Store) error {
+	return store.ExecTx(ctx, func(q repository.Querier) error {
+		rows, err := q.ListWebhookSecrets(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list webhook secrets: %w", err)
+		}
+		for _, row := range rows {
+			if !v.keys.NeedsRewrap(row.EncryptedSecret) {
+				continue
+			}
+			key, _, err := v.keys.DecryptDEK(row.EncryptedSecret)
+			if err != nil {
+				return fmt.Errorf("failed to unwrap secret of webhook %s: %w", row.ID, err)
+			}
+			wrapped, err := v.keys.EncryptDEK(key)
+			if err != nil {
+				return fmt.Errorf("failed to wrap secret of webhook %s: %w", row.ID, err)
+			}
+			if _, err := q.RewrapWebhookSecret(ctx, repository.RewrapWebhookSecretParams{
+				NewSecret: wrapped,
+				ID:        row.ID,
+				OldSecret: row.EncryptedSecret,
+			}); err != nil {
+				return fmt.Errorf("failed to store secret of webhook %s: %w", row.ID, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,37 @@
+-- ============================================================================
+-- SECRET HISTORY: RECORD CIPHERTEXT-ONLY CHANGES AS 'rotated'
+-- ============================================================================
+-- Re-sealing a value (binding it to its identity, rotating a DEK or the
+-- master key) rewrites encrypted_value without changing the secret's
+-- version. Log those as 'rotated' so history distinguishes them from edits.
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION log_secret_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, changed_by)
+        VALUES (NEW.id, NEW.environment_id, 'created', NEW.key, NEW.encrypted_value, NEW.created_by);
+    ELSIF TG_OP = 'UPDATE' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, changed_by)
+        VALUES (
+            NEW.id,
+            NEW.environment_id,
+            CASE
+                WHEN NEW.version = OLD.version
+                     AND NEW.encrypted_value IS DISTINCT FROM OLD.encrypted_value
+                     AND NEW.deleted_at IS NOT DISTINCT FROM OLD.deleted_at
+                THEN 'rotated'::secret_action
+                ELSE 'updated'::secret_action
+            END,
+            NEW.key,
+            NEW.encrypted_value,
+            NEW.updated_by
+        );
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, changed_by)
+        VALUES (OLD.id, OLD.environment_id, 'deleted', OLD.key, NULL, OLD.updated_by);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
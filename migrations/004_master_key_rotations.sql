@@ -0,0 +1,37 @@
+-- ============================================================================
+-- MASTER KEY ROTATIONS
+-- ============================================================================
+-- Purpose: Track jobs that re-wrap every project DEK with a new master key
+-- (KEK). Progress is checkpointed in the same transaction as each batch, so
+-- an interrupted job resumes after the last project it committed.
+-- ============================================================================
+
+CREATE TYPE master_key_rotation_status AS ENUM ('running', 'completed', 'failed');
+
+CREATE TABLE master_key_rotations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+
+    -- The master key version every DEK is re-wrapped with
+    target_version INTEGER NOT NULL CHECK (target_version > 0),
+    status master_key_rotation_status NOT NULL DEFAULT 'running',
+
+    -- Checkpoint: projects are processed in id order
+    last_project_id UUID,
+    projects_scanned INTEGER NOT NULL DEFAULT 0,
+    projects_rotated INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+
+    started_by UUID NOT NULL REFERENCES users(id),
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_master_key_rotations_started_at ON master_key_rotations(started_at DESC);
+
+-- Only one unfinished job per target version
+CREATE UNIQUE INDEX idx_master_key_rotations_unfinished ON master_key_rotations(target_version)
+    WHERE status <> 'completed';
+
+CREATE TRIGGER update_master_key_rotations_updated_at BEFORE UPDATE ON master_key_rotations
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
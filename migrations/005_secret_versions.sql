@@ -0,0 +1,80 @@
+-- ============================================================================
+-- SECRET VERSIONS AND ROLLBACK
+-- ============================================================================
+-- Purpose: Record which secret version every history entry holds so old
+-- values can be looked up and restored. A rollback writes the old value as a
+-- new version and is logged as 'rolled_back', naming the version it restored.
+-- ============================================================================
+
+ALTER TYPE secret_action ADD VALUE IF NOT EXISTS 'rolled_back';
+
+-- Set by a rollback, cleared by the next regular update
+ALTER TABLE secrets ADD COLUMN restored_version INTEGER;
+
+ALTER TABLE secret_history
+    ADD COLUMN version INTEGER,
+    ADD COLUMN restored_version INTEGER;
+
+-- Backfill: every 'created' entry and every 'updated' entry that changed the
+-- value started a new version. Soft deletes were logged as 'updated' with an
+-- unchanged value and do not count.
+WITH changes AS (
+    SELECT
+        id,
+        secret_id,
+        created_at,
+        CASE
+            WHEN action = 'created' THEN 1
+            WHEN action = 'updated'
+                 AND encrypted_value IS DISTINCT FROM LAG(encrypted_value) OVER w
+            THEN 1
+            ELSE 0
+        END AS bump
+    FROM secret_history
+    WINDOW w AS (PARTITION BY secret_id ORDER BY created_at, id)
+), numbered AS (
+    SELECT id, SUM(bump) OVER (PARTITION BY secret_id ORDER BY created_at, id) AS version
+    FROM changes
+)
+UPDATE secret_history h
+SET version = GREATEST(numbered.version, 1)
+FROM numbered
+WHERE numbered.id = h.id;
+
+CREATE INDEX idx_secret_history_version ON secret_history(secret_id, version);
+
+CREATE OR REPLACE FUNCTION log_secret_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, changed_by)
+        VALUES (NEW.id, NEW.environment_id, 'created', NEW.key, NEW.encrypted_value, NEW.version, NEW.created_by);
+    ELSIF TG_OP = 'UPDATE' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, restored_version, changed_by)
+        VALUES (
+            NEW.id,
+            NEW.environment_id,
+            CASE
+                WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL
+                THEN 'deleted'::secret_action
+                WHEN NEW.version = OLD.version
+                     AND NEW.encrypted_value IS DISTINCT FROM OLD.encrypted_value
+                     AND NEW.deleted_at IS NOT DISTINCT FROM OLD.deleted_at
+                THEN 'rotated'::secret_action
+                WHEN NEW.version <> OLD.version AND NEW.restored_version IS NOT NULL
+                THEN 'rolled_back'::secret_action
+                ELSE 'updated'::secret_action
+            END,
+            NEW.key,
+            NEW.encrypted_value,
+            NEW.version,
+            CASE WHEN NEW.version <> OLD.version THEN NEW.restored_version END,
+            NEW.updated_by
+        );
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, changed_by)
+        VALUES (OLD.id, OLD.environment_id, 'deleted', OLD.key, NULL, OLD.version, OLD.updated_by);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,98 @@
+-- ============================================================================
+-- CHANGE REQUESTS: APPROVALS FOR PROTECTED ENVIRONMENTS
+-- ============================================================================
+-- Purpose: Writes to a protected environment are proposed as a change request
+-- instead of being applied. Once it has the environment's required number of
+-- approvals (never from the requester) every change is applied to secrets in
+-- one transaction.
+-- ============================================================================
+
+-- Approvals needed before a change request to a protected environment applies
+ALTER TABLE environments
+    ADD COLUMN required_approvals INTEGER NOT NULL DEFAULT 1 CHECK (required_approvals > 0);
+
+-- Audit trail of the workflow in access_logs
+ALTER TYPE access_action ADD VALUE IF NOT EXISTS 'approve';
+ALTER TYPE access_action ADD VALUE IF NOT EXISTS 'reject';
+ALTER TYPE access_action ADD VALUE IF NOT EXISTS 'cancel';
+ALTER TYPE access_action ADD VALUE IF NOT EXISTS 'apply';
+
+CREATE TYPE change_request_status AS ENUM ('pending', 'applied', 'rejected', 'cancelled');
+CREATE TYPE change_operation AS ENUM ('create', 'update', 'delete');
+
+CREATE TABLE change_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    environment_id UUID NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+
+    status change_request_status NOT NULL DEFAULT 'pending',
+    reason TEXT,
+
+    -- Copied from the environment when the request is made
+    required_approvals INTEGER NOT NULL CHECK (required_approvals > 0),
+
+    requested_by UUID NOT NULL REFERENCES users(id),
+
+    -- Who applied, rejected or cancelled the request
+    decided_by UUID REFERENCES users(id),
+    decision_comment TEXT,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    decided_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_change_requests_environment ON change_requests(environment_id, created_at DESC);
+CREATE INDEX idx_change_requests_pending ON change_requests(environment_id) WHERE status = 'pending';
+
+CREATE TRIGGER update_change_requests_updated_at BEFORE UPDATE ON change_requests
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TABLE change_request_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    change_request_id UUID NOT NULL REFERENCES change_requests(id) ON DELETE CASCADE,
+
+    key VARCHAR(255) NOT NULL,
+    operation change_operation NOT NULL,
+
+    -- Proposed value, encrypted like secrets.encrypted_value. NULL for deletes.
+    encrypted_value TEXT,
+    description TEXT,
+
+    -- Version of the secret the change was based on (update/delete). The
+    -- request cannot apply once the secret has moved on.
+    base_version INTEGER,
+
+    -- Set when the change rolls the secret back to an earlier version
+    restored_version INTEGER,
+
+    CHECK ((operation = 'delete') = (encrypted_value IS NULL)),
+    UNIQUE(change_request_id, key)
+);
+
+CREATE TABLE change_request_approvals (
+    change_request_id UUID NOT NULL REFERENCES change_requests(id) ON DELETE CASCADE,
+    approved_by UUID NOT NULL REFERENCES users(id),
+    comment TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (change_request_id, approved_by)
+);
+
+-- Requesters may never approve their own change
+CREATE OR REPLACE FUNCTION prevent_self_approval()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM change_requests
+        WHERE id = NEW.change_request_id AND requested_by = NEW.approved_by
+    ) THEN
+        RAISE EXCEPTION 'change request % cannot be approved by its requester', NEW.change_request_id
+            USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER change_request_self_approval_trigger
+BEFORE INSERT ON change_request_approvals
+FOR EACH ROW EXECUTE FUNCTION prevent_self_approval();
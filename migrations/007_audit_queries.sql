@@ -0,0 +1,44 @@
+-- ============================================================================
+-- AUDIT QUERIES: ORGANIZATION-SCOPED ACCESS LOGS
+-- ============================================================================
+-- Purpose: Organization admins search and export their access logs. Entries
+-- record the organization of the resource they name, so searches stay within
+-- one organization and page by (created_at, id) from an index.
+-- ============================================================================
+
+-- Kept when the organization is deleted; the entries remain for the record
+ALTER TABLE access_logs
+    ADD COLUMN organization_id UUID REFERENCES organizations(id) ON DELETE SET NULL;
+
+-- Backfill from the resource each entry names
+UPDATE access_logs l
+SET organization_id = p.organization_id
+FROM projects p
+WHERE l.resource_type = 'project' AND p.id = l.resource_id;
+
+UPDATE access_logs l
+SET organization_id = p.organization_id
+FROM environments e
+JOIN projects p ON p.id = e.project_id
+WHERE l.resource_type = 'environment' AND e.id = l.resource_id;
+
+UPDATE access_logs l
+SET organization_id = p.organization_id
+FROM secrets s
+JOIN environments e ON e.id = s.environment_id
+JOIN projects p ON p.id = e.project_id
+WHERE l.resource_type = 'secret' AND s.id = l.resource_id;
+
+UPDATE access_logs l
+SET organization_id = p.organization_id
+FROM change_requests cr
+JOIN environments e ON e.id = cr.environment_id
+JOIN projects p ON p.id = e.project_id
+WHERE l.resource_type = 'change_request' AND cr.id = l.resource_id;
+
+CREATE INDEX idx_access_logs_organization ON access_logs(organization_id, created_at DESC, id DESC);
+
+-- Keyset pagination of history across an organization; replaces the
+-- created_at index with one that breaks ties by id
+DROP INDEX idx_secret_history_created_at;
+CREATE INDEX idx_secret_history_created_at ON secret_history(created_at DESC, id DESC);
@@ -0,0 +1,53 @@
+-- ============================================================================
+-- AUDIT CHAIN: TAMPER-EVIDENT ACCESS LOGS AND SECRET HISTORY
+-- ============================================================================
+-- Purpose: Seal audit rows into a hash chain per table. The API seals rows
+-- shortly after they are written: each sealed row gets the next chain_seq,
+-- the row_hash of the row before it (prev_hash) and a SHA-256 row_hash over
+-- its contents and prev_hash. Editing, inserting or deleting a sealed row
+-- breaks the chain. With a signing key configured, each sealing pass also
+-- signs its last row_hash into audit_checkpoints, so a rewritten chain can
+-- not be passed off as the original.
+-- ============================================================================
+
+-- Chained rows must never change or disappear through cascades: history
+-- outlives its secret and environment, and access logs keep the
+-- organization they were recorded for
+ALTER TABLE secret_history
+    DROP CONSTRAINT IF EXISTS secret_history_secret_id_fkey,
+    DROP CONSTRAINT IF EXISTS secret_history_environment_id_fkey;
+
+ALTER TABLE access_logs
+    DROP CONSTRAINT IF EXISTS access_logs_organization_id_fkey;
+
+-- NULL until sealed
+ALTER TABLE access_logs
+    ADD COLUMN chain_seq BIGINT UNIQUE,
+    ADD COLUMN prev_hash BYTEA,
+    ADD COLUMN row_hash BYTEA;
+
+ALTER TABLE secret_history
+    ADD COLUMN chain_seq BIGINT UNIQUE,
+    ADD COLUMN prev_hash BYTEA,
+    ADD COLUMN row_hash BYTEA;
+
+-- The sealer picks up unsealed rows oldest first
+CREATE INDEX idx_access_logs_unsealed ON access_logs(created_at, id) WHERE chain_seq IS NULL;
+CREATE INDEX idx_secret_history_unsealed ON secret_history(created_at, id) WHERE chain_seq IS NULL;
+
+CREATE TABLE audit_checkpoints (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+
+    -- The sealed link: 'access_logs' or 'secret_history'
+    chain VARCHAR(50) NOT NULL,
+    chain_seq BIGINT NOT NULL,
+    row_hash BYTEA NOT NULL,
+
+    -- Ed25519 signature by the server key named by key_id
+    key_id VARCHAR(64) NOT NULL,
+    signature BYTEA NOT NULL,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    UNIQUE (chain, chain_seq)
+);
@@ -0,0 +1,55 @@
+-- ============================================================================
+-- ENVIRONMENT INHERITANCE
+-- ============================================================================
+-- Purpose: An environment may inherit the secrets of a parent environment in
+-- the same project. Reads return the parent's secrets merged with the
+-- environment's own, which override keys of the same name. Parents may have
+-- parents of their own, but never the environment itself.
+-- ============================================================================
+
+-- A parent cannot be deleted while environments still inherit from it
+ALTER TABLE environments
+    ADD COLUMN parent_id UUID REFERENCES environments(id),
+    ADD CONSTRAINT environments_parent_not_self CHECK (parent_id <> id);
+
+CREATE INDEX idx_environments_parent ON environments(parent_id) WHERE parent_id IS NOT NULL;
+
+-- Parents must belong to the same project and must not inherit, directly or
+-- through their own parents, from the environment. The API locks the
+-- project row before changing a parent so concurrent changes cannot race
+-- into a cycle.
+CREATE OR REPLACE FUNCTION check_environment_parent()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.parent_id IS NULL THEN
+        RETURN NEW;
+    END IF;
+
+    IF NOT EXISTS (
+        SELECT 1 FROM environments
+        WHERE id = NEW.parent_id AND project_id = NEW.project_id
+    ) THEN
+        RAISE EXCEPTION 'parent of environment % must be in the same project', NEW.id
+            USING ERRCODE = 'check_violation';
+    END IF;
+
+    IF EXISTS (
+        WITH RECURSIVE ancestors(id, parent_id) AS (
+            SELECT id, parent_id FROM environments WHERE id = NEW.parent_id
+            UNION
+            SELECT e.id, e.parent_id FROM environments e
+            JOIN ancestors a ON e.id = a.parent_id
+        )
+        SELECT 1 FROM ancestors WHERE id = NEW.id
+    ) THEN
+        RAISE EXCEPTION 'environment % cannot inherit from itself', NEW.id
+            USING ERRCODE = 'check_violation';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER environment_parent_trigger
+BEFORE INSERT OR UPDATE OF parent_id ON environments
+FOR EACH ROW EXECUTE FUNCTION check_environment_parent();
@@ -0,0 +1,51 @@
+-- ============================================================================
+-- EPHEMERAL ENVIRONMENTS
+-- ============================================================================
+-- Purpose: Environments created for a pull request preview or similar can
+-- expire. The API deletes expired environments in the background, together
+-- with their secrets, and records each deletion in access_logs.
+-- ============================================================================
+
+-- NULL for environments that never expire
+ALTER TABLE environments ADD COLUMN expires_at TIMESTAMPTZ;
+
+CREATE INDEX idx_environments_expires_at ON environments(expires_at) WHERE expires_at IS NOT NULL;
+
+-- Deleting an environment deletes its secrets outright, which logs them as
+-- 'deleted' in secret_history. Secrets that were never updated have no
+-- updated_by, so attribute the deletion to their last writer instead.
+CREATE OR REPLACE FUNCTION log_secret_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, changed_by)
+        VALUES (NEW.id, NEW.environment_id, 'created', NEW.key, NEW.encrypted_value, NEW.version, NEW.created_by);
+    ELSIF TG_OP = 'UPDATE' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, restored_version, changed_by)
+        VALUES (
+            NEW.id,
+            NEW.environment_id,
+            CASE
+                WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL
+                THEN 'deleted'::secret_action
+                WHEN NEW.version = OLD.version
+                     AND NEW.encrypted_value IS DISTINCT FROM OLD.encrypted_value
+                     AND NEW.deleted_at IS NOT DISTINCT FROM OLD.deleted_at
+                THEN 'rotated'::secret_action
+                WHEN NEW.version <> OLD.version AND NEW.restored_version IS NOT NULL
+                THEN 'rolled_back'::secret_action
+                ELSE 'updated'::secret_action
+            END,
+            NEW.key,
+            NEW.encrypted_value,
+            NEW.version,
+            CASE WHEN NEW.version <> OLD.version THEN NEW.restored_version END,
+            NEW.updated_by
+        );
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, changed_by)
+        VALUES (OLD.id, OLD.environment_id, 'deleted', OLD.key, NULL, OLD.version, COALESCE(OLD.updated_by, OLD.created_by));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
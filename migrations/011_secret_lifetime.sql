@@ -0,0 +1,127 @@
+-- ============================================================================
+-- SECRET EXPIRY AND ROTATION REMINDERS
+-- ============================================================================
+-- Purpose: Secrets such as third-party API keys can carry the date they
+-- expire and how often their value should be rotated. The API flags secrets
+-- that are expired or due for rotation once per deadline and records an
+-- event for each in the events outbox.
+-- ============================================================================
+
+ALTER TABLE secrets
+    ADD COLUMN expires_at TIMESTAMPTZ,
+    ADD COLUMN rotate_every INTERVAL,
+    -- When the current version was written; rotation is due rotate_every
+    -- after it
+    ADD COLUMN value_changed_at TIMESTAMPTZ,
+    ADD CONSTRAINT secrets_rotate_every_positive CHECK (rotate_every > INTERVAL '0');
+
+-- Backfill: the last entry that started a new version
+UPDATE secrets s
+SET value_changed_at = COALESCE(
+    (
+        SELECT MAX(h.created_at) FROM secret_history h
+        WHERE h.secret_id = s.id AND h.action IN ('created', 'updated', 'rolled_back')
+    ),
+    s.created_at
+);
+
+ALTER TABLE secrets
+    ALTER COLUMN value_changed_at SET DEFAULT NOW(),
+    ALTER COLUMN value_changed_at SET NOT NULL;
+
+CREATE INDEX idx_secrets_expires_at ON secrets(expires_at) WHERE expires_at IS NOT NULL AND deleted_at IS NULL;
+CREATE INDEX idx_secrets_rotate_every ON secrets(environment_id) WHERE rotate_every IS NOT NULL AND deleted_at IS NULL;
+
+CREATE OR REPLACE FUNCTION set_secret_value_changed_at()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.version <> OLD.version THEN
+        NEW.value_changed_at = NOW();
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER secret_value_changed_at_trigger
+BEFORE UPDATE ON secrets
+FOR EACH ROW EXECUTE FUNCTION set_secret_value_changed_at();
+
+-- Changing when a secret expires or must be rotated, or deactivating an
+-- expired one, leaves its value, version and deletion alone. Such updates
+-- are recorded in access_logs instead of secret_history, which holds values.
+CREATE OR REPLACE FUNCTION log_secret_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, changed_by)
+        VALUES (NEW.id, NEW.environment_id, 'created', NEW.key, NEW.encrypted_value, NEW.version, NEW.created_by);
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF NEW.encrypted_value IS NOT DISTINCT FROM OLD.encrypted_value
+           AND NEW.version = OLD.version
+           AND NEW.deleted_at IS NOT DISTINCT FROM OLD.deleted_at THEN
+            RETURN NEW;
+        END IF;
+
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, restored_version, changed_by)
+        VALUES (
+            NEW.id,
+            NEW.environment_id,
+            CASE
+                WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL
+                THEN 'deleted'::secret_action
+                WHEN NEW.version = OLD.version
+                     AND NEW.encrypted_value IS DISTINCT FROM OLD.encrypted_value
+                     AND NEW.deleted_at IS NOT DISTINCT FROM OLD.deleted_at
+                THEN 'rotated'::secret_action
+                WHEN NEW.version <> OLD.version AND NEW.restored_version IS NOT NULL
+                THEN 'rolled_back'::secret_action
+                ELSE 'updated'::secret_action
+            END,
+            NEW.key,
+            NEW.encrypted_value,
+            NEW.version,
+            CASE WHEN NEW.version <> OLD.version THEN NEW.restored_version END,
+            NEW.updated_by
+        );
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, changed_by)
+        VALUES (OLD.id, OLD.environment_id, 'deleted', OLD.key, NULL, OLD.version, COALESCE(OLD.updated_by, OLD.created_by));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- ============================================================================
+-- SECRET_REMINDERS TABLE
+-- ============================================================================
+-- Purpose: One row per deadline a secret was flagged for, so each is
+-- reported once. Extending the expiry or writing a new value moves the
+-- deadline, and the next one is reported again.
+-- ============================================================================
+
+CREATE TABLE secret_reminders (
+    secret_id UUID NOT NULL REFERENCES secrets(id) ON DELETE CASCADE,
+    event VARCHAR(100) NOT NULL CHECK (event IN ('secret.expired', 'secret.rotation_due')),
+    due_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (secret_id, event, due_at)
+);
+
+-- ============================================================================
+-- EVENTS TABLE
+-- ============================================================================
+-- Purpose: Outbox of notable changes, written in the transaction that makes
+-- them and delivered to notification integrations from there. Payloads
+-- never include secret values.
+-- ============================================================================
+
+CREATE TABLE events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+    type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_events_organization ON events(organization_id, created_at DESC);
@@ -0,0 +1,56 @@
+-- ============================================================================
+-- DYNAMIC DATABASE CREDENTIALS
+-- ============================================================================
+-- Purpose: Instead of a static password, an environment can hold an admin
+-- connection to a PostgreSQL server. Reading it creates a short-lived login
+-- role from templated statements and returns its credentials under a lease.
+-- The API drops the role when the lease expires or is revoked.
+-- ============================================================================
+
+CREATE TABLE dynamic_secrets (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    environment_id UUID NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+
+    -- Admin connection URL (AES-256-GCM encrypted with the project's DEK)
+    encrypted_connection TEXT NOT NULL,
+
+    -- SQL run on the target with {{name}}, {{password}} and {{expiration}}
+    -- replaced by the credentials of each lease
+    creation_statements TEXT NOT NULL,
+    revocation_statements TEXT NOT NULL,
+
+    default_ttl INTERVAL NOT NULL,
+    max_ttl INTERVAL NOT NULL,
+
+    created_by UUID REFERENCES users(id),
+    updated_by UUID REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT dynamic_secrets_key_unique UNIQUE (environment_id, key),
+    CONSTRAINT dynamic_secrets_ttl_valid CHECK (default_ttl > INTERVAL '0' AND max_ttl >= default_ttl)
+);
+
+CREATE TRIGGER update_dynamic_secrets_updated_at
+    BEFORE UPDATE ON dynamic_secrets
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- A lease is recorded before its role is created, so a crash in between
+-- leaves a lease the revoker cleans up rather than an untracked role
+CREATE TABLE dynamic_secret_leases (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    dynamic_secret_id UUID NOT NULL REFERENCES dynamic_secrets(id) ON DELETE CASCADE,
+    role_name VARCHAR(63) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+
+    -- Why the last attempt to drop the role failed; it is retried
+    revoke_error TEXT,
+
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_dynamic_secret_leases_expires_at ON dynamic_secret_leases(expires_at) WHERE revoked_at IS NULL;
+CREATE INDEX idx_dynamic_secret_leases_secret ON dynamic_secret_leases(dynamic_secret_id) WHERE revoked_at IS NULL;
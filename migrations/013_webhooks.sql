@@ -0,0 +1,74 @@
+-- ============================================================================
+-- WEBHOOKS
+-- ============================================================================
+-- Purpose: Organizations and projects can subscribe URLs to the events
+-- outbox, e.g. so deploy tooling restarts services when production config
+-- changes. The API fans each event out to the matching subscriptions and
+-- POSTs it to them, signed with HMAC-SHA256, retrying with exponential
+-- backoff. Every delivery is kept as a log.
+-- ============================================================================
+
+-- Set once an event has a delivery for every matching webhook
+ALTER TABLE events ADD COLUMN dispatched_at TIMESTAMPTZ;
+
+-- Events recorded before webhooks existed have no one to go to
+UPDATE events SET dispatched_at = created_at;
+
+CREATE INDEX idx_events_undispatched ON events(created_at) WHERE dispatched_at IS NULL;
+
+CREATE TABLE webhooks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+
+    -- Only events of this project are delivered; NULL for the whole
+    -- organization
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+
+    url TEXT NOT NULL,
+
+    -- Event types such as 'secret.updated', 'environment.*' or '*'
+    events TEXT[] NOT NULL CHECK (cardinality(events) > 0),
+
+    -- HMAC signing secret, wrapped with the master key like a project DEK
+    encrypted_secret TEXT NOT NULL,
+
+    description TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhooks_organization ON webhooks(organization_id) WHERE is_active;
+
+CREATE TRIGGER update_webhooks_updated_at
+    BEFORE UPDATE ON webhooks
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TYPE webhook_delivery_status AS ENUM ('pending', 'delivered', 'failed');
+
+-- One row per event sent to a webhook. Redelivering an event adds a row, so
+-- earlier attempts stay in the log.
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    redelivery_of UUID REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+
+    status webhook_delivery_status NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    -- Outcome of the last attempt
+    last_attempt_at TIMESTAMPTZ,
+    response_status INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMPTZ,
+
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at DESC);
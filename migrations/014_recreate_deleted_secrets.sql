@@ -0,0 +1,13 @@
+-- ============================================================================
+-- RECREATING DELETED SECRETS
+-- ============================================================================
+-- Purpose: Deleting a secret only soft-deletes its row, which keeps its
+-- history. Keys must be unique among the secrets that are not deleted, so a
+-- deleted key can be created again. The new secret has a history of its
+-- own and continues the deleted secrets' versions, since values are bound
+-- to their key and version.
+-- ============================================================================
+
+ALTER TABLE secrets DROP CONSTRAINT secrets_environment_id_key_key;
+
+CREATE UNIQUE INDEX idx_secrets_environment_key_unique ON secrets(environment_id, key) WHERE deleted_at IS NULL;
@@ -0,0 +1,46 @@
+-- ============================================================================
+-- SECRET HISTORY BY ORGANIZATION
+-- ============================================================================
+-- Purpose: The history search found an organization's entries through their
+-- environment and project, so the history of a deleted environment (or of
+-- an expired one the reaper removed) dropped out of it. Each entry now keeps
+-- the organization it was written in.
+-- ============================================================================
+
+-- Kept when the environment is deleted; the entries remain for the record.
+-- Derived from environment_id, so the audit chain does not cover it.
+ALTER TABLE secret_history ADD COLUMN organization_id UUID;
+
+-- Entries of environments deleted before this migration stay NULL
+UPDATE secret_history h
+SET organization_id = p.organization_id
+FROM environments e
+JOIN projects p ON p.id = e.project_id
+WHERE e.id = h.environment_id;
+
+CREATE INDEX idx_secret_history_organization ON secret_history(organization_id, created_at DESC, id DESC);
+
+-- Deleting an environment deletes its secrets after the environment row is
+-- gone, so their 'deleted' entries take the organization of the
+-- environment's earlier entries.
+CREATE OR REPLACE FUNCTION set_secret_history_organization()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.organization_id IS NULL THEN
+        NEW.organization_id := COALESCE(
+            (SELECT p.organization_id
+             FROM environments e
+             JOIN projects p ON p.id = e.project_id
+             WHERE e.id = NEW.environment_id),
+            (SELECT h.organization_id
+             FROM secret_history h
+             WHERE h.environment_id = NEW.environment_id AND h.organization_id IS NOT NULL
+             LIMIT 1)
+        );
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER set_secret_history_organization BEFORE INSERT ON secret_history
+    FOR EACH ROW EXECUTE FUNCTION set_secret_history_organization();
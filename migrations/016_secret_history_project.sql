@@ -0,0 +1,50 @@
+-- ============================================================================
+-- SECRET HISTORY BY PROJECT
+-- ============================================================================
+-- Purpose: Rotating a project's DEK, and re-sealing values, found historical
+-- values through their environment, so the history of a deleted environment
+-- stayed encrypted with the retired DEK and could no longer be read. Each
+-- entry now keeps the project it was written in, next to its organization.
+-- ============================================================================
+
+-- Kept when the environment is deleted, like organization_id
+ALTER TABLE secret_history ADD COLUMN project_id UUID;
+
+-- Entries of environments deleted before this migration stay NULL
+UPDATE secret_history h
+SET project_id = e.project_id
+FROM environments e
+WHERE e.id = h.environment_id;
+
+CREATE INDEX idx_secret_history_project ON secret_history(project_id, id) WHERE encrypted_value IS NOT NULL;
+
+-- Replaces set_secret_history_organization, setting the project as well
+DROP TRIGGER set_secret_history_organization ON secret_history;
+DROP FUNCTION set_secret_history_organization();
+
+-- Deleting an environment deletes its secrets after the environment row is
+-- gone, so their 'deleted' entries take the project and organization of
+-- the environment's earlier entries.
+CREATE OR REPLACE FUNCTION set_secret_history_owner()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.project_id IS NULL THEN
+        SELECT e.project_id, p.organization_id
+        INTO NEW.project_id, NEW.organization_id
+        FROM environments e
+        JOIN projects p ON p.id = e.project_id
+        WHERE e.id = NEW.environment_id;
+    END IF;
+    IF NEW.project_id IS NULL THEN
+        SELECT h.project_id, h.organization_id
+        INTO NEW.project_id, NEW.organization_id
+        FROM secret_history h
+        WHERE h.environment_id = NEW.environment_id AND h.project_id IS NOT NULL
+        LIMIT 1;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER set_secret_history_owner BEFORE INSERT ON secret_history
+    FOR EACH ROW EXECUTE FUNCTION set_secret_history_owner();
@@ -0,0 +1,11 @@
+-- ============================================================================
+-- SEALING SECRET HISTORY OWNERS
+-- ============================================================================
+-- Purpose: The history search selects entries by organization_id and key
+-- rotation by project_id, so changing either could hide an entry. Entries
+-- sealed from now on cover both in their chain hash; link_version records
+-- which columns a sealed entry's hash covers.
+-- ============================================================================
+
+-- NULL for entries sealed before, whose hash covers neither column
+ALTER TABLE secret_history ADD COLUMN link_version SMALLINT;
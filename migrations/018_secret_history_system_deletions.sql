@@ -0,0 +1,56 @@
+-- ============================================================================
+-- SYSTEM DELETIONS IN SECRET HISTORY
+-- ============================================================================
+-- Purpose: Deleting an environment deletes its secrets, and the 'deleted'
+-- entry is attributed to the secret's last writer. Both columns are
+-- nullable, and a secret with neither set (e.g. one loaded outside the API)
+-- violated changed_by NOT NULL, aborting the whole environment deletion,
+-- including the reaper's. Such deletions are now attributed to the nil user
+-- ID, which stands for the system.
+-- ============================================================================
+
+-- changed_by no longer always names a user, and the history outlives them
+ALTER TABLE secret_history
+    DROP CONSTRAINT IF EXISTS secret_history_changed_by_fkey;
+
+CREATE OR REPLACE FUNCTION log_secret_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, changed_by)
+        VALUES (NEW.id, NEW.environment_id, 'created', NEW.key, NEW.encrypted_value, NEW.version, NEW.created_by);
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF NEW.encrypted_value IS NOT DISTINCT FROM OLD.encrypted_value
+           AND NEW.version = OLD.version
+           AND NEW.deleted_at IS NOT DISTINCT FROM OLD.deleted_at THEN
+            RETURN NEW;
+        END IF;
+
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, restored_version, changed_by)
+        VALUES (
+            NEW.id,
+            NEW.environment_id,
+            CASE
+                WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL
+                THEN 'deleted'::secret_action
+                WHEN NEW.version = OLD.version
+                     AND NEW.encrypted_value IS DISTINCT FROM OLD.encrypted_value
+                     AND NEW.deleted_at IS NOT DISTINCT FROM OLD.deleted_at
+                THEN 'rotated'::secret_action
+                WHEN NEW.version <> OLD.version AND NEW.restored_version IS NOT NULL
+                THEN 'rolled_back'::secret_action
+                ELSE 'updated'::secret_action
+            END,
+            NEW.key,
+            NEW.encrypted_value,
+            NEW.version,
+            CASE WHEN NEW.version <> OLD.version THEN NEW.restored_version END,
+            NEW.updated_by
+        );
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO secret_history (secret_id, environment_id, action, key, encrypted_value, version, changed_by)
+        VALUES (OLD.id, OLD.environment_id, 'deleted', OLD.key, NULL, OLD.version, COALESCE(OLD.updated_by, OLD.created_by, '00000000-0000-0000-0000-000000000000'));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
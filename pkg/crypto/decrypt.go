@@ -1,53 +1,75 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
 // Decrypt decrypts ciphertext using AES-256-GCM
-// Expects format: [nonce (12 bytes)][ciphertext + auth tag]
+// Accepts FormatV1 envelopes and legacy [nonce (12 bytes)][ciphertext + auth tag] blobs
 func Decrypt(ciphertext []byte, key []byte) ([]byte, error) {
+	_, plaintext, err := decryptEnvelope(ciphertext, key, nil)
+	return plaintext, err
+}
+
+// DecryptWithAAD decrypts a ciphertext produced by EncryptWithAAD.
+// Ciphertexts that are not bound to associated data are rejected with
+// ErrUnboundCiphertext; callers migrating old data may fall back to Decrypt.
+func DecryptWithAAD(ciphertext, key, aad []byte) ([]byte, error) {
+	_, plaintext, err := decryptEnvelope(ciphertext, key, aad)
+	return plaintext, err
+}
+
+// decryptEnvelope parses and decrypts a blob, returning the envelope it was read from.
+// A non-nil aad requires a bound (FormatV2) envelope.
+func decryptEnvelope(ciphertext []byte, key []byte, aad []byte) (*EncryptedData, []byte, error) {
 	// Validate key size
 	if len(key) != AES256KeySize {
-		return nil, ErrInvalidKeySize
-	}
-
-	// Validate ciphertext size
-	if len(ciphertext) < MinEncryptedSize {
-		return nil, ErrInvalidCiphertext
+		return nil, nil, ErrInvalidKeySize
 	}
 
-	// Create AES cipher block
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	ed, parseErr := ParseEncryptedData(ciphertext)
+	if ed == nil {
+		return nil, nil, parseErr
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("%w: failed to create GCM: %v", ErrDecryptionFailed, err)
+	if aad != nil {
+		if parseErr != nil {
+			return nil, nil, parseErr
+		}
+		if !ed.Bound() {
+			return ed, nil, ErrUnboundCiphertext
+		}
+		plaintext, err := open(ed, key, aad)
+		if err != nil {
+			// A legacy blob whose nonce looks like a bound header
+			legacy := &EncryptedData{Ciphertext: ciphertext, Version: FormatLegacy, Algorithm: AlgAES256GCM}
+			if _, lerr := open(legacy, key, nil); lerr == nil {
+				return legacy, nil, ErrUnboundCiphertext
+			}
+			return ed, nil, err
+		}
+		return ed, plaintext, nil
 	}
 
-	// Extract nonce (first 12 bytes)
-	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, ErrInvalidNonce
+	envelopeErr := parseErr
+	if parseErr == nil {
+		plaintext, err := open(ed, key, nil)
+		if err == nil || ed.Version == FormatLegacy {
+			return ed, plaintext, err
+		}
+		envelopeErr = err
 	}
 
-	nonce := ciphertext[:nonceSize]
-	encryptedData := ciphertext[nonceSize:]
-
-	// Decrypt and verify authentication tag
-	plaintext, err := gcm.Open(nil, nonce, encryptedData, nil)
+	// The header may be a legacy nonce that happens to start with the magic
+	legacy := &EncryptedData{Ciphertext: ciphertext, Version: FormatLegacy, Algorithm: AlgAES256GCM}
+	plaintext, err := open(legacy, key, nil)
 	if err != nil {
-		return nil, fmt.Errorf("%w: authentication failed or corrupted data: %v", ErrDecryptionFailed, err)
+		return nil, nil, envelopeErr
 	}
-
-	return plaintext, nil
+	return legacy, plaintext, nil
 }
 
 // DecryptString decrypts a base64-encoded ciphertext to string
@@ -70,11 +92,55 @@ func DecryptWithDEK(ciphertext []byte, dek *DataKey) ([]byte, error) {
 	if err := dek.Validate(); err != nil {
 		return nil, err
 	}
-	return Decrypt(ciphertext, dek.Key)
+
+	plaintext, err := Decrypt(ciphertext, dek.Key)
+	if err != nil {
+		if mismatch := keyVersionMismatch(ciphertext, dek.Version); mismatch != nil {
+			return nil, mismatch
+		}
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+// DecryptWithDEKAndAAD decrypts data bound to aad using a Data Encryption Key
+func DecryptWithDEKAndAAD(ciphertext []byte, dek *DataKey, aad []byte) ([]byte, error) {
+	if err := dek.Validate(); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := DecryptWithAAD(ciphertext, dek.Key, aad)
+	if err != nil {
+		if errors.Is(err, ErrDecryptionFailed) {
+			if mismatch := keyVersionMismatch(ciphertext, dek.Version); mismatch != nil {
+				return nil, mismatch
+			}
+		}
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+// DecryptStringWithDEK decrypts a base64-encoded ciphertext with a DEK
+func DecryptStringWithDEK(encodedCiphertext string, dek *DataKey) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encodedCiphertext)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %w", err)
+	}
+
+	plaintext, err := DecryptWithDEK(ciphertext, dek)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
 }
 
 // DecryptDEK decrypts an encrypted Data Encryption Key using the Master Key
 // This is used to retrieve DEKs from the database
+// Legacy wrapped DEKs carry no version and are returned as version 1
 func DecryptDEK(encryptedDEK string, masterKey *MasterKey) (*DataKey, error) {
 	if err := masterKey.Validate(); err != nil {
 		return nil, err
@@ -87,18 +153,41 @@ func DecryptDEK(encryptedDEK string, masterKey *MasterKey) (*DataKey, error) {
 	}
 
 	// Decrypt with master key
-	dekBytes, err := Decrypt(ciphertext, masterKey.Key)
+	ed, payload, err := decryptEnvelope(ciphertext, masterKey.Key, nil)
 	if err != nil {
+		if mismatch := keyVersionMismatch(ciphertext, masterKey.Version); mismatch != nil {
+			return nil, fmt.Errorf("failed to decrypt DEK: %w", mismatch)
+		}
 		return nil, fmt.Errorf("failed to decrypt DEK: %w", err)
 	}
 
-	// Validate decrypted key size
-	if len(dekBytes) != AES256KeySize {
+	if ed.Version == FormatLegacy {
+		// Validate decrypted key size
+		if len(payload) != AES256KeySize {
+			return nil, ErrInvalidKeySize
+		}
+		return &DataKey{
+			Key:     payload,
+			Version: 1,
+		}, nil
+	}
+
+	if len(payload) != 4+AES256KeySize {
 		return nil, ErrInvalidKeySize
 	}
 
 	return &DataKey{
-		Key:     dekBytes,
-		Version: 1,
+		Key:     payload[4:],
+		Version: int(binary.BigEndian.Uint32(payload[:4])),
 	}, nil
 }
+
+// keyVersionMismatch explains a failed decryption when the envelope was
+// stamped with a different key version than the key that was tried
+func keyVersionMismatch(ciphertext []byte, have int) error {
+	ed, err := ParseEncryptedData(ciphertext)
+	if err != nil || ed.KeyVersion == 0 || ed.KeyVersion == have {
+		return nil
+	}
+	return fmt.Errorf("%w: encrypted with key v%d, have v%d", ErrKeyVersionMismatch, ed.KeyVersion, have)
+}
@@ -1,46 +1,20 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
-	"io"
 )
 
 // Encrypt encrypts plaintext using AES-256-GCM
-// The nonce is prepended to the ciphertext for easy decryption
-// Format: [nonce (12 bytes)][ciphertext + auth tag]
+// The result is a FormatV1 envelope with an unknown (zero) key version
+// Format: [header (8 bytes)][nonce (12 bytes)][ciphertext + auth tag]
 func Encrypt(plaintext []byte, key []byte) ([]byte, error) {
-	// Validate key size
-	if len(key) != AES256KeySize {
-		return nil, ErrInvalidKeySize
-	}
-
-	// Create AES cipher block
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrEncryptionFailed, err)
-	}
-
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	ed, err := seal(plaintext, key, 0, nil)
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to create GCM: %v", ErrEncryptionFailed, err)
-	}
-
-	// Generate a random nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("%w: nonce generation failed: %v", ErrEncryptionFailed, err)
+		return nil, err
 	}
-
-	// Encrypt and authenticate
-	// Seal appends the ciphertext and tag to nonce
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-
-	return ciphertext, nil
+	return ed.Marshal(), nil
 }
 
 // EncryptString encrypts a string and returns base64-encoded ciphertext
@@ -55,15 +29,62 @@ func EncryptString(plaintext string, key []byte) (string, error) {
 }
 
 // EncryptWithDEK encrypts data using a Data Encryption Key
+// The envelope records the DEK version
 func EncryptWithDEK(plaintext []byte, dek *DataKey) ([]byte, error) {
 	if err := dek.Validate(); err != nil {
 		return nil, err
 	}
-	return Encrypt(plaintext, dek.Key)
+
+	ed, err := seal(plaintext, dek.Key, dek.Version, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ed.Marshal(), nil
+}
+
+// EncryptStringWithDEK encrypts a string with a DEK and returns base64-encoded ciphertext
+func EncryptStringWithDEK(plaintext string, dek *DataKey) (string, error) {
+	ciphertext, err := EncryptWithDEK([]byte(plaintext), dek)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// EncryptWithAAD encrypts plaintext and binds it to aad (e.g. the identity of
+// the record it is stored in). Decryption requires the same aad, so the
+// ciphertext cannot be moved to another record.
+func EncryptWithAAD(plaintext, key, aad []byte) ([]byte, error) {
+	if len(aad) == 0 {
+		return nil, fmt.Errorf("%w: empty associated data", ErrEncryptionFailed)
+	}
+
+	ed, err := seal(plaintext, key, 0, aad)
+	if err != nil {
+		return nil, err
+	}
+	return ed.Marshal(), nil
+}
+
+// EncryptWithDEKAndAAD encrypts data with a DEK and binds it to aad
+func EncryptWithDEKAndAAD(plaintext []byte, dek *DataKey, aad []byte) ([]byte, error) {
+	if err := dek.Validate(); err != nil {
+		return nil, err
+	}
+	if len(aad) == 0 {
+		return nil, fmt.Errorf("%w: empty associated data", ErrEncryptionFailed)
+	}
+
+	ed, err := seal(plaintext, dek.Key, dek.Version, aad)
+	if err != nil {
+		return nil, err
+	}
+	return ed.Marshal(), nil
 }
 
 // EncryptDEK encrypts a Data Encryption Key with the Master Key
 // This is used to store DEKs securely in the database
+// The envelope records the master key version and the payload carries the DEK version
 func EncryptDEK(dek *DataKey, masterKey *MasterKey) (string, error) {
 	if err := masterKey.Validate(); err != nil {
 		return "", err
@@ -72,12 +93,17 @@ func EncryptDEK(dek *DataKey, masterKey *MasterKey) (string, error) {
 		return "", err
 	}
 
+	// Payload: [DEK version (4 bytes)][DEK]
+	payload := make([]byte, 4, 4+AES256KeySize)
+	binary.BigEndian.PutUint32(payload, uint32(dek.Version))
+	payload = append(payload, dek.Key...)
+
 	// Encrypt the DEK with the master key
-	encrypted, err := Encrypt(dek.Key, masterKey.Key)
+	ed, err := seal(payload, masterKey.Key, masterKey.Version, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to encrypt DEK: %w", err)
 	}
 
 	// Return base64-encoded encrypted DEK
-	return base64.StdEncoding.EncodeToString(encrypted), nil
+	return ed.String(), nil
 }
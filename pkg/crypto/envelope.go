@@ -0,0 +1,169 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Envelope layout (FormatV1 and FormatV2):
+//
+//	[magic "EH" (2)][format version (1)][algorithm (1)][key version (4, big endian)]
+//	[nonce (12)][ciphertext + auth tag]
+//
+// FormatV2 blobs additionally authenticate caller-supplied associated data,
+// which is not stored and must be presented again to decrypt.
+//
+// Blobs written before the envelope existed have no header. A legacy blob
+// whose random nonce happens to look like a header fails authentication as an
+// envelope and is retried as legacy, so old rows always decrypt.
+
+const (
+	envelopeMagic0 = 'E'
+	envelopeMagic1 = 'H'
+
+	// EnvelopeHeaderSize is the size of the envelope header
+	EnvelopeHeaderSize = 8
+)
+
+// header returns the serialised envelope header for ed
+func (ed *EncryptedData) header() []byte {
+	h := make([]byte, EnvelopeHeaderSize)
+	h[0] = envelopeMagic0
+	h[1] = envelopeMagic1
+	h[2] = byte(ed.Version)
+	h[3] = byte(ed.Algorithm)
+	binary.BigEndian.PutUint32(h[4:], uint32(ed.KeyVersion))
+	return h
+}
+
+// Marshal serialises the envelope. Legacy envelopes are written without a header.
+func (ed *EncryptedData) Marshal() []byte {
+	if ed.Version == FormatLegacy {
+		return append([]byte(nil), ed.Ciphertext...)
+	}
+	return append(ed.header(), ed.Ciphertext...)
+}
+
+// String returns the base64 encoding of the marshalled envelope
+func (ed *EncryptedData) String() string {
+	return base64.StdEncoding.EncodeToString(ed.Marshal())
+}
+
+// ParseEncryptedData parses a ciphertext blob. Blobs without a recognised
+// header are returned as FormatLegacy.
+func ParseEncryptedData(blob []byte) (*EncryptedData, error) {
+	if len(blob) < MinEncryptedSize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	legacy := &EncryptedData{Ciphertext: blob, Version: FormatLegacy, Algorithm: AlgAES256GCM}
+	if len(blob) < EnvelopeHeaderSize+MinEncryptedSize || blob[0] != envelopeMagic0 || blob[1] != envelopeMagic1 {
+		return legacy, nil
+	}
+
+	ed := &EncryptedData{
+		Ciphertext: blob[EnvelopeHeaderSize:],
+		Version:    int(blob[2]),
+		Algorithm:  Algorithm(blob[3]),
+		KeyVersion: int(binary.BigEndian.Uint32(blob[4:EnvelopeHeaderSize])),
+	}
+	if ed.Version != FormatV1 && ed.Version != FormatV2 {
+		return legacy, fmt.Errorf("%w: version %d", ErrUnsupportedFormat, ed.Version)
+	}
+	if ed.Algorithm != AlgAES256GCM {
+		return legacy, fmt.Errorf("%w: algorithm %d", ErrUnsupportedFormat, ed.Algorithm)
+	}
+
+	return ed, nil
+}
+
+// Bound reports whether the envelope is bound to associated data
+func (ed *EncryptedData) Bound() bool {
+	return ed.Version == FormatV2
+}
+
+// ParseEncryptedString parses a base64-encoded ciphertext blob
+func ParseEncryptedString(encoded string) (*EncryptedData, error) {
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	return ParseEncryptedData(blob)
+}
+
+// seal encrypts plaintext into an envelope stamped with keyVersion.
+// A non-nil aad produces a FormatV2 envelope bound to it.
+func seal(plaintext, key []byte, keyVersion int, aad []byte) (*EncryptedData, error) {
+	if len(key) != AES256KeySize {
+		return nil, ErrInvalidKeySize
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEncryptionFailed, err)
+	}
+
+	ed := &EncryptedData{Version: FormatV1, Algorithm: AlgAES256GCM, KeyVersion: keyVersion}
+	if aad != nil {
+		ed.Version = FormatV2
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("%w: nonce generation failed: %v", ErrEncryptionFailed, err)
+	}
+
+	// The header is authenticated so the key version cannot be altered
+	ed.Ciphertext = gcm.Seal(nonce, nonce, plaintext, append(ed.header(), aad...))
+
+	return ed, nil
+}
+
+// open decrypts an envelope, dispatching on its format version.
+// aad is only used by FormatV2 envelopes.
+func open(ed *EncryptedData, key []byte, aad []byte) ([]byte, error) {
+	if len(key) != AES256KeySize {
+		return nil, ErrInvalidKeySize
+	}
+
+	switch ed.Version {
+	case FormatLegacy:
+		aad = nil
+	case FormatV1:
+		aad = ed.header()
+	case FormatV2:
+		aad = append(ed.header(), aad...)
+	default:
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedFormat, ed.Version)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(ed.Ciphertext) < nonceSize {
+		return nil, ErrInvalidNonce
+	}
+
+	plaintext, err := gcm.Open(nil, ed.Ciphertext[:nonceSize], ed.Ciphertext[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: authentication failed or corrupted data: %v", ErrDecryptionFailed, err)
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
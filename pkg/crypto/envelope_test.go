@@ -0,0 +1,211 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// legacyEncrypt produces a headerless blob the way Encrypt did before envelopes
+func legacyEncrypt(t *testing.T, plaintext, key, nonce []byte) []byte {
+	t.Helper()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatalf("newGCM: %v", err)
+	}
+	if nonce == nil {
+		nonce = make([]byte, gcm.NonceSize())
+		_, _ = rand.Read(nonce)
+	}
+	return gcm.Seal(append([]byte(nil), nonce...), nonce, plaintext, nil)
+}
+
+func TestEnvelopeHeader(t *testing.T) {
+	dek, _ := GenerateDataKey()
+	dek.Version = 7
+
+	ciphertext, err := EncryptWithDEK([]byte("secret"), dek)
+	if err != nil {
+		t.Fatalf("EncryptWithDEK failed: %v", err)
+	}
+
+	ed, err := ParseEncryptedData(ciphertext)
+	if err != nil {
+		t.Fatalf("ParseEncryptedData failed: %v", err)
+	}
+	if ed.Version != FormatV1 || ed.Algorithm != AlgAES256GCM || ed.KeyVersion != 7 {
+		t.Errorf("Unexpected header: version=%d alg=%d key=%d", ed.Version, ed.Algorithm, ed.KeyVersion)
+	}
+	if !bytes.Equal(ed.Marshal(), ciphertext) {
+		t.Errorf("Marshal does not round-trip")
+	}
+
+	plaintext, err := DecryptWithDEK(ciphertext, dek)
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("DecryptWithDEK = %q, %v", plaintext, err)
+	}
+}
+
+func TestEnvelopeHeaderIsAuthenticated(t *testing.T) {
+	dek, _ := GenerateDataKey()
+	ciphertext, _ := EncryptWithDEK([]byte("secret"), dek)
+
+	// Rewriting the key version must break authentication
+	ciphertext[EnvelopeHeaderSize-1] ^= 0x02
+	if _, err := Decrypt(ciphertext, dek.Key); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed, got %v", err)
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	dk, _ := GenerateDataKey()
+
+	tests := []struct {
+		name  string
+		nonce []byte
+	}{
+		{"Random nonce", nil},
+		// A legacy nonce that looks exactly like a FormatV1 header
+		{"Nonce resembling header", []byte{'E', 'H', FormatV1, byte(AlgAES256GCM), 0, 0, 0, 1, 9, 9, 9, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := legacyEncrypt(t, []byte("DATABASE_URL=postgres://..."), dk.Key, tt.nonce)
+
+			plaintext, err := Decrypt(blob, dk.Key)
+			if err != nil {
+				t.Fatalf("Decrypt failed: %v", err)
+			}
+			if string(plaintext) != "DATABASE_URL=postgres://..." {
+				t.Errorf("Unexpected plaintext %q", plaintext)
+			}
+
+			decoded, err := DecryptString(base64.StdEncoding.EncodeToString(blob), dk.Key)
+			if err != nil || decoded != string(plaintext) {
+				t.Errorf("DecryptString = %q, %v", decoded, err)
+			}
+		})
+	}
+}
+
+func TestDecryptLegacyDEK(t *testing.T) {
+	mk, _ := GenerateMasterKey()
+	dek, _ := GenerateDataKey()
+	wrapped := base64.StdEncoding.EncodeToString(legacyEncrypt(t, dek.Key, mk.Key, nil))
+
+	got, err := DecryptDEK(wrapped, mk)
+	if err != nil {
+		t.Fatalf("DecryptDEK failed: %v", err)
+	}
+	if !bytes.Equal(got.Key, dek.Key) || got.Version != 1 {
+		t.Errorf("Expected legacy DEK at version 1, got version %d", got.Version)
+	}
+}
+
+func TestDEKVersionsRoundTrip(t *testing.T) {
+	mk, _ := GenerateMasterKey()
+	mk.Version = 3
+	dek, _ := GenerateDataKey()
+	dek.Version = 5
+
+	wrapped, err := EncryptDEK(dek, mk)
+	if err != nil {
+		t.Fatalf("EncryptDEK failed: %v", err)
+	}
+
+	ed, err := ParseEncryptedString(wrapped)
+	if err != nil {
+		t.Fatalf("ParseEncryptedString failed: %v", err)
+	}
+	if ed.KeyVersion != 3 {
+		t.Errorf("Expected master key version 3 in header, got %d", ed.KeyVersion)
+	}
+
+	got, err := DecryptDEK(wrapped, mk)
+	if err != nil {
+		t.Fatalf("DecryptDEK failed: %v", err)
+	}
+	if got.Version != 5 || !bytes.Equal(got.Key, dek.Key) {
+		t.Errorf("Expected DEK version 5, got %d", got.Version)
+	}
+
+	// A different master key version names the mismatch
+	other, _ := GenerateMasterKey()
+	other.Version = 4
+	if _, err := DecryptDEK(wrapped, other); !errors.Is(err, ErrKeyVersionMismatch) {
+		t.Errorf("Expected ErrKeyVersionMismatch, got %v", err)
+	}
+}
+
+func TestUnsupportedEnvelope(t *testing.T) {
+	dk, _ := GenerateDataKey()
+	ciphertext, _ := Encrypt([]byte("secret"), dk.Key)
+
+	tests := []struct {
+		name   string
+		offset int
+		value  byte
+	}{
+		{"Unknown format version", 2, 9},
+		{"Unknown algorithm", 3, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob := append([]byte(nil), ciphertext...)
+			blob[tt.offset] = tt.value
+
+			if _, err := ParseEncryptedData(blob); !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("ParseEncryptedData: expected ErrUnsupportedFormat, got %v", err)
+			}
+			if _, err := Decrypt(blob, dk.Key); !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("Decrypt: expected ErrUnsupportedFormat, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAssociatedData(t *testing.T) {
+	dek, _ := GenerateDataKey()
+	aad := []byte("project/env/DATABASE_URL")
+
+	ciphertext, err := EncryptWithDEKAndAAD([]byte("secret"), dek, aad)
+	if err != nil {
+		t.Fatalf("EncryptWithDEKAndAAD failed: %v", err)
+	}
+
+	ed, _ := ParseEncryptedData(ciphertext)
+	if ed.Version != FormatV2 || !ed.Bound() {
+		t.Errorf("Expected a bound FormatV2 envelope, got version %d", ed.Version)
+	}
+
+	plaintext, err := DecryptWithDEKAndAAD(ciphertext, dek, aad)
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("DecryptWithDEKAndAAD = %q, %v", plaintext, err)
+	}
+
+	if _, err := DecryptWithDEKAndAAD(ciphertext, dek, []byte("project/env/OTHER")); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Wrong aad: expected ErrDecryptionFailed, got %v", err)
+	}
+	if _, err := DecryptWithDEK(ciphertext, dek); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Missing aad: expected ErrDecryptionFailed, got %v", err)
+	}
+
+	// Unbound ciphertexts are rejected by the strict decrypt
+	unbound, _ := EncryptWithDEK([]byte("secret"), dek)
+	if _, err := DecryptWithDEKAndAAD(unbound, dek, aad); !errors.Is(err, ErrUnboundCiphertext) {
+		t.Errorf("Unbound: expected ErrUnboundCiphertext, got %v", err)
+	}
+	legacy := legacyEncrypt(t, []byte("secret"), dek.Key, nil)
+	if _, err := DecryptWithAAD(legacy, dek.Key, aad); !errors.Is(err, ErrUnboundCiphertext) {
+		t.Errorf("Legacy: expected ErrUnboundCiphertext, got %v", err)
+	}
+
+	if _, err := EncryptWithAAD([]byte("secret"), dek.Key, nil); !errors.Is(err, ErrEncryptionFailed) {
+		t.Errorf("Empty aad: expected ErrEncryptionFailed, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownKeyVersion is returned when no key in the ring has the version a
+// ciphertext was sealed with
+var ErrUnknownKeyVersion = errors.New("crypto: unknown master key version")
+
+// KeyRing holds every master key version that may still wrap a DEK.
+// New DEKs are wrapped with the active key; any known version can unwrap.
+// During a rotation the previous keys stay in the ring until every DEK has
+// been re-wrapped with the active one.
+type KeyRing struct {
+	active *MasterKey
+	keys   map[int]*MasterKey
+}
+
+// NewKeyRing creates a key ring from the active key and any previous keys
+func NewKeyRing(active *MasterKey, previous ...*MasterKey) (*KeyRing, error) {
+	if active == nil {
+		return nil, ErrInvalidMasterKey
+	}
+
+	r := &KeyRing{active: active, keys: make(map[int]*MasterKey, 1+len(previous))}
+	for _, mk := range append([]*MasterKey{active}, previous...) {
+		if err := mk.Validate(); err != nil {
+			return nil, fmt.Errorf("master key v%d: %w", mk.Version, err)
+		}
+		if _, ok := r.keys[mk.Version]; ok {
+			return nil, fmt.Errorf("%w: duplicate master key version %d", ErrInvalidMasterKey, mk.Version)
+		}
+		r.keys[mk.Version] = mk
+	}
+
+	return r, nil
+}
+
+// Active returns the key used to wrap new DEKs
+func (r *KeyRing) Active() *MasterKey { return r.active }
+
+// Key returns the key with the given version
+func (r *KeyRing) Key(version int) (*MasterKey, bool) {
+	mk, ok := r.keys[version]
+	return mk, ok
+}
+
+// Versions returns the versions in the ring, newest first
+func (r *KeyRing) Versions() []int {
+	versions := make([]int, 0, len(r.keys))
+	for v := range r.keys {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	return versions
+}
+
+// EncryptDEK wraps a DEK with the active key
+func (r *KeyRing) EncryptDEK(dek *DataKey) (string, error) {
+	return EncryptDEK(dek, r.active)
+}
+
+// DecryptDEK unwraps a DEK with whichever key sealed it and returns the
+// version of that key. Envelopes name their key version; legacy blobs,
+// which do not, are tried against every key, newest first.
+func (r *KeyRing) DecryptDEK(encryptedDEK string) (*DataKey, int, error) {
+	version, err := WrappedKeyVersion(encryptedDEK)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if version != 0 {
+		mk, ok := r.keys[version]
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: DEK is wrapped with v%d, have %s", ErrUnknownKeyVersion, version, r)
+		}
+		dek, err := DecryptDEK(encryptedDEK, mk)
+		return dek, version, err
+	}
+
+	for _, v := range r.Versions() {
+		if dek, err := DecryptDEK(encryptedDEK, r.keys[v]); err == nil {
+			return dek, v, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: no key in %s unwraps the DEK", ErrDecryptionFailed, r)
+}
+
+// NeedsRewrap reports whether a wrapped DEK is sealed with a key other than
+// the active one. Legacy blobs, which record no version, always need it.
+func (r *KeyRing) NeedsRewrap(encryptedDEK string) bool {
+	version, err := WrappedKeyVersion(encryptedDEK)
+	return err != nil || version != r.active.Version
+}
+
+// String lists the versions in the ring, e.g. "keys v3 (active), v2"
+func (r *KeyRing) String() string {
+	parts := make([]string, 0, len(r.keys))
+	for _, v := range r.Versions() {
+		part := "v" + strconv.Itoa(v)
+		if v == r.active.Version {
+			part += " (active)"
+		}
+		parts = append(parts, part)
+	}
+	return "keys " + strings.Join(parts, ", ")
+}
+
+// WrappedKeyVersion returns the master key version recorded in a wrapped
+// DEK, or 0 for legacy blobs that predate the envelope
+func WrappedKeyVersion(encryptedDEK string) (int, error) {
+	ed, err := ParseEncryptedString(encryptedDEK)
+	if errors.Is(err, ErrUnsupportedFormat) {
+		// A legacy nonce that starts with the envelope magic
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return ed.KeyVersion, nil
+}
+
+// ParseMasterKeyList parses a list of versioned base64 master keys in the
+// form "1:<base64>,2:<base64>". Entries may also be separated by newlines.
+func ParseMasterKeyList(s string) ([]*MasterKey, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' '
+	})
+
+	keys := make([]*MasterKey, 0, len(fields))
+	for _, field := range fields {
+		version, encoded, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: expected <version>:<base64 key>", ErrInvalidMasterKey)
+		}
+		v, err := strconv.Atoi(version)
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("%w: invalid version %q", ErrInvalidMasterKey, version)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key v%d: invalid base64 encoding: %w", v, err)
+		}
+		if len(decoded) != AES256KeySize {
+			return nil, fmt.Errorf("master key v%d: %w", v, ErrInvalidKeySize)
+		}
+		keys = append(keys, &MasterKey{Key: decoded, Version: v})
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,121 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func newVersionedKey(t *testing.T, version int) *MasterKey {
+	t.Helper()
+
+	mk, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("GenerateMasterKey failed: %v", err)
+	}
+	mk.Version = version
+	return mk
+}
+
+func TestKeyRing(t *testing.T) {
+	v1, v2 := newVersionedKey(t, 1), newVersionedKey(t, 2)
+	dek, _ := GenerateDataKey()
+
+	wrappedV1, _ := EncryptDEK(dek, v1)
+	legacy := base64.StdEncoding.EncodeToString(legacyEncrypt(t, dek.Key, v1.Key, nil))
+	// A legacy nonce that starts with the magic and an unknown format
+	badHeader := base64.StdEncoding.EncodeToString(legacyEncrypt(t, dek.Key, v1.Key, []byte{'E', 'H', 9, byte(AlgAES256GCM), 0, 0, 0, 1, 9, 9, 9, 9}))
+
+	ring, err := NewKeyRing(v2, v1)
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	if ring.Active() != v2 {
+		t.Errorf("Expected v2 to be active")
+	}
+	if got := ring.String(); got != "keys v2 (active), v1" {
+		t.Errorf("Unexpected String() %q", got)
+	}
+
+	// New DEKs are wrapped with the active key
+	wrappedV2, err := ring.EncryptDEK(dek)
+	if err != nil {
+		t.Fatalf("EncryptDEK failed: %v", err)
+	}
+	if v, _ := WrappedKeyVersion(wrappedV2); v != 2 {
+		t.Errorf("Expected new DEK wrapped with v2, got v%d", v)
+	}
+
+	tests := []struct {
+		name    string
+		wrapped string
+		version int
+		rewrap  bool
+	}{
+		{"Active key", wrappedV2, 2, false},
+		{"Previous key", wrappedV1, 1, true},
+		{"Legacy blob", legacy, 1, true},
+		{"Legacy blob resembling a header", badHeader, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, version, err := ring.DecryptDEK(tt.wrapped)
+			if err != nil {
+				t.Fatalf("DecryptDEK failed: %v", err)
+			}
+			if !bytes.Equal(got.Key, dek.Key) || version != tt.version {
+				t.Errorf("Expected DEK unwrapped with v%d, got v%d", tt.version, version)
+			}
+			if ring.NeedsRewrap(tt.wrapped) != tt.rewrap {
+				t.Errorf("NeedsRewrap = %v, want %v", !tt.rewrap, tt.rewrap)
+			}
+		})
+	}
+
+	// Once v1 is dropped, DEKs still wrapped with it name the missing version
+	onlyV2, _ := NewKeyRing(v2)
+	if _, _, err := onlyV2.DecryptDEK(wrappedV1); !errors.Is(err, ErrUnknownKeyVersion) {
+		t.Errorf("Expected ErrUnknownKeyVersion, got %v", err)
+	}
+	if _, _, err := onlyV2.DecryptDEK(legacy); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for legacy blob, got %v", err)
+	}
+}
+
+func TestNewKeyRingValidation(t *testing.T) {
+	v1 := newVersionedKey(t, 1)
+
+	if _, err := NewKeyRing(v1, newVersionedKey(t, 1)); !errors.Is(err, ErrInvalidMasterKey) {
+		t.Errorf("Duplicate version: expected ErrInvalidMasterKey, got %v", err)
+	}
+	if _, err := NewKeyRing(v1, &MasterKey{Key: []byte("short"), Version: 2}); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("Short key: expected ErrInvalidKeySize, got %v", err)
+	}
+	if _, err := NewKeyRing(nil); !errors.Is(err, ErrInvalidMasterKey) {
+		t.Errorf("Nil key: expected ErrInvalidMasterKey, got %v", err)
+	}
+}
+
+func TestParseMasterKeyList(t *testing.T) {
+	v1, v2 := newVersionedKey(t, 1), newVersionedKey(t, 2)
+
+	keys, err := ParseMasterKeyList(fmt.Sprintf("1:%s,\n2:%s\n", v1.ToBase64(), v2.ToBase64()))
+	if err != nil {
+		t.Fatalf("ParseMasterKeyList failed: %v", err)
+	}
+	if len(keys) != 2 || keys[1].Version != 2 || !bytes.Equal(keys[1].Key, v2.Key) {
+		t.Errorf("Unexpected keys %+v", keys)
+	}
+
+	if keys, err := ParseMasterKeyList(""); err != nil || len(keys) != 0 {
+		t.Errorf("Empty list = %v, %v", keys, err)
+	}
+
+	for _, bad := range []string{v1.ToBase64(), "0:" + v1.ToBase64(), "x:" + v1.ToBase64(), "1:not-base64", "1:c2hvcnQ="} {
+		if _, err := ParseMasterKeyList(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrInsecureKeyFile is returned when a key file is readable by group or others
+var ErrInsecureKeyFile = errors.New("crypto: key file permissions are too open")
+
+// KeyProvider supplies the master key (KEK) at startup
+type KeyProvider interface {
+	MasterKey(ctx context.Context) (*MasterKey, error)
+}
+
+// EnvKeyProvider reads a base64 master key from an environment variable
+type EnvKeyProvider struct {
+	Var string
+}
+
+// NewEnvKeyProvider creates a provider reading the named environment variable
+func NewEnvKeyProvider(name string) *EnvKeyProvider {
+	return &EnvKeyProvider{Var: name}
+}
+
+// MasterKey loads the master key from the environment
+func (p *EnvKeyProvider) MasterKey(ctx context.Context) (*MasterKey, error) {
+	value := os.Getenv(p.Var)
+	if value == "" {
+		return nil, fmt.Errorf("%s is not set", p.Var)
+	}
+	return MasterKeyFromBase64(value)
+}
+
+// FileKeyProvider reads a base64 master key from a file that must not be
+// accessible to group or others (e.g. a mounted Docker or Kubernetes secret)
+type FileKeyProvider struct {
+	Path string
+}
+
+// NewFileKeyProvider creates a provider reading the key file at path
+func NewFileKeyProvider(path string) *FileKeyProvider {
+	return &FileKeyProvider{Path: path}
+}
+
+// MasterKey loads the master key from the file
+func (p *FileKeyProvider) MasterKey(ctx context.Context) (*MasterKey, error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat key file: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return nil, fmt.Errorf("%w: %s has mode %#o, expected 0600 or stricter", ErrInsecureKeyFile, p.Path, perm)
+	}
+
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	return MasterKeyFromBase64(strings.TrimSpace(string(data)))
+}
+
+// KDFParams are the Argon2id cost parameters used to derive a master key
+// from a passphrase. Changing them changes the derived key.
+type KDFParams struct {
+	Time    uint32 // number of passes
+	Memory  uint32 // memory in KiB
+	Threads uint8
+}
+
+// DefaultKDFParams follow the RFC 9106 second recommended option
+var DefaultKDFParams = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// MinSaltSize is the minimum salt length accepted for key derivation
+const MinSaltSize = 16
+
+// DeriveMasterKey derives a master key from a passphrase with Argon2id
+func DeriveMasterKey(passphrase, salt []byte, params KDFParams) (*MasterKey, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%w: empty passphrase", ErrKeyDerivationFailed)
+	}
+	if len(salt) < MinSaltSize {
+		return nil, fmt.Errorf("%w: salt must be at least %d bytes", ErrKeyDerivationFailed, MinSaltSize)
+	}
+	if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+		return nil, fmt.Errorf("%w: invalid argon2id parameters", ErrKeyDerivationFailed)
+	}
+
+	key := argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, AES256KeySize)
+
+	return &MasterKey{
+		Key:     key,
+		Version: 1,
+	}, nil
+}
+
+// PassphraseKeyProvider derives the master key from a passphrase and salt
+type PassphraseKeyProvider struct {
+	Passphrase []byte
+	Salt       []byte
+	Params     KDFParams
+}
+
+// NewPassphraseKeyProvider creates a provider using DefaultKDFParams
+func NewPassphraseKeyProvider(passphrase, salt []byte) *PassphraseKeyProvider {
+	return &PassphraseKeyProvider{Passphrase: passphrase, Salt: salt, Params: DefaultKDFParams}
+}
+
+// MasterKey derives the master key
+func (p *PassphraseKeyProvider) MasterKey(ctx context.Context) (*MasterKey, error) {
+	return DeriveMasterKey(p.Passphrase, p.Salt, p.Params)
+}
+
+// maxKMSResponseSize bounds the KMS response body we are willing to read
+const maxKMSResponseSize = 64 << 10
+
+// KMSKeyProvider unwraps the master key with a remote key management service.
+// The master key is stored encrypted by the KMS (WrappedKey) and never leaves
+// it in plaintext except in this process.
+//
+// The adapter speaks a small JSON protocol that a KMS or a thin sidecar in
+// front of one (AWS KMS, Cloud KMS, Vault Transit) can implement:
+//
+//	POST {Endpoint}/v1/decrypt
+//	Authorization: Bearer {Token}
+//	{"key_id": "...", "ciphertext": "<base64>"}
+//
+//	200 OK
+//	{"plaintext": "<base64 master key>"}
+type KMSKeyProvider struct {
+	Endpoint   string
+	KeyID      string
+	WrappedKey string
+	Token      string
+	Client     *http.Client
+}
+
+type kmsDecryptRequest struct {
+	KeyID      string `json:"key_id"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+type kmsDecryptResponse struct {
+	Plaintext string `json:"plaintext"`
+}
+
+// NewKMSKeyProvider creates a provider that unwraps wrappedKey with keyID
+func NewKMSKeyProvider(endpoint, keyID, wrappedKey, token string) *KMSKeyProvider {
+	return &KMSKeyProvider{
+		Endpoint:   strings.TrimRight(endpoint, "/"),
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+		Token:      token,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// MasterKey asks the KMS to unwrap the master key
+func (p *KMSKeyProvider) MasterKey(ctx context.Context) (*MasterKey, error) {
+	body, err := json.Marshal(kmsDecryptRequest{KeyID: p.KeyID, Ciphertext: p.WrappedKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode KMS request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint+"/v1/decrypt", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build KMS request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("KMS request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKMSResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KMS response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("KMS decrypt failed: unexpected status %d", resp.StatusCode)
+	}
+
+	var out kmsDecryptResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("invalid KMS response: %w", err)
+	}
+
+	mk, err := MasterKeyFromBase64(out.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("KMS returned an invalid master key: %w", err)
+	}
+	return mk, nil
+}
+
+// KeyProviderConfig selects and configures the master key provider
+type KeyProviderConfig struct {
+	// Kind is one of "env", "file", "passphrase" or "kms"
+	Kind string
+
+	EnvVar string
+
+	File string
+
+	Passphrase []byte
+	Salt       []byte
+
+	KMSEndpoint   string
+	KMSKeyID      string
+	KMSWrappedKey string
+	KMSToken      string
+
+	// Version is assigned to the provider's key (1 if zero)
+	Version int
+
+	// Previous keys can still unwrap DEKs during a rotation
+	Previous []*MasterKey
+}
+
+// LoadKeyProviderConfigFromEnv loads the provider configuration from
+// environment variables. MASTER_KEY_PROVIDER defaults to "env", which keeps
+// reading MASTER_ENCRYPTION_KEY.
+func LoadKeyProviderConfigFromEnv() (KeyProviderConfig, error) {
+	cfg := KeyProviderConfig{
+		Kind:          os.Getenv("MASTER_KEY_PROVIDER"),
+		EnvVar:        "MASTER_ENCRYPTION_KEY",
+		File:          os.Getenv("MASTER_KEY_FILE"),
+		KMSEndpoint:   os.Getenv("KMS_ENDPOINT"),
+		KMSKeyID:      os.Getenv("KMS_KEY_ID"),
+		KMSWrappedKey: os.Getenv("MASTER_KEY_WRAPPED"),
+	}
+	if cfg.Kind == "" {
+		cfg.Kind = "env"
+	}
+
+	if version := os.Getenv("MASTER_KEY_VERSION"); version != "" {
+		v, err := strconv.Atoi(version)
+		if err != nil || v < 1 {
+			return cfg, fmt.Errorf("MASTER_KEY_VERSION must be a positive integer")
+		}
+		cfg.Version = v
+	}
+	previous, err := readSecretEnv("MASTER_KEY_PREVIOUS")
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Previous, err = ParseMasterKeyList(previous); err != nil {
+		return cfg, fmt.Errorf("MASTER_KEY_PREVIOUS: %w", err)
+	}
+
+	switch cfg.Kind {
+	case "env":
+	case "file":
+		if cfg.File == "" {
+			return cfg, fmt.Errorf("MASTER_KEY_FILE is required for the file provider")
+		}
+	case "passphrase":
+		passphrase, err := readSecretEnv("MASTER_KEY_PASSPHRASE")
+		if err != nil {
+			return cfg, err
+		}
+		if passphrase == "" {
+			return cfg, fmt.Errorf("MASTER_KEY_PASSPHRASE or MASTER_KEY_PASSPHRASE_FILE is required for the passphrase provider")
+		}
+		salt, err := base64.StdEncoding.DecodeString(os.Getenv("MASTER_KEY_SALT"))
+		if err != nil || len(salt) == 0 {
+			return cfg, fmt.Errorf("MASTER_KEY_SALT must be set to a base64 salt for the passphrase provider")
+		}
+		cfg.Passphrase = []byte(passphrase)
+		cfg.Salt = salt
+	case "kms":
+		token, err := readSecretEnv("KMS_TOKEN")
+		if err != nil {
+			return cfg, err
+		}
+		cfg.KMSToken = token
+		if cfg.KMSEndpoint == "" || cfg.KMSKeyID == "" || cfg.KMSWrappedKey == "" {
+			return cfg, fmt.Errorf("KMS_ENDPOINT, KMS_KEY_ID and MASTER_KEY_WRAPPED are required for the kms provider")
+		}
+	default:
+		return cfg, fmt.Errorf("unknown MASTER_KEY_PROVIDER %q", cfg.Kind)
+	}
+
+	return cfg, nil
+}
+
+// NewKeyProvider creates the provider described by cfg
+func NewKeyProvider(cfg KeyProviderConfig) (KeyProvider, error) {
+	switch cfg.Kind {
+	case "", "env":
+		name := cfg.EnvVar
+		if name == "" {
+			name = "MASTER_ENCRYPTION_KEY"
+		}
+		return NewEnvKeyProvider(name), nil
+	case "file":
+		return NewFileKeyProvider(cfg.File), nil
+	case "passphrase":
+		return NewPassphraseKeyProvider(cfg.Passphrase, cfg.Salt), nil
+	case "kms":
+		return NewKMSKeyProvider(cfg.KMSEndpoint, cfg.KMSKeyID, cfg.KMSWrappedKey, cfg.KMSToken), nil
+	}
+	return nil, fmt.Errorf("unknown key provider %q", cfg.Kind)
+}
+
+// LoadKeyRing loads the active master key from the configured provider and
+// adds the previous keys
+func LoadKeyRing(ctx context.Context, cfg KeyProviderConfig) (*KeyRing, error) {
+	provider, err := NewKeyProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := provider.MasterKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Version > 0 {
+		active.Version = cfg.Version
+	}
+
+	return NewKeyRing(active, cfg.Previous...)
+}
+
+// readSecretEnv reads name, or the file named by name_FILE so the value
+// can come from a mounted secret instead of the environment
+func readSecretEnv(name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,254 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testKDFParams keep Argon2id cheap in tests
+var testKDFParams = KDFParams{Time: 1, Memory: 1024, Threads: 1}
+
+func TestEnvKeyProvider(t *testing.T) {
+	mk, _ := GenerateMasterKey()
+	t.Setenv("TEST_MASTER_KEY", mk.ToBase64())
+
+	got, err := NewEnvKeyProvider("TEST_MASTER_KEY").MasterKey(t.Context())
+	if err != nil {
+		t.Fatalf("MasterKey failed: %v", err)
+	}
+	if !bytes.Equal(got.Key, mk.Key) {
+		t.Errorf("Loaded key doesn't match original")
+	}
+
+	if _, err := NewEnvKeyProvider("TEST_MASTER_KEY_UNSET").MasterKey(t.Context()); err == nil {
+		t.Errorf("Expected error for unset variable")
+	}
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	mk, _ := GenerateMasterKey()
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, []byte(mk.ToBase64()+"\n"), 0o600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+
+	got, err := NewFileKeyProvider(path).MasterKey(t.Context())
+	if err != nil {
+		t.Fatalf("MasterKey failed: %v", err)
+	}
+	if !bytes.Equal(got.Key, mk.Key) {
+		t.Errorf("Loaded key doesn't match original")
+	}
+
+	// World-readable key files are rejected
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if _, err := NewFileKeyProvider(path).MasterKey(t.Context()); !errors.Is(err, ErrInsecureKeyFile) {
+		t.Errorf("Expected ErrInsecureKeyFile, got %v", err)
+	}
+
+	if _, err := NewFileKeyProvider(filepath.Join(t.TempDir(), "missing")).MasterKey(t.Context()); err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+}
+
+func TestDeriveMasterKey(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+
+	mk1, err := DeriveMasterKey([]byte("correct horse"), salt, testKDFParams)
+	if err != nil {
+		t.Fatalf("DeriveMasterKey failed: %v", err)
+	}
+	if err := mk1.Validate(); err != nil {
+		t.Fatalf("Derived key invalid: %v", err)
+	}
+
+	mk2, _ := DeriveMasterKey([]byte("correct horse"), salt, testKDFParams)
+	if !bytes.Equal(mk1.Key, mk2.Key) {
+		t.Errorf("Derivation is not deterministic")
+	}
+
+	other, _ := DeriveMasterKey([]byte("battery staple"), salt, testKDFParams)
+	if bytes.Equal(mk1.Key, other.Key) {
+		t.Errorf("Different passphrases derived the same key")
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		salt       []byte
+		params     KDFParams
+	}{
+		{"Empty passphrase", "", salt, testKDFParams},
+		{"Short salt", "pass", []byte("short"), testKDFParams},
+		{"Zero params", "pass", salt, KDFParams{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DeriveMasterKey([]byte(tt.passphrase), tt.salt, tt.params)
+			if !errors.Is(err, ErrKeyDerivationFailed) {
+				t.Errorf("Expected ErrKeyDerivationFailed, got %v", err)
+			}
+		})
+	}
+}
+
+// fakeKMS is a local stand-in for a KMS that wraps keys with its own AES key
+type fakeKMS struct {
+	keyID string
+	token string
+	kek   []byte
+}
+
+func (k *fakeKMS) wrap(t *testing.T, mk *MasterKey) string {
+	t.Helper()
+	// The wrapped value is what the KMS would return from its encrypt call
+	ciphertext, err := EncryptString(mk.ToBase64(), k.kek)
+	if err != nil {
+		t.Fatalf("wrap: %v", err)
+	}
+	return ciphertext
+}
+
+func (k *fakeKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/decrypt" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+k.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req kmsDecryptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyID != k.keyID {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	plaintext, err := DecryptString(req.Ciphertext, k.kek)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(kmsDecryptResponse{Plaintext: plaintext})
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	kek, _ := GenerateDataKey()
+	kms := &fakeKMS{keyID: "envhub-master", token: "s3cret", kek: kek.Key}
+	srv := httptest.NewServer(kms)
+	defer srv.Close()
+
+	mk, _ := GenerateMasterKey()
+	wrapped := kms.wrap(t, mk)
+
+	got, err := NewKMSKeyProvider(srv.URL+"/", "envhub-master", wrapped, "s3cret").MasterKey(t.Context())
+	if err != nil {
+		t.Fatalf("MasterKey failed: %v", err)
+	}
+	if !bytes.Equal(got.Key, mk.Key) {
+		t.Errorf("Unwrapped key doesn't match original")
+	}
+
+	tests := []struct {
+		name    string
+		keyID   string
+		wrapped string
+		token   string
+	}{
+		{"Bad token", "envhub-master", wrapped, "wrong"},
+		{"Unknown key", "other", wrapped, "s3cret"},
+		{"Corrupt ciphertext", "envhub-master", base64.StdEncoding.EncodeToString([]byte("garbage-garbage-garbage-garbage")), "s3cret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKMSKeyProvider(srv.URL, tt.keyID, tt.wrapped, tt.token).MasterKey(t.Context()); err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestLoadKeyProviderConfigFromEnv(t *testing.T) {
+	t.Run("Defaults to env", func(t *testing.T) {
+		t.Setenv("MASTER_KEY_PROVIDER", "")
+		cfg, err := LoadKeyProviderConfigFromEnv()
+		if err != nil {
+			t.Fatalf("LoadKeyProviderConfigFromEnv failed: %v", err)
+		}
+		p, _ := NewKeyProvider(cfg)
+		if env, ok := p.(*EnvKeyProvider); !ok || env.Var != "MASTER_ENCRYPTION_KEY" {
+			t.Errorf("Expected env provider for MASTER_ENCRYPTION_KEY, got %#v", p)
+		}
+	})
+
+	t.Run("Passphrase from file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "passphrase")
+		if err := os.WriteFile(path, []byte("correct horse\n"), 0o600); err != nil {
+			t.Fatalf("write passphrase: %v", err)
+		}
+		t.Setenv("MASTER_KEY_PROVIDER", "passphrase")
+		t.Setenv("MASTER_KEY_PASSPHRASE", "")
+		t.Setenv("MASTER_KEY_PASSPHRASE_FILE", path)
+		t.Setenv("MASTER_KEY_SALT", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+
+		cfg, err := LoadKeyProviderConfigFromEnv()
+		if err != nil {
+			t.Fatalf("LoadKeyProviderConfigFromEnv failed: %v", err)
+		}
+		if string(cfg.Passphrase) != "correct horse" {
+			t.Errorf("Expected passphrase from file, got %q", cfg.Passphrase)
+		}
+	})
+
+	t.Run("Key ring", func(t *testing.T) {
+		active, _ := GenerateMasterKey()
+		previous, _ := GenerateMasterKey()
+		t.Setenv("MASTER_KEY_PROVIDER", "env")
+		t.Setenv("MASTER_ENCRYPTION_KEY", active.ToBase64())
+		t.Setenv("MASTER_KEY_VERSION", "2")
+		t.Setenv("MASTER_KEY_PREVIOUS", "1:"+previous.ToBase64())
+
+		cfg, err := LoadKeyProviderConfigFromEnv()
+		if err != nil {
+			t.Fatalf("LoadKeyProviderConfigFromEnv failed: %v", err)
+		}
+		ring, err := LoadKeyRing(t.Context(), cfg)
+		if err != nil {
+			t.Fatalf("LoadKeyRing failed: %v", err)
+		}
+		if ring.Active().Version != 2 || !bytes.Equal(ring.Active().Key, active.Key) {
+			t.Errorf("Expected the provider's key as active v2, got v%d", ring.Active().Version)
+		}
+		if mk, ok := ring.Key(1); !ok || !bytes.Equal(mk.Key, previous.Key) {
+			t.Errorf("Expected previous key as v1")
+		}
+	})
+
+	errorCases := map[string]map[string]string{
+		"Unknown provider":   {"MASTER_KEY_PROVIDER": "hsm"},
+		"File without path":  {"MASTER_KEY_PROVIDER": "file", "MASTER_KEY_FILE": ""},
+		"Passphrase no salt": {"MASTER_KEY_PROVIDER": "passphrase", "MASTER_KEY_PASSPHRASE": "x", "MASTER_KEY_SALT": ""},
+		"KMS incomplete":     {"MASTER_KEY_PROVIDER": "kms", "KMS_ENDPOINT": "http://kms", "KMS_KEY_ID": "", "MASTER_KEY_WRAPPED": ""},
+		"Bad key version":    {"MASTER_KEY_PROVIDER": "env", "MASTER_KEY_VERSION": "0"},
+		"Bad previous keys":  {"MASTER_KEY_PROVIDER": "env", "MASTER_KEY_PREVIOUS": "1:nope"},
+	}
+	for name, env := range errorCases {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := LoadKeyProviderConfigFromEnv(); err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
@@ -13,6 +13,9 @@ var (
 	ErrInvalidNonce        = errors.New("crypto: invalid nonce size")
 	ErrKeyDerivationFailed = errors.New("crypto: key derivation failed")
 	ErrInvalidMasterKey    = errors.New("crypto: invalid master key")
+	ErrUnsupportedFormat   = errors.New("crypto: unsupported envelope format")
+	ErrKeyVersionMismatch  = errors.New("crypto: key version mismatch")
+	ErrUnboundCiphertext   = errors.New("crypto: ciphertext is not bound to associated data")
 )
 
 // Key sizes in bytes
@@ -28,13 +31,42 @@ const (
 	MinEncryptedSize = GCMNonceSize + 16
 )
 
+// Algorithm identifies the cipher used to produce a ciphertext
+type Algorithm uint8
+
+const (
+	// AlgAES256GCM is AES-256 in GCM mode with a 96-bit random nonce
+	AlgAES256GCM Algorithm = 1
+)
+
+// Envelope format versions
+const (
+	// FormatLegacy is a headerless [nonce][ciphertext + tag] blob
+	FormatLegacy = 0
+
+	// FormatV1 prefixes the blob with a header recording the algorithm
+	// and key version. The header is authenticated as GCM additional data.
+	FormatV1 = 1
+
+	// FormatV2 is FormatV1 with caller-supplied associated data (such as a
+	// secret's project, environment and key) bound into the tag as well
+	FormatV2 = 2
+)
+
 // EncryptedData represents encrypted data with metadata
 type EncryptedData struct {
 	// Ciphertext includes nonce prepended (first 12 bytes)
 	Ciphertext []byte
 
-	// Version for future algorithm changes
+	// Version is the envelope format version
 	Version int
+
+	// Algorithm is the cipher that produced Ciphertext
+	Algorithm Algorithm
+
+	// KeyVersion is the version of the key that encrypted the data.
+	// Zero means unknown (legacy blobs or raw-key encryption).
+	KeyVersion int
 }
 
 // MasterKey represents the Key Encryption Key (KEK)
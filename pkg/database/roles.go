@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Placeholders replaced in role statements by RoleCredentials.Render
+const (
+	PlaceholderName       = "{{name}}"
+	PlaceholderPassword   = "{{password}}"
+	PlaceholderExpiration = "{{expiration}}"
+)
+
+// DefaultCreationStatements create a login role that may read every table
+// in the public schema
+const DefaultCreationStatements = `CREATE ROLE "{{name}}" WITH LOGIN PASSWORD '{{password}}' VALID UNTIL '{{expiration}}';
+GRANT SELECT ON ALL TABLES IN SCHEMA public TO "{{name}}";`
+
+// DefaultRevocationStatements drop a role and every privilege granted to it
+// in the database connected to
+const DefaultRevocationStatements = `DROP OWNED BY "{{name}}";
+DROP ROLE "{{name}}";`
+
+// StatementTimeout bounds how long RunStatements waits for the target server
+const StatementTimeout = 30 * time.Second
+
+// maxRoleName is PostgreSQL's identifier length limit (NAMEDATALEN - 1)
+const maxRoleName = 63
+
+// rolePasswordBytes is the number of random bytes in a role password
+const rolePasswordBytes = 24
+
+// pgUndefinedObject is the SQLSTATE for a role that does not exist
+const pgUndefinedObject = "42704"
+
+// RoleCredentials are the login of a short-lived role
+type RoleCredentials struct {
+	Username   string
+	Password   string
+	Expiration time.Time
+}
+
+// NewRoleCredentials generates a role named after prefix with a random
+// suffix and password, valid until expiration. Only lowercase letters,
+// digits and underscores of prefix are kept, so the name never needs more
+// than double quotes and the password never needs escaping inside single
+// quotes.
+func NewRoleCredentials(prefix string, expiration time.Time) (RoleCredentials, error) {
+	b := make([]byte, 8+rolePasswordBytes)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return RoleCredentials{}, fmt.Errorf("failed to generate role credentials: %w", err)
+	}
+	suffix := "_" + hex.EncodeToString(b[:8])
+
+	var name strings.Builder
+	for _, r := range strings.ToLower(prefix) {
+		if name.Len() == maxRoleName-len(suffix) {
+			break
+		}
+		if r == '_' || ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			name.WriteRune(r)
+		}
+	}
+
+	return RoleCredentials{
+		Username:   name.String() + suffix,
+		Password:   base64.RawURLEncoding.EncodeToString(b[8:]),
+		Expiration: expiration.UTC().Truncate(time.Second),
+	}, nil
+}
+
+// Render replaces the placeholders in statements with c
+func (c RoleCredentials) Render(statements string) string {
+	return strings.NewReplacer(
+		PlaceholderName, c.Username,
+		PlaceholderPassword, c.Password,
+		PlaceholderExpiration, c.Expiration.Format(time.RFC3339),
+	).Replace(statements)
+}
+
+// URL returns connString, a postgres:// URL, logging in as c instead
+func (c RoleCredentials) URL(connString string) (string, error) {
+	u, err := ParseConnectionURL(connString)
+	if err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword(c.Username, c.Password)
+	return u.String(), nil
+}
+
+// ParseConnectionURL parses a postgres:// or postgresql:// connection URL
+func ParseConnectionURL(connString string) (*url.URL, error) {
+	u, err := url.Parse(connString)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") || u.Host == "" {
+		return nil, errors.New("database: connection must be a postgres:// URL")
+	}
+	return u, nil
+}
+
+// RunStatements connects to connString and executes statements, which may
+// hold several commands, in one transaction
+func RunStatements(ctx context.Context, connString, statements string) error {
+	ctx, cancel := context.WithTimeout(ctx, StatementTimeout)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return fmt.Errorf("failed to connect to target: %w", err)
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	// Without arguments pgx uses the simple protocol, which allows
+	// several commands in one string
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, statements)
+		return err
+	})
+}
+
+// IsUndefinedRole reports whether err says a role does not exist, e.g.
+// because revocation statements already ran
+func IsUndefinedRole(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUndefinedObject
+}
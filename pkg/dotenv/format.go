@@ -0,0 +1,81 @@
+package dotenv
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// shellName matches the keys a POSIX shell accepts as variable names
+var shellName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Marshal writes entries as a .env file that Parse reads back unchanged.
+// Values are left bare when that is safe and quoted otherwise.
+func Marshal(entries []Entry) []byte {
+	var b bytes.Buffer
+	for _, e := range entries {
+		b.WriteString(e.Key)
+		b.WriteByte('=')
+		b.WriteString(Quote(e.Value))
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// MarshalShell writes entries as `export KEY='value'` lines for a POSIX
+// shell to source. It fails on keys that are not valid variable names.
+func MarshalShell(entries []Entry) ([]byte, error) {
+	var b bytes.Buffer
+	for _, e := range entries {
+		if !shellName.MatchString(e.Key) {
+			return nil, fmt.Errorf("%s is not a valid shell variable name", e.Key)
+		}
+		fmt.Fprintf(&b, "export %s='%s'\n", e.Key, strings.ReplaceAll(e.Value, "'", `'\''`))
+	}
+	return b.Bytes(), nil
+}
+
+// Quote formats a value for a .env file: bare if it only holds safe
+// characters, single-quoted if it has no quotes or line breaks, and
+// double-quoted with escapes otherwise
+func Quote(value string) string {
+	if isBare(value) {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\n\r") {
+		return "'" + value + "'"
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '"', '\\', '$':
+			// $ is escaped for tools that expand ${VAR} in double quotes
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func isBare(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("_-./:@%+,=", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
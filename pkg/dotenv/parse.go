@@ -0,0 +1,223 @@
+// Package dotenv reads and writes .env files.
+//
+// The syntax follows the common dotenv conventions:
+//
+//	# comments and blank lines are ignored
+//	export API_KEY=abc123         # "export " prefixes are allowed
+//	PLAIN = value with spaces     # unquoted values are trimmed; " #" starts a comment
+//	SINGLE='literal $HOME \n'     # single quotes (and backticks) are literal
+//	DOUBLE="tab\there \"quoted\"" # double quotes support \n \r \t \" \\ \$
+//	MULTI="first line
+//	second line"
+//
+// Values are taken literally: ${VAR} references are not expanded.
+package dotenv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Entry is one KEY=value assignment of a .env file
+type Entry struct {
+	Key   string
+	Value string
+	// Line is the 1-based line the assignment starts on
+	Line int
+}
+
+// ParseError reports malformed input with the line it was found on
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Parse reads the assignments of a .env file in the order they appear.
+// Keys assigned more than once are returned once per assignment.
+func Parse(data []byte) ([]Entry, error) {
+	src := strings.TrimPrefix(string(data), "\ufeff")
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+
+	p := &parser{src: src, line: 1}
+	entries := []Entry{}
+	for !p.eof() {
+		p.skipSpace()
+		switch {
+		case p.eof():
+		case p.peek() == '\n':
+			p.next()
+		case p.peek() == '#':
+			p.skipLine()
+		default:
+			e, err := p.assignment()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// parser walks the source byte by byte, counting lines
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) eof() bool  { return p.pos >= len(p.src) }
+func (p *parser) peek() byte { return p.src[p.pos] }
+
+func (p *parser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipLine moves to the start of the next line
+func (p *parser) skipLine() {
+	for !p.eof() && p.next() != '\n' {
+	}
+}
+
+// word reads up to whitespace, '=' or the end of the line
+func (p *parser) word() string {
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\n=", rune(p.peek())) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) errorf(line int, format string, args ...any) error {
+	return &ParseError{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// assignment reads `[export] KEY = value`
+func (p *parser) assignment() (Entry, error) {
+	line := p.line
+	key := p.word()
+	p.skipSpace()
+	if key == "export" && !p.eof() && p.peek() != '=' && p.peek() != '\n' {
+		key = p.word()
+		p.skipSpace()
+	}
+	if key == "" {
+		return Entry{}, p.errorf(line, "expected KEY=value")
+	}
+	if p.eof() || p.peek() != '=' {
+		return Entry{}, p.errorf(line, "expected '=' after %s", key)
+	}
+	p.next()
+
+	value, err := p.value(line)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Key: key, Value: value, Line: line}, nil
+}
+
+// value reads a quoted or unquoted value and the rest of its line
+func (p *parser) value(line int) (string, error) {
+	start := p.pos
+	p.skipSpace()
+	if p.eof() {
+		return "", nil
+	}
+
+	switch q := p.peek(); q {
+	case '\'', '`':
+		p.next()
+		end := strings.IndexByte(p.src[p.pos:], q)
+		if end < 0 {
+			return "", p.errorf(line, "unterminated %c-quoted value", q)
+		}
+		value := p.src[p.pos : p.pos+end]
+		for range end + 1 {
+			p.next()
+		}
+		return value, p.endOfValue()
+
+	case '"':
+		p.next()
+		var b strings.Builder
+		for {
+			if p.eof() {
+				return "", p.errorf(line, "unterminated \"-quoted value")
+			}
+			c := p.next()
+			switch c {
+			case '"':
+				return b.String(), p.endOfValue()
+			case '\\':
+				if p.eof() {
+					return "", p.errorf(line, "unterminated \"-quoted value")
+				}
+				b.WriteString(unescape(p.next()))
+			default:
+				b.WriteByte(c)
+			}
+		}
+
+	default:
+		// Unquoted values run to the end of the line. A '#' only starts a
+		// comment after whitespace, so KEY=a#b keeps its '#'.
+		p.pos = start
+		end := strings.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		raw := p.src[p.pos : p.pos+end]
+		p.pos += end
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '#' && (raw[i-1] == ' ' || raw[i-1] == '\t') {
+				raw = raw[:i]
+				break
+			}
+		}
+		return strings.Trim(raw, " \t"), nil
+	}
+}
+
+// endOfValue allows only whitespace and a comment after a closing quote
+func (p *parser) endOfValue() error {
+	p.skipSpace()
+	switch {
+	case p.eof(), p.peek() == '\n':
+		return nil
+	case p.peek() == '#':
+		p.skipLine()
+		return nil
+	}
+	return p.errorf(p.line, "unexpected %q after closing quote", p.peek())
+}
+
+// unescape resolves the character after a backslash in a double-quoted
+// value. Unknown escapes are kept as written.
+func unescape(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case '"', '\\', '$':
+		return string(c)
+	}
+	return "\\" + string(c)
+}
@@ -0,0 +1,120 @@
+package dotenv
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Entry
+	}{
+		{"Empty file", "", []Entry{}},
+		{"Only comments and blanks", "# header\n\n   \n\t# indented comment\n", []Entry{}},
+		{"Simple", "A=1\nB=two", []Entry{{"A", "1", 1}, {"B", "two", 2}}},
+		{"Trailing newline", "A=1\n", []Entry{{"A", "1", 1}}},
+		{"Spaces around equals", "A = 1\nB\t=\t2", []Entry{{"A", "1", 1}, {"B", "2", 2}}},
+		{"Leading indentation", "   A=1", []Entry{{"A", "1", 1}}},
+		{"Empty value", "A=\nB=", []Entry{{"A", "", 1}, {"B", "", 2}}},
+		{"Empty value with comment", "A= # nothing", []Entry{{"A", "", 1}}},
+		{"Unquoted inner spaces", "A=hello world  ", []Entry{{"A", "hello world", 1}}},
+		{"Unquoted inline comment", "A=value # comment", []Entry{{"A", "value", 1}}},
+		{"Unquoted tab comment", "A=value\t# comment", []Entry{{"A", "value", 1}}},
+		{"Hash without space is kept", "A=abc#def", []Entry{{"A", "abc#def", 1}}},
+		{"Leading hash is kept", "COLOR=#ff0000", []Entry{{"COLOR", "#ff0000", 1}}},
+		{"Equals in value", "URL=postgres://u:p@h/db?sslmode=disable&a=b", []Entry{{"URL", "postgres://u:p@h/db?sslmode=disable&a=b", 1}}},
+		{"Unquoted backslashes are literal", `A=C:\path\n`, []Entry{{"A", `C:\path\n`, 1}}},
+		{"Unquoted inner quotes are literal", `A=it's "fine"`, []Entry{{"A", `it's "fine"`, 1}}},
+		{"Export prefix", "export A=1\nexport   B=2", []Entry{{"A", "1", 1}, {"B", "2", 2}}},
+		{"Key named export", "export=1", []Entry{{"export", "1", 1}}},
+		{"Key named export with spaces", "export = 1", []Entry{{"export", "1", 1}}},
+		{"Exported key named export", "export export=1", []Entry{{"export", "1", 1}}},
+		{"Lowercase and dotted keys", "app.name=x\nmy-key=y", []Entry{{"app.name", "x", 1}, {"my-key", "y", 2}}},
+		{"Single quotes are literal", `A='$HOME \n "x" # not a comment'`, []Entry{{"A", `$HOME \n "x" # not a comment`, 1}}},
+		{"Single quotes keep spaces", "A='  padded  '", []Entry{{"A", "  padded  ", 1}}},
+		{"Single quotes then comment", "A='x'   # comment", []Entry{{"A", "x", 1}}},
+		{"Empty single quotes", "A=''", []Entry{{"A", "", 1}}},
+		{"Backticks are literal", "A=`it's \"both\"`", []Entry{{"A", `it's "both"`, 1}}},
+		{"Double quote escapes", `A="a\nb\rc\td\"e\\f\$g"`, []Entry{{"A", "a\nb\rc\td\"e\\f$g", 1}}},
+		{"Unknown escapes are kept", `A="\x\u00e9"`, []Entry{{"A", `\x\u00e9`, 1}}},
+		{"Double quotes keep hashes", `A="a # b"`, []Entry{{"A", "a # b", 1}}},
+		{"Double quotes keep single quotes", `A="it's"`, []Entry{{"A", "it's", 1}}},
+		{"Empty double quotes", `A=""`, []Entry{{"A", "", 1}}},
+		{"Double quotes then comment", `A="x" # comment`, []Entry{{"A", "x", 1}}},
+		{"Space before quote", `A=  "x"`, []Entry{{"A", "x", 1}}},
+		{
+			"Multiline double quotes",
+			"A=\"first\nsecond\n\"\nB=after",
+			[]Entry{{"A", "first\nsecond\n", 1}, {"B", "after", 4}},
+		},
+		{
+			"Multiline single quotes",
+			"KEY='-----BEGIN KEY-----\nMIIB\\n\n-----END KEY-----'\nNEXT=1",
+			[]Entry{{"KEY", "-----BEGIN KEY-----\nMIIB\\n\n-----END KEY-----", 1}, {"NEXT", "1", 4}},
+		},
+		{"Escaped newline stays on one line", "A=\"x\\ny\"\nB=2", []Entry{{"A", "x\ny", 1}, {"B", "2", 2}}},
+		{"CRLF line endings", "A=1\r\nB=\"x\r\ny\"\r\n", []Entry{{"A", "1", 1}, {"B", "x\ny", 2}}},
+		{"Byte order mark", "\ufeffA=1", []Entry{{"A", "1", 1}}},
+		{"Unicode", "GREETING=héllo 世界 🌍\nQ=\"ü\"", []Entry{{"GREETING", "héllo 世界 🌍", 1}, {"Q", "ü", 2}}},
+		{"Duplicates are all returned", "A=1\nA=2", []Entry{{"A", "1", 1}, {"A", "2", 2}}},
+		{
+			"Mixed file",
+			"# Database\nexport DB_HOST=localhost\nDB_PASS='p@ss w0rd'  # keep quoted\n\n" +
+				"JSON={\"a\":1}\nCERT=\"line1\nline2\"\nEMPTY=\n",
+			[]Entry{
+				{"DB_HOST", "localhost", 2},
+				{"DB_PASS", "p@ss w0rd", 3},
+				{"JSON", `{"a":1}`, 5},
+				{"CERT", "line1\nline2", 6},
+				{"EMPTY", "", 8},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{"Missing equals", "A=1\nJUSTAKEY\n", 2},
+		{"Missing equals after export", "export A", 1},
+		{"Key with spaces", "MY KEY=1", 1},
+		{"No key", "=value", 1},
+		{"Unterminated double quote", "A=1\nB=\"open\nC=2\n", 2},
+		{"Unterminated single quote", "\n\nA='open", 3},
+		{"Unterminated backtick", "A=`open", 1},
+		{"Trailing backslash", `A="open\`, 1},
+		{"Text after closing quote", "A=\"x\"y", 1},
+		{"Text after multiline quote", "A='x\ny' z", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.input))
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Expected a ParseError, got %v", err)
+			}
+			if perr.Line != tt.line {
+				t.Errorf("Expected the error on line %d, got %v", tt.line, perr)
+			}
+		})
+	}
+}